@@ -1,22 +1,32 @@
 package main
 
 import (
+	"context"
 	"encoding/gob"
 	"flag"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/alexedwards/scs/v2"
+	"github.com/dhanekom/bookings/internal/calsync"
 	"github.com/dhanekom/bookings/internal/config"
 	"github.com/dhanekom/bookings/internal/driver"
 	"github.com/dhanekom/bookings/internal/handlers"
 	"github.com/dhanekom/bookings/internal/helpers"
+	"github.com/dhanekom/bookings/internal/holds"
+	"github.com/dhanekom/bookings/internal/mailer"
+	"github.com/dhanekom/bookings/internal/media"
 	"github.com/dhanekom/bookings/internal/models"
+	"github.com/dhanekom/bookings/internal/payments"
 	"github.com/dhanekom/bookings/internal/render"
 	"github.com/dhanekom/bookings/internal/repository/dbrepo"
+	"github.com/dhanekom/bookings/internal/throttle"
+	"github.com/dhanekom/bookings/internal/trash"
+	"github.com/dhanekom/bookings/internal/waitlist"
 )
 
 const portNumber = ":8080"
@@ -33,17 +43,11 @@ func main() {
 	}
 	defer db.SQL.Close()
 
-	defer close(app.MailChan)
-	listenForMail()
-
-	msg := models.MailData{
-		To:      "john@do.ca",
-		From:    "me@here.com",
-		Subject: "Some subject",
-		Content: "Hallo <strong>world</strong>",
-	}
-
-	app.MailChan <- msg
+	app.Mail.Start(context.Background())
+	app.CalendarSync.Start(context.Background())
+	app.HoldSweeper.Start(context.Background())
+	app.Waitlist.Start(context.Background())
+	app.Trash.Start(context.Background())
 
 	log.Printf("Starting server on port %s\n", portNumber)
 	srv := http.Server{
@@ -60,6 +64,8 @@ func run() (*driver.DB, error) {
 	gob.Register(models.Room{})
 	gob.Register(models.Restriction{})
 	gob.Register(models.RoomRestriction{})
+	gob.Register(models.Cart{})
+	gob.Register(models.Booking{})
 
 	// read flags
 	inProduction := flag.Bool("production", true, "Application is in production")
@@ -70,6 +76,33 @@ func run() (*driver.DB, error) {
 	dbPass := flag.String("dbpass", "", "Database password")
 	dbPort := flag.String("dbport", "5432", "Database post")
 	dbSSL := flag.String("dbssl", "disable", "Database sslsettings (disable, prefer, require)")
+	secretKey := flag.String("secret", "", "Secret key used to sign tokens")
+	baseURL := flag.String("baseurl", "http://localhost:8080", "Public URL of the site, used in emailed links")
+	propertyName := flag.String("propertyname", "Fort Smythe", "Name of the property, used in calendar invites")
+	propertyAddress := flag.String("propertyaddress", "", "Address of the property, used in calendar invites")
+	throttleStore := flag.String("throttlestore", "postgres", "Where failed logins are tracked (postgres, memory)")
+	smtpHost := flag.String("smtphost", mailer.DefaultConfig.Host, "SMTP host")
+	smtpPort := flag.Int("smtpport", mailer.DefaultConfig.Port, "SMTP port")
+	smtpUser := flag.String("smtpuser", "", "SMTP user, leave empty for servers without authentication")
+	smtpPass := flag.String("smtppass", "", "SMTP password")
+	smtpEncryption := flag.String("smtpencryption", mailer.DefaultConfig.Encryption, "SMTP encryption (none, starttls, ssl)")
+	mailWorkers := flag.Int("mailworkers", 2, "Number of messages sent at the same time")
+	mediaDir := flag.String("mediadir", "./media", "Directory uploaded room photos are stored in")
+	currency := flag.String("currency", "$", "Currency symbol prices are shown with")
+	calendarSync := flag.Duration("calendarsync", 15*time.Minute, "Time between imports of external room calendars")
+	holdDuration := flag.Duration("holdduration", 15*time.Minute, "How long a chosen room is held while the guest books it, 0 to turn holds off")
+	holdSweep := flag.Duration("holdsweep", time.Minute, "Time between deletions of expired room holds")
+	waitlistMatch := flag.Duration("waitlistmatch", 15*time.Minute, "Time between matches of waitlisted guests to available rooms")
+	paymentGateway := flag.String("payments", "none", "Payment gateway deposits are taken with (none, fake, hosted)")
+	paymentURL := flag.String("paymenturl", "", "API URL of the hosted checkout provider")
+	paymentKey := flag.String("paymentkey", "", "API key of the hosted checkout provider")
+	paymentSecret := flag.String("paymentsecret", "", "Secret the payment provider signs webhooks with")
+	paymentCurrency := flag.String("paymentcurrency", "USD", "ISO 4217 code of the currency payments are taken in")
+	deposit := flag.Int("deposit", 100, "Percentage of a reservation's total taken as a deposit when it is booked")
+	paymentWindow := flag.Duration("paymentwindow", 30*time.Minute, "How long a room is held while the guest pays")
+	waitlistOffer := flag.Duration("waitlistoffer", 24*time.Hour, "How long a waitlisted guest has to book the room they were offered")
+	trashRetention := flag.Duration("trashretention", 30*24*time.Hour, "How long deleted reservations can be restored before they are purged")
+	trashPurge := flag.Duration("trashpurge", time.Hour, "Time between purges of deleted reservations")
 
 	flag.Parse()
 
@@ -78,9 +111,6 @@ func run() (*driver.DB, error) {
 		os.Exit(1)
 	}
 
-	mailChan := make(chan models.MailData)
-	app.MailChan = mailChan
-
 	app.TemplatePath = "./templates"
 
 	tc, err := render.CreateTemplateCache(app.TemplatePath)
@@ -90,6 +120,12 @@ func run() (*driver.DB, error) {
 
 	app.InProduction = *inProduction
 	app.UseCache = *userCache
+	app.BaseURL = strings.TrimSuffix(*baseURL, "/")
+	app.PropertyName = *propertyName
+	app.PropertyAddress = *propertyAddress
+	app.Currency = *currency
+	app.Media = media.New(*mediaDir)
+	app.HoldDuration = *holdDuration
 
 	infoLog = log.New(os.Stdout, "INFO\t", log.Ldate|log.Ltime)
 	app.InfoLog = infoLog
@@ -105,6 +141,37 @@ func run() (*driver.DB, error) {
 
 	app.Session = session
 
+	if *secretKey == "" {
+		// without a configured secret, signed tokens don't survive a restart
+		infoLog.Println("no -secret given, using a random secret key")
+		key, err := helpers.RandomToken(32)
+		if err != nil {
+			return nil, err
+		}
+		*secretKey = key
+	}
+	app.SecretKey = []byte(*secretKey)
+
+	switch *paymentGateway {
+	case "none":
+	case "fake":
+		// the fake gateway's webhooks never leave the application, so they're signed with its own secret
+		app.Payments = payments.NewFake(app.BaseURL+"/payments/fake-checkout", app.SecretKey)
+	case "hosted":
+		if *paymentURL == "" || *paymentSecret == "" {
+			return nil, fmt.Errorf("the hosted payment gateway needs -paymenturl and -paymentsecret")
+		}
+		app.Payments = payments.NewHosted(*paymentURL, *paymentKey, []byte(*paymentSecret))
+	default:
+		return nil, fmt.Errorf("unknown payment gateway %q", *paymentGateway)
+	}
+	if *deposit < 0 || *deposit > 100 {
+		return nil, fmt.Errorf("-deposit must be a percentage from 0 to 100")
+	}
+	app.PaymentCurrency = *paymentCurrency
+	app.DepositPercent = *deposit
+	app.PaymentWindow = *paymentWindow
+
 	// connect to database
 	log.Println("connecting to database...")
 	// host=localhost port=5432 dbname=bookings user=pos password=pos
@@ -117,11 +184,63 @@ func run() (*driver.DB, error) {
 
 	log.Println("connected to database")
 
+	switch *throttleStore {
+	case "postgres":
+		app.LoginThrottle = throttle.New(throttle.NewPostgresStore(db.SQL), nil)
+	case "memory":
+		app.LoginThrottle = throttle.New(throttle.NewMemoryStore(), nil)
+	default:
+		return nil, fmt.Errorf("unknown throttle store %q", *throttleStore)
+	}
+
+	mailConfig := mailer.DefaultConfig
+	mailConfig.Host = *smtpHost
+	mailConfig.Port = *smtpPort
+	mailConfig.Username = *smtpUser
+	mailConfig.Password = *smtpPass
+	mailConfig.Encryption = *smtpEncryption
+
+	sender, err := mailer.NewSMTPSender(mailConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	app.Mail = mailer.NewOutbox(mailer.NewPostgresStore(db.SQL), sender, nil)
+	app.Mail.Workers = *mailWorkers
+	app.Mail.ErrorLog = errorLog
+
+	app.Mail.Templates, err = mailer.NewTemplates("./email-templates", app.UseCache)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create mail template cache - %s", err)
+	}
+
 	app.TemplateCache = tc
 	myDBRepo := dbrepo.NewPostgresRepo(db.SQL, &app)
+
+	app.CalendarSync = calsync.New(myDBRepo, nil)
+	app.CalendarSync.Interval = *calendarSync
+	app.CalendarSync.ErrorLog = errorLog
+
+	app.HoldSweeper = holds.New(myDBRepo, nil)
+	app.HoldSweeper.Interval = *holdSweep
+	app.HoldSweeper.ErrorLog = errorLog
+
+	app.Trash = trash.New(myDBRepo, nil)
+	app.Trash.Retention = *trashRetention
+	app.Trash.Interval = *trashPurge
+	app.Trash.ErrorLog = errorLog
+
 	render.NewRendered(&app)
 	handlers.NewRepo(&app, myDBRepo)
 	helpers.NewHelpers(&app)
 
+	app.Waitlist = waitlist.New(myDBRepo, handlers.Repo.OfferWaitlist, nil)
+	app.Waitlist.Interval = *waitlistMatch
+	app.Waitlist.OfferDuration = *waitlistOffer
+	app.Waitlist.ErrorLog = errorLog
+
+	// bookings removed from an external calendar may free a room someone is waiting for
+	app.CalendarSync.Replaced = app.Waitlist.Trigger
+
 	return db, nil
 }
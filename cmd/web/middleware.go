@@ -1,9 +1,14 @@
 package main
 
 import (
+	"database/sql"
+	"errors"
 	"net/http"
+	"time"
 
+	"github.com/dhanekom/bookings/internal/handlers"
 	"github.com/dhanekom/bookings/internal/helpers"
+	"github.com/dhanekom/bookings/internal/models"
 	"github.com/justinas/nosurf"
 )
 
@@ -25,6 +30,42 @@ func SessionLoad(next http.Handler) http.Handler {
 	return session.LoadAndSave(next)
 }
 
+// LoadUser puts the logged in user, if any, into the request context
+func LoadUser(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, ok := session.Get(r.Context(), "user_id").(int)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		u, err := handlers.Repo.DB.GetUserByID(id)
+		if errors.Is(err, sql.ErrNoRows) || (err == nil && (!u.DeactivatedAt.IsZero() || passwordChangedSinceLogin(r, u))) {
+			// the user no longer exists, was deactivated or changed their password since logging in,
+			// so the session is no longer valid
+			session.Remove(r.Context(), "user_id")
+			session.Remove(r.Context(), "login_at")
+			next.ServeHTTP(w, r)
+			return
+		} else if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(helpers.ContextWithUser(r.Context(), u)))
+	})
+}
+
+// passwordChangedSinceLogin reports whether the user's password was changed after the session logged in
+func passwordChangedSinceLogin(r *http.Request, u models.User) bool {
+	if u.PasswordChangedAt.IsZero() {
+		return false
+	}
+
+	loginAt, _ := session.Get(r.Context(), "login_at").(int64)
+	return u.PasswordChangedAt.After(time.Unix(0, loginAt))
+}
+
 func Auth(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if !helpers.IsAuthenticated(r) {
@@ -35,3 +76,37 @@ func Auth(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
+
+// RequireTwoFactor sends users who must use two-factor authentication, but haven't set it up, to set it up
+func RequireTwoFactor(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u, _ := helpers.UserFromContext(r.Context())
+		if u.TOTPEnabledAt.IsZero() {
+			required, err := handlers.Repo.TwoFactorRequired(u)
+			if err != nil {
+				helpers.ServerError(w, err)
+				return
+			}
+
+			if required {
+				session.Put(r.Context(), "warning", "Set up two-factor authentication to continue")
+				http.Redirect(w, r, "/admin/two-factor", http.StatusSeeOther)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RequireAccessLevel only allows users with at least the given access level through
+func RequireAccessLevel(level int) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !helpers.HasAccessLevel(r, level) {
+				helpers.ClientError(w, http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -2,7 +2,11 @@ package main
 
 import (
 	"net/http"
+	"net/http/httptest"
 	"testing"
+
+	"github.com/dhanekom/bookings/internal/helpers"
+	"github.com/dhanekom/bookings/internal/models"
 )
 
 func TestNoSurf(t *testing.T) {
@@ -28,3 +32,113 @@ func TestSessionLoad(t *testing.T) {
 		t.Errorf("got type %T, expected type http.Handler", v)
 	}
 }
+
+// sessionRequest returns a request with a loaded session, holding the user id if it isn't 0
+func sessionRequest(t *testing.T, userID int) *http.Request {
+	req := httptest.NewRequest("GET", "/admin/dashboard", nil)
+
+	ctx, err := session.Load(req.Context(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if userID != 0 {
+		session.Put(ctx, "user_id", userID)
+	}
+
+	return req.WithContext(ctx)
+}
+
+func TestLoadUser(t *testing.T) {
+	var tests = []struct {
+		name       string
+		userID     int
+		expectedID int
+	}{
+		{"logged in", 2, 2},
+		{"not logged in", 0, 0},
+		{"user no longer exists", 1000, 0},
+	}
+
+	for _, e := range tests {
+		req := sessionRequest(t, e.userID)
+
+		var u models.User
+		var ok bool
+		h := LoadUser(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			u, ok = helpers.UserFromContext(r.Context())
+		}))
+
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+
+		if ok != (e.expectedID != 0) || u.ID != e.expectedID {
+			t.Errorf("%s: expected user %d in the context, got %d (%v)", e.name, e.expectedID, u.ID, ok)
+		}
+
+		if e.userID == 1000 && session.Exists(req.Context(), "user_id") {
+			t.Errorf("%s: expected the user to be logged out", e.name)
+		}
+	}
+}
+
+func TestAuth(t *testing.T) {
+	var tests = []struct {
+		name               string
+		userID             int
+		expectedStatusCode int
+		expectedLocation   string
+	}{
+		{"logged in", 2, http.StatusOK, ""},
+		{"not logged in", 0, http.StatusSeeOther, "/user/login"},
+	}
+
+	for _, e := range tests {
+		rr := httptest.NewRecorder()
+		Auth(&myHandler{}).ServeHTTP(rr, sessionRequest(t, e.userID))
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected %d, got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+
+		if loc := rr.Header().Get("Location"); loc != e.expectedLocation {
+			t.Errorf("%s: expected location %q, got %q", e.name, e.expectedLocation, loc)
+		}
+	}
+}
+
+func TestRequireAccessLevel(t *testing.T) {
+	var tests = []struct {
+		name               string
+		user               *models.User
+		expectedStatusCode int
+	}{
+		{"no user", nil, http.StatusForbidden},
+		{"lower level", &models.User{ID: 2, AccessLevel: models.AccessLevelStaff}, http.StatusForbidden},
+		{"same level", &models.User{ID: 2, AccessLevel: models.AccessLevelManager}, http.StatusOK},
+		{"higher level", &models.User{ID: 1, AccessLevel: models.AccessLevelAdmin}, http.StatusOK},
+	}
+
+	for _, e := range tests {
+		req := httptest.NewRequest("GET", "/admin/reservations-calendar", nil)
+		if e.user != nil {
+			req = req.WithContext(helpers.ContextWithUser(req.Context(), *e.user))
+		}
+
+		var reached bool
+		h := RequireAccessLevel(models.AccessLevelManager)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			reached = true
+		}))
+
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected %d, got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+
+		if expected := e.expectedStatusCode == http.StatusOK; reached != expected {
+			t.Errorf("%s: expected the next handler to be reached: %v", e.name, expected)
+		}
+	}
+}
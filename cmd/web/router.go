@@ -5,6 +5,7 @@ import (
 
 	"github.com/dhanekom/bookings/internal/config"
 	"github.com/dhanekom/bookings/internal/handlers"
+	"github.com/dhanekom/bookings/internal/models"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 )
@@ -13,45 +14,181 @@ func routes(a *config.AppConfig) http.Handler {
 	mux := chi.NewRouter()
 
 	mux.Use(middleware.Recoverer)
-	mux.Use(NoSurf)
-	mux.Use(SessionLoad)
 
-	mux.Get("/", handlers.Repo.Home)
-	mux.Get("/about", handlers.Repo.About)
-	mux.Get("/generals-quarters", handlers.Repo.Generals)
-	mux.Get("/majors-suite", handlers.Repo.Majors)
+	// the JSON API authenticates with API keys, so it sits outside the session and CSRF middleware
+	mux.Route("/api/v1", func(r chi.Router) {
+		r.NotFound(handlers.Repo.APINotFound)
+		r.MethodNotAllowed(handlers.Repo.APIMethodNotAllowed)
+		r.Use(handlers.Repo.APIAuth)
 
-	mux.Get("/search-availability", handlers.Repo.Availability)
-	mux.Post("/search-availability", handlers.Repo.PostAvailability)
-	mux.Post("/search-availability-json", handlers.Repo.AvailabilityJSON)
-	mux.Get("/choose-room/{id}", handlers.Repo.ChooseRoom)
-	mux.Get("/book-room", handlers.Repo.BookRoom)
+		r.Get("/rooms", handlers.Repo.APIListRooms)
+		r.Get("/rooms/{id}/availability", handlers.Repo.APIRoomAvailability)
+		r.Post("/reservations", handlers.Repo.APICreateReservation)
+		r.Get("/reservations/{id}", handlers.Repo.APIGetReservation)
+		r.Patch("/reservations/{id}", handlers.Repo.APIUpdateReservation)
+		r.Delete("/reservations/{id}", handlers.Repo.APIDeleteReservation)
+	})
+
+	// booking channels fetch calendar feeds without a session, authenticated by the token in the URL
+	mux.Get("/calendars/{token}.ics", handlers.Repo.RoomCalendarFeed)
+
+	// the payment provider signs its webhooks instead
+	mux.Post("/payments/webhook", handlers.Repo.PaymentWebhook)
+
+	mux.Group(func(mux chi.Router) {
+		mux.Use(NoSurf)
+		mux.Use(SessionLoad)
+		mux.Use(LoadUser)
+
+		mux.Get("/", handlers.Repo.Home)
+		mux.Get("/about", handlers.Repo.About)
+		mux.Get("/rooms", handlers.Repo.Rooms)
+		mux.Get("/rooms/{slug}", handlers.Repo.Room)
+
+		// the rooms used to have pages of their own
+		mux.Handle("/generals-quarters", http.RedirectHandler("/rooms/generals-quarters", http.StatusMovedPermanently))
+		mux.Handle("/majors-suite", http.RedirectHandler("/rooms/majors-suite", http.StatusMovedPermanently))
+
+		mux.Get("/search-availability", handlers.Repo.Availability)
+		mux.Post("/search-availability", handlers.Repo.PostAvailability)
+		mux.Post("/search-availability-json", handlers.Repo.AvailabilityJSON)
+		mux.Get("/choose-room/{id}", handlers.Repo.ChooseRoom)
+		mux.Get("/book-room", handlers.Repo.BookRoom)
+
+		mux.Get("/waitlist", handlers.Repo.Waitlist)
+		mux.Post("/waitlist", handlers.Repo.PostWaitlist)
+		mux.Get("/waitlist/offer", handlers.Repo.WaitlistOffer)
+
+		mux.Get("/contact", handlers.Repo.Contact)
+
+		mux.Get("/make-reservation", handlers.Repo.Reservation)
+		mux.Post("/make-reservation", handlers.Repo.PostReservation)
+		mux.Get("/reservation-summary", handlers.Repo.ReservationSummary)
+		mux.Get("/payments/fake-checkout", handlers.Repo.FakeCheckout)
+		mux.Post("/payments/fake-checkout", handlers.Repo.PostFakeCheckout)
+
+		mux.Get("/cart", handlers.Repo.Cart)
+		mux.Post("/cart/add", handlers.Repo.PostCartAdd)
+		mux.Post("/cart/{index}/remove", handlers.Repo.PostCartRemove)
+		mux.Post("/cart/checkout", handlers.Repo.PostCartCheckout)
+		mux.Get("/booking-summary", handlers.Repo.BookingSummary)
+
+		mux.Get("/manage-booking", handlers.Repo.ManageBooking)
+		mux.Post("/manage-booking", handlers.Repo.PostManageBooking)
+		mux.Get("/manage-booking/reservation", handlers.Repo.ManageBookingReservation)
+		mux.Post("/manage-booking/contact", handlers.Repo.PostManageBookingContact)
+		mux.Post("/manage-booking/dates", handlers.Repo.PostManageBookingDates)
+		mux.Post("/manage-booking/cancel", handlers.Repo.PostManageBookingCancel)
+
+		mux.Get("/user/login", handlers.Repo.ShowLogin)
+		mux.Post("/user/login", handlers.Repo.PostShowLogin)
+		mux.Get("/user/login/two-factor", handlers.Repo.ShowLoginTwoFactor)
+		mux.Post("/user/login/two-factor", handlers.Repo.PostLoginTwoFactor)
+		mux.Get("/user/logout", handlers.Repo.Logout)
+		mux.Get("/user/forgot-password", handlers.Repo.ForgotPassword)
+		mux.Post("/user/forgot-password", handlers.Repo.PostForgotPassword)
+		mux.Get("/user/reset-password", handlers.Repo.ResetPassword)
+		mux.Post("/user/reset-password", handlers.Repo.PostResetPassword)
+
+		fileServer := http.FileServer(http.Dir("./static/"))
+		mux.Handle("/static/*", http.StripPrefix("/static", fileServer))
+		mux.Handle("/media/*", http.StripPrefix("/media", a.Media.Handler()))
+
+		mux.Route("/admin", func(r chi.Router) {
+			r.Use(Auth)
+
+			// every user can set up their own two-factor authentication
+			r.Get("/two-factor", handlers.Repo.AdminTwoFactor)
+			r.Post("/two-factor/enable", handlers.Repo.AdminPostEnableTwoFactor)
+			r.Post("/two-factor/disable", handlers.Repo.AdminPostDisableTwoFactor)
+			r.Post("/two-factor/recovery-codes", handlers.Repo.AdminPostRecoveryCodes)
+
+			r.Group(func(r chi.Router) {
+				r.Use(RequireTwoFactor)
+
+				// staff can view reservations and check guests in and out
+				r.Get("/dashboard", handlers.Repo.AdminDashboard)
+				r.Get("/reservations-new", handlers.Repo.AdminNewReservations)
+				r.Get("/reservations-all", handlers.Repo.AdminAllReservations)
+				r.Get("/reservations-calendar", handlers.Repo.AdminReservationsCalendar)
+				r.Post("/reservations/{src}/{id}/status", handlers.Repo.AdminTransitionReservation)
+				r.Get("/reservations/{src}/{id}", handlers.Repo.AdminShowReservation)
+
+				// managers can change reservations and block rooms
+				r.Group(func(r chi.Router) {
+					r.Use(RequireAccessLevel(models.AccessLevelManager))
+
+					r.Post("/reservations-calendar", handlers.Repo.AdminPostReservationsCalendar)
+					r.Post("/reservations/{src}/{id}", handlers.Repo.AdminPostShowReservation)
+				})
+
+				// only admins can cancel, delete and restore reservations, manage API keys, users, rooms, pricing,
+				// stay rules, cancellation policies and calendars, and browse the audit log
+				r.Group(func(r chi.Router) {
+					r.Use(RequireAccessLevel(models.AccessLevelAdmin))
+
+					r.Post("/reservations/{src}/{id}/cancel", handlers.Repo.AdminCancelReservation)
+					r.Post("/reservations/{src}/{id}/delete", handlers.Repo.AdminDeleteReservation)
+					r.Get("/reservations-trash", handlers.Repo.AdminTrash)
+					r.Post("/reservations-trash/{id}/restore", handlers.Repo.AdminRestoreReservation)
+
+					r.Get("/api-keys", handlers.Repo.AdminAPIKeys)
+					r.Post("/api-keys", handlers.Repo.AdminPostAPIKey)
+					r.Post("/api-keys/{id}/revoke", handlers.Repo.AdminRevokeAPIKey)
+
+					r.Get("/users", handlers.Repo.AdminUsers)
+					r.Get("/users/new", handlers.Repo.AdminNewUser)
+					r.Post("/users/new", handlers.Repo.AdminPostNewUser)
+					r.Get("/users/{id}", handlers.Repo.AdminShowUser)
+					r.Post("/users/{id}", handlers.Repo.AdminPostShowUser)
+					r.Post("/users/{id}/password", handlers.Repo.AdminPostUserPassword)
+					r.Post("/users/{id}/deactivate", handlers.Repo.AdminDeactivateUser)
+					r.Post("/users/{id}/activate", handlers.Repo.AdminActivateUser)
+					r.Post("/users/{id}/two-factor/reset", handlers.Repo.AdminResetUserTwoFactor)
+					r.Post("/users/two-factor-policy", handlers.Repo.AdminPostTwoFactorPolicy)
+
+					r.Get("/locked-accounts", handlers.Repo.AdminLockedAccounts)
+					r.Post("/locked-accounts/unlock", handlers.Repo.AdminUnlockAccount)
 
-	mux.Get("/contact", handlers.Repo.Contact)
+					r.Get("/audit-log", handlers.Repo.AdminAuditLog)
 
-	mux.Get("/make-reservation", handlers.Repo.Reservation)
-	mux.Post("/make-reservation", handlers.Repo.PostReservation)
-	mux.Get("/reservation-summary", handlers.Repo.ReservationSummary)
+					r.Get("/mail-queue", handlers.Repo.AdminMailQueue)
+					r.Post("/mail-queue/{id}/resend", handlers.Repo.AdminResendMail)
 
-	mux.Get("/user/login", handlers.Repo.ShowLogin)
-	mux.Post("/user/login", handlers.Repo.PostShowLogin)
-	mux.Get("/user/logout", handlers.Repo.Logout)
+					r.Get("/rooms", handlers.Repo.AdminRooms)
+					r.Get("/rooms/new", handlers.Repo.AdminNewRoom)
+					r.Post("/rooms/new", handlers.Repo.AdminPostNewRoom)
+					r.Get("/rooms/{id}", handlers.Repo.AdminShowRoom)
+					r.Post("/rooms/{id}", handlers.Repo.AdminPostShowRoom)
+					r.Post("/rooms/{id}/delete", handlers.Repo.AdminDeleteRoom)
+					r.Post("/rooms/{id}/photos", handlers.Repo.AdminPostRoomPhoto)
+					r.Post("/rooms/{id}/photos/{photoID}/delete", handlers.Repo.AdminDeleteRoomPhoto)
+					r.Post("/rooms/{id}/photos/{photoID}/move", handlers.Repo.AdminMoveRoomPhoto)
 
-	fileServer := http.FileServer(http.Dir("./static/"))
-	mux.Handle("/static/*", http.StripPrefix("/static", fileServer))
+					r.Get("/pricing", handlers.Repo.AdminPricing)
+					r.Post("/pricing/seasons", handlers.Repo.AdminPostSeasonalRate)
+					r.Post("/pricing/seasons/{id}/delete", handlers.Repo.AdminDeleteSeasonalRate)
+					r.Post("/pricing/discounts", handlers.Repo.AdminPostStayDiscount)
+					r.Post("/pricing/discounts/{id}/delete", handlers.Repo.AdminDeleteStayDiscount)
+					r.Post("/pricing/fees", handlers.Repo.AdminPostFee)
+					r.Post("/pricing/fees/{id}/delete", handlers.Repo.AdminDeleteFee)
 
-	mux.Route("/admin", func(r chi.Router) {
-		// r.Use(Auth)
+					r.Get("/stay-rules", handlers.Repo.AdminStayRules)
+					r.Post("/stay-rules", handlers.Repo.AdminPostStayRule)
+					r.Post("/stay-rules/{id}/delete", handlers.Repo.AdminDeleteStayRule)
 
-		r.Get("/dashboard", handlers.Repo.AdminDashboard)
-		r.Get("/reservations-new", handlers.Repo.AdminNewReservations)
-		r.Get("/reservations-all", handlers.Repo.AdminAllReservations)
-		r.Get("/reservations-calendar", handlers.Repo.AdminReservationsCalendar)
-		r.Get("/process-reservation/{src}/{id}", handlers.Repo.AdminProcessReservation)
-		r.Get("/delete-reservation/{src}/{id}", handlers.Repo.AdminDeleteReservation)
+					r.Get("/cancellation-policies", handlers.Repo.AdminCancellationPolicies)
+					r.Post("/cancellation-policies", handlers.Repo.AdminPostCancellationPolicy)
+					r.Post("/cancellation-policies/{id}/delete", handlers.Repo.AdminDeleteCancellationPolicy)
 
-		r.Get("/reservations/{src}/{id}", handlers.Repo.AdminShowReservation)
-		r.Post("/reservations/{src}/{id}", handlers.Repo.AdminPostShowReservation)
+					r.Get("/calendars", handlers.Repo.AdminCalendars)
+					r.Post("/calendars", handlers.Repo.AdminPostRoomCalendar)
+					r.Post("/calendars/rooms/{id}/token", handlers.Repo.AdminPostRoomCalendarToken)
+					r.Post("/calendars/{id}/sync", handlers.Repo.AdminSyncRoomCalendar)
+					r.Post("/calendars/{id}/delete", handlers.Repo.AdminDeleteRoomCalendar)
+				})
+			})
+		})
 	})
 
 	return mux
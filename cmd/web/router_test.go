@@ -4,11 +4,12 @@ import (
 	"testing"
 
 	"github.com/dhanekom/bookings/internal/config"
+	"github.com/dhanekom/bookings/internal/media"
 	"github.com/go-chi/chi/v5"
 )
 
 func TestRoutes(t *testing.T) {
-	var app *config.AppConfig
+	app := &config.AppConfig{Media: media.New(t.TempDir())}
 	handler := routes(app)
 
 	switch v := handler.(type) {
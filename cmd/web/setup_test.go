@@ -1,12 +1,27 @@
 package main
 
 import (
+	"log"
 	"net/http"
 	"os"
 	"testing"
+
+	"github.com/alexedwards/scs/v2"
+	"github.com/dhanekom/bookings/internal/handlers"
+	"github.com/dhanekom/bookings/internal/helpers"
+	"github.com/dhanekom/bookings/internal/repository/dbrepo"
 )
 
 func TestMain(m *testing.M) {
+	app.InfoLog = log.New(os.Stdout, "INFO\t", log.Ldate|log.Ltime)
+	app.ErrorLog = log.New(os.Stdout, "ERROR\t", log.Ldate|log.Ltime|log.Lshortfile)
+
+	session = scs.New()
+	app.Session = session
+
+	helpers.NewHelpers(&app)
+	handlers.NewRepo(&app, dbrepo.NewTestDBRepo(&app))
+
 	os.Exit(m.Run())
 }
 
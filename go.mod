@@ -11,6 +11,7 @@ require (
 	github.com/jackc/pgx/v4 v4.12.0
 	github.com/justinas/nosurf v1.1.1
 	github.com/pkg/errors v0.9.1 // indirect
+	github.com/skip2/go-qrcode v0.0.0-20200617195104-da1b6568686e
 	github.com/xhit/go-simple-mail/v2 v2.10.0
 	golang.org/x/crypto v0.0.0-20210616213533-5ff15b29337e
 	gopkg.in/yaml.v3 v3.0.0-20210107192922-496545a6307b // indirect
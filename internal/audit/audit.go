@@ -0,0 +1,159 @@
+// Package audit describes changes to reservations, users, owner blocks, calendars and payments for the audit
+// log, as the fields that changed with their values before and after
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/dhanekom/bookings/internal/models"
+)
+
+// Fields holds the audited fields of an entity by name. Values are strings, numbers or booleans
+type Fields map[string]interface{}
+
+// Reservation returns the audited fields of r
+func Reservation(r models.Reservation) Fields {
+	return Fields{
+		"first_name":          r.FirstName,
+		"last_name":           r.LastName,
+		"email":               r.Email,
+		"phone":               r.Phone,
+		"start_date":          r.StartDate.Format("2006-01-02"),
+		"end_date":            r.EndDate.Format("2006-01-02"),
+		"room_id":             r.RoomID,
+		"adults":              r.Adults,
+		"children":            r.Children,
+		"total_price":         r.Quote.Total,
+		"status":              r.Status,
+		"cancellation_reason": r.CancellationReason,
+		"cancellation_fee":    r.CancellationFee,
+	}
+}
+
+// User returns the audited fields of u. Passwords are never audited
+func User(u models.User) Fields {
+	return Fields{
+		"first_name":   u.FirstName,
+		"last_name":    u.LastName,
+		"email":        u.Email,
+		"access_level": u.AccessLevel,
+		"active":       u.DeactivatedAt.IsZero(),
+		"two_factor":   !u.TOTPEnabledAt.IsZero(),
+	}
+}
+
+// Block returns the audited fields of the owner block r
+func Block(r models.RoomRestriction) Fields {
+	return Fields{
+		"room_id":    r.RoomID,
+		"start_date": r.StartDate.Format("2006-01-02"),
+		"end_date":   r.EndDate.Format("2006-01-02"),
+	}
+}
+
+// Calendar returns the audited fields of the external calendar c
+func Calendar(c models.RoomCalendar) Fields {
+	return Fields{
+		"room_id": c.RoomID,
+		"name":    c.Name,
+		"url":     c.URL,
+	}
+}
+
+// Payment returns the audited fields of the payment or refund p
+func Payment(p models.Payment) Fields {
+	return Fields{
+		"reservation_id": p.ReservationID,
+		"kind":           p.Kind,
+		"status":         p.Status,
+		"amount":         p.Amount,
+		"currency":       p.Currency,
+	}
+}
+
+// APIKey returns the audited fields of the API key k. The key itself is never audited
+func APIKey(k models.APIKey) Fields {
+	return Fields{
+		"name": k.Name,
+	}
+}
+
+// Diff returns the fields whose values differ between before and after, with their values before and after.
+// Everything in after is new if before is nil, and everything in before was removed if after is nil
+func Diff(before, after Fields) (Fields, Fields) {
+	changedBefore, changedAfter := Fields{}, Fields{}
+
+	for k, v := range before {
+		if a, ok := after[k]; !ok || !reflect.DeepEqual(v, a) {
+			changedBefore[k] = v
+		}
+	}
+
+	for k, v := range after {
+		if b, ok := before[k]; !ok || !reflect.DeepEqual(v, b) {
+			changedAfter[k] = v
+		}
+	}
+
+	return changedBefore, changedAfter
+}
+
+// Change is the change of one field, as shown in the audit log. Before is empty for fields that were added, and
+// After for fields that were removed
+type Change struct {
+	Field  string
+	Before string
+	After  string
+}
+
+// Changes returns the changes recorded by the JSON objects before and after of an audit entry, in order of
+// field name
+func Changes(before, after string) ([]Change, error) {
+	b, err := decode(before)
+	if err != nil {
+		return nil, err
+	}
+
+	a, err := decode(after)
+	if err != nil {
+		return nil, err
+	}
+
+	var changes []Change
+	for k, v := range b {
+		c := Change{Field: k, Before: fmt.Sprint(v)}
+		if v, ok := a[k]; ok {
+			c.After = fmt.Sprint(v)
+		}
+		changes = append(changes, c)
+	}
+	for k, v := range a {
+		if _, ok := b[k]; !ok {
+			changes = append(changes, Change{Field: k, After: fmt.Sprint(v)})
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Field < changes[j].Field
+	})
+
+	return changes, nil
+}
+
+// decode decodes a JSON object of fields, keeping numbers as they were written
+func decode(s string) (Fields, error) {
+	var f Fields
+	if s == "" {
+		return f, nil
+	}
+
+	d := json.NewDecoder(strings.NewReader(s))
+	d.UseNumber()
+	err := d.Decode(&f)
+
+	return f, err
+}
@@ -0,0 +1,83 @@
+package audit
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/dhanekom/bookings/internal/models"
+)
+
+func TestDiff(t *testing.T) {
+	res := models.Reservation{
+		FirstName: "John",
+		Email:     "john@smith.com",
+		StartDate: time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC),
+		Status:    models.ReservationConfirmed,
+	}
+
+	changed := res
+	changed.Email = "jane@smith.com"
+	changed.Status = models.ReservationCheckedIn
+
+	var tests = []struct {
+		name           string
+		before         Fields
+		after          Fields
+		expectedBefore Fields
+		expectedAfter  Fields
+	}{
+		{"unchanged", Reservation(res), Reservation(res), Fields{}, Fields{}},
+		{"changed", Reservation(res), Reservation(changed),
+			Fields{"email": "john@smith.com", "status": "confirmed"},
+			Fields{"email": "jane@smith.com", "status": "checked-in"}},
+		{"created", nil, Fields{"email": "john@smith.com"}, Fields{}, Fields{"email": "john@smith.com"}},
+		{"deleted", Fields{"email": "john@smith.com"}, nil, Fields{"email": "john@smith.com"}, Fields{}},
+	}
+
+	for _, e := range tests {
+		before, after := Diff(e.before, e.after)
+		if !reflect.DeepEqual(before, e.expectedBefore) || !reflect.DeepEqual(after, e.expectedAfter) {
+			t.Errorf("%s: expected %v to %v, got %v to %v", e.name, e.expectedBefore, e.expectedAfter, before, after)
+		}
+	}
+}
+
+func TestUser(t *testing.T) {
+	u := User(models.User{Email: "admin@here.com", Password: "secret", DeactivatedAt: time.Now()})
+
+	if u["active"] != false || u["two_factor"] != false {
+		t.Errorf("unexpected fields %v", u)
+	}
+
+	for _, v := range u {
+		if v == "secret" {
+			t.Error("expected the password not to be audited")
+		}
+	}
+}
+
+func TestChanges(t *testing.T) {
+	before, _ := json.Marshal(Fields{"status": "confirmed", "total_price": 1000000, "active": true})
+	after, _ := json.Marshal(Fields{"status": "checked-in", "total_price": 1200000, "phone": "555-1234"})
+
+	changes, err := Changes(string(before), string(after))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []Change{
+		{"active", "true", ""},
+		{"phone", "", "555-1234"},
+		{"status", "confirmed", "checked-in"},
+		{"total_price", "1000000", "1200000"},
+	}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Errorf("expected %v, got %v", expected, changes)
+	}
+
+	if _, err := Changes("not json", ""); err == nil {
+		t.Error("expected invalid JSON to fail")
+	}
+}
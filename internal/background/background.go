@@ -0,0 +1,60 @@
+// Package background runs the application's periodic jobs, such as sweeping expired holds or sending queued mail,
+// and provides the clock they tell the time by
+package background
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// Clock tells the time
+type Clock interface {
+	Now() time.Time
+}
+
+// SystemClock tells the system time
+type SystemClock struct{}
+
+// Now returns the current system time
+func (SystemClock) Now() time.Time {
+	return time.Now()
+}
+
+// Job is work that is done in the background at a fixed interval
+type Job struct {
+	// Run does the work
+	Run func(ctx context.Context) error
+	// Interval is the time between runs
+	Interval time.Duration
+	// Trigger, if it isn't nil, runs the job early whenever it receives
+	Trigger <-chan struct{}
+	// ErrorLog logs runs that failed while the job was running. Nothing is logged if it is nil
+	ErrorLog *log.Logger
+}
+
+// Start runs j straight away, and then whenever it is triggered or Interval has passed, until ctx is done. wg is
+// done once j has stopped
+func (j Job) Start(ctx context.Context, wg *sync.WaitGroup) {
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		ticker := time.NewTicker(j.Interval)
+		defer ticker.Stop()
+
+		for {
+			if err := j.Run(ctx); err != nil && j.ErrorLog != nil && ctx.Err() == nil {
+				j.ErrorLog.Println(err)
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-j.Trigger:
+			case <-ticker.C:
+			}
+		}
+	}()
+}
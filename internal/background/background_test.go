@@ -0,0 +1,132 @@
+package background
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// counter counts the runs of a job, which fail with fail
+type counter struct {
+	mu   sync.Mutex
+	runs int
+	fail error
+}
+
+func (c *counter) run(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.runs++
+	return c.fail
+}
+
+func (c *counter) count() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.runs
+}
+
+// waitFor waits up to 5 seconds for the job to have run at least n times
+func (c *counter) waitFor(n int) {
+	deadline := time.Now().Add(5 * time.Second)
+	for c.count() < n && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// syncBuffer is a buffer that is safe for the job's logger to write to while the test reads it
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.buf.String()
+}
+
+func TestJobRunsEveryIntervalUntilDone(t *testing.T) {
+	c := &counter{}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	Job{Run: c.run, Interval: 10 * time.Millisecond}.Start(ctx, &wg)
+
+	c.waitFor(3)
+	cancel()
+	wg.Wait()
+
+	runs := c.count()
+	if runs < 3 {
+		t.Errorf("expected repeated runs, got %d", runs)
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	if c.count() != runs {
+		t.Error("expected no runs once the job was stopped")
+	}
+}
+
+func TestJobRunsWhenTriggered(t *testing.T) {
+	c := &counter{}
+	trigger := make(chan struct{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	Job{Run: c.run, Interval: time.Hour, Trigger: trigger}.Start(ctx, &wg)
+
+	c.waitFor(1)
+	trigger <- struct{}{}
+	c.waitFor(2)
+
+	cancel()
+	wg.Wait()
+
+	if runs := c.count(); runs != 2 {
+		t.Errorf("expected a run at the start and when triggered, got %d", runs)
+	}
+}
+
+func TestJobLogsFailedRuns(t *testing.T) {
+	c := &counter{fail: errors.New("store unavailable")}
+	var buf syncBuffer
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	Job{Run: c.run, Interval: time.Hour, ErrorLog: log.New(&buf, "", 0)}.Start(ctx, &wg)
+
+	// the failure is logged after the run, so wait for it rather than for the run
+	deadline := time.Now().Add(5 * time.Second)
+	for !strings.Contains(buf.String(), "store unavailable") && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	cancel()
+	wg.Wait()
+
+	if !strings.Contains(buf.String(), "store unavailable") {
+		t.Errorf("expected the failed run to be logged, got %q", buf.String())
+	}
+}
+
+func TestSystemClock(t *testing.T) {
+	if d := time.Since(SystemClock{}.Now()); d < 0 || d > time.Minute {
+		t.Errorf("expected the current time, got one %s away", d)
+	}
+}
@@ -0,0 +1,215 @@
+// Package calsync imports the events of external iCalendar feeds, such as those of booking channels, as
+// external bookings of rooms
+package calsync
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/dhanekom/bookings/internal/background"
+	"github.com/dhanekom/bookings/internal/ical"
+	"github.com/dhanekom/bookings/internal/models"
+)
+
+// ErrTooLarge is returned when a feed is larger than the syncer accepts
+var ErrTooLarge = errors.New("calendar is too large")
+
+// Store holds external calendars and the bookings imported from them
+type Store interface {
+	// AllRoomCalendars returns all external calendars
+	AllRoomCalendars() ([]models.RoomCalendar, error)
+	// ReplaceExternalBookings replaces the bookings imported from a calendar and returns how many of them
+	// overlap a reservation
+	ReplaceExternalBookings(ctx context.Context, calendarID int, bookings []models.RoomRestriction) (int, error)
+	// UpdateRoomCalendarSync records the outcome of the last sync of a calendar
+	UpdateRoomCalendarSync(c models.RoomCalendar) error
+}
+
+// Syncer polls external calendars and stores their events as external bookings
+type Syncer struct {
+	Store  Store
+	Client *http.Client
+	Clock  background.Clock
+	// Interval is the time between syncs of all calendars
+	Interval time.Duration
+	// MaxSize is the largest feed in bytes that is accepted
+	MaxSize int64
+	// ErrorLog logs calendars that failed to sync. Nothing is logged if it is nil
+	ErrorLog *log.Logger
+	// Replaced, if it isn't nil, is called whenever the bookings of a calendar have been replaced, as nights
+	// they blocked may have been freed
+	Replaced func()
+}
+
+// New returns a syncer with default settings. A nil clock uses the system time
+func New(store Store, clock background.Clock) *Syncer {
+	if clock == nil {
+		clock = background.SystemClock{}
+	}
+
+	return &Syncer{
+		Store:    store,
+		Client:   &http.Client{Timeout: 30 * time.Second},
+		Clock:    clock,
+		Interval: 15 * time.Minute,
+		MaxSize:  5 << 20,
+	}
+}
+
+// ValidateURL returns an error if raw isn't an absolute http or https URL
+func ValidateURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("must be an http or https URL")
+	}
+
+	return nil
+}
+
+// Sync imports the events of c. If the calendar can't be fetched or parsed, the bookings imported before
+// are kept and the error is recorded on the calendar
+func (s *Syncer) Sync(ctx context.Context, c models.RoomCalendar) error {
+	bookings, err := s.fetch(ctx, c.URL)
+	c.LastSyncedAt = s.Clock.Now()
+
+	if err == nil {
+		var conflicts int
+		conflicts, err = s.Store.ReplaceExternalBookings(ctx, c.ID, bookings)
+		if err == nil {
+			c.EventCount = len(bookings)
+			c.Conflicts = conflicts
+
+			if s.Replaced != nil {
+				s.Replaced()
+			}
+		}
+	}
+
+	c.LastError = ""
+	if err != nil {
+		c.LastError = err.Error()
+	}
+
+	if updateErr := s.Store.UpdateRoomCalendarSync(c); updateErr != nil && err == nil {
+		err = updateErr
+	}
+
+	return err
+}
+
+// SyncAll imports the events of all calendars. A calendar that fails to sync doesn't stop the others from
+// being synced
+func (s *Syncer) SyncAll(ctx context.Context) error {
+	calendars, err := s.Store.AllRoomCalendars()
+	if err != nil {
+		return err
+	}
+
+	for _, c := range calendars {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		err := s.Sync(ctx, c)
+		if err != nil && s.ErrorLog != nil {
+			s.ErrorLog.Printf("calendar %d (%s) of room %d failed to sync: %s", c.ID, c.Name, c.RoomID, err)
+		}
+	}
+
+	return nil
+}
+
+// Start syncs all calendars straight away and then every Interval until ctx is done. The returned wait group
+// is done once syncing has stopped
+func (s *Syncer) Start(ctx context.Context) *sync.WaitGroup {
+	var wg sync.WaitGroup
+	background.Job{
+		Run:      s.SyncAll,
+		Interval: s.Interval,
+		ErrorLog: s.ErrorLog,
+	}.Start(ctx, &wg)
+
+	return &wg
+}
+
+// fetch downloads and parses a calendar and returns its events as bookings
+func (s *Syncer) fetch(ctx context.Context, rawURL string) ([]models.RoomRestriction, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", ical.ContentType)
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("calendar returned %s", resp.Status)
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, s.MaxSize+1))
+	if err != nil {
+		return nil, err
+	}
+
+	if int64(len(body)) > s.MaxSize {
+		return nil, ErrTooLarge
+	}
+
+	events, err := ical.Parse(bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	return Bookings(events, s.Clock.Now()), nil
+}
+
+// Bookings returns the nights blocked by events that haven't ended by now. Cancelled events are skipped.
+// Events are rounded to whole days in their own time zone and an event within a single day blocks that night
+func Bookings(events []ical.Event, now time.Time) []models.RoomRestriction {
+	today := date(now)
+
+	var bookings []models.RoomRestriction
+	for _, e := range events {
+		if e.Status == ical.StatusCancelled || e.Start.IsZero() {
+			continue
+		}
+
+		start := date(e.Start)
+		end := date(e.End)
+		if !end.After(start) {
+			end = start.AddDate(0, 0, 1)
+		}
+
+		if !end.After(today) {
+			continue
+		}
+
+		bookings = append(bookings, models.RoomRestriction{
+			StartDate:     start,
+			EndDate:       end,
+			RestrictionID: models.RestrictionExternalBooking,
+			ExternalUID:   e.UID,
+		})
+	}
+
+	return bookings
+}
+
+// date returns the date of t, without its time of day
+func date(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
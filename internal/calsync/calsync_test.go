@@ -0,0 +1,292 @@
+package calsync
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dhanekom/bookings/internal/ical"
+	"github.com/dhanekom/bookings/internal/models"
+)
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (c fakeClock) Now() time.Time {
+	return c.now
+}
+
+// fakeStore holds calendars and their bookings in memory. Bookings starting on the reserved date conflict
+type fakeStore struct {
+	mu        sync.Mutex
+	calendars []models.RoomCalendar
+	bookings  map[int][]models.RoomRestriction
+	reserved  time.Time
+	fail      error
+}
+
+func (s *fakeStore) AllRoomCalendars() ([]models.RoomCalendar, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]models.RoomCalendar(nil), s.calendars...), nil
+}
+
+func (s *fakeStore) ReplaceExternalBookings(ctx context.Context, calendarID int, bookings []models.RoomRestriction) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.fail != nil {
+		return 0, s.fail
+	}
+
+	conflicts := 0
+	for _, b := range bookings {
+		if b.StartDate.Equal(s.reserved) {
+			conflicts++
+		}
+	}
+
+	s.bookings[calendarID] = bookings
+	return conflicts, nil
+}
+
+func (s *fakeStore) UpdateRoomCalendarSync(c models.RoomCalendar) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.calendars {
+		if s.calendars[i].ID == c.ID {
+			s.calendars[i] = c
+		}
+	}
+
+	return nil
+}
+
+func (s *fakeStore) calendar(id int) models.RoomCalendar {
+	calendars, _ := s.AllRoomCalendars()
+	for _, c := range calendars {
+		if c.ID == id {
+			return c
+		}
+	}
+
+	return models.RoomCalendar{}
+}
+
+const testFeed = "BEGIN:VCALENDAR\r\n" +
+	"VERSION:2.0\r\n" +
+	"BEGIN:VEVENT\r\nUID:past@channel\r\nDTSTART;VALUE=DATE:20491201\r\nDTEND;VALUE=DATE:20491205\r\nEND:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\nUID:current@channel\r\nDTSTART;VALUE=DATE:20491230\r\nDTEND;VALUE=DATE:20500102\r\nEND:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\nUID:future@channel\r\nDTSTART;VALUE=DATE:20500110\r\nDTEND;VALUE=DATE:20500112\r\nEND:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\nUID:cancelled@channel\r\nDTSTART;VALUE=DATE:20500115\r\nDTEND;VALUE=DATE:20500116\r\nSTATUS:CANCELLED\r\nEND:VEVENT\r\n" +
+	"END:VCALENDAR\r\n"
+
+var testNow = time.Date(2050, 1, 1, 12, 0, 0, 0, time.UTC)
+
+// newTestServer serves testFeed at /feed.ics, fails at /error.ics and serves a web page anywhere else
+func newTestServer(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/feed.ics":
+			w.Header().Set("Content-Type", ical.ContentTypeFor(""))
+			w.Write([]byte(testFeed))
+		case "/error.ics":
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		default:
+			w.Write([]byte("<html><body>Log in</body></html>"))
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv
+}
+
+func newTestSyncer(srv *httptest.Server, calendars ...models.RoomCalendar) (*Syncer, *fakeStore) {
+	store := &fakeStore{
+		calendars: calendars,
+		bookings:  make(map[int][]models.RoomRestriction),
+		reserved:  time.Date(2050, 1, 10, 0, 0, 0, 0, time.UTC),
+	}
+
+	s := New(store, fakeClock{now: testNow})
+	s.Client = srv.Client()
+
+	return s, store
+}
+
+func TestSync(t *testing.T) {
+	srv := newTestServer(t)
+	c := models.RoomCalendar{ID: 1, RoomID: 1, Name: "Channel", URL: srv.URL + "/feed.ics"}
+	s, store := newTestSyncer(srv, c)
+
+	replaced := 0
+	s.Replaced = func() { replaced++ }
+
+	if err := s.Sync(context.Background(), c); err != nil {
+		t.Fatal(err)
+	}
+
+	if replaced != 1 {
+		t.Errorf("expected to be told the bookings were replaced once, got %d", replaced)
+	}
+
+	bookings := store.bookings[1]
+	if len(bookings) != 2 {
+		t.Fatalf("expected the current and future events to be imported, got %+v", bookings)
+	}
+
+	var tests = []struct {
+		name     string
+		got      interface{}
+		expected interface{}
+	}{
+		{"uid", bookings[0].ExternalUID, "current@channel"},
+		{"start", bookings[0].StartDate, time.Date(2049, 12, 30, 0, 0, 0, 0, time.UTC)},
+		{"end", bookings[0].EndDate, time.Date(2050, 1, 2, 0, 0, 0, 0, time.UTC)},
+		{"restriction", bookings[1].RestrictionID, models.RestrictionExternalBooking},
+		{"event count", store.calendar(1).EventCount, 2},
+		{"conflicts", store.calendar(1).Conflicts, 1},
+		{"synced at", store.calendar(1).LastSyncedAt, testNow},
+		{"no error", store.calendar(1).LastError, ""},
+	}
+
+	for _, e := range tests {
+		if e.got != e.expected {
+			t.Errorf("%s: expected %v, got %v", e.name, e.expected, e.got)
+		}
+	}
+}
+
+func TestSyncFailureKeepsBookings(t *testing.T) {
+	srv := newTestServer(t)
+	c := models.RoomCalendar{ID: 1, RoomID: 1, Name: "Channel", URL: srv.URL + "/feed.ics"}
+	s, store := newTestSyncer(srv, c)
+
+	if err := s.Sync(context.Background(), c); err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = []struct {
+		name     string
+		url      string
+		maxSize  int64
+		storeErr error
+		expected string
+	}{
+		{"http error", srv.URL + "/error.ics", 0, nil, "503"},
+		{"not a calendar", srv.URL + "/login", 0, nil, ical.ErrNotCalendar.Error()},
+		{"too large", srv.URL + "/feed.ics", 100, nil, ErrTooLarge.Error()},
+		{"unreachable", "http://127.0.0.1:1/feed.ics", 0, nil, "connect"},
+		{"store error", srv.URL + "/feed.ics", 0, errors.New("database is down"), "database is down"},
+	}
+
+	for _, e := range tests {
+		c := store.calendar(1)
+		c.URL = e.url
+
+		s.MaxSize = 5 << 20
+		if e.maxSize > 0 {
+			s.MaxSize = e.maxSize
+		}
+		store.fail = e.storeErr
+
+		s.Replaced = func() { t.Errorf("%s: expected not to be told the bookings were replaced", e.name) }
+		err := s.Sync(context.Background(), c)
+		if err == nil {
+			t.Errorf("%s: expected an error", e.name)
+			continue
+		}
+
+		got := store.calendar(1)
+		if !strings.Contains(got.LastError, e.expected) {
+			t.Errorf("%s: expected the error %q to be recorded, got %q", e.name, e.expected, got.LastError)
+		}
+
+		if len(store.bookings[1]) != 2 || got.EventCount != 2 || got.Conflicts != 1 {
+			t.Errorf("%s: expected the bookings from the last sync to be kept", e.name)
+		}
+	}
+}
+
+func TestSyncAll(t *testing.T) {
+	srv := newTestServer(t)
+	s, store := newTestSyncer(srv,
+		models.RoomCalendar{ID: 1, RoomID: 1, URL: srv.URL + "/error.ics"},
+		models.RoomCalendar{ID: 2, RoomID: 2, URL: srv.URL + "/feed.ics"},
+	)
+
+	if err := s.SyncAll(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if store.calendar(1).LastError == "" {
+		t.Error("expected the failed calendar to record its error")
+	}
+
+	if len(store.bookings[2]) != 2 {
+		t.Error("expected a failed calendar not to stop the others from syncing")
+	}
+}
+
+func TestBookings(t *testing.T) {
+	sast := time.FixedZone("SAST", 2*60*60)
+	events := []ical.Event{
+		{UID: "timed", Start: time.Date(2050, 2, 1, 14, 0, 0, 0, sast), End: time.Date(2050, 2, 3, 10, 0, 0, 0, sast)},
+		{UID: "same day", Start: time.Date(2050, 2, 5, 9, 0, 0, 0, sast), End: time.Date(2050, 2, 5, 11, 0, 0, 0, sast)},
+		{UID: "ends today", Start: time.Date(2049, 12, 30, 0, 0, 0, 0, time.UTC), End: time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{UID: "no start"},
+	}
+
+	bookings := Bookings(events, testNow)
+	if len(bookings) != 2 {
+		t.Fatalf("expected 2 bookings, got %+v", bookings)
+	}
+
+	var tests = []struct {
+		name     string
+		got      time.Time
+		expected time.Time
+	}{
+		{"timed start", bookings[0].StartDate, time.Date(2050, 2, 1, 0, 0, 0, 0, time.UTC)},
+		{"timed end", bookings[0].EndDate, time.Date(2050, 2, 3, 0, 0, 0, 0, time.UTC)},
+		{"same day start", bookings[1].StartDate, time.Date(2050, 2, 5, 0, 0, 0, 0, time.UTC)},
+		{"same day blocks the night", bookings[1].EndDate, time.Date(2050, 2, 6, 0, 0, 0, 0, time.UTC)},
+	}
+
+	for _, e := range tests {
+		if !e.got.Equal(e.expected) {
+			t.Errorf("%s: expected %v, got %v", e.name, e.expected, e.got)
+		}
+	}
+}
+
+func TestValidateURL(t *testing.T) {
+	var tests = []struct {
+		url   string
+		valid bool
+	}{
+		{"https://channel.example.com/room.ics?token=abc", true},
+		{"http://localhost:8080/feed.ics", true},
+		{"webcal://channel.example.com/room.ics", false},
+		{"file:///etc/passwd", false},
+		{"/feed.ics", false},
+		{"", false},
+	}
+
+	for _, e := range tests {
+		err := ValidateURL(e.url)
+		if (err == nil) != e.valid {
+			t.Errorf("%q: expected valid to be %t, got %v", e.url, e.valid, err)
+		}
+	}
+}
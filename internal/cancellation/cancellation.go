@@ -0,0 +1,110 @@
+// Package cancellation works out what cancelling a reservation costs under its cancellation policy, and how
+// much of what the guest paid is refunded
+package cancellation
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/dhanekom/bookings/internal/models"
+)
+
+// Settlement is what a guest owes and gets back when they cancel a reservation. Amounts are in cents
+type Settlement struct {
+	// Paid is what the guest paid for the reservation, less what was already refunded
+	Paid int
+	// Fee is what cancelling costs under the policy
+	Fee int
+	// Refund is what is paid back, which is what was paid less the fee
+	Refund int
+}
+
+// Due returns what the guest still owes if the fee is more than they paid
+func (s Settlement) Due() int {
+	if s.Fee > s.Paid {
+		return s.Fee - s.Paid
+	}
+	return 0
+}
+
+// Fee returns what cancelling a stay with a price of total that arrives on arrival costs on now. Without a
+// policy, cancelling is free until the day of arrival
+func Fee(p models.CancellationPolicy, total int, arrival, now time.Time) int {
+	before := days(dateOf(now), dateOf(arrival))
+
+	switch {
+	case before >= p.FreeDays && before > 0:
+		return 0
+	case before >= p.NonRefundableDays && before > 0:
+		return total * p.PenaltyPercent / 100
+	default:
+		return total
+	}
+}
+
+// Paid returns what was captured by payments, less what was refunded
+func Paid(payments []models.Payment) int {
+	paid := 0
+	for _, p := range payments {
+		switch {
+		case p.Kind == models.PaymentKindPayment && (p.Status == models.PaymentCaptured || p.Status == models.PaymentRefunded):
+			paid += p.Amount
+		case p.Kind == models.PaymentKindRefund && p.Status != models.PaymentFailed:
+			paid -= p.Amount
+		}
+	}
+
+	return paid
+}
+
+// Settle returns the settlement of cancelling res on now under policy p, given the payments made for it
+func Settle(p models.CancellationPolicy, res models.Reservation, payments []models.Payment, now time.Time) Settlement {
+	s := Settlement{
+		Paid: Paid(payments),
+		Fee:  Fee(p, res.Quote.Total, res.StartDate, now),
+	}
+
+	if s.Paid > s.Fee {
+		s.Refund = s.Paid - s.Fee
+	}
+
+	return s
+}
+
+// Describe summarises a policy for guests, such as "Free cancellation until 14 days before arrival, then 50%
+// of the price until 3 days before arrival, then non-refundable"
+func Describe(p models.CancellationPolicy) string {
+	s := "Free cancellation until " + until(p.FreeDays)
+	if p.NonRefundableDays < p.FreeDays {
+		s += fmt.Sprintf(", then %d%% of the price until %s", p.PenaltyPercent, until(p.NonRefundableDays))
+	}
+
+	return s + ", then non-refundable"
+}
+
+// until describes the day a number of days before arrival
+func until(n int) string {
+	if n == 0 {
+		return "the day of arrival"
+	}
+	return plural(n, "day") + " before arrival"
+}
+
+// days returns the number of days from one date to another
+func days(from, to time.Time) int {
+	return int(to.Sub(from) / (24 * time.Hour))
+}
+
+// plural returns n followed by unit, with an s if n isn't 1
+func plural(n int, unit string) string {
+	if n == 1 {
+		return fmt.Sprintf("1 %s", unit)
+	}
+	return fmt.Sprintf("%d %ss", n, unit)
+}
+
+// dateOf returns the date of t at midnight UTC, which is how dates are parsed and stored
+func dateOf(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
@@ -0,0 +1,95 @@
+package cancellation
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dhanekom/bookings/internal/models"
+)
+
+func date(day int) time.Time {
+	return time.Date(2050, 1, day, 0, 0, 0, 0, time.UTC)
+}
+
+var testPolicy = models.CancellationPolicy{FreeDays: 14, PenaltyPercent: 50, NonRefundableDays: 3}
+
+func TestFee(t *testing.T) {
+	arrival := date(31)
+
+	var tests = []struct {
+		name     string
+		policy   models.CancellationPolicy
+		now      time.Time
+		expected int
+	}{
+		{"well ahead", testPolicy, date(1), 0},
+		{"on the last free day", testPolicy, date(17), 0},
+		{"after the free days", testPolicy, date(18).Add(23 * time.Hour), 5000},
+		{"on the last penalty day", testPolicy, date(28), 5000},
+		{"non-refundable", testPolicy, date(29), 10000},
+		{"on arrival", testPolicy, date(31), 10000},
+		{"after arrival", testPolicy, time.Date(2050, 2, 1, 0, 0, 0, 0, time.UTC), 10000},
+		{"no policy", models.CancellationPolicy{}, date(30), 0},
+		{"no policy on arrival", models.CancellationPolicy{}, date(31), 10000},
+		{"no penalty days", models.CancellationPolicy{FreeDays: 7, PenaltyPercent: 50, NonRefundableDays: 7}, date(25), 10000},
+	}
+
+	for _, e := range tests {
+		fee := Fee(e.policy, 10000, arrival, e.now)
+		if fee != e.expected {
+			t.Errorf("%s: expected a fee of %d, got %d", e.name, e.expected, fee)
+		}
+	}
+}
+
+func TestSettle(t *testing.T) {
+	res := models.Reservation{StartDate: date(31), Quote: models.Quote{Total: 10000}}
+
+	captured := models.Payment{Kind: models.PaymentKindPayment, Status: models.PaymentCaptured, Amount: 2000}
+	refunded := models.Payment{Kind: models.PaymentKindRefund, Status: models.PaymentRefunded, Amount: 500}
+
+	var tests = []struct {
+		name     string
+		payments []models.Payment
+		now      time.Time
+		expected Settlement
+		due      int
+	}{
+		{"free", []models.Payment{captured}, date(1), Settlement{Paid: 2000, Fee: 0, Refund: 2000}, 0},
+		{"fee more than paid", []models.Payment{captured}, date(20), Settlement{Paid: 2000, Fee: 5000, Refund: 0}, 3000},
+		{"paid in full", []models.Payment{captured, {Kind: models.PaymentKindPayment, Status: models.PaymentCaptured, Amount: 8000}}, date(20), Settlement{Paid: 10000, Fee: 5000, Refund: 5000}, 0},
+		{"already refunded", []models.Payment{captured, refunded}, date(1), Settlement{Paid: 1500, Fee: 0, Refund: 1500}, 0},
+		{"not captured", []models.Payment{{Kind: models.PaymentKindPayment, Status: models.PaymentAuthorized, Amount: 2000}}, date(1), Settlement{}, 0},
+		{"failed refund", []models.Payment{captured, {Kind: models.PaymentKindRefund, Status: models.PaymentFailed, Amount: 500}}, date(1), Settlement{Paid: 2000, Fee: 0, Refund: 2000}, 0},
+		{"nothing paid", nil, date(30), Settlement{Paid: 0, Fee: 10000, Refund: 0}, 10000},
+	}
+
+	for _, e := range tests {
+		s := Settle(testPolicy, res, e.payments, e.now)
+		if s != e.expected {
+			t.Errorf("%s: expected %+v, got %+v", e.name, e.expected, s)
+		}
+
+		if s.Due() != e.due {
+			t.Errorf("%s: expected %d due, got %d", e.name, e.due, s.Due())
+		}
+	}
+}
+
+func TestDescribe(t *testing.T) {
+	var tests = []struct {
+		policy   models.CancellationPolicy
+		expected string
+	}{
+		{testPolicy, "Free cancellation until 14 days before arrival, then 50% of the price until 3 days before arrival, then non-refundable"},
+		{models.CancellationPolicy{FreeDays: 1, PenaltyPercent: 20}, "Free cancellation until 1 day before arrival, then 20% of the price until the day of arrival, then non-refundable"},
+		{models.CancellationPolicy{FreeDays: 7, NonRefundableDays: 7}, "Free cancellation until 7 days before arrival, then non-refundable"},
+		{models.CancellationPolicy{}, "Free cancellation until the day of arrival, then non-refundable"},
+	}
+
+	for _, e := range tests {
+		if got := Describe(e.policy); got != e.expected {
+			t.Errorf("expected %q, got %q", e.expected, got)
+		}
+	}
+}
@@ -3,9 +3,17 @@ package config
 import (
 	"html/template"
 	"log"
+	"time"
 
 	"github.com/alexedwards/scs/v2"
-	"github.com/dhanekom/bookings/internal/models"
+	"github.com/dhanekom/bookings/internal/calsync"
+	"github.com/dhanekom/bookings/internal/holds"
+	"github.com/dhanekom/bookings/internal/mailer"
+	"github.com/dhanekom/bookings/internal/media"
+	"github.com/dhanekom/bookings/internal/payments"
+	"github.com/dhanekom/bookings/internal/throttle"
+	"github.com/dhanekom/bookings/internal/trash"
+	"github.com/dhanekom/bookings/internal/waitlist"
 )
 
 // AppConfig holds the application config
@@ -17,5 +25,32 @@ type AppConfig struct {
 	InProduction  bool
 	Session       *scs.SessionManager
 	TemplatePath  string
-	MailChan      chan models.MailData
+	Mail          *mailer.Outbox
+	SecretKey     []byte
+	BaseURL       string
+	// PropertyName and PropertyAddress describe the bed and breakfast in calendar invites
+	PropertyName    string
+	PropertyAddress string
+	// Currency is the symbol prices are shown with
+	Currency string
+	// Media stores uploaded files such as room photos
+	Media         *media.Library
+	LoginThrottle *throttle.Throttler
+	CalendarSync  *calsync.Syncer
+	// HoldDuration is how long a room is held for a guest filling in the reservation form. Rooms aren't held
+	// if it is 0
+	HoldDuration time.Duration
+	HoldSweeper  *holds.Sweeper
+	Waitlist     *waitlist.Matcher
+	// Trash purges deleted reservations once they have been kept for its retention period
+	Trash *trash.Purger
+	// Payments takes payment for reservations before they are confirmed. Reservations are confirmed without
+	// payment if it is nil
+	Payments payments.Gateway
+	// PaymentCurrency is the ISO 4217 code of the currency payments are taken in
+	PaymentCurrency string
+	// DepositPercent is the percentage of a reservation's total that is taken when it is booked
+	DepositPercent int
+	// PaymentWindow is how long a room is held for a guest while they pay
+	PaymentWindow time.Duration
 }
@@ -3,6 +3,7 @@ package forms
 import (
 	"fmt"
 	"net/url"
+	"regexp"
 	"strings"
 
 	"github.com/asaskevich/govalidator"
@@ -60,6 +61,18 @@ func (f *Form) IsEmail(field string) bool {
 	return true
 }
 
+// slugPattern matches lower case words of letters and digits joined by hyphens
+var slugPattern = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+// IsSlug checks that a field can be used in a URL path, such as "generals-quarters"
+func (f *Form) IsSlug(field string) bool {
+	if !slugPattern.MatchString(f.Get(field)) {
+		f.Errors.Add(field, "Use only lower case letters, digits and single hyphens")
+		return false
+	}
+	return true
+}
+
 // Has check if form field is in post an not empty
 func (f *Form) Has(field string) bool {
 	x := f.Get(field)
@@ -70,3 +83,12 @@ func (f *Form) Has(field string) bool {
 
 	return true
 }
+
+// Matches checks that two form fields have the same value
+func (f *Form) Matches(field, otherField string) bool {
+	if f.Get(field) != f.Get(otherField) {
+		f.Errors.Add(otherField, "The values do not match")
+		return false
+	}
+	return true
+}
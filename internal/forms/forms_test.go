@@ -90,6 +90,34 @@ func TestForm_IsEmail(t *testing.T) {
 	}
 }
 
+var testSlugs = []struct {
+	description    string
+	value          string
+	expectedResult bool
+}{
+	{"valid slug", "generals-quarters", true},
+	{"digits", "room-101", true},
+	{"blank slug", "", false},
+	{"upper case", "Generals-Quarters", false},
+	{"spaces", "generals quarters", false},
+	{"leading hyphen", "-generals", false},
+	{"double hyphen", "generals--quarters", false},
+	{"path", "../admin", false},
+}
+
+func TestForm_IsSlug(t *testing.T) {
+	for _, e := range testSlugs {
+		postData := url.Values{}
+		postData.Add("slug", e.value)
+
+		form := New(postData)
+		result := form.IsSlug("slug")
+		if result != e.expectedResult {
+			t.Errorf("%s - expected %s to be valid %v, got %v", e.description, e.value, e.expectedResult, result)
+		}
+	}
+}
+
 func TestForm_Has(t *testing.T) {
 	postData := url.Values{}
 	postData.Add("a", "a")
@@ -111,3 +139,24 @@ func TestForm_Has(t *testing.T) {
 		t.Error(`should have found field "a"`)
 	}
 }
+
+func TestForm_Matches(t *testing.T) {
+	postData := url.Values{}
+	postData.Add("password", "secret123")
+	postData.Add("password_confirm", "secret123")
+	postData.Add("other", "different")
+
+	form := New(postData)
+	if !form.Matches("password", "password_confirm") {
+		t.Error("matching fields reported as different")
+	}
+
+	form = New(postData)
+	if form.Matches("password", "other") {
+		t.Error("different fields reported as matching")
+	}
+
+	if form.Errors.Get("other") == "" {
+		t.Error(`expected error for field "other"`)
+	}
+}
@@ -0,0 +1,586 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/asaskevich/govalidator"
+	"github.com/dhanekom/bookings/internal/audit"
+	"github.com/dhanekom/bookings/internal/helpers"
+	"github.com/dhanekom/bookings/internal/models"
+	"github.com/dhanekom/bookings/internal/repository"
+	"github.com/dhanekom/bookings/internal/stayrules"
+	"github.com/go-chi/chi/v5"
+)
+
+// apiError is the body of every API error response
+type apiError struct {
+	Error apiErrorDetail `json:"error"`
+}
+
+type apiErrorDetail struct {
+	Status  int               `json:"status"`
+	Message string            `json:"message"`
+	Fields  map[string]string `json:"fields,omitempty"`
+}
+
+// apiRoom is the API representation of a room
+type apiRoom struct {
+	ID          int      `json:"id"`
+	RoomName    string   `json:"room_name"`
+	Slug        string   `json:"slug"`
+	Description string   `json:"description"`
+	Capacity    int      `json:"capacity"`
+	MaxAdults   int      `json:"max_adults"`
+	MaxChildren int      `json:"max_children"`
+	Amenities   []string `json:"amenities"`
+	BasePrice   int      `json:"base_price"`
+}
+
+// apiAvailability is the API representation of a room availability check
+type apiAvailability struct {
+	RoomID    int       `json:"room_id"`
+	StartDate time.Time `json:"start_date"`
+	EndDate   time.Time `json:"end_date"`
+	Available bool      `json:"available"`
+	// TotalPrice is the price of the stay in cents
+	TotalPrice int `json:"total_price"`
+	// Restrictions are the stay rules and occupancy limits of the room that the stay breaks, which make it
+	// unavailable
+	Restrictions []string `json:"restrictions,omitempty"`
+}
+
+// apiReservation is the API representation of a reservation
+type apiReservation struct {
+	ID               int        `json:"id"`
+	FirstName        string     `json:"first_name"`
+	LastName         string     `json:"last_name"`
+	Email            string     `json:"email"`
+	Phone            string     `json:"phone"`
+	StartDate        time.Time  `json:"start_date"`
+	EndDate          time.Time  `json:"end_date"`
+	RoomID           int        `json:"room_id"`
+	RoomName         string     `json:"room_name,omitempty"`
+	Adults           int        `json:"adults"`
+	Children         int        `json:"children"`
+	ConfirmationCode string     `json:"confirmation_code,omitempty"`
+	TotalPrice       int        `json:"total_price"`
+	Status           string     `json:"status"`
+	CancelledAt      *time.Time `json:"cancelled_at,omitempty"`
+	CancellationFee  int        `json:"cancellation_fee,omitempty"`
+	CreatedAt        time.Time  `json:"created_at"`
+	UpdatedAt        time.Time  `json:"updated_at"`
+}
+
+// apiReservationRequest is the body of a POST /reservations request. Adults defaults to 1 if it's omitted
+type apiReservationRequest struct {
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+	Email     string `json:"email"`
+	Phone     string `json:"phone"`
+	StartDate string `json:"start_date"`
+	EndDate   string `json:"end_date"`
+	RoomID    int    `json:"room_id"`
+	Adults    *int   `json:"adults"`
+	Children  int    `json:"children"`
+}
+
+// apiReservationPatch is the body of a PATCH /reservations/{id} request. Omitted fields are left unchanged
+type apiReservationPatch struct {
+	FirstName *string `json:"first_name"`
+	LastName  *string `json:"last_name"`
+	Email     *string `json:"email"`
+	Phone     *string `json:"phone"`
+}
+
+func newAPIReservation(r models.Reservation) apiReservation {
+	res := apiReservation{
+		ID:               r.ID,
+		FirstName:        r.FirstName,
+		LastName:         r.LastName,
+		Email:            r.Email,
+		Phone:            r.Phone,
+		StartDate:        r.StartDate,
+		EndDate:          r.EndDate,
+		RoomID:           r.RoomID,
+		RoomName:         r.Room.RoomName,
+		Adults:           r.Adults,
+		Children:         r.Children,
+		ConfirmationCode: r.ConfirmationCode,
+		TotalPrice:       r.Quote.Total,
+		Status:           r.Status,
+		CreatedAt:        r.CreateAt,
+		UpdatedAt:        r.UpdatedAt,
+	}
+
+	if !r.CancelledAt.IsZero() {
+		res.CancelledAt = &r.CancelledAt
+		res.CancellationFee = r.CancellationFee
+	}
+
+	return res
+}
+
+// writeJSON writes v as a JSON response with the given status
+func (m *Repository) writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	out, err := json.MarshalIndent(v, "", "    ")
+	if err != nil {
+		m.App.ErrorLog.Println(err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(out)
+}
+
+// writeJSONError writes an error envelope with the given status
+func (m *Repository) writeJSONError(w http.ResponseWriter, status int, message string, fields map[string]string) {
+	m.writeJSON(w, status, apiError{
+		Error: apiErrorDetail{
+			Status:  status,
+			Message: message,
+			Fields:  fields,
+		},
+	})
+}
+
+// writeJSONServerError logs err and writes a generic internal server error envelope
+func (m *Repository) writeJSONServerError(w http.ResponseWriter, err error) {
+	m.App.ErrorLog.Println(err)
+	m.writeJSONError(w, http.StatusInternalServerError, "Internal server error", nil)
+}
+
+// readJSON decodes a JSON request body into dst, rejecting unknown fields
+func readJSON(w http.ResponseWriter, r *http.Request, dst interface{}) error {
+	r.Body = http.MaxBytesReader(w, r.Body, 1<<20)
+
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+
+	err := dec.Decode(dst)
+	if err != nil {
+		return err
+	}
+
+	if dec.More() {
+		return errors.New("body must only contain a single JSON object")
+	}
+
+	return nil
+}
+
+// parseAPIDate parses an RFC 3339 date or date-time and returns midnight UTC of that day
+func parseAPIDate(s string) (time.Time, error) {
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		t, err = time.Parse("2006-01-02", s)
+		if err != nil {
+			return t, err
+		}
+	}
+
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC), nil
+}
+
+// APIAuth only allows requests that carry an active API key as a bearer token
+func (m *Repository) APIAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if token == "" || token == r.Header.Get("Authorization") {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="api"`)
+			m.writeJSONError(w, http.StatusUnauthorized, "Missing API key", nil)
+			return
+		}
+
+		key, err := m.DB.GetAPIKeyByHash(helpers.HashToken(token))
+		if errors.Is(err, sql.ErrNoRows) {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="api"`)
+			m.writeJSONError(w, http.StatusUnauthorized, "Invalid API key", nil)
+			return
+		} else if err != nil {
+			m.writeJSONServerError(w, err)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(helpers.ContextWithAPIKey(r.Context(), key)))
+	})
+}
+
+// APINotFound handles unknown API routes
+func (m *Repository) APINotFound(w http.ResponseWriter, r *http.Request) {
+	m.writeJSONError(w, http.StatusNotFound, "Resource not found", nil)
+}
+
+// APIMethodNotAllowed handles API routes called with the wrong method
+func (m *Repository) APIMethodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	m.writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed", nil)
+}
+
+// APIListRooms returns all rooms
+func (m *Repository) APIListRooms(w http.ResponseWriter, r *http.Request) {
+	rooms, err := m.DB.AllRooms()
+	if err != nil {
+		m.writeJSONServerError(w, err)
+		return
+	}
+
+	out := make([]apiRoom, 0, len(rooms))
+	for _, room := range rooms {
+		amenities := room.Amenities
+		if amenities == nil {
+			amenities = []string{}
+		}
+
+		out = append(out, apiRoom{
+			ID:          room.ID,
+			RoomName:    room.RoomName,
+			Slug:        room.Slug,
+			Description: room.Description,
+			Capacity:    room.Capacity,
+			MaxAdults:   room.MaxAdults,
+			MaxChildren: room.MaxChildren,
+			Amenities:   amenities,
+			BasePrice:   room.BasePrice,
+		})
+	}
+
+	m.writeJSON(w, http.StatusOK, out)
+}
+
+// APIRoomAvailability returns whether a room is available for the start and end query parameters
+func (m *Repository) APIRoomAvailability(w http.ResponseWriter, r *http.Request) {
+	roomID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		m.writeJSONError(w, http.StatusNotFound, "Room not found", nil)
+		return
+	}
+
+	fields := make(map[string]string)
+
+	startDate, err := parseAPIDate(r.URL.Query().Get("start"))
+	if err != nil {
+		fields["start"] = "must be an RFC 3339 date"
+	}
+
+	endDate, err := parseAPIDate(r.URL.Query().Get("end"))
+	if err != nil {
+		fields["end"] = "must be an RFC 3339 date"
+	}
+
+	if len(fields) == 0 && !endDate.After(startDate) {
+		fields["end"] = "must be after start"
+	}
+
+	// the party is optional, and is one adult if it isn't given
+	adults, children := 1, 0
+	if v := r.URL.Query().Get("adults"); v != "" {
+		adults, err = strconv.Atoi(v)
+		if err != nil || adults < 1 {
+			fields["adults"] = "must be at least 1"
+		}
+	}
+
+	if v := r.URL.Query().Get("children"); v != "" {
+		children, err = strconv.Atoi(v)
+		if err != nil || children < 0 {
+			fields["children"] = "must be 0 or more"
+		}
+	}
+
+	if len(fields) > 0 {
+		m.writeJSONError(w, http.StatusBadRequest, "Invalid query parameters", fields)
+		return
+	}
+
+	room, quote, err := m.quoteRoom(roomID, startDate, endDate)
+	if errors.Is(err, sql.ErrNoRows) {
+		m.writeJSONError(w, http.StatusNotFound, "Room not found", nil)
+		return
+	} else if err != nil {
+		m.writeJSONServerError(w, err)
+		return
+	}
+
+	available, err := m.DB.SearchAvailabilityByDatesByRoomID(startDate, endDate, roomID)
+	if err != nil {
+		m.writeJSONServerError(w, err)
+		return
+	}
+
+	violations, err := m.checkStay(roomID, startDate, endDate)
+	if err != nil {
+		m.writeJSONServerError(w, err)
+		return
+	}
+	violations = append(violations, stayrules.CheckOccupancy(room, adults, children)...)
+
+	var restrictions []string
+	for _, v := range violations {
+		restrictions = append(restrictions, v.Message)
+	}
+
+	m.writeJSON(w, http.StatusOK, apiAvailability{
+		RoomID:       roomID,
+		StartDate:    startDate,
+		EndDate:      endDate,
+		Available:    available && len(violations) == 0,
+		TotalPrice:   quote.Total,
+		Restrictions: restrictions,
+	})
+}
+
+// APICreateReservation books a room
+func (m *Repository) APICreateReservation(w http.ResponseWriter, r *http.Request) {
+	var req apiReservationRequest
+	err := readJSON(w, r, &req)
+	if err != nil {
+		m.writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("Invalid request body: %s", err), nil)
+		return
+	}
+
+	fields := make(map[string]string)
+	if strings.TrimSpace(req.FirstName) == "" {
+		fields["first_name"] = "is required"
+	}
+	if strings.TrimSpace(req.LastName) == "" {
+		fields["last_name"] = "is required"
+	}
+	if !govalidator.IsEmail(req.Email) {
+		fields["email"] = "must be a valid email address"
+	}
+	if req.RoomID <= 0 {
+		fields["room_id"] = "is required"
+	}
+
+	adults := 1
+	if req.Adults != nil {
+		adults = *req.Adults
+	}
+	if adults < 1 {
+		fields["adults"] = "must be at least 1"
+	}
+	if req.Children < 0 {
+		fields["children"] = "must be 0 or more"
+	}
+
+	startDate, err := parseAPIDate(req.StartDate)
+	if err != nil {
+		fields["start_date"] = "must be an RFC 3339 date"
+	}
+
+	endDate, err := parseAPIDate(req.EndDate)
+	if err != nil {
+		fields["end_date"] = "must be an RFC 3339 date"
+	}
+
+	if fields["start_date"] == "" && fields["end_date"] == "" && !endDate.After(startDate) {
+		fields["end_date"] = "must be after start_date"
+	}
+
+	if len(fields) > 0 {
+		m.writeJSONError(w, http.StatusUnprocessableEntity, "Validation failed", fields)
+		return
+	}
+
+	room, quote, err := m.quoteRoom(req.RoomID, startDate, endDate)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			m.writeJSONError(w, http.StatusUnprocessableEntity, "Validation failed", map[string]string{"room_id": "does not exist"})
+			return
+		}
+		m.writeJSONServerError(w, err)
+		return
+	}
+
+	violations, err := m.checkStay(req.RoomID, startDate, endDate)
+	if err != nil {
+		m.writeJSONServerError(w, err)
+		return
+	}
+	violations = append(violations, stayrules.CheckOccupancy(room, adults, req.Children)...)
+
+	if len(violations) > 0 {
+		for _, v := range violations {
+			field := v.Field
+			switch v.Field {
+			case stayrules.Arrival:
+				field = "start_date"
+			case stayrules.Departure:
+				field = "end_date"
+			}
+
+			if fields[field] == "" {
+				fields[field] = v.Message
+			}
+		}
+
+		m.writeJSONError(w, http.StatusUnprocessableEntity, "Validation failed", fields)
+		return
+	}
+
+	reservation := models.Reservation{
+		FirstName: req.FirstName,
+		LastName:  req.LastName,
+		Email:     req.Email,
+		Phone:     req.Phone,
+		StartDate: startDate,
+		EndDate:   endDate,
+		RoomID:    req.RoomID,
+		Adults:    adults,
+		Children:  req.Children,
+		Quote:     quote,
+		Room:      room,
+	}
+
+	// deposits are only taken through the website's checkout, so the API can't book stays that need one
+	if m.deposit(quote.Total) > 0 {
+		m.writeJSONError(w, http.StatusPaymentRequired, "A deposit is required for this reservation; book it through the website", nil)
+		return
+	}
+
+	reservation.ConfirmationCode, err = helpers.NewConfirmationCode()
+	if err != nil {
+		m.writeJSONServerError(w, err)
+		return
+	}
+
+	newID, err := m.DB.BookRoom(r.Context(), reservation, 0)
+	if errors.Is(err, repository.ErrRoomUnavailable) {
+		m.writeJSONError(w, http.StatusConflict, "Room is not available for the requested dates", nil)
+		return
+	} else if err != nil {
+		m.writeJSONServerError(w, err)
+		return
+	}
+
+	reservation, err = m.DB.GetReservationByID(newID)
+	if err != nil {
+		m.writeJSONServerError(w, err)
+		return
+	}
+	m.audit(r, models.AuditCreate, models.AuditEntityReservation, newID, nil, audit.Reservation(reservation))
+
+	w.Header().Set("Location", fmt.Sprintf("/api/v1/reservations/%d", newID))
+	m.writeJSON(w, http.StatusCreated, newAPIReservation(reservation))
+}
+
+// apiReservationFromURL loads the reservation named by the id URL parameter, writing an error response if it can't
+func (m *Repository) apiReservationFromURL(w http.ResponseWriter, r *http.Request) (models.Reservation, bool) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		m.writeJSONError(w, http.StatusNotFound, "Reservation not found", nil)
+		return models.Reservation{}, false
+	}
+
+	reservation, err := m.DB.GetReservationByID(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		m.writeJSONError(w, http.StatusNotFound, "Reservation not found", nil)
+		return reservation, false
+	} else if err != nil {
+		m.writeJSONServerError(w, err)
+		return reservation, false
+	}
+
+	return reservation, true
+}
+
+// APIGetReservation returns a reservation
+func (m *Repository) APIGetReservation(w http.ResponseWriter, r *http.Request) {
+	reservation, ok := m.apiReservationFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	m.writeJSON(w, http.StatusOK, newAPIReservation(reservation))
+}
+
+// APIUpdateReservation updates the guest details of a reservation
+func (m *Repository) APIUpdateReservation(w http.ResponseWriter, r *http.Request) {
+	reservation, ok := m.apiReservationFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	var req apiReservationPatch
+	err := readJSON(w, r, &req)
+	if err != nil {
+		m.writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("Invalid request body: %s", err), nil)
+		return
+	}
+
+	before := audit.Reservation(reservation)
+	if req.FirstName != nil {
+		reservation.FirstName = *req.FirstName
+	}
+	if req.LastName != nil {
+		reservation.LastName = *req.LastName
+	}
+	if req.Email != nil {
+		reservation.Email = *req.Email
+	}
+	if req.Phone != nil {
+		reservation.Phone = *req.Phone
+	}
+
+	fields := make(map[string]string)
+	if strings.TrimSpace(reservation.FirstName) == "" {
+		fields["first_name"] = "is required"
+	}
+	if strings.TrimSpace(reservation.LastName) == "" {
+		fields["last_name"] = "is required"
+	}
+	if !govalidator.IsEmail(reservation.Email) {
+		fields["email"] = "must be a valid email address"
+	}
+
+	if len(fields) > 0 {
+		m.writeJSONError(w, http.StatusUnprocessableEntity, "Validation failed", fields)
+		return
+	}
+
+	err = m.DB.UpdateReservation(reservation)
+	if err != nil {
+		m.writeJSONServerError(w, err)
+		return
+	}
+	m.audit(r, models.AuditUpdate, models.AuditEntityReservation, reservation.ID, before, audit.Reservation(reservation))
+
+	m.writeJSON(w, http.StatusOK, newAPIReservation(reservation))
+}
+
+// APIDeleteReservation cancels a reservation, refunding what its cancellation policy allows. Cancelling a
+// reservation that was already cancelled does nothing, but reservations that don't exist or were deleted aren't
+// found
+func (m *Repository) APIDeleteReservation(w http.ResponseWriter, r *http.Request) {
+	reservation, ok := m.apiReservationFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	if reservation.CancelledAt.IsZero() {
+		_, _, err := m.cancelReservation(r, reservation, "Cancelled through the API")
+		if errors.Is(err, sql.ErrNoRows) {
+			// the reservation was cancelled or deleted since it was loaded, and only the first is idempotent
+			_, ok = m.apiReservationFromURL(w, r)
+			if !ok {
+				return
+			}
+		} else if errors.Is(err, repository.ErrInvalidTransition) {
+			m.writeJSONError(w, http.StatusConflict, "Reservation can't be cancelled once the guest has arrived", nil)
+			return
+		} else if err != nil {
+			m.writeJSONServerError(w, err)
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
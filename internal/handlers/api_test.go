@@ -0,0 +1,159 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/dhanekom/bookings/internal/repository/dbrepo"
+	"github.com/go-chi/chi/v5"
+)
+
+func getAPIRoutes() http.Handler {
+	mux := chi.NewRouter()
+
+	mux.Route("/api/v1", func(r chi.Router) {
+		r.NotFound(Repo.APINotFound)
+		r.MethodNotAllowed(Repo.APIMethodNotAllowed)
+		r.Use(Repo.APIAuth)
+
+		r.Get("/rooms", Repo.APIListRooms)
+		r.Get("/rooms/{id}/availability", Repo.APIRoomAvailability)
+		r.Post("/reservations", Repo.APICreateReservation)
+		r.Get("/reservations/{id}", Repo.APIGetReservation)
+		r.Patch("/reservations/{id}", Repo.APIUpdateReservation)
+		r.Delete("/reservations/{id}", Repo.APIDeleteReservation)
+	})
+
+	return mux
+}
+
+var apiTests = []struct {
+	name               string
+	method             string
+	url                string
+	apiKey             string
+	body               string
+	expectedStatusCode int
+}{
+	{"missing api key", "GET", "/api/v1/rooms", "", "", http.StatusUnauthorized},
+	{"invalid api key", "GET", "/api/v1/rooms", "invalid", "", http.StatusUnauthorized},
+	{"unknown route", "GET", "/api/v1/unknown", "test-api-key", "", http.StatusNotFound},
+	{"list rooms", "GET", "/api/v1/rooms", "test-api-key", "", http.StatusOK},
+	{"room available", "GET", "/api/v1/rooms/2/availability?start=2050-01-01&end=2050-01-02", "test-api-key", "", http.StatusOK},
+	{"availability with date-time", "GET", "/api/v1/rooms/2/availability?start=2050-01-01T00:00:00Z&end=2050-01-02T00:00:00Z", "test-api-key", "", http.StatusOK},
+	{"availability invalid start", "GET", "/api/v1/rooms/2/availability?start=invalid&end=2050-01-02", "test-api-key", "", http.StatusBadRequest},
+	{"availability end before start", "GET", "/api/v1/rooms/2/availability?start=2050-01-02&end=2050-01-01", "test-api-key", "", http.StatusBadRequest},
+	{"availability breaking stay rules", "GET", "/api/v1/rooms/2/availability?start=2050-02-06&end=2050-02-07", "test-api-key", "", http.StatusOK},
+	{"availability with party", "GET", "/api/v1/rooms/2/availability?start=2050-01-01&end=2050-01-02&adults=2&children=1", "test-api-key", "", http.StatusOK},
+	{"availability invalid party", "GET", "/api/v1/rooms/2/availability?start=2050-01-01&end=2050-01-02&adults=none", "test-api-key", "", http.StatusBadRequest},
+	{"availability invalid room", "GET", "/api/v1/rooms/x/availability?start=2050-01-01&end=2050-01-02", "test-api-key", "", http.StatusNotFound},
+	{"create reservation", "POST", "/api/v1/reservations", "test-api-key",
+		`{"first_name":"John","last_name":"Smith","email":"john@smith.com","start_date":"2050-01-01","end_date":"2050-01-02","room_id":2}`,
+		http.StatusCreated},
+	{"create reservation room unavailable", "POST", "/api/v1/reservations", "test-api-key",
+		`{"first_name":"John","last_name":"Smith","email":"john@smith.com","start_date":"2050-01-01","end_date":"2050-01-02","room_id":1001}`,
+		http.StatusConflict},
+	{"create reservation invalid data", "POST", "/api/v1/reservations", "test-api-key",
+		`{"first_name":"","last_name":"Smith","email":"john","start_date":"2050-01-01","end_date":"2050-01-02","room_id":2}`,
+		http.StatusUnprocessableEntity},
+	{"create reservation breaking stay rules", "POST", "/api/v1/reservations", "test-api-key",
+		`{"first_name":"John","last_name":"Smith","email":"john@smith.com","start_date":"2050-02-06","end_date":"2050-02-07","room_id":2}`,
+		http.StatusUnprocessableEntity},
+	{"create reservation too many guests", "POST", "/api/v1/reservations", "test-api-key",
+		`{"first_name":"John","last_name":"Smith","email":"john@smith.com","start_date":"2050-01-01","end_date":"2050-01-02","room_id":2,"adults":3}`,
+		http.StatusUnprocessableEntity},
+	{"create reservation in the past", "POST", "/api/v1/reservations", "test-api-key",
+		`{"first_name":"John","last_name":"Smith","email":"john@smith.com","start_date":"2020-01-01","end_date":"2020-01-02","room_id":2}`,
+		http.StatusUnprocessableEntity},
+	{"create reservation without adults", "POST", "/api/v1/reservations", "test-api-key",
+		`{"first_name":"John","last_name":"Smith","email":"john@smith.com","start_date":"2050-01-01","end_date":"2050-01-02","room_id":2,"adults":0}`,
+		http.StatusUnprocessableEntity},
+	{"create reservation negative children", "POST", "/api/v1/reservations", "test-api-key",
+		`{"first_name":"John","last_name":"Smith","email":"john@smith.com","start_date":"2050-01-01","end_date":"2050-01-02","room_id":2,"children":-1}`,
+		http.StatusUnprocessableEntity},
+	{"create reservation unknown field", "POST", "/api/v1/reservations", "test-api-key", `{"unknown":1}`, http.StatusBadRequest},
+	{"create reservation invalid json", "POST", "/api/v1/reservations", "test-api-key", `{`, http.StatusBadRequest},
+	{"get reservation", "GET", "/api/v1/reservations/1", "test-api-key", "", http.StatusOK},
+	{"get missing reservation", "GET", "/api/v1/reservations/1000", "test-api-key", "", http.StatusNotFound},
+	{"update reservation", "PATCH", "/api/v1/reservations/1", "test-api-key", `{"phone":"555-1234"}`, http.StatusOK},
+	{"update reservation invalid email", "PATCH", "/api/v1/reservations/1", "test-api-key", `{"email":"john"}`, http.StatusUnprocessableEntity},
+	{"update missing reservation", "PATCH", "/api/v1/reservations/1000", "test-api-key", `{"phone":"555-1234"}`, http.StatusNotFound},
+	{"delete reservation", "DELETE", "/api/v1/reservations/1", "test-api-key", "", http.StatusNoContent},
+	{"delete missing reservation", "DELETE", "/api/v1/reservations/1000", "test-api-key", "", http.StatusNotFound},
+	{"delete cancelled reservation", "DELETE", "/api/v1/reservations/2", "test-api-key", "", http.StatusNoContent},
+}
+
+func TestAPIRoomAvailabilityStayRules(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/api/v1/rooms/2/availability?start=2050-02-06&end=2050-02-07", nil)
+	req.Header.Set("Authorization", "Bearer test-api-key")
+	rr := httptest.NewRecorder()
+	getAPIRoutes().ServeHTTP(rr, req)
+
+	var body apiAvailability
+	err := json.Unmarshal(rr.Body.Bytes(), &body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if body.Available {
+		t.Error("expected a stay breaking the stay rules to be unavailable")
+	}
+
+	if len(body.Restrictions) != 2 {
+		t.Errorf("expected the minimum stay and closed to arrival restrictions, got %v", body.Restrictions)
+	}
+}
+
+func TestAPI(t *testing.T) {
+	routes := getAPIRoutes()
+
+	for _, e := range apiTests {
+		req, _ := http.NewRequest(e.method, e.url, strings.NewReader(e.body))
+		if e.apiKey != "" {
+			req.Header.Set("Authorization", "Bearer "+e.apiKey)
+		}
+		req.Header.Set("Content-Type", "application/json")
+
+		rr := httptest.NewRecorder()
+		routes.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected %d, got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+
+		if rr.Code >= 400 {
+			var body apiError
+			err := json.Unmarshal(rr.Body.Bytes(), &body)
+			if err != nil {
+				t.Errorf("%s: failed to parse error envelope - %v", e.name, err)
+			} else if body.Error.Status != rr.Code {
+				t.Errorf("%s: expected error status %d in envelope, got %d", e.name, rr.Code, body.Error.Status)
+			}
+		}
+	}
+}
+
+func TestAPICreateReservationDeposit(t *testing.T) {
+	_, done := useFakePayments()
+	defer done()
+
+	body := `{"first_name":"John","last_name":"Smith","email":"john@smith.com","start_date":"2050-01-01","end_date":"2050-01-02","room_id":2}`
+	req, _ := http.NewRequest("POST", "/api/v1/reservations", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer test-api-key")
+	req.Header.Set("Content-Type", "application/json")
+
+	dbrepo.AuditLog = nil
+	rr := httptest.NewRecorder()
+	getAPIRoutes().ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusPaymentRequired {
+		t.Errorf("expected %d, got %d", http.StatusPaymentRequired, rr.Code)
+	}
+
+	if len(dbrepo.AuditLog) != 0 {
+		t.Error("expected no reservation to be created")
+	}
+}
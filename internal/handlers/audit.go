@@ -0,0 +1,168 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/dhanekom/bookings/internal/audit"
+	"github.com/dhanekom/bookings/internal/helpers"
+	"github.com/dhanekom/bookings/internal/models"
+	"github.com/dhanekom/bookings/internal/render"
+)
+
+// audit records in the audit log that whoever made r did action to the entity with id, with the fields that
+// differ between before and after. Updates that change nothing aren't recorded. The change has already been
+// made, so failing to record it is logged rather than failing the request
+func (m *Repository) audit(r *http.Request, action, entity string, id int, before, after audit.Fields) {
+	e := models.AuditEntry{
+		Action:   action,
+		Entity:   entity,
+		EntityID: id,
+		IP:       helpers.ClientIP(r),
+	}
+	e.ActorID, e.Actor = actor(r)
+
+	m.recordAudit(e, before, after)
+}
+
+// auditPayment records in the audit log that the payment provider, whose webhook is r, changed a payment from
+// before to after
+func (m *Repository) auditPayment(r *http.Request, before, after models.Payment) {
+	m.recordAudit(models.AuditEntry{
+		Actor:    "payment provider " + after.Provider,
+		Action:   models.AuditUpdate,
+		Entity:   models.AuditEntityPayment,
+		EntityID: after.ID,
+		IP:       helpers.ClientIP(r),
+	}, audit.Payment(before), audit.Payment(after))
+}
+
+// recordAudit inserts e in the audit log with the fields that differ between before and after
+func (m *Repository) recordAudit(e models.AuditEntry, before, after audit.Fields) {
+	b, a := audit.Diff(before, after)
+	if e.Action == models.AuditUpdate && len(a) == 0 {
+		return
+	}
+
+	beforeJSON, err := json.Marshal(b)
+	if err != nil {
+		m.App.ErrorLog.Printf("%s of %s %d couldn't be audited: %s", e.Action, e.Entity, e.EntityID, err)
+		return
+	}
+
+	afterJSON, err := json.Marshal(a)
+	if err != nil {
+		m.App.ErrorLog.Printf("%s of %s %d couldn't be audited: %s", e.Action, e.Entity, e.EntityID, err)
+		return
+	}
+
+	e.Before = string(beforeJSON)
+	e.After = string(afterJSON)
+
+	err = m.DB.InsertAuditEntry(e)
+	if err != nil {
+		m.App.ErrorLog.Printf("%s of %s %d couldn't be audited: %s", e.Action, e.Entity, e.EntityID, err)
+	}
+}
+
+// actor returns the id of the user who made r and a description of them. Requests made with an API key are
+// described by the key's name, and any others were made by guests
+func actor(r *http.Request) (int, string) {
+	if u, ok := helpers.UserFromContext(r.Context()); ok {
+		return u.ID, u.Email
+	}
+
+	if k, ok := helpers.APIKeyFromContext(r.Context()); ok {
+		return 0, "API key " + k.Name
+	}
+
+	return 0, "guest"
+}
+
+// auditChanges returns the changes recorded by each entry, by entry id
+func auditChanges(entries []models.AuditEntry) (map[int][]audit.Change, error) {
+	changes := make(map[int][]audit.Change)
+	for _, e := range entries {
+		c, err := audit.Changes(e.Before, e.After)
+		if err != nil {
+			return nil, err
+		}
+		changes[e.ID] = c
+	}
+
+	return changes, nil
+}
+
+// auditActions are the actions the audit log can be filtered by
+var auditActions = []string{
+	models.AuditCreate,
+	models.AuditUpdate,
+	models.AuditCancel,
+	models.AuditDelete,
+	models.AuditRestore,
+	models.AuditPassword,
+	models.AuditTransition,
+	models.AuditPurge,
+	models.AuditRevoke,
+}
+
+// AdminAuditLog shows the audit log, filtered by the entity, entity id, user and action given in the query
+func (m *Repository) AdminAuditLog(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	f := models.AuditFilter{
+		Entity: q.Get("entity"),
+		Action: q.Get("action"),
+	}
+
+	for name, n := range map[string]*int{"entity_id": &f.EntityID, "actor_id": &f.ActorID} {
+		if q.Get(name) == "" {
+			continue
+		}
+
+		id, err := strconv.Atoi(q.Get(name))
+		if err != nil || id < 1 {
+			helpers.ClientError(w, http.StatusBadRequest)
+			return
+		}
+		*n = id
+	}
+
+	entries, err := m.DB.AuditEntries(f)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	changes, err := auditChanges(entries)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	users, err := m.DB.AllUsers()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["entries"] = entries
+	data["changes"] = changes
+	data["users"] = users
+	data["entities"] = []string{
+		models.AuditEntityReservation,
+		models.AuditEntityUser,
+		models.AuditEntityBlock,
+		models.AuditEntityCalendar,
+		models.AuditEntityPayment,
+		models.AuditEntityAPIKey,
+	}
+	data["actions"] = auditActions
+	data["filter"] = f
+
+	render.Template(w, r, "admin-audit-log.page.tmpl", &models.TemplateData{
+		Data: data,
+	})
+}
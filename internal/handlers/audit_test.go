@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/dhanekom/bookings/internal/helpers"
+	"github.com/dhanekom/bookings/internal/models"
+	"github.com/dhanekom/bookings/internal/repository/dbrepo"
+	"github.com/go-chi/chi/v5"
+)
+
+// getAdminRoute routes POST requests for pattern to h as an admin
+func getAdminRoute(pattern string, h http.HandlerFunc) http.Handler {
+	mux := chi.NewRouter()
+	mux.Use(SessionLoad)
+	mux.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			u := models.User{ID: 1, AccessLevel: models.AccessLevelAdmin}
+			next.ServeHTTP(w, r.WithContext(helpers.ContextWithUser(r.Context(), u)))
+		})
+	})
+	mux.Post(pattern, h)
+
+	return mux
+}
+
+func TestRepository_Audit(t *testing.T) {
+	var tests = []struct {
+		name             string
+		handler          http.Handler
+		method           string
+		url              string
+		postedData       url.Values
+		body             string
+		expectedActorID  int
+		expectedActor    string
+		expectedAction   string
+		expectedEntity   string
+		expectedEntityID int
+		expectedAfter    string
+	}{
+		{"deactivate user", getUserRoutes(), "POST", "/admin/users/2/deactivate", url.Values{}, "",
+			1, "", models.AuditUpdate, models.AuditEntityUser, 2, `"active":false`},
+		{"change user password", getUserRoutes(), "POST", "/admin/users/2/password", url.Values{
+			"password":         {"password123"},
+			"password_confirm": {"password123"},
+		}, "", 1, "", models.AuditPassword, models.AuditEntityUser, 2, "{}"},
+		{"update reservation with api key", getAPIRoutes(), "PATCH", "/api/v1/reservations/1", nil, `{"phone":"555-1234"}`,
+			0, "API key test", models.AuditUpdate, models.AuditEntityReservation, 1, `"phone":"555-1234"`},
+		{"check in reservation", getAdminRoute("/admin/reservations/{src}/{id}/status", Repo.AdminTransitionReservation),
+			"POST", "/admin/reservations/all/1/status", url.Values{"status": {models.ReservationCheckedIn}}, "",
+			1, "", models.AuditTransition, models.AuditEntityReservation, 1, `"status":"checked-in"`},
+		{"block a night", getAdminRoute("/admin/reservations-calendar", Repo.AdminPostReservationsCalendar),
+			"POST", "/admin/reservations-calendar", url.Values{
+				"y":                  {"2050"},
+				"m":                  {"01"},
+				"block_1_2050-01-04": {"1"},
+				"block_2_2050-01-11": {"1"},
+			}, "", 1, "", models.AuditCreate, models.AuditEntityBlock, 1, `"start_date":"2050-01-11"`},
+		{"unblock a night", getAdminRoute("/admin/reservations-calendar", Repo.AdminPostReservationsCalendar),
+			"POST", "/admin/reservations-calendar", url.Values{"y": {"2050"}, "m": {"01"}}, "",
+			1, "", models.AuditDelete, models.AuditEntityBlock, 2, "{}"},
+		{"create api key", getAdminRoute("/admin/api-keys", Repo.AdminPostAPIKey),
+			"POST", "/admin/api-keys", url.Values{"name": {"channel manager"}}, "",
+			1, "", models.AuditCreate, models.AuditEntityAPIKey, 1, `{"name":"channel manager"}`},
+		{"revoke api key", getAdminRoute("/admin/api-keys/{id}/revoke", Repo.AdminRevokeAPIKey),
+			"POST", "/admin/api-keys/1/revoke", url.Values{}, "",
+			1, "", models.AuditRevoke, models.AuditEntityAPIKey, 1, "{}"},
+		{"remove calendar", getAdminRoute("/admin/calendars/{id}/delete", Repo.AdminDeleteRoomCalendar),
+			"POST", "/admin/calendars/1/delete", url.Values{}, "",
+			1, "", models.AuditDelete, models.AuditEntityCalendar, 1, "{}"},
+	}
+
+	for _, e := range tests {
+		dbrepo.AuditLog = nil
+
+		var req *http.Request
+		if e.postedData != nil {
+			req, _ = http.NewRequest(e.method, e.url, strings.NewReader(e.postedData.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		} else {
+			req, _ = http.NewRequest(e.method, e.url, strings.NewReader(e.body))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("Authorization", "Bearer test-api-key")
+		}
+
+		rr := httptest.NewRecorder()
+		e.handler.ServeHTTP(rr, req)
+
+		if len(dbrepo.AuditLog) != 1 {
+			t.Errorf("%s: expected one audit entry, got %d (status %d)", e.name, len(dbrepo.AuditLog), rr.Code)
+			continue
+		}
+
+		a := dbrepo.AuditLog[0]
+		if a.ActorID != e.expectedActorID || a.Actor != e.expectedActor {
+			t.Errorf("%s: expected actor %d %q, got %d %q", e.name, e.expectedActorID, e.expectedActor, a.ActorID, a.Actor)
+		}
+
+		if a.Action != e.expectedAction || a.Entity != e.expectedEntity || a.EntityID != e.expectedEntityID {
+			t.Errorf("%s: expected %s of %s %d, got %s of %s %d", e.name, e.expectedAction, e.expectedEntity,
+				e.expectedEntityID, a.Action, a.Entity, a.EntityID)
+		}
+
+		if !strings.Contains(a.After, e.expectedAfter) {
+			t.Errorf("%s: expected %s to contain %s", e.name, a.After, e.expectedAfter)
+		}
+	}
+
+	dbrepo.AuditLog = nil
+}
+
+func TestRepository_AdminAuditLog(t *testing.T) {
+	dbrepo.AuditLog = []models.AuditEntry{
+		{ID: 1, ActorID: 1, Actor: "admin@here.com", Action: models.AuditUpdate, Entity: models.AuditEntityReservation,
+			EntityID: 1, Before: `{"phone":"555-0000"}`, After: `{"phone":"555-1234"}`},
+		{ID: 2, Actor: "guest", Action: models.AuditCancel, Entity: models.AuditEntityReservation, EntityID: 2,
+			Before: `{"status":"confirmed"}`, After: `{"status":"cancelled"}`},
+	}
+	defer func() { dbrepo.AuditLog = nil }()
+
+	var tests = []struct {
+		name               string
+		url                string
+		expectedStatusCode int
+		expectedText       string
+		unexpectedText     string
+	}{
+		{"all entries", "/admin/audit-log", http.StatusOK, "555-1234", ""},
+		{"by entity id", "/admin/audit-log?entity=reservation&entity_id=2", http.StatusOK, "cancelled", "555-1234"},
+		{"by action", "/admin/audit-log?action=update", http.StatusOK, "555-0000", "cancelled"},
+		{"invalid entity id", "/admin/audit-log?entity_id=x", http.StatusBadRequest, "", ""},
+		{"invalid actor id", "/admin/audit-log?actor_id=0", http.StatusBadRequest, "", ""},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", e.url, nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.AdminAuditLog).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected %d, got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+
+		if e.expectedText != "" && !strings.Contains(rr.Body.String(), e.expectedText) {
+			t.Errorf("%s: expected %q to be shown", e.name, e.expectedText)
+		}
+
+		if e.unexpectedText != "" && strings.Contains(rr.Body.String(), e.unexpectedText) {
+			t.Errorf("%s: expected %q not to be shown", e.name, e.unexpectedText)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/dhanekom/bookings/internal/ical"
+	"github.com/dhanekom/bookings/internal/models"
+)
+
+// calendarProdID identifies this application in iCalendar files
+const calendarProdID = "-//Fort Smythe//Bookings//EN"
+
+// calendarHost returns the host name that makes the UIDs of calendar events unique
+func (m *Repository) calendarHost() string {
+	if u, err := url.Parse(m.App.BaseURL); err == nil && u.Hostname() != "" {
+		return u.Hostname()
+	}
+
+	return "bookings"
+}
+
+// reservationUID returns the iCalendar UID of a reservation, which stays the same when it is changed or cancelled
+func (m *Repository) reservationUID(res models.Reservation) string {
+	return fmt.Sprintf("reservation-%d@%s", res.ID, m.calendarHost())
+}
+
+// reservationEvent returns the calendar event covering a stay from check-in to check-out
+func (m *Repository) reservationEvent(res models.Reservation) ical.Event {
+	location := m.App.PropertyAddress
+	if location == "" {
+		location = m.App.PropertyName
+	}
+
+	status := ical.StatusConfirmed
+	if !res.CancelledAt.IsZero() {
+		status = ical.StatusCancelled
+	}
+
+	description := fmt.Sprintf("Confirmation code: %s\nRoom: %s\nView, change or cancel your reservation at %s",
+		res.ConfirmationCode, res.Room.RoomName, m.App.BaseURL+"/manage-booking")
+
+	return ical.Event{
+		UID:         m.reservationUID(res),
+		Sequence:    res.CalendarSequence,
+		Stamp:       time.Now(),
+		Start:       res.StartDate,
+		End:         res.EndDate,
+		Summary:     fmt.Sprintf("Stay at %s - %s", m.App.PropertyName, res.Room.RoomName),
+		Description: description,
+		Location:    location,
+		Status:      status,
+		Organizer:   ical.Person{Name: m.App.PropertyName, Email: "me@here.com"},
+		Attendee:    ical.Person{Name: res.FirstName + " " + res.LastName, Email: res.Email},
+	}
+}
+
+// reservationInvite returns res as a calendar attachment. Sent with ical.MethodRequest it adds the stay to
+// the guest's calendar or updates it, with ical.MethodCancel it removes it
+func (m *Repository) reservationInvite(res models.Reservation, method string) models.Attachment {
+	cal := ical.Calendar{
+		ProdID: calendarProdID,
+		Method: method,
+		Events: []ical.Event{m.reservationEvent(res)},
+	}
+
+	return models.Attachment{
+		Filename:    "invite.ics",
+		ContentType: ical.ContentTypeFor(method),
+		Data:        cal.Bytes(),
+	}
+}
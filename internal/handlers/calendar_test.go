@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dhanekom/bookings/internal/ical"
+	"github.com/dhanekom/bookings/internal/models"
+)
+
+func TestReservationInvite(t *testing.T) {
+	res := models.Reservation{
+		ID:               7,
+		FirstName:        "John",
+		LastName:         "Smith",
+		Email:            "john@smith.com",
+		ConfirmationCode: "ABCDEFGHJK",
+		StartDate:        time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC),
+		EndDate:          time.Date(2050, 1, 3, 0, 0, 0, 0, time.UTC),
+		Room:             models.Room{RoomName: "General's Quarters"},
+	}
+
+	request := Repo.reservationInvite(res, ical.MethodRequest)
+
+	res.CancelledAt = time.Now()
+	res.CalendarSequence = 1
+	cancel := Repo.reservationInvite(res, ical.MethodCancel)
+
+	var tests = []struct {
+		name     string
+		got      string
+		expected string
+	}{
+		{"request content type", request.ContentType, "text/calendar; charset=utf-8; method=REQUEST"},
+		{"request filename", request.Filename, "invite.ics"},
+		{"request uid", string(request.Data), "UID:reservation-7@localhost\r\n"},
+		{"request check-in", string(request.Data), "DTSTART;VALUE=DATE:20500101\r\n"},
+		{"request check-out", string(request.Data), "DTEND;VALUE=DATE:20500103\r\n"},
+		{"request room", string(request.Data), `SUMMARY:Stay at Fort Smythe - General's Quarters`},
+		{"request address", string(request.Data), `LOCATION:1 Main Road\, Fort Smythe`},
+		{"request confirmation code", string(request.Data), "DESCRIPTION:Confirmation code: ABCDEFGHJK"},
+		{"request status", string(request.Data), "STATUS:CONFIRMED"},
+		{"request sequence", string(request.Data), "SEQUENCE:0"},
+		{"cancel content type", cancel.ContentType, "text/calendar; charset=utf-8; method=CANCEL"},
+		{"cancel same uid", string(cancel.Data), "UID:reservation-7@localhost\r\n"},
+		{"cancel status", string(cancel.Data), "STATUS:CANCELLED"},
+		{"cancel sequence", string(cancel.Data), "SEQUENCE:1"},
+	}
+
+	for _, e := range tests {
+		if !strings.Contains(e.got, e.expected) {
+			t.Errorf("%s: expected %q in %q", e.name, e.expected, e.got)
+		}
+	}
+}
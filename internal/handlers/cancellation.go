@@ -0,0 +1,397 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/dhanekom/bookings/internal/audit"
+	"github.com/dhanekom/bookings/internal/cancellation"
+	"github.com/dhanekom/bookings/internal/forms"
+	"github.com/dhanekom/bookings/internal/helpers"
+	"github.com/dhanekom/bookings/internal/ical"
+	"github.com/dhanekom/bookings/internal/models"
+	"github.com/dhanekom/bookings/internal/render"
+	"github.com/dhanekom/bookings/internal/repository"
+	"github.com/go-chi/chi/v5"
+)
+
+// cancellationPolicy returns the cancellation policy of res. Reservations without one can be cancelled for
+// free until the day of arrival
+func (m *Repository) cancellationPolicy(res models.Reservation) (models.CancellationPolicy, error) {
+	p, err := m.DB.CancellationPolicyForStay(res.RoomID, res.StartDate)
+	if errors.Is(err, sql.ErrNoRows) {
+		return p, nil
+	}
+
+	return p, err
+}
+
+// settleCancellation returns what cancelling res now costs and refunds, and the payments made for it
+func (m *Repository) settleCancellation(res models.Reservation) (cancellation.Settlement, []models.Payment, error) {
+	policy, err := m.cancellationPolicy(res)
+	if err != nil {
+		return cancellation.Settlement{}, nil, err
+	}
+
+	paid, err := m.DB.PaymentsForReservation(res.ID)
+	if err != nil {
+		return cancellation.Settlement{}, nil, err
+	}
+
+	return cancellation.Settle(policy, res, paid, time.Now()), paid, nil
+}
+
+// cancelReservation cancels res for reason as requested by r, refunds what its cancellation policy allows and emails the guest
+// and the property the amounts. It returns the settlement and the part of the refund that couldn't be paid
+// back through the payment gateway, which must be refunded by hand. sql.ErrNoRows is returned if res was
+// already cancelled, and ErrInvalidTransition if the guest has already arrived
+func (m *Repository) cancelReservation(r *http.Request, res models.Reservation, reason string) (cancellation.Settlement, int, error) {
+	s, paid, err := m.settleCancellation(res)
+	if err != nil {
+		return s, 0, err
+	}
+
+	err = m.DB.CancelReservation(res.ID, reason, s.Fee)
+	if err != nil {
+		return s, 0, err
+	}
+	m.matchWaitlist()
+
+	unrefunded := m.refund(r.Context(), paid, s.Refund)
+	m.voidAuthorized(r, paid)
+
+	// mirror the cancellation, so that the invite cancels the one the guest already has
+	before := audit.Reservation(res)
+	res.Status = models.ReservationCancelled
+	res.CancelledAt = time.Now()
+	res.CalendarSequence++
+	res.CancellationReason = reason
+	res.CancellationFee = s.Fee
+	m.audit(r, models.AuditCancel, models.AuditEntityReservation, res.ID, before, audit.Reservation(res))
+
+	data := map[string]interface{}{
+		"reservation": res,
+		"reason":      reason,
+		"settled":     s.Paid > 0 || s.Fee > 0,
+		"paid":        render.FormatPrice(s.Paid),
+		"fee":         render.FormatPrice(s.Fee),
+		"refund":      render.FormatPrice(s.Refund),
+	}
+	if s.Due() > 0 {
+		data["due"] = render.FormatPrice(s.Due())
+	}
+	if s.Refund > 0 {
+		data["refunded"] = true
+	}
+
+	m.SendMail(models.MailData{
+		To:          res.Email,
+		From:        "me@here.com",
+		Subject:     "Reservation Cancelled",
+		Template:    "reservation-cancelled",
+		Data:        data,
+		Attachments: []models.Attachment{m.reservationInvite(res, ical.MethodCancel)},
+	})
+
+	notification := make(map[string]interface{})
+	for k, v := range data {
+		notification[k] = v
+	}
+	if unrefunded > 0 {
+		notification["unrefunded"] = render.FormatPrice(unrefunded)
+	}
+
+	m.SendMail(models.MailData{
+		To:       "me@here.com",
+		From:     "me@here.com",
+		Subject:  "Reservation Cancelled",
+		Template: "reservation-cancelled-notification",
+		Data:     notification,
+	})
+
+	return s, unrefunded, nil
+}
+
+// refund pays back amount of the captured payments through the payment gateway, and records the refunds. It
+// returns what couldn't be refunded, because the gateway failed or the payments were taken by another gateway
+func (m *Repository) refund(ctx context.Context, paid []models.Payment, amount int) int {
+	gateway := m.App.Payments
+
+	for _, p := range paid {
+		if amount == 0 {
+			break
+		}
+
+		if p.Kind != models.PaymentKindPayment || p.Status != models.PaymentCaptured {
+			continue
+		}
+
+		if gateway == nil || gateway.Name() != p.Provider {
+			continue
+		}
+
+		n := amount
+		if p.Amount < n {
+			n = p.Amount
+		}
+
+		ref, err := gateway.Refund(ctx, p.ProviderRef, n)
+		if err != nil {
+			m.App.ErrorLog.Printf("payment %s couldn't be refunded: %s", p.ProviderRef, err)
+			continue
+		}
+		amount -= n
+
+		_, err = m.DB.InsertPayment(models.Payment{
+			ReservationID: p.ReservationID,
+			Provider:      p.Provider,
+			ProviderRef:   ref,
+			Kind:          models.PaymentKindRefund,
+			Status:        models.PaymentRefunded,
+			Amount:        n,
+			Currency:      p.Currency,
+		})
+		if err != nil {
+			// the money was paid back, so it mustn't be refunded again by hand
+			m.App.ErrorLog.Printf("refund %s of payment %s couldn't be recorded: %s", ref, p.ProviderRef, err)
+		}
+	}
+
+	return amount
+}
+
+// voidAuthorized releases the payments that were authorized but not captured, which aren't counted as paid, so
+// that the guest isn't charged for a cancelled stay, and records them as voided
+func (m *Repository) voidAuthorized(r *http.Request, paid []models.Payment) {
+	gateway := m.App.Payments
+
+	for _, p := range paid {
+		if p.Kind != models.PaymentKindPayment || p.Status != models.PaymentAuthorized {
+			continue
+		}
+
+		if gateway == nil || gateway.Name() != p.Provider {
+			m.App.ErrorLog.Printf("payment %s must be voided by hand", p.ProviderRef)
+			continue
+		}
+
+		err := gateway.Void(r.Context(), p.ProviderRef)
+		if err != nil {
+			m.App.ErrorLog.Printf("payment %s couldn't be voided: %s", p.ProviderRef, err)
+			continue
+		}
+
+		before, err := m.DB.UpdatePaymentStatus(p.Provider, p.ProviderRef, models.PaymentVoided)
+		if err != nil {
+			// the payment was released, so it mustn't be captured later
+			m.App.ErrorLog.Printf("voiding payment %s couldn't be recorded: %s", p.ProviderRef, err)
+			continue
+		}
+
+		after := before
+		after.Status = models.PaymentVoided
+		m.audit(r, models.AuditUpdate, models.AuditEntityPayment, after.ID, audit.Payment(before), audit.Payment(after))
+	}
+}
+
+// AdminCancelReservation cancels a reservation for the reason given, refunding what its cancellation policy
+// allows
+func (m *Repository) AdminCancelReservation(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	src := chi.URLParam(r, "src")
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+	url := fmt.Sprintf("/admin/reservations/%s/%d", src, id)
+
+	res, err := m.DB.GetReservationByID(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	} else if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	reason := r.Form.Get("reason")
+	if reason == "" {
+		m.AddError(r, "Enter the reason the reservation is cancelled")
+		http.Redirect(w, r, url, http.StatusSeeOther)
+		return
+	}
+
+	if !res.CancelledAt.IsZero() {
+		m.AddError(r, "This reservation was already cancelled")
+		http.Redirect(w, r, url, http.StatusSeeOther)
+		return
+	}
+
+	s, unrefunded, err := m.cancelReservation(r, res, reason)
+	if errors.Is(err, sql.ErrNoRows) {
+		m.AddError(r, "This reservation was already cancelled")
+		http.Redirect(w, r, url, http.StatusSeeOther)
+		return
+	} else if errors.Is(err, repository.ErrInvalidTransition) {
+		m.AddError(r, "This reservation can't be cancelled once the guest has arrived")
+		http.Redirect(w, r, url, http.StatusSeeOther)
+		return
+	} else if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	msg := "Reservation cancelled"
+	if s.Refund > 0 {
+		msg = fmt.Sprintf("Reservation cancelled, with a refund of %s", render.FormatPrice(s.Refund))
+	}
+	m.AddFlash(r, msg)
+
+	if unrefunded > 0 {
+		m.AddWarning(r, fmt.Sprintf("%s couldn't be refunded through the payment provider and must be refunded by hand", render.FormatPrice(unrefunded)))
+	}
+
+	http.Redirect(w, r, url, http.StatusSeeOther)
+}
+
+// renderAdminCancellationPolicies renders the cancellation policies page with form, which holds the values and
+// errors of the last post
+func (m *Repository) renderAdminCancellationPolicies(w http.ResponseWriter, r *http.Request, form *forms.Form) {
+	rooms, err := m.DB.AllRooms()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	seasons, err := m.DB.AllSeasonalRates()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	policies, err := m.DB.AllCancellationPolicies()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	descriptions := make(map[int]string)
+	for _, p := range policies {
+		descriptions[p.ID] = cancellation.Describe(p)
+	}
+
+	data := make(map[string]interface{})
+	data["rooms"] = rooms
+	data["seasons"] = seasons
+	data["policies"] = policies
+	data["descriptions"] = descriptions
+
+	render.Template(w, r, "admin-cancellation-policies.page.tmpl", &models.TemplateData{
+		Data: data,
+		Form: form,
+	})
+}
+
+// AdminCancellationPolicies shows the cancellation policies of all rooms
+func (m *Repository) AdminCancellationPolicies(w http.ResponseWriter, r *http.Request) {
+	m.renderAdminCancellationPolicies(w, r, forms.New(nil))
+}
+
+// AdminPostCancellationPolicy sets the cancellation policy of a room, or of one of its seasons
+func (m *Repository) AdminPostCancellationPolicy(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("room_id", "free_days")
+
+	roomID, err := strconv.Atoi(form.Get("room_id"))
+	if err == nil {
+		_, err = m.DB.GetRoomByID(roomID)
+	}
+	if err != nil && form.Errors.Get("room_id") == "" {
+		form.Errors.Add("room_id", "Choose a room")
+	}
+
+	// a policy without a season is the room's standard policy
+	seasonID := 0
+	if form.Get("seasonal_rate_id") != "" {
+		seasons, err := m.DB.AllSeasonalRates()
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+
+		id, _ := strconv.Atoi(form.Get("seasonal_rate_id"))
+		for _, s := range seasons {
+			if s.ID == id && s.RoomID == roomID {
+				seasonID = id
+			}
+		}
+
+		if seasonID == 0 {
+			form.Errors.Add("seasonal_rate_id", "Choose a season of the room")
+		}
+	}
+
+	limits := make(map[string]int)
+	for _, field := range []string{"free_days", "penalty_percent", "non_refundable_days"} {
+		if form.Get(field) == "" {
+			continue
+		}
+
+		n, err := strconv.Atoi(form.Get(field))
+		if err != nil || n < 0 {
+			form.Errors.Add(field, "Enter a whole number of 0 or more")
+			continue
+		}
+		limits[field] = n
+	}
+
+	if limits["penalty_percent"] > 100 {
+		form.Errors.Add("penalty_percent", "The penalty can't be more than 100%")
+	}
+
+	if limits["non_refundable_days"] > limits["free_days"] {
+		form.Errors.Add("non_refundable_days", "Stays can't become non-refundable before free cancellation ends")
+	}
+
+	if !form.Valid() {
+		m.renderAdminCancellationPolicies(w, r, form)
+		return
+	}
+
+	_, err = m.DB.SaveCancellationPolicy(models.CancellationPolicy{
+		RoomID:            roomID,
+		SeasonalRateID:    seasonID,
+		FreeDays:          limits["free_days"],
+		PenaltyPercent:    limits["penalty_percent"],
+		NonRefundableDays: limits["non_refundable_days"],
+	})
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.AddFlash(r, "Cancellation policy saved")
+	http.Redirect(w, r, "/admin/cancellation-policies", http.StatusSeeOther)
+}
+
+// AdminDeleteCancellationPolicy deletes a cancellation policy
+func (m *Repository) AdminDeleteCancellationPolicy(w http.ResponseWriter, r *http.Request) {
+	m.deleteRule(w, r, m.DB.DeleteCancellationPolicy, "Cancellation policy deleted", "/admin/cancellation-policies")
+}
@@ -0,0 +1,237 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/dhanekom/bookings/internal/helpers"
+	"github.com/dhanekom/bookings/internal/models"
+	"github.com/dhanekom/bookings/internal/payments"
+	"github.com/dhanekom/bookings/internal/repository/dbrepo"
+	"github.com/go-chi/chi/v5"
+)
+
+// capturedFakePayment takes the $300 the test repo records for reservation 1 as fake_1 through fake, and
+// authorizes the $60 it records as fake_2
+func capturedFakePayment(t *testing.T, fake *payments.Fake) {
+	ctx := context.Background()
+
+	checkout, _ := fake.Authorize(ctx, payments.CheckoutRequest{Amount: 30000})
+	_, _, _ = fake.Pay(checkout.PaymentID)
+	if err := fake.Capture(ctx, checkout.PaymentID, 30000); err != nil || checkout.PaymentID != "fake_1" {
+		t.Fatalf("expected fake_1 to be captured, got %s %v", checkout.PaymentID, err)
+	}
+
+	checkout, _ = fake.Authorize(ctx, payments.CheckoutRequest{Amount: 6000})
+	if _, _, err := fake.Pay(checkout.PaymentID); err != nil || checkout.PaymentID != "fake_2" {
+		t.Fatalf("expected fake_2 to be authorized, got %s %v", checkout.PaymentID, err)
+	}
+}
+
+func TestCancelReservation(t *testing.T) {
+	res, _ := Repo.DB.GetReservationByID(1)
+
+	var tests = []struct {
+		name               string
+		gateway            bool
+		expectedUnrefunded int
+		expectedGuestText  string
+		expectedNotice     string
+	}{
+		{"refunded", true, 0, "Refund:            $300.00", "Paid:              $300.00"},
+		{"without a gateway", false, 30000, "Refund:            $300.00", "$300.00 couldn't be refunded"},
+	}
+
+	for _, e := range tests {
+		var fake *payments.Fake
+		done := func() {}
+		if e.gateway {
+			fake, done = useFakePayments()
+			capturedFakePayment(t, fake)
+		}
+
+		before, _ := app.Mail.Store.List("", 0)
+		dbrepo.AuditLog = nil
+
+		req, _ := http.NewRequest("POST", "/manage-booking/cancel", nil)
+		s, unrefunded, err := Repo.cancelReservation(req, res, "Cancelled by the guest")
+		if err != nil {
+			t.Fatalf("%s: %s", e.name, err)
+		}
+
+		if s.Paid != 30000 || s.Fee != 0 || s.Refund != 30000 || unrefunded != e.expectedUnrefunded {
+			t.Errorf("%s: unexpected settlement %+v with %d unrefunded", e.name, s, unrefunded)
+		}
+
+		if fake != nil {
+			if p, _ := fake.Payment("fake_1"); p.Refunded != 30000 {
+				t.Errorf("%s: expected the payment to be refunded, got %d", e.name, p.Refunded)
+			}
+
+			if p, _ := fake.Payment("fake_2"); !p.Voided || p.Captured != 0 {
+				t.Errorf("%s: expected the uncaptured payment to be voided, got %+v", e.name, p)
+			}
+
+			voided := false
+			for _, a := range dbrepo.AuditLog {
+				if a.Entity == models.AuditEntityPayment && strings.Contains(a.After, `"status":"voided"`) {
+					voided = true
+				}
+			}
+			if !voided {
+				t.Errorf("%s: expected the voided payment to be recorded, got %+v", e.name, dbrepo.AuditLog)
+			}
+		}
+
+		after, _ := app.Mail.Store.List("", 0)
+		if len(after)-len(before) != 2 {
+			t.Fatalf("%s: expected 2 messages to be queued, got %d", e.name, len(after)-len(before))
+		}
+
+		notice, guest := after[0].Mail, after[1].Mail
+		if guest.To != res.Email || !strings.Contains(guest.Text, e.expectedGuestText) {
+			t.Errorf("%s: expected the guest to be told %q, got %s", e.name, e.expectedGuestText, guest.Text)
+		}
+
+		if !strings.Contains(notice.Text, "Cancelled by the guest") || !strings.Contains(notice.Text, e.expectedNotice) {
+			t.Errorf("%s: expected the property to be told %q, got %s", e.name, e.expectedNotice, notice.Text)
+		}
+
+		done()
+	}
+}
+
+func TestAdminCancelReservation(t *testing.T) {
+	mux := chi.NewRouter()
+	mux.Use(SessionLoad)
+	mux.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			u := models.User{ID: 1, AccessLevel: models.AccessLevelAdmin}
+			next.ServeHTTP(w, r.WithContext(helpers.ContextWithUser(r.Context(), u)))
+		})
+	})
+	mux.Get("/admin/reservations/{src}/{id}", Repo.AdminShowReservation)
+	mux.Post("/admin/reservations/{src}/{id}/cancel", Repo.AdminCancelReservation)
+
+	var tests = []struct {
+		name             string
+		url              string
+		reason           string
+		expectedCode     int
+		expectedLocation string
+		expectedMessages int
+	}{
+		{"cancel", "/admin/reservations/all/1/cancel", "The guest called to cancel", http.StatusSeeOther, "/admin/reservations/all/1", 2},
+		{"no reason", "/admin/reservations/all/1/cancel", "", http.StatusSeeOther, "/admin/reservations/all/1", 0},
+		{"already cancelled", "/admin/reservations/new/2/cancel", "Again", http.StatusSeeOther, "/admin/reservations/new/2", 0},
+		{"invalid id", "/admin/reservations/all/x/cancel", "Oops", http.StatusBadRequest, "", 0},
+	}
+
+	for _, e := range tests {
+		before, _ := app.Mail.Store.List("", 0)
+
+		req, _ := http.NewRequest("POST", e.url, strings.NewReader(url.Values{"reason": {e.reason}}.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedCode {
+			t.Errorf("%s: expected %d, got %d", e.name, e.expectedCode, rr.Code)
+		}
+
+		if loc := rr.Header().Get("Location"); loc != e.expectedLocation {
+			t.Errorf("%s: expected location %q, got %q", e.name, e.expectedLocation, loc)
+		}
+
+		after, _ := app.Mail.Store.List("", 0)
+		if len(after)-len(before) != e.expectedMessages {
+			t.Errorf("%s: expected %d messages to be queued, got %d", e.name, e.expectedMessages, len(after)-len(before))
+		}
+	}
+
+	// the reservation page shows what cancelling would cost under the summer policy of room 1
+	req, _ := http.NewRequest("GET", "/admin/reservations/all/1", nil)
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+
+	if !strings.Contains(rr.Body.String(), "Free cancellation until 30 days before arrival") || !strings.Contains(rr.Body.String(), "$300.00 of the $300.00 the guest paid will be refunded") {
+		t.Error("expected the cancellation policy and refund to be shown")
+	}
+}
+
+func TestAdminPostCancellationPolicy(t *testing.T) {
+	var tests = []struct {
+		name          string
+		postedData    url.Values
+		expectedCode  int
+		expectedError string
+	}{
+		{"standard policy", url.Values{
+			"room_id":             {"1"},
+			"free_days":           {"14"},
+			"penalty_percent":     {"50"},
+			"non_refundable_days": {"3"},
+		}, http.StatusSeeOther, ""},
+		{"seasonal policy", url.Values{
+			"room_id":          {"1"},
+			"seasonal_rate_id": {"1"},
+			"free_days":        {"30"},
+		}, http.StatusSeeOther, ""},
+		{"missing room", url.Values{
+			"free_days": {"14"},
+		}, http.StatusOK, "This field cannot be blank"},
+		{"season of another room", url.Values{
+			"room_id":          {"2"},
+			"seasonal_rate_id": {"1"},
+			"free_days":        {"14"},
+		}, http.StatusOK, "Choose a season of the room"},
+		{"penalty over 100%", url.Values{
+			"room_id":         {"1"},
+			"free_days":       {"14"},
+			"penalty_percent": {"150"},
+		}, http.StatusOK, "more than 100%"},
+		{"non-refundable before free cancellation ends", url.Values{
+			"room_id":             {"1"},
+			"free_days":           {"3"},
+			"non_refundable_days": {"14"},
+		}, http.StatusOK, "before free cancellation ends"},
+		{"negative days", url.Values{
+			"room_id":   {"1"},
+			"free_days": {"-1"},
+		}, http.StatusOK, "Enter a whole number of 0 or more"},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", "/admin/cancellation-policies", strings.NewReader(e.postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.AdminPostCancellationPolicy).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedCode {
+			t.Errorf("%s: expected %d, got %d", e.name, e.expectedCode, rr.Code)
+		}
+
+		if e.expectedError != "" && !strings.Contains(rr.Body.String(), e.expectedError) {
+			t.Errorf("%s: expected %q in the response", e.name, e.expectedError)
+		}
+	}
+
+	req, _ := http.NewRequest("GET", "/admin/cancellation-policies", nil)
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(Repo.AdminCancellationPolicies).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "then 50% of the price until 3 days before arrival") {
+		t.Errorf("expected the policies to be listed, got %d", rr.Code)
+	}
+}
@@ -0,0 +1,278 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/dhanekom/bookings/internal/forms"
+	"github.com/dhanekom/bookings/internal/helpers"
+	"github.com/dhanekom/bookings/internal/ical"
+	"github.com/dhanekom/bookings/internal/models"
+	"github.com/dhanekom/bookings/internal/render"
+	"github.com/dhanekom/bookings/internal/repository"
+	"github.com/dhanekom/bookings/internal/stayrules"
+	"github.com/go-chi/chi/v5"
+)
+
+// sessionCart returns the cart kept in the session, which is empty if the guest hasn't added a room
+func (m *Repository) sessionCart(r *http.Request) models.Cart {
+	cart, _ := m.App.Session.Get(r.Context(), "cart").(models.Cart)
+	return cart
+}
+
+// renderCart renders the cart page with form, which holds the guest details and errors of the last checkout
+func (m *Repository) renderCart(w http.ResponseWriter, r *http.Request, cart models.Cart, form *forms.Form) {
+	var total int
+	var hold models.RoomRestriction
+	for _, item := range cart.Items {
+		total += item.Reservation.Quote.Total
+
+		// the countdown shows the hold that expires first
+		if item.Hold.ID != 0 && (hold.ID == 0 || item.Hold.ExpiresAt.Before(hold.ExpiresAt)) {
+			hold = item.Hold
+		}
+	}
+
+	data := make(map[string]interface{})
+	data["cart"] = cart
+	if hold.ID != 0 {
+		data["hold"] = hold
+	}
+
+	intMap := make(map[string]int)
+	intMap["total"] = total
+
+	render.Template(w, r, "cart.page.tmpl", &models.TemplateData{
+		Data:   data,
+		IntMap: intMap,
+		Form:   form,
+	})
+}
+
+// Cart shows the rooms the guest has added to their booking
+func (m *Repository) Cart(w http.ResponseWriter, r *http.Request) {
+	m.renderCart(w, r, m.sessionCart(r), forms.New(nil))
+}
+
+// PostCartAdd adds a room to the cart for the dates and guests of the last search, and holds it until the
+// guest checks out
+func (m *Repository) PostCartAdd(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	res, ok := m.App.Session.Get(r.Context(), "reservation").(models.Reservation)
+	if !ok {
+		m.AddError(r, "Search for your dates before adding a room")
+		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+		return
+	}
+
+	roomID, err := strconv.Atoi(r.Form.Get("room_id"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	room, quote, err := m.quoteRoom(roomID, res.StartDate, res.EndDate)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	cart := m.sessionCart(r)
+	for _, item := range cart.Items {
+		other := item.Reservation
+		if other.RoomID == roomID && other.StartDate.Before(res.EndDate) && res.StartDate.Before(other.EndDate) {
+			m.AddError(r, fmt.Sprintf("%s is already in your booking for some of these dates", room.RoomName))
+			http.Redirect(w, r, "/cart", http.StatusSeeOther)
+			return
+		}
+	}
+
+	res.RoomID = roomID
+	res.Room.RoomName = room.RoomName
+	res.Quote = quote
+
+	hold, err := m.newHold(r, res)
+	if errors.Is(err, repository.ErrRoomUnavailable) {
+		m.AddError(r, "Sorry, this room has just been taken for some of your dates. Please search again.")
+		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+		return
+	} else if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	cart.Items = append(cart.Items, models.CartItem{Reservation: res, Hold: hold})
+	m.App.Session.Put(r.Context(), "cart", cart)
+
+	m.AddFlash(r, fmt.Sprintf("%s added to your booking", room.RoomName))
+	http.Redirect(w, r, "/cart", http.StatusSeeOther)
+}
+
+// PostCartRemove removes a room from the cart by its position, and releases its hold
+func (m *Repository) PostCartRemove(w http.ResponseWriter, r *http.Request) {
+	cart := m.sessionCart(r)
+
+	i, err := strconv.Atoi(chi.URLParam(r, "index"))
+	if err != nil || i < 0 || i >= len(cart.Items) {
+		http.Redirect(w, r, "/cart", http.StatusSeeOther)
+		return
+	}
+
+	if hold := cart.Items[i].Hold; hold.ID != 0 {
+		if err := m.DB.ReleaseHold(hold.ID); err != nil {
+			m.App.ErrorLog.Println(err)
+		}
+	}
+
+	cart.Items = append(cart.Items[:i], cart.Items[i+1:]...)
+	m.App.Session.Put(r.Context(), "cart", cart)
+
+	m.AddFlash(r, "Room removed from your booking")
+	http.Redirect(w, r, "/cart", http.StatusSeeOther)
+}
+
+// PostCartCheckout books all the rooms in the cart for the posted guest, as one booking. Either every room is
+// booked or none are
+func (m *Repository) PostCartCheckout(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	cart := m.sessionCart(r)
+	if len(cart.Items) == 0 {
+		m.AddError(r, "Add a room to your booking first")
+		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("first_name", "last_name", "email")
+	form.MinLength("first_name", 3)
+	form.IsEmail("email")
+
+	booking := models.Booking{
+		FirstName: form.Get("first_name"),
+		LastName:  form.Get("last_name"),
+		Email:     form.Get("email"),
+		Phone:     form.Get("phone"),
+	}
+
+	var holdIDs []int
+	for _, item := range cart.Items {
+		res := item.Reservation
+
+		// every room is priced and checked again, as prices and rules could have changed since it was added
+		room, quote, err := m.quoteRoom(res.RoomID, res.StartDate, res.EndDate)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+
+		violations, err := m.checkStay(res.RoomID, res.StartDate, res.EndDate)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+		violations = append(violations, stayrules.CheckOccupancy(room, res.Adults, res.Children)...)
+
+		for _, v := range violations {
+			form.Errors.Add("cart", fmt.Sprintf("%s: %s", room.RoomName, v.Message))
+		}
+
+		res.FirstName = booking.FirstName
+		res.LastName = booking.LastName
+		res.Email = booking.Email
+		res.Phone = booking.Phone
+		res.Room.RoomName = room.RoomName
+		res.Quote = quote
+
+		res.ConfirmationCode, err = helpers.NewConfirmationCode()
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+
+		booking.Reservations = append(booking.Reservations, res)
+		booking.TotalPrice += quote.Total
+		holdIDs = append(holdIDs, item.Hold.ID)
+	}
+
+	if !form.Valid() {
+		m.renderCart(w, r, cart, form)
+		return
+	}
+
+	bookingID, ids, err := m.DB.BookRooms(r.Context(), booking, holdIDs)
+	if errors.Is(err, repository.ErrRoomUnavailable) {
+		form.Errors.Add("cart", "Sorry, one of these rooms has just been booked for some of your dates. Please remove it and search again.")
+		m.renderCart(w, r, cart, form)
+		return
+	} else if err != nil {
+		m.AddError(r, "can't insert booking into database")
+		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+		return
+	}
+
+	booking.ID = bookingID
+	var invites []models.Attachment
+	for i := range booking.Reservations {
+		booking.Reservations[i].ID = ids[i]
+		booking.Reservations[i].BookingID = bookingID
+
+		invite := m.reservationInvite(booking.Reservations[i], ical.MethodRequest)
+		invite.Filename = fmt.Sprintf("invite-%d.ics", i+1)
+		invites = append(invites, invite)
+	}
+
+	m.SendMail(models.MailData{
+		To:       booking.Email,
+		From:     "me@here.com",
+		Subject:  "Booking Confirmation",
+		Template: "booking-confirmation",
+		Data: map[string]interface{}{
+			"booking":    booking,
+			"manage_url": m.App.BaseURL + "/manage-booking",
+		},
+		Attachments: invites,
+	})
+
+	m.SendMail(models.MailData{
+		To:       "me@here.com",
+		From:     "me@here.com",
+		Subject:  "Booking Notification",
+		Template: "booking-notification",
+		Data: map[string]interface{}{
+			"booking": booking,
+		},
+	})
+
+	m.App.Session.Remove(r.Context(), "cart")
+	m.App.Session.Put(r.Context(), "booking", booking)
+	http.Redirect(w, r, "/booking-summary", http.StatusSeeOther)
+}
+
+// BookingSummary shows the booking the guest just checked out
+func (m *Repository) BookingSummary(w http.ResponseWriter, r *http.Request) {
+	booking, ok := m.App.Session.Pop(r.Context(), "booking").(models.Booking)
+	if !ok {
+		m.AddFlash(r, "Booking details not set in session")
+		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["booking"] = booking
+
+	render.Template(w, r, "booking-summary.page.tmpl", &models.TemplateData{
+		Data: data,
+	})
+}
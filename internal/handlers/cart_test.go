@@ -0,0 +1,288 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dhanekom/bookings/internal/helpers"
+	"github.com/dhanekom/bookings/internal/mailer"
+	"github.com/dhanekom/bookings/internal/models"
+	"github.com/go-chi/chi/v5"
+)
+
+// cartItem returns a cart item for a room on the given January 2050 dates
+func cartItem(roomID, start, end int) models.CartItem {
+	res := models.Reservation{
+		RoomID:    roomID,
+		StartDate: time.Date(2050, 1, start, 0, 0, 0, 0, time.UTC),
+		EndDate:   time.Date(2050, 1, end, 0, 0, 0, 0, time.UTC),
+		Adults:    2,
+	}
+	res.Quote.Total = 10000
+
+	return models.CartItem{
+		Reservation: res,
+		Hold:        models.RoomRestriction{ID: roomID + 100, RoomID: roomID, ExpiresAt: time.Now().Add(10 * time.Minute)},
+	}
+}
+
+func TestCart(t *testing.T) {
+	search := models.Reservation{
+		StartDate: time.Date(2050, 1, 3, 0, 0, 0, 0, time.UTC),
+		EndDate:   time.Date(2050, 1, 5, 0, 0, 0, 0, time.UTC),
+		Adults:    2,
+	}
+
+	var tests = []struct {
+		name             string
+		method           string
+		url              string
+		index            string
+		search           bool
+		cart             []models.CartItem
+		postedData       url.Values
+		expectedCode     int
+		expectedLocation string
+		expectedItems    int
+		expectedText     string
+	}{
+		{"show empty", "GET", "/cart", "", false, nil, nil, http.StatusOK, "", 0, "You haven't added any rooms yet"},
+		{"show", "GET", "/cart", "", false, []models.CartItem{cartItem(1, 3, 5), cartItem(2, 3, 5)}, nil, http.StatusOK, "", 2, "$200.00"},
+		{"show countdown", "GET", "/cart", "", false, []models.CartItem{cartItem(1, 3, 5)}, nil, http.StatusOK, "", 1, `id="hold-countdown"`},
+		{"add", "POST", "/cart/add", "", true, []models.CartItem{cartItem(1, 3, 5)}, url.Values{"room_id": {"2"}}, http.StatusSeeOther, "/cart", 2, ""},
+		{"add without search", "POST", "/cart/add", "", false, nil, url.Values{"room_id": {"2"}}, http.StatusSeeOther, "/search-availability", 0, ""},
+		{"add invalid room", "POST", "/cart/add", "", true, nil, url.Values{"room_id": {"x"}}, http.StatusBadRequest, "", 0, ""},
+		{"add room already in cart", "POST", "/cart/add", "", true, []models.CartItem{cartItem(2, 4, 6)}, url.Values{"room_id": {"2"}}, http.StatusSeeOther, "/cart", 1, ""},
+		{"add same room on other dates", "POST", "/cart/add", "", true, []models.CartItem{cartItem(2, 5, 7)}, url.Values{"room_id": {"2"}}, http.StatusSeeOther, "/cart", 2, ""},
+		{"add room taken", "POST", "/cart/add", "", true, nil, url.Values{"room_id": {"1001"}}, http.StatusSeeOther, "/search-availability", 0, ""},
+		{"remove", "POST", "/cart/0/remove", "0", false, []models.CartItem{cartItem(1, 3, 5), cartItem(2, 3, 5)}, nil, http.StatusSeeOther, "/cart", 1, ""},
+		{"remove missing", "POST", "/cart/5/remove", "5", false, []models.CartItem{cartItem(1, 3, 5)}, nil, http.StatusSeeOther, "/cart", 1, ""},
+	}
+
+	for _, e := range tests {
+		var body *strings.Reader
+		if e.postedData != nil {
+			body = strings.NewReader(e.postedData.Encode())
+		} else {
+			body = strings.NewReader("")
+		}
+
+		req, _ := http.NewRequest(e.method, e.url, body)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		ctx := getCtx(req)
+		if e.index != "" {
+			rctx := chi.NewRouteContext()
+			rctx.URLParams.Add("index", e.index)
+			ctx = context.WithValue(ctx, chi.RouteCtxKey, rctx)
+		}
+		req = req.WithContext(ctx)
+
+		if e.search {
+			session.Put(ctx, "reservation", search)
+		}
+		if e.cart != nil {
+			session.Put(ctx, "cart", models.Cart{Items: e.cart})
+		}
+
+		handler := Repo.Cart
+		switch {
+		case strings.HasSuffix(e.url, "/add"):
+			handler = Repo.PostCartAdd
+		case strings.HasSuffix(e.url, "/remove"):
+			handler = Repo.PostCartRemove
+		}
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(handler).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedCode {
+			t.Errorf("%s: expected %d, got %d", e.name, e.expectedCode, rr.Code)
+		}
+
+		if e.expectedLocation != "" && rr.Header().Get("Location") != e.expectedLocation {
+			t.Errorf("%s: expected location %s, got %s", e.name, e.expectedLocation, rr.Header().Get("Location"))
+		}
+
+		if e.expectedText != "" && !strings.Contains(rr.Body.String(), e.expectedText) {
+			t.Errorf("%s: expected %q in the response", e.name, e.expectedText)
+		}
+
+		cart, _ := session.Get(ctx, "cart").(models.Cart)
+		if len(cart.Items) != e.expectedItems {
+			t.Errorf("%s: expected %d rooms in the cart, got %d", e.name, e.expectedItems, len(cart.Items))
+		}
+	}
+}
+
+func TestCartCheckout(t *testing.T) {
+	// use an outbox of our own to find the messages queued by this booking
+	saved := app.Mail
+	defer func() { app.Mail = saved }()
+	app.Mail = mailer.NewOutbox(mailer.NewMemoryStore(), nil, nil)
+	app.Mail.Templates = saved.Templates
+
+	guest := url.Values{
+		"first_name": {"John"},
+		"last_name":  {"Smith"},
+		"email":      {"john@smith.com"},
+		"phone":      {"123456789"},
+	}
+
+	with := func(key, value string) url.Values {
+		v := url.Values{}
+		for k, values := range guest {
+			v[k] = values
+		}
+		v.Set(key, value)
+		return v
+	}
+
+	var tests = []struct {
+		name             string
+		cart             []models.CartItem
+		postedData       url.Values
+		expectedCode     int
+		expectedLocation string
+		expectedText     string
+		expectedMessages int
+	}{
+		{"checkout", []models.CartItem{cartItem(1, 3, 5), cartItem(2, 3, 5)}, guest, http.StatusSeeOther, "/booking-summary", "", 2},
+		{"empty cart", nil, guest, http.StatusSeeOther, "/search-availability", "", 0},
+		{"invalid guest", []models.CartItem{cartItem(1, 3, 5)}, with("email", "john"), http.StatusOK, "", "Invalid email address", 0},
+		// Major's Suite doesn't take arrivals on Sundays in February
+		{"stay rules broken", []models.CartItem{cartItem(1, 3, 5), {Reservation: models.Reservation{
+			RoomID:    2,
+			StartDate: time.Date(2050, 2, 6, 0, 0, 0, 0, time.UTC),
+			EndDate:   time.Date(2050, 2, 8, 0, 0, 0, 0, time.UTC),
+			Adults:    2,
+		}}}, guest, http.StatusOK, "", "Major&#39;s Suite: Arrivals aren&#39;t possible on Sundays", 0},
+		{"room taken", []models.CartItem{cartItem(1, 3, 5), cartItem(1001, 3, 5)}, guest, http.StatusOK, "", "one of these rooms has just been booked", 0},
+	}
+
+	for _, e := range tests {
+		before, _ := app.Mail.Store.List("", 0)
+
+		req, _ := http.NewRequest("POST", "/cart/checkout", strings.NewReader(e.postedData.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		if e.cart != nil {
+			session.Put(ctx, "cart", models.Cart{Items: e.cart})
+		}
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.PostCartCheckout).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedCode {
+			t.Errorf("%s: expected %d, got %d", e.name, e.expectedCode, rr.Code)
+		}
+
+		if e.expectedLocation != "" && rr.Header().Get("Location") != e.expectedLocation {
+			t.Errorf("%s: expected location %s, got %s", e.name, e.expectedLocation, rr.Header().Get("Location"))
+		}
+
+		if e.expectedText != "" && !strings.Contains(rr.Body.String(), e.expectedText) {
+			t.Errorf("%s: expected %q in the response", e.name, e.expectedText)
+		}
+
+		after, _ := app.Mail.Store.List("", 0)
+		if len(after)-len(before) != e.expectedMessages {
+			t.Errorf("%s: expected %d messages to be queued, got %d", e.name, e.expectedMessages, len(after)-len(before))
+		}
+
+		if e.expectedLocation != "/booking-summary" {
+			continue
+		}
+
+		booking, ok := session.Get(ctx, "booking").(models.Booking)
+		if !ok || len(booking.Reservations) != 2 || booking.ID == 0 {
+			t.Fatalf("%s: expected the booking of both rooms in the session, got %+v", e.name, booking)
+		}
+
+		if session.Exists(ctx, "cart") {
+			t.Errorf("%s: expected the cart to be emptied", e.name)
+		}
+
+		// messages are listed newest first
+		staff, confirmation := after[0].Mail, after[1].Mail
+		if len(confirmation.Attachments) != 2 {
+			t.Errorf("%s: expected an invite for each room, got %d", e.name, len(confirmation.Attachments))
+		}
+
+		for _, res := range booking.Reservations {
+			if res.BookingID != booking.ID || res.ConfirmationCode == "" {
+				t.Errorf("%s: expected every reservation to belong to the booking with its own code, got %+v", e.name, res)
+			}
+
+			if !strings.Contains(confirmation.Text, res.ConfirmationCode) {
+				t.Errorf("%s: expected the confirmation to list code %s", e.name, res.ConfirmationCode)
+			}
+		}
+
+		if !strings.Contains(staff.Content, "A booking of 2 rooms has been made") {
+			t.Errorf("%s: expected a notification of the booking, got %q", e.name, staff.Content)
+		}
+
+		// the summary shows the booking once
+		req, _ = http.NewRequest("GET", "/booking-summary", nil)
+		req = req.WithContext(ctx)
+		rr = httptest.NewRecorder()
+		http.HandlerFunc(Repo.BookingSummary).ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), booking.Reservations[1].ConfirmationCode) {
+			t.Errorf("%s: expected the summary to show the booking, got %d", e.name, rr.Code)
+		}
+
+		rr = httptest.NewRecorder()
+		http.HandlerFunc(Repo.BookingSummary).ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusTemporaryRedirect {
+			t.Errorf("%s: expected a redirect once the summary was shown, got %d", e.name, rr.Code)
+		}
+	}
+}
+
+func TestAdminShowBooking(t *testing.T) {
+	mux := chi.NewRouter()
+	mux.Use(SessionLoad)
+	mux.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			u := models.User{ID: 1, AccessLevel: models.AccessLevelAdmin}
+			next.ServeHTTP(w, r.WithContext(helpers.ContextWithUser(r.Context(), u)))
+		})
+	})
+	mux.Get("/admin/reservations/{src}/{id}", Repo.AdminShowReservation)
+
+	var tests = []struct {
+		name     string
+		url      string
+		expected bool
+	}{
+		{"booked alone", "/admin/reservations/all/1", false},
+		{"booked with other rooms", "/admin/reservations/all/3", true},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", e.url, nil)
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusOK {
+			t.Errorf("%s: expected %d, got %d", e.name, http.StatusOK, rr.Code)
+		}
+
+		body := rr.Body.String()
+		if got := strings.Contains(body, "Booking #1"); got != e.expected {
+			t.Errorf("%s: expected the booking shown %v, got %v", e.name, e.expected, got)
+		}
+
+		if e.expected && !strings.Contains(body, `<a href="/admin/reservations/all/4">Major&#39;s Suite</a>`) {
+			t.Errorf("%s: expected a link to the other room of the booking", e.name)
+		}
+	}
+}
@@ -1,20 +1,29 @@
 package handlers
 
 import (
+	"database/sql"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
+	"github.com/dhanekom/bookings/internal/audit"
+	"github.com/dhanekom/bookings/internal/cancellation"
 	"github.com/dhanekom/bookings/internal/config"
 	"github.com/dhanekom/bookings/internal/forms"
 	"github.com/dhanekom/bookings/internal/helpers"
+	"github.com/dhanekom/bookings/internal/ical"
+	"github.com/dhanekom/bookings/internal/lifecycle"
 	"github.com/dhanekom/bookings/internal/models"
+	"github.com/dhanekom/bookings/internal/pricing"
 	"github.com/dhanekom/bookings/internal/render"
 	"github.com/dhanekom/bookings/internal/repository"
+	"github.com/dhanekom/bookings/internal/stayrules"
+	"github.com/dhanekom/bookings/internal/throttle"
 	"github.com/go-chi/chi/v5"
 )
 
@@ -44,6 +53,14 @@ func (repo *Repository) AddWarning(r *http.Request, msg string) {
 	repo.App.Session.Put(r.Context(), "warning", msg)
 }
 
+// SendMail queues msg in the outbox. A failure to queue it is logged rather than failing the request
+func (repo *Repository) SendMail(msg models.MailData) {
+	err := repo.App.Mail.Queue(msg)
+	if err != nil {
+		repo.App.ErrorLog.Println(err)
+	}
+}
+
 func (m *Repository) Home(w http.ResponseWriter, r *http.Request) {
 	render.Template(w, r, "home.page.tmpl", &models.TemplateData{})
 }
@@ -61,14 +78,19 @@ func (m *Repository) Reservation(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	room, err := m.DB.GetRoomByID(res.RoomID)
-	if err != nil {
+	room, quote, err := m.quoteRoom(res.RoomID, res.StartDate, res.EndDate)
+	if errors.Is(err, pricing.ErrNoNights) {
+		m.AddError(r, "The departure date must be after the arrival date")
+		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+		return
+	} else if err != nil {
 		m.AddError(r, "can't find room")
 		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
 		return
 	}
 
 	res.Room.RoomName = room.RoomName
+	res.Quote = quote
 
 	m.App.Session.Put(r.Context(), "reservation", res)
 
@@ -81,6 +103,9 @@ func (m *Repository) Reservation(w http.ResponseWriter, r *http.Request) {
 
 	data := make(map[string]interface{})
 	data["reservation"] = res
+	if hold, ok := m.sessionHold(r, res); ok {
+		data["hold"] = hold
+	}
 
 	render.Template(w, r, "make-reservation.page.tmpl", &models.TemplateData{
 		Form:      forms.New(nil),
@@ -130,6 +155,15 @@ func (m *Repository) PostReservation(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// the stay is priced again rather than trusting the quote shown on the form. A stay without nights can't
+	// be priced, and is reported with the stay rules below
+	room, quote, err := m.quoteRoom(roomID, startDate, endDate)
+	if err != nil && !errors.Is(err, pricing.ErrNoNights) {
+		m.AddError(r, "can't price reservation")
+		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+		return
+	}
+
 	reservation := models.Reservation{
 		FirstName: r.Form.Get("first_name"),
 		LastName:  r.Form.Get("last_name"),
@@ -138,7 +172,9 @@ func (m *Repository) PostReservation(w http.ResponseWriter, r *http.Request) {
 		StartDate: startDate,
 		EndDate:   endDate,
 		RoomID:    roomID,
+		Quote:     quote,
 	}
+	reservation.Room.RoomName = room.RoomName
 
 	// reservation.FirstName = r.Form.Get("first_name")
 	// reservation.LastName = r.Form.Get("last_name")
@@ -154,126 +190,219 @@ func (m *Repository) PostReservation(w http.ResponseWriter, r *http.Request) {
 	form.MinLength("first_name", 3)
 	form.IsEmail("email")
 
+	// the stay rules are checked again, as the dates could have changed since the search
+	violations, err := m.checkStay(roomID, startDate, endDate)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	addStayErrors(form, violations, "start_date", "end_date")
+
+	reservation.Adults, reservation.Children = parseParty(form)
+	if form.Errors.Get("adults") == "" && form.Errors.Get("children") == "" {
+		addStayErrors(form, stayrules.CheckOccupancy(room, reservation.Adults, reservation.Children), "start_date", "end_date")
+	}
+
+	stringMap := make(map[string]string)
+	stringMap["start_date"] = sd
+	stringMap["end_date"] = ed
+
 	// If not valid, re-render for with previous values
 	if !form.Valid() {
 		data := make(map[string]interface{})
 		data["reservation"] = reservation
 		http.Error(w, "my own error message", http.StatusSeeOther)
 		render.Template(w, r, "make-reservation.page.tmpl", &models.TemplateData{
-			Form: form,
-			Data: data,
+			Form:      form,
+			Data:      data,
+			StringMap: stringMap,
 		})
 		return
 	}
 
-	newReservationID, err := m.DB.InsertReservation(reservation)
+	reservation.ConfirmationCode, err = helpers.NewConfirmationCode()
 	if err != nil {
-		m.AddError(r, "can't insert reservation into database")
-		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+		helpers.ServerError(w, err)
 		return
 	}
 
-	restriction := models.RoomRestriction{
-		StartDate:     reservation.StartDate,
-		EndDate:       reservation.EndDate,
-		RoomID:        reservation.RoomID,
-		ReservationID: newReservationID,
-		RestrictionID: 1,
-	}
+	// the guest's hold on the room is turned into the reservation, even if it has expired. If a deposit is
+	// due, the room stays held for the reservation while the guest pays
+	hold, _ := m.App.Session.Get(r.Context(), "hold").(models.RoomRestriction)
 
-	err = m.DB.InsertRoomRestriction(restriction)
-	if err != nil {
-		m.AddError(r, "can't insert room restriction into database")
+	var newReservationID int
+	var checkoutURL string
+	if m.deposit(reservation.Quote.Total) > 0 {
+		newReservationID, checkoutURL, err = m.reserveForPayment(r.Context(), reservation, hold.ID)
+	} else {
+		newReservationID, err = m.DB.BookRoom(r.Context(), reservation, hold.ID)
+	}
+	if errors.Is(err, repository.ErrRoomUnavailable) {
+		form.Errors.Add("room_id", "Sorry, this room has just been booked for some of your dates. Please search again.")
+		data := make(map[string]interface{})
+		data["reservation"] = reservation
+		render.Template(w, r, "make-reservation.page.tmpl", &models.TemplateData{
+			Form:      form,
+			Data:      data,
+			StringMap: stringMap,
+		})
+		return
+	} else if err != nil {
+		m.AddError(r, "can't insert reservation into database")
 		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
 		return
 	}
-
-	htmlMessage := fmt.Sprintf(`
-		<strong>Reservation Confirmation</strong><br>
-		Dear %s: <br>
-		This is to confirm you reservation from %s to %s.
-	`, reservation.FirstName, reservation.StartDate.Format("2006-01-02"), reservation.EndDate.Format("2006-01-02"))
-
-	msg := models.MailData{
-		To:       reservation.Email,
-		From:     "me@here.com",
-		Subject:  "Reservation Confirmation",
-		Content:  htmlMessage,
-		Template: "basic.html",
-	}
-
-	m.App.MailChan <- msg
-
-	htmlMessage = fmt.Sprintf(`
-		<strong>Reservation Notification</strong><br>
-		A reservation has been made for %s from %s to %s.
-	`, reservation.Room.RoomName, reservation.StartDate.Format("2006-01-02"), reservation.EndDate.Format("2006-01-02"))
-
-	msg = models.MailData{
-		To:      "me@here.com",
-		From:    "me@here.com",
-		Subject: "Reservation Confirmation",
-		Content: htmlMessage,
+	reservation.ID = newReservationID
+	m.App.Session.Remove(r.Context(), "hold")
+	m.audit(r, models.AuditCreate, models.AuditEntityReservation, reservation.ID, nil, audit.Reservation(reservation))
+
+	// the reservation is confirmed once the payment provider reports the payment
+	if checkoutURL != "" {
+		reservation.AwaitingPayment = true
+		m.App.Session.Put(r.Context(), "reservation", reservation)
+		http.Redirect(w, r, checkoutURL, http.StatusSeeOther)
+		return
 	}
 
-	m.App.MailChan <- msg
+	m.sendReservationConfirmation(reservation)
 
 	m.App.Session.Put(r.Context(), "reservation", reservation)
 	http.Redirect(w, r, "/reservation-summary", http.StatusSeeOther)
 }
 
-// Generals renders the room page
-func (m *Repository) Generals(w http.ResponseWriter, r *http.Request) {
-	render.Template(w, r, "generals.page.tmpl", &models.TemplateData{})
-}
+// sendReservationConfirmation emails the guest the confirmation of a reservation, and notifies the property
+func (m *Repository) sendReservationConfirmation(reservation models.Reservation) {
+	m.SendMail(models.MailData{
+		To:       reservation.Email,
+		From:     "me@here.com",
+		Subject:  "Reservation Confirmation",
+		Template: "reservation-confirmation",
+		Data: map[string]interface{}{
+			"reservation": reservation,
+			"manage_url":  m.App.BaseURL + "/manage-booking",
+		},
+		Attachments: []models.Attachment{m.reservationInvite(reservation, ical.MethodRequest)},
+	})
 
-// Majors renders the room page
-func (m *Repository) Majors(w http.ResponseWriter, r *http.Request) {
-	render.Template(w, r, "majors.page.tmpl", &models.TemplateData{})
+	m.SendMail(models.MailData{
+		To:       "me@here.com",
+		From:     "me@here.com",
+		Subject:  "Reservation Notification",
+		Template: "reservation-notification",
+		Data: map[string]interface{}{
+			"reservation": reservation,
+		},
+	})
 }
 
 // Availability renders the search availability page
 func (m *Repository) Availability(w http.ResponseWriter, r *http.Request) {
-	render.Template(w, r, "search-availability.page.tmpl", &models.TemplateData{})
+	render.Template(w, r, "search-availability.page.tmpl", &models.TemplateData{
+		Form: forms.New(nil),
+	})
 }
 
 // PostAvailability renders the search availability page
 func (m *Repository) PostAvailability(w http.ResponseWriter, r *http.Request) {
-	start := r.Form.Get("start")
-	end := r.Form.Get("end")
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("start", "end", "adults")
 
 	layout := "2006-01-02"
 
-	startDate, err := time.Parse(layout, start)
-	if err != nil {
-		helpers.ServerError(w, err)
+	startDate, err := time.Parse(layout, form.Get("start"))
+	if err != nil && form.Errors.Get("start") == "" {
+		form.Errors.Add("start", "Invalid date")
+	}
+
+	endDate, err := time.Parse(layout, form.Get("end"))
+	if err != nil && form.Errors.Get("end") == "" {
+		form.Errors.Add("end", "Invalid date")
+	}
+
+	if form.Valid() {
+		addStayErrors(form, stayrules.Check(nil, startDate, endDate, time.Now()), "start", "end")
+	}
+
+	adults, children := 0, 0
+	if form.Get("adults") != "" {
+		adults, children = parseParty(form)
+	}
+
+	if !form.Valid() {
+		render.Template(w, r, "search-availability.page.tmpl", &models.TemplateData{
+			Form: form,
+		})
 		return
 	}
 
-	endDate, err := time.Parse(layout, end)
+	available, err := m.DB.SearchAvailabilityForAllRooms(startDate, endDate, adults, children)
 	if err != nil {
 		helpers.ServerError(w, err)
 		return
 	}
 
-	rooms, err := m.DB.SearchAvailabilityForAllRooms(startDate, endDate)
-	if err != nil {
-		helpers.ServerError(w, err)
+	if len(available) == 0 {
+		m.AddWarning(r, "No rooms are available for these dates. Join the waitlist and we'll email you if one becomes free.")
+		http.Redirect(w, r, waitlistURL(startDate, endDate, adults, children), http.StatusSeeOther)
 		return
 	}
 
+	// rooms whose stay rules the stay breaks aren't offered, and if that's all of them the guest is told why
+	var rooms []models.Room
+	var violations []stayrules.Violation
+	for _, room := range available {
+		v, err := m.checkStay(room.ID, startDate, endDate)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+
+		if len(v) > 0 {
+			violations = append(violations, v...)
+			continue
+		}
+
+		rooms = append(rooms, room)
+	}
+
 	if len(rooms) == 0 {
-		m.AddError(r, "No availability")
-		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+		addStayErrors(form, violations, "start", "end")
+		render.Template(w, r, "search-availability.page.tmpl", &models.TemplateData{
+			Form: form,
+		})
 		return
 	}
 
+	quotes, err := m.quote(startDate, endDate, rooms...)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	// quotes are looked up by room id
+	roomQuotes := make(map[int]models.Quote)
+	for i, room := range rooms {
+		roomQuotes[room.ID] = quotes[i]
+	}
+
 	data := make(map[string]interface{})
 	data["rooms"] = rooms
+	data["quotes"] = roomQuotes
+	if cart := m.sessionCart(r); len(cart.Items) > 0 {
+		data["cart_items"] = len(cart.Items)
+	}
 
 	res := models.Reservation{
 		StartDate: startDate,
 		EndDate:   endDate,
+		Adults:    adults,
+		Children:  children,
 	}
 
 	m.App.Session.Put(r.Context(), "reservation", res)
@@ -284,11 +413,16 @@ func (m *Repository) PostAvailability(w http.ResponseWriter, r *http.Request) {
 }
 
 type jsonResponse struct {
-	OK        bool   `json:"ok"`
-	Message   string `json:"message"`
-	RoomID    string `json:"room_id"`
-	StartDate string `json:"start_date"`
-	EndDate   string `json:"end_date"`
+	OK         bool   `json:"ok"`
+	Message    string `json:"message"`
+	RoomID     string `json:"room_id"`
+	StartDate  string `json:"start_date"`
+	EndDate    string `json:"end_date"`
+	Adults     int    `json:"adults,omitempty"`
+	Children   int    `json:"children,omitempty"`
+	Nights     int    `json:"nights,omitempty"`
+	TotalPrice int    `json:"total_price,omitempty"`
+	Total      string `json:"total,omitempty"`
 }
 
 // AvailabilityJSON handles requests for availability and send JSON response
@@ -331,6 +465,13 @@ func (m *Repository) AvailabilityJSON(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// the party is optional, and is one adult if it isn't given
+	form := forms.New(r.PostForm)
+	if form.Get("adults") == "" {
+		form.Set("adults", "1")
+	}
+	adults, children := parseParty(form)
+
 	available, err := m.DB.SearchAvailabilityByDatesByRoomID(startDate, endDate, roomID)
 	if err != nil {
 		resp := jsonResponse{
@@ -350,6 +491,46 @@ func (m *Repository) AvailabilityJSON(w http.ResponseWriter, r *http.Request) {
 		StartDate: sd,
 		EndDate:   ed,
 		RoomID:    strconv.Itoa(roomID),
+		Adults:    adults,
+		Children:  children,
+	}
+
+	if !form.Valid() {
+		resp.OK = false
+		resp.Message = form.Errors.Get("adults")
+		if resp.Message == "" {
+			resp.Message = form.Errors.Get("children")
+		}
+	}
+
+	if resp.OK {
+		violations, err := m.checkStay(roomID, startDate, endDate)
+		if err != nil {
+			resp = jsonResponse{
+				OK:      false,
+				Message: "Error connecting to database",
+			}
+		} else if len(violations) > 0 {
+			resp.OK = false
+			resp.Message = violations[0].Message
+		}
+	}
+
+	if resp.OK {
+		room, quote, err := m.quoteRoom(roomID, startDate, endDate)
+		if err != nil {
+			resp = jsonResponse{
+				OK:      false,
+				Message: "Unable to price the stay",
+			}
+		} else if violations := stayrules.CheckOccupancy(room, adults, children); len(violations) > 0 {
+			resp.OK = false
+			resp.Message = violations[0].Message
+		} else {
+			resp.Nights = len(quote.Nights)
+			resp.TotalPrice = quote.Total
+			resp.Total = render.FormatPrice(quote.Total)
+		}
 	}
 
 	out, _ := json.MarshalIndent(resp, "", "     ")
@@ -404,10 +585,31 @@ func (m *Repository) ChooseRoom(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	res.RoomID = roomID
+
+	if !m.holdRoom(w, r, res) {
+		return
+	}
+
 	m.App.Session.Put(r.Context(), "reservation", res)
 	http.Redirect(w, r, "/make-reservation", http.StatusSeeOther)
 }
 
+// holdRoom places a hold for res, and redirects to the search page if the room has been taken in the meantime.
+// It returns false if the response has been written
+func (m *Repository) holdRoom(w http.ResponseWriter, r *http.Request, res models.Reservation) bool {
+	err := m.placeHold(r, res)
+	if errors.Is(err, repository.ErrRoomUnavailable) {
+		m.AddError(r, "Sorry, this room has just been taken for some of your dates. Please search again.")
+		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+		return false
+	} else if err != nil {
+		helpers.ServerError(w, err)
+		return false
+	}
+
+	return true
+}
+
 // BookRoom takes URL parameters, builds a sessional variable, and  takes user to make res screen
 func (m *Repository) BookRoom(w http.ResponseWriter, r *http.Request) {
 	roomID, _ := strconv.Atoi(r.URL.Query().Get("id"))
@@ -417,6 +619,8 @@ func (m *Repository) BookRoom(w http.ResponseWriter, r *http.Request) {
 	const layout = "2006-01-02"
 	startDate, _ := time.Parse(layout, sd)
 	endDate, _ := time.Parse(layout, ed)
+	adults, _ := strconv.Atoi(r.URL.Query().Get("a"))
+	children, _ := strconv.Atoi(r.URL.Query().Get("c"))
 
 	var res models.Reservation
 
@@ -430,6 +634,12 @@ func (m *Repository) BookRoom(w http.ResponseWriter, r *http.Request) {
 	res.RoomID = roomID
 	res.StartDate = startDate
 	res.EndDate = endDate
+	res.Adults = adults
+	res.Children = children
+
+	if !m.holdRoom(w, r, res) {
+		return
+	}
 
 	m.App.Session.Put(r.Context(), "reservation", res)
 	http.Redirect(w, r, "/make-reservation", http.StatusSeeOther)
@@ -467,15 +677,59 @@ func (m *Repository) PostShowLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	ip := helpers.ClientIP(r)
+	wait, err := m.App.LoginThrottle.Attempt(email, ip)
+	if errors.Is(err, throttle.ErrLocked) {
+		m.AddError(r, "This account is locked because of too many failed logins. Try again later or ask an administrator to unlock it")
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	} else if errors.Is(err, throttle.ErrThrottled) {
+		m.AddError(r, fmt.Sprintf("Too many failed logins. Try again in %s", (wait+time.Second-1).Truncate(time.Second)))
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	} else if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
 	id, _, err := m.DB.Authenticate(email, password)
 	if err != nil {
+		// the attempt was counted as a failure when it was made
 		log.Println(err)
+
 		m.AddError(r, "Invalid login credentials")
 		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
 		return
 	}
 
-	m.App.Session.Put(r.Context(), "user_id", id)
+	u, err := m.DB.GetUserByID(id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	if !u.TOTPEnabledAt.IsZero() {
+		// the password is right, but the user isn't logged in until they enter a code as well, which is
+		// throttled as an attempt of its own
+		err = m.App.LoginThrottle.Cancel(email, ip)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+
+		m.App.Session.Put(r.Context(), "two_factor_user_id", id)
+		m.App.Session.Put(r.Context(), "two_factor_started_at", time.Now().Unix())
+		http.Redirect(w, r, "/user/login/two-factor", http.StatusSeeOther)
+		return
+	}
+
+	err = m.App.LoginThrottle.Success(email, ip)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.logIn(r, id)
 	m.AddFlash(r, "Logged in successfully")
 	http.Redirect(w, r, "/", http.StatusSeeOther)
 }
@@ -492,9 +746,15 @@ func (m *Repository) AdminDashboard(w http.ResponseWriter, r *http.Request) {
 	render.Template(w, r, "admin-dashboard.page.tmpl", &models.TemplateData{})
 }
 
-// AdminNewReservations shows all reservations in admin tool
+// AdminAllReservations shows all reservations in admin tool, or those in the state given by ?status=
 func (m *Repository) AdminAllReservations(w http.ResponseWriter, r *http.Request) {
-	reservations, err := m.DB.AllReservations()
+	status := r.URL.Query().Get("status")
+	if status != "" && !lifecycle.Valid(status) {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	reservations, err := m.DB.AllReservations(status)
 	if err != nil {
 		helpers.ServerError(w, err)
 		return
@@ -502,15 +762,36 @@ func (m *Repository) AdminAllReservations(w http.ResponseWriter, r *http.Request
 
 	data := make(map[string]interface{})
 	data["reservations"] = reservations
+	data["states"] = lifecycle.States
 
 	render.Template(w, r, "admin-all-reservations.page.tmpl", &models.TemplateData{
-		Data: data,
+		StringMap: map[string]string{"status": status},
+		Data:      data,
 	})
 }
 
-// AdminNewReservations shows all new reservations in admin tool
+// newReservationStates are the states staff can filter new reservations by. Pending reservations, which still
+// have to be confirmed, are shown by default
+var newReservationStates = []string{models.ReservationPending, models.ReservationConfirmed, models.ReservationCheckedIn}
+
+// AdminNewReservations shows the reservations staff still have to act on in admin tool, in the state given by
+// ?status=
 func (m *Repository) AdminNewReservations(w http.ResponseWriter, r *http.Request) {
-	reservations, err := m.DB.AllNewReservations()
+	status := r.URL.Query().Get("status")
+	if status == "" {
+		status = models.ReservationPending
+	}
+
+	valid := false
+	for _, s := range newReservationStates {
+		valid = valid || s == status
+	}
+	if !valid {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	reservations, err := m.DB.AllNewReservations(status)
 	if err != nil {
 		helpers.ServerError(w, err)
 		return
@@ -518,11 +799,21 @@ func (m *Repository) AdminNewReservations(w http.ResponseWriter, r *http.Request
 
 	data := make(map[string]interface{})
 	data["reservations"] = reservations
+	data["states"] = newReservationStates
 	render.Template(w, r, "admin-new-reservations.page.tmpl", &models.TemplateData{
-		Data: data,
+		StringMap: map[string]string{"status": status},
+		Data:      data,
 	})
 }
 
+// adminReservationsURL returns the admin page a reservation was opened from
+func adminReservationsURL(src string) string {
+	if src == "cal" {
+		return "/admin/reservations-calendar"
+	}
+	return fmt.Sprintf("/admin/reservations-%s", src)
+}
+
 // AdminShowReservation show the reservation in the admin tool
 func (m *Repository) AdminShowReservation(w http.ResponseWriter, r *http.Request) {
 	// get reservation from the database
@@ -545,6 +836,64 @@ func (m *Repository) AdminShowReservation(w http.ResponseWriter, r *http.Request
 	data := make(map[string]interface{})
 	data["reservation"] = reservation
 
+	// a reservation booked with other rooms is shown with the rest of its booking
+	if reservation.BookingID != 0 {
+		booking, err := m.DB.GetBookingByID(reservation.BookingID)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+		data["booking"] = booking
+	}
+
+	paid, err := m.DB.PaymentsForReservation(reservationID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	data["payments"] = paid
+
+	events, err := m.DB.ReservationEvents(reservationID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	data["events"] = events
+
+	entries, err := m.DB.AuditEntries(models.AuditFilter{Entity: models.AuditEntityReservation, EntityID: reservationID})
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	changes, err := auditChanges(entries)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	data["audit"] = map[string]interface{}{"entries": entries, "changes": changes}
+
+	// staff move the reservation along from here, while cancelling has its own form
+	var next []string
+	for _, s := range lifecycle.Next(reservation.Status) {
+		if s != models.ReservationCancelled {
+			next = append(next, s)
+		}
+	}
+	data["next"] = next
+
+	// admins see what cancelling would cost before they cancel
+	if lifecycle.Can(reservation.Status, models.ReservationCancelled) {
+		policy, err := m.cancellationPolicy(reservation)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+
+		stringMap["cancellation_policy"] = cancellation.Describe(policy)
+		data["settlement"] = cancellation.Settle(policy, reservation, paid, time.Now())
+	}
+
 	render.Template(w, r, "admin-reservations-show.page.tmpl", &models.TemplateData{
 		StringMap: stringMap,
 		Data:      data,
@@ -572,6 +921,8 @@ func (m *Repository) AdminPostShowReservation(w http.ResponseWriter, r *http.Req
 		return
 	}
 
+	before := audit.Reservation(res)
+
 	res.FirstName = r.Form.Get("first_name")
 	res.LastName = r.Form.Get("last_name")
 	res.Email = r.Form.Get("email")
@@ -582,34 +933,497 @@ func (m *Repository) AdminPostShowReservation(w http.ResponseWriter, r *http.Req
 		helpers.ServerError(w, err)
 		return
 	}
+	m.audit(r, models.AuditUpdate, models.AuditEntityReservation, res.ID, before, audit.Reservation(res))
 
 	m.AddFlash(r, "Changes saved")
-	http.Redirect(w, r, fmt.Sprintf("/admin/reservations-%s", src), http.StatusSeeOther)
+	http.Redirect(w, r, adminReservationsURL(src), http.StatusSeeOther)
+}
+
+// calendarMonth returns the first day of the month given by the y and m values, or the current month if y is empty
+func calendarMonth(y, m string) (time.Time, error) {
+	now := time.Now()
+	if y == "" {
+		return time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC), nil
+	}
+
+	year, err := strconv.Atoi(y)
+	if err != nil {
+		return now, err
+	}
+
+	month, err := strconv.Atoi(m)
+	if err != nil {
+		return now, err
+	}
+
+	if month < 1 || month > 12 {
+		return now, fmt.Errorf("invalid month %d", month)
+	}
+
+	return time.Date(year, time.Month(month), 1, 0, 0, 0, 0, time.UTC), nil
 }
 
 // AdminReservationsCalendar displays the reservation calendar
 func (m *Repository) AdminReservationsCalendar(w http.ResponseWriter, r *http.Request) {
-	render.Template(w, r, "admin-reservations-calendar.page.tmpl", &models.TemplateData{})
+	firstOfMonth, err := calendarMonth(r.URL.Query().Get("y"), r.URL.Query().Get("m"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	next := firstOfMonth.AddDate(0, 1, 0)
+	last := firstOfMonth.AddDate(0, -1, 0)
+	lastOfMonth := next.AddDate(0, 0, -1)
+
+	stringMap := make(map[string]string)
+	stringMap["next_month"] = next.Format("01")
+	stringMap["next_month_year"] = next.Format("2006")
+	stringMap["last_month"] = last.Format("01")
+	stringMap["last_month_year"] = last.Format("2006")
+	stringMap["this_month"] = firstOfMonth.Format("01")
+	stringMap["this_month_year"] = firstOfMonth.Format("2006")
+
+	intMap := make(map[string]int)
+	intMap["days_in_month"] = lastOfMonth.Day()
+
+	rooms, err := m.DB.AllRooms()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["now"] = firstOfMonth
+	data["rooms"] = rooms
+
+	for _, room := range rooms {
+		reservationMap := make(map[string]int)
+		blockMap := make(map[string]int)
+		externalMap := make(map[string]int)
+
+		restrictions, err := m.DB.GetRestrictionsForRoomByDate(room.ID, firstOfMonth, next)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+
+		for _, rr := range restrictions {
+			for d := rr.StartDate; d.Before(rr.EndDate); d = d.AddDate(0, 0, 1) {
+				key := d.Format("2006-01-02")
+				switch rr.RestrictionID {
+				case models.RestrictionOwnerBlock:
+					blockMap[key] = rr.ID
+				case models.RestrictionExternalBooking:
+					externalMap[key] = rr.ID
+				default:
+					reservationMap[key] = rr.ReservationID
+				}
+			}
+		}
+
+		data[fmt.Sprintf("reservation_map_%d", room.ID)] = reservationMap
+		data[fmt.Sprintf("block_map_%d", room.ID)] = blockMap
+		data[fmt.Sprintf("external_map_%d", room.ID)] = externalMap
+	}
+
+	render.Template(w, r, "admin-reservations-calendar.page.tmpl", &models.TemplateData{
+		StringMap: stringMap,
+		IntMap:    intMap,
+		Data:      data,
+	})
+}
+
+// AdminPostReservationsCalendar adds and removes owner blocks for the posted month
+func (m *Repository) AdminPostReservationsCalendar(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	firstOfMonth, err := calendarMonth(r.Form.Get("y"), r.Form.Get("m"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+	next := firstOfMonth.AddDate(0, 1, 0)
+
+	rooms, err := m.DB.AllRooms()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	const layout = "2006-01-02"
+
+	for _, room := range rooms {
+		restrictions, err := m.DB.GetRestrictionsForRoomByDate(room.ID, firstOfMonth, next)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+
+		// occupied holds every day in the month that is already covered by a restriction we keep
+		occupied := make(map[string]bool)
+
+		for _, rr := range restrictions {
+			var days []string
+			unticked := make(map[string]bool)
+			for d := rr.StartDate; d.Before(rr.EndDate); d = d.AddDate(0, 0, 1) {
+				if d.Before(firstOfMonth) || !d.Before(next) {
+					continue
+				}
+				key := d.Format(layout)
+				days = append(days, key)
+				if _, ok := r.PostForm[fmt.Sprintf("block_%d_%s", room.ID, key)]; !ok {
+					unticked[key] = true
+				}
+			}
+
+			if rr.RestrictionID == models.RestrictionOwnerBlock && len(unticked) > 0 {
+				err = m.unblockNights(r, rr, unticked)
+				if err != nil {
+					helpers.ServerError(w, err)
+					return
+				}
+				m.matchWaitlist()
+			}
+
+			for _, day := range days {
+				if rr.RestrictionID != models.RestrictionOwnerBlock || !unticked[day] {
+					occupied[day] = true
+				}
+			}
+		}
+
+		prefix := fmt.Sprintf("block_%d_", room.ID)
+		for name := range r.PostForm {
+			if !strings.HasPrefix(name, prefix) {
+				continue
+			}
+
+			key := strings.TrimPrefix(name, prefix)
+			if occupied[key] {
+				continue
+			}
+
+			day, err := time.Parse(layout, key)
+			if err != nil || day.Before(firstOfMonth) || !day.Before(next) {
+				continue
+			}
+
+			blockID, err := m.DB.InsertBlockForRoom(room.ID, day)
+			if err != nil {
+				helpers.ServerError(w, err)
+				return
+			}
+
+			block := models.RoomRestriction{StartDate: day, EndDate: day.AddDate(0, 0, 1), RoomID: room.ID}
+			m.audit(r, models.AuditCreate, models.AuditEntityBlock, blockID, nil, audit.Block(block))
+		}
+	}
+
+	m.AddFlash(r, "Changes saved")
+	http.Redirect(w, r, fmt.Sprintf("/admin/reservations-calendar?y=%d&m=%d", firstOfMonth.Year(), firstOfMonth.Month()), http.StatusSeeOther)
+}
+
+// unblockNights removes the nights of the owner block rr whose dates are in unticked, keeping the rest of the
+// block. A block that has nights left is split around the nights removed
+func (m *Repository) unblockNights(r *http.Request, rr models.RoomRestriction, unticked map[string]bool) error {
+	var blocks []models.RoomRestriction
+	for d := rr.StartDate; d.Before(rr.EndDate); d = d.AddDate(0, 0, 1) {
+		if unticked[d.Format("2006-01-02")] {
+			continue
+		}
+
+		// extend the block of the night before, or start a new one
+		if n := len(blocks); n > 0 && blocks[n-1].EndDate.Equal(d) {
+			blocks[n-1].EndDate = d.AddDate(0, 0, 1)
+			continue
+		}
+		blocks = append(blocks, models.RoomRestriction{StartDate: d, EndDate: d.AddDate(0, 0, 1), RoomID: rr.RoomID})
+	}
+
+	if len(blocks) == 0 {
+		err := m.DB.DeleteBlockByID(rr.ID)
+		if err != nil {
+			return err
+		}
+		m.audit(r, models.AuditDelete, models.AuditEntityBlock, rr.ID, audit.Block(rr), nil)
+		return nil
+	}
+
+	ids, err := m.DB.SplitBlock(rr.ID, blocks)
+	if err != nil {
+		return err
+	}
+
+	m.audit(r, models.AuditDelete, models.AuditEntityBlock, rr.ID, audit.Block(rr), nil)
+	for i, id := range ids {
+		m.audit(r, models.AuditCreate, models.AuditEntityBlock, id, nil, audit.Block(blocks[i]))
+	}
+
+	return nil
 }
 
-// AdminProcessReservation marks a reservation as processed
-func (m *Repository) AdminProcessReservation(w http.ResponseWriter, r *http.Request) {
-	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+// transitionMessages are the flash messages shown when staff change the state of a reservation
+var transitionMessages = map[string]string{
+	models.ReservationConfirmed:  "Reservation confirmed",
+	models.ReservationCheckedIn:  "Guest checked in",
+	models.ReservationCheckedOut: "Guest checked out",
+	models.ReservationNoShow:     "Reservation marked as a no-show",
+}
+
+// AdminTransitionReservation moves a reservation to the state posted, such as checking the guest in or out
+func (m *Repository) AdminTransitionReservation(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
 	src := chi.URLParam(r, "src")
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+	url := fmt.Sprintf("/admin/reservations/%s/%d", src, id)
 
-	_ = m.DB.UpdateProcessedForReservation(id, 1)
-	m.AddFlash(r, "Reservation marked as processed")
+	status := r.Form.Get("status")
+	msg, ok := transitionMessages[status]
+	if !ok {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
 
-	http.Redirect(w, r, fmt.Sprintf("/admin/reservations-%s", src), http.StatusSeeOther)
+	res, err := m.DB.GetReservationByID(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	} else if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	err = m.DB.TransitionReservation(id, status)
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	} else if errors.Is(err, repository.ErrInvalidTransition) {
+		m.AddError(r, fmt.Sprintf("This reservation can't be changed to %s", strings.ToLower(lifecycle.Label(status))))
+		http.Redirect(w, r, url, http.StatusSeeOther)
+		return
+	} else if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	before := audit.Reservation(res)
+	res.Status = status
+	m.audit(r, models.AuditTransition, models.AuditEntityReservation, id, before, audit.Reservation(res))
+
+	m.AddFlash(r, msg)
+	http.Redirect(w, r, url, http.StatusSeeOther)
 }
 
-// AdminDeleteReservation deletes a reservation
+// AdminDeleteReservation moves a reservation to the trash, releasing its room
 func (m *Repository) AdminDeleteReservation(w http.ResponseWriter, r *http.Request) {
-	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
 	src := chi.URLParam(r, "src")
 
-	_ = m.DB.DeleteReservation(id)
-	m.AddFlash(r, "Reservation deleted")
+	res, err := m.DB.GetReservationByID(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	} else if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	err = m.DB.DeleteReservation(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	} else if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	m.audit(r, models.AuditDelete, models.AuditEntityReservation, id, audit.Reservation(res), nil)
+	m.matchWaitlist()
+	m.AddFlash(r, "Reservation moved to the trash")
+
+	http.Redirect(w, r, adminReservationsURL(src), http.StatusSeeOther)
+}
+
+// AdminTrash lists the deleted reservations, which can be restored until they are purged
+func (m *Repository) AdminTrash(w http.ResponseWriter, r *http.Request) {
+	reservations, err := m.DB.DeletedReservations()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	// the date each reservation will be purged on, by reservation id
+	purge := make(map[int]time.Time)
+	if m.App.Trash != nil {
+		for _, res := range reservations {
+			purge[res.ID] = res.DeletedAt.Add(m.App.Trash.Retention)
+		}
+	}
+
+	data := make(map[string]interface{})
+	data["reservations"] = reservations
+	data["purge"] = purge
+
+	render.Template(w, r, "admin-trash.page.tmpl", &models.TemplateData{
+		Data: data,
+	})
+}
+
+// AdminRestoreReservation takes a reservation out of the trash, re-booking its room if it is still available
+func (m *Repository) AdminRestoreReservation(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	err = m.DB.RestoreReservation(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	} else if errors.Is(err, repository.ErrRoomUnavailable) {
+		m.AddError(r, "The room has been booked for these dates since the reservation was deleted, so it can't be restored")
+		http.Redirect(w, r, "/admin/reservations-trash", http.StatusSeeOther)
+		return
+	} else if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	res, err := m.DB.GetReservationByID(id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	m.audit(r, models.AuditRestore, models.AuditEntityReservation, id, nil, audit.Reservation(res))
+
+	m.AddFlash(r, "Reservation restored")
+	http.Redirect(w, r, fmt.Sprintf("/admin/reservations/all/%d", id), http.StatusSeeOther)
+}
+
+// AdminAPIKeys lists the API keys
+func (m *Repository) AdminAPIKeys(w http.ResponseWriter, r *http.Request) {
+	keys, err := m.DB.AllAPIKeys()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["api_keys"] = keys
+
+	// a newly created key is only ever shown once
+	stringMap := make(map[string]string)
+	stringMap["new_api_key"] = m.App.Session.PopString(r.Context(), "new_api_key")
+
+	render.Template(w, r, "admin-api-keys.page.tmpl", &models.TemplateData{
+		Data:      data,
+		StringMap: stringMap,
+		Form:      forms.New(nil),
+	})
+}
+
+// AdminPostAPIKey creates a new API key
+func (m *Repository) AdminPostAPIKey(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("name")
+	if !form.Valid() {
+		keys, err := m.DB.AllAPIKeys()
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+
+		data := make(map[string]interface{})
+		data["api_keys"] = keys
+
+		render.Template(w, r, "admin-api-keys.page.tmpl", &models.TemplateData{
+			Data:      data,
+			StringMap: make(map[string]string),
+			Form:      form,
+		})
+		return
+	}
+
+	token, err := helpers.RandomToken(32)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	key := models.APIKey{
+		Name:    r.Form.Get("name"),
+		KeyHash: helpers.HashToken(token),
+	}
+	key.ID, err = m.DB.InsertAPIKey(key)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	m.audit(r, models.AuditCreate, models.AuditEntityAPIKey, key.ID, nil, audit.APIKey(key))
+
+	m.App.Session.Put(r.Context(), "new_api_key", token)
+	m.AddFlash(r, "API key created")
+	http.Redirect(w, r, "/admin/api-keys", http.StatusSeeOther)
+}
+
+// AdminRevokeAPIKey revokes an API key
+func (m *Repository) AdminRevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	keys, err := m.DB.AllAPIKeys()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	var key models.APIKey
+	for _, k := range keys {
+		if k.ID == id {
+			key = k
+		}
+	}
+	if key.ID == 0 {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+
+	err = m.DB.RevokeAPIKey(id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	if key.RevokedAt.IsZero() {
+		m.audit(r, models.AuditRevoke, models.AuditEntityAPIKey, key.ID, audit.APIKey(key), nil)
+	}
 
-	http.Redirect(w, r, fmt.Sprintf("/admin/reservations-%s", src), http.StatusSeeOther)
+	m.AddFlash(r, "API key revoked")
+	http.Redirect(w, r, "/admin/api-keys", http.StatusSeeOther)
 }
@@ -7,10 +7,16 @@ import (
 	"log"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/dhanekom/bookings/internal/helpers"
+	"github.com/dhanekom/bookings/internal/mailer"
 	"github.com/dhanekom/bookings/internal/models"
+	"github.com/dhanekom/bookings/internal/repository/dbrepo"
+	"github.com/go-chi/chi/v5"
 )
 
 type postData struct {
@@ -26,8 +32,7 @@ var theTests = []struct {
 }{
 	{"home", "/", "GET", http.StatusOK},
 	{"about", "/about", "GET", http.StatusOK},
-	{"generals quarters", "/generals-quarters", "GET", http.StatusOK},
-	{"majors suite", "/majors-suite", "GET", http.StatusOK},
+	{"rooms", "/rooms", "GET", http.StatusOK},
 	{"search availability", "/search-availability", "GET", http.StatusOK},
 	{"contact", "/contact", "GET", http.StatusOK},
 }
@@ -52,7 +57,9 @@ func TestHandlers(t *testing.T) {
 
 func TestRepository_Reservation(t *testing.T) {
 	reservation := models.Reservation{
-		RoomID: 1,
+		RoomID:    1,
+		StartDate: time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC),
+		EndDate:   time.Date(2050, 1, 3, 0, 0, 0, 0, time.UTC),
 		Room: models.Room{
 			ID:       1,
 			RoomName: "General's Quarters",
@@ -74,6 +81,26 @@ func TestRepository_Reservation(t *testing.T) {
 		t.Errorf("reservation handler returned %d, wanted %d", rr.Code, http.StatusOK)
 	}
 
+	// two nights of the summer season, with VAT and the cleaning fee
+	if !strings.Contains(rr.Body.String(), "$370.00") {
+		t.Error("expected the total price of the stay on the page")
+	}
+
+	// test with a stay of no nights
+	req, _ = http.NewRequest("GET", "/make-reservation", nil)
+	ctx = getCtx(req)
+	req = req.WithContext(ctx)
+	rr = httptest.NewRecorder()
+	noNights := reservation
+	noNights.EndDate = noNights.StartDate
+	session.Put(ctx, "reservation", noNights)
+
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != "/search-availability" {
+		t.Errorf("reservation handler returned %d %s, wanted a redirect to the search", rr.Code, rr.Header().Get("Location"))
+	}
+
 	// test case where reservation is not in session
 	req, _ = http.NewRequest("GET", "/make-reservation", nil)
 	ctx = getCtx(req)
@@ -108,6 +135,7 @@ func TestRepository_PostReservation(t *testing.T) {
 	reqBody = fmt.Sprintf("%s&%s", reqBody, "last_name=Smith")
 	reqBody = fmt.Sprintf("%s&%s", reqBody, "email=john@smith.com")
 	reqBody = fmt.Sprintf("%s&%s", reqBody, "phone=123456789")
+	reqBody = fmt.Sprintf("%s&%s", reqBody, "adults=2")
 	reqBody = fmt.Sprintf("%s&%s", reqBody, "room_id=1")
 
 	req, _ := http.NewRequest("POST", "/make-reservation", strings.NewReader(reqBody))
@@ -118,6 +146,7 @@ func TestRepository_PostReservation(t *testing.T) {
 
 	rr := httptest.NewRecorder()
 
+	dbrepo.AuditLog = nil
 	handler := http.HandlerFunc(Repo.PostReservation)
 	handler.ServeHTTP(rr, req)
 
@@ -125,6 +154,11 @@ func TestRepository_PostReservation(t *testing.T) {
 		t.Errorf("PostReservation handler returned %d, wanted %d", rr.Code, http.StatusSeeOther)
 	}
 
+	if len(dbrepo.AuditLog) != 1 || dbrepo.AuditLog[0].Action != models.AuditCreate || dbrepo.AuditLog[0].Actor != "guest" {
+		t.Errorf("expected the guest's reservation to be audited, got %+v", dbrepo.AuditLog)
+	}
+	dbrepo.AuditLog = nil
+
 	// test for missing post body
 	req, _ = http.NewRequest("POST", "/make-reservation", nil)
 	ctx = getCtx(req)
@@ -146,6 +180,7 @@ func TestRepository_PostReservation(t *testing.T) {
 	reqBody = fmt.Sprintf("%s&%s", reqBody, "last_name=Smith")
 	reqBody = fmt.Sprintf("%s&%s", reqBody, "email=john@smith.com")
 	reqBody = fmt.Sprintf("%s&%s", reqBody, "phone=123456789")
+	reqBody = fmt.Sprintf("%s&%s", reqBody, "adults=2")
 	reqBody = fmt.Sprintf("%s&%s", reqBody, "room_id=1")
 
 	req, _ = http.NewRequest("POST", "/make-reservation", strings.NewReader(reqBody))
@@ -168,6 +203,7 @@ func TestRepository_PostReservation(t *testing.T) {
 	reqBody = fmt.Sprintf("%s&%s", reqBody, "last_name=Smith")
 	reqBody = fmt.Sprintf("%s&%s", reqBody, "email=john@smith.com")
 	reqBody = fmt.Sprintf("%s&%s", reqBody, "phone=123456789")
+	reqBody = fmt.Sprintf("%s&%s", reqBody, "adults=2")
 	reqBody = fmt.Sprintf("%s&%s", reqBody, "room_id=1")
 
 	req, _ = http.NewRequest("POST", "/make-reservation", strings.NewReader(reqBody))
@@ -190,6 +226,7 @@ func TestRepository_PostReservation(t *testing.T) {
 	reqBody = fmt.Sprintf("%s&%s", reqBody, "last_name=Smith")
 	reqBody = fmt.Sprintf("%s&%s", reqBody, "email=john@smith.com")
 	reqBody = fmt.Sprintf("%s&%s", reqBody, "phone=123456789")
+	reqBody = fmt.Sprintf("%s&%s", reqBody, "adults=2")
 	reqBody = fmt.Sprintf("%s&%s", reqBody, "room_id=invalid")
 
 	req, _ = http.NewRequest("POST", "/make-reservation", strings.NewReader(reqBody))
@@ -212,6 +249,7 @@ func TestRepository_PostReservation(t *testing.T) {
 	reqBody = fmt.Sprintf("%s&%s", reqBody, "last_name=Smith")
 	reqBody = fmt.Sprintf("%s&%s", reqBody, "email=john@smith.com")
 	reqBody = fmt.Sprintf("%s&%s", reqBody, "phone=123456789")
+	reqBody = fmt.Sprintf("%s&%s", reqBody, "adults=2")
 	reqBody = fmt.Sprintf("%s&%s", reqBody, "room_id=1")
 
 	req, _ = http.NewRequest("POST", "/make-reservation", strings.NewReader(reqBody))
@@ -234,6 +272,7 @@ func TestRepository_PostReservation(t *testing.T) {
 	reqBody = fmt.Sprintf("%s&%s", reqBody, "last_name=Smith")
 	reqBody = fmt.Sprintf("%s&%s", reqBody, "email=john@smith.com")
 	reqBody = fmt.Sprintf("%s&%s", reqBody, "phone=123456789")
+	reqBody = fmt.Sprintf("%s&%s", reqBody, "adults=2")
 	reqBody = fmt.Sprintf("%s&%s", reqBody, "room_id=0")
 
 	req, _ = http.NewRequest("POST", "/make-reservation", strings.NewReader(reqBody))
@@ -256,6 +295,7 @@ func TestRepository_PostReservation(t *testing.T) {
 	reqBody = fmt.Sprintf("%s&%s", reqBody, "last_name=Smith")
 	reqBody = fmt.Sprintf("%s&%s", reqBody, "email=john@smith.com")
 	reqBody = fmt.Sprintf("%s&%s", reqBody, "phone=123456789")
+	reqBody = fmt.Sprintf("%s&%s", reqBody, "adults=2")
 	reqBody = fmt.Sprintf("%s&%s", reqBody, "room_id=1000")
 
 	req, _ = http.NewRequest("POST", "/make-reservation", strings.NewReader(reqBody))
@@ -270,6 +310,86 @@ func TestRepository_PostReservation(t *testing.T) {
 	if rr.Code != http.StatusTemporaryRedirect {
 		t.Errorf("PostReservation handler failed when trying to fail inserting room restriction: got %d, wanted %d", rr.Code, http.StatusTemporaryRedirect)
 	}
+
+	// test for room booked by someone else in the meantime
+	reqBody = "start_date=2050-01-01"
+	reqBody = fmt.Sprintf("%s&%s", reqBody, "end_date=2050-01-02")
+	reqBody = fmt.Sprintf("%s&%s", reqBody, "first_name=John")
+	reqBody = fmt.Sprintf("%s&%s", reqBody, "last_name=Smith")
+	reqBody = fmt.Sprintf("%s&%s", reqBody, "email=john@smith.com")
+	reqBody = fmt.Sprintf("%s&%s", reqBody, "phone=123456789")
+	reqBody = fmt.Sprintf("%s&%s", reqBody, "adults=2")
+	reqBody = fmt.Sprintf("%s&%s", reqBody, "room_id=1001")
+
+	req, _ = http.NewRequest("POST", "/make-reservation", strings.NewReader(reqBody))
+	ctx = getCtx(req)
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = httptest.NewRecorder()
+
+	handler = http.HandlerFunc(Repo.PostReservation)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("PostReservation handler failed when room is unavailable: got %d, wanted %d", rr.Code, http.StatusOK)
+	}
+
+	if !strings.Contains(rr.Body.String(), "just been booked") {
+		t.Error("PostReservation handler did not show the room unavailable message")
+	}
+
+	// test for a stay that breaks the stay rules of the room
+	reqBody = "start_date=2050-02-06"
+	reqBody = fmt.Sprintf("%s&%s", reqBody, "end_date=2050-02-07")
+	reqBody = fmt.Sprintf("%s&%s", reqBody, "first_name=John")
+	reqBody = fmt.Sprintf("%s&%s", reqBody, "last_name=Smith")
+	reqBody = fmt.Sprintf("%s&%s", reqBody, "email=john@smith.com")
+	reqBody = fmt.Sprintf("%s&%s", reqBody, "phone=123456789")
+	reqBody = fmt.Sprintf("%s&%s", reqBody, "adults=2")
+	reqBody = fmt.Sprintf("%s&%s", reqBody, "room_id=2")
+
+	req, _ = http.NewRequest("POST", "/make-reservation", strings.NewReader(reqBody))
+	ctx = getCtx(req)
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = httptest.NewRecorder()
+
+	handler = http.HandlerFunc(Repo.PostReservation)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusSeeOther {
+		t.Errorf("PostReservation handler failed when the stay rules are broken: got %d, wanted %d", rr.Code, http.StatusSeeOther)
+	}
+
+	for _, msg := range []string{"Arrivals aren&#39;t possible on Sundays", "must be at least 2 nights"} {
+		if !strings.Contains(rr.Body.String(), msg) {
+			t.Errorf("PostReservation handler did not show the stay rule message %q", msg)
+		}
+	}
+
+	// test for a party that the room doesn't sleep
+	reqBody = "start_date=2050-01-01"
+	reqBody = fmt.Sprintf("%s&%s", reqBody, "end_date=2050-01-02")
+	reqBody = fmt.Sprintf("%s&%s", reqBody, "first_name=John")
+	reqBody = fmt.Sprintf("%s&%s", reqBody, "last_name=Smith")
+	reqBody = fmt.Sprintf("%s&%s", reqBody, "email=john@smith.com")
+	reqBody = fmt.Sprintf("%s&%s", reqBody, "phone=123456789")
+	reqBody = fmt.Sprintf("%s&%s", reqBody, "adults=1")
+	reqBody = fmt.Sprintf("%s&%s", reqBody, "children=1")
+	reqBody = fmt.Sprintf("%s&%s", reqBody, "room_id=1")
+
+	req, _ = http.NewRequest("POST", "/make-reservation", strings.NewReader(reqBody))
+	ctx = getCtx(req)
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = httptest.NewRecorder()
+
+	handler = http.HandlerFunc(Repo.PostReservation)
+	handler.ServeHTTP(rr, req)
+
+	if !strings.Contains(rr.Body.String(), "This room doesn&#39;t sleep children") {
+		t.Error("PostReservation handler did not show the occupancy message")
+	}
 }
 
 func TestRepository_AvailabilityJSON(t *testing.T) {
@@ -279,14 +399,19 @@ func TestRepository_AvailabilityJSON(t *testing.T) {
 		HasPostBody bool
 		OK          bool
 		Code        int
+		TotalPrice  int
 	}{
-		{"can't parse form", "", false, false, http.StatusOK},
-		{"can't parse start date", "start=invalid&end=2050-01-02&room_id=1", true, false, http.StatusTemporaryRedirect},
-		{"can't parse end date", "start=2050-01-01&end=invalid&room_id=1", true, false, http.StatusTemporaryRedirect},
-		{"can't parse room id", "start=2050-01-01&end=2050-01-02&room_id=invalid", true, false, http.StatusTemporaryRedirect},
-		{"db error - can't search room availability", "start=2050-01-01&end=2050-01-02&room_id=0", true, false, http.StatusOK},
-		{"room not available", "start=2050-01-01&end=2050-01-02&room_id=1", true, false, http.StatusOK},
-		{"room available", "start=2050-01-01&end=2050-01-02&room_id=2", true, true, http.StatusOK},
+		{"can't parse form", "", false, false, http.StatusOK, 0},
+		{"can't parse start date", "start=invalid&end=2050-01-02&room_id=1", true, false, http.StatusTemporaryRedirect, 0},
+		{"can't parse end date", "start=2050-01-01&end=invalid&room_id=1", true, false, http.StatusTemporaryRedirect, 0},
+		{"can't parse room id", "start=2050-01-01&end=2050-01-02&room_id=invalid", true, false, http.StatusTemporaryRedirect, 0},
+		{"db error - can't search room availability", "start=2050-01-01&end=2050-01-02&room_id=0", true, false, http.StatusOK, 0},
+		{"room not available", "start=2050-01-01&end=2050-01-02&room_id=1", true, false, http.StatusOK, 0},
+		// a Saturday night with the weekend uplift, VAT and the cleaning fee
+		{"room available", "start=2050-01-01&end=2050-01-02&room_id=2", true, true, http.StatusOK, 27340},
+		{"stay rules broken", "start=2050-02-06&end=2050-02-08&room_id=2", true, false, http.StatusOK, 0},
+		{"invalid party", "start=2050-01-01&end=2050-01-02&room_id=2&adults=0", true, false, http.StatusOK, 0},
+		{"party too large", "start=2050-01-01&end=2050-01-02&room_id=2&adults=2&children=3", true, false, http.StatusOK, 0},
 	}
 
 	//{"start":"invalid","end":"2050-01-02","room_id":"1"}
@@ -329,11 +454,147 @@ func TestRepository_AvailabilityJSON(t *testing.T) {
 				if j.OK != e.OK {
 					t.Errorf("AvailabilityJSON failed: got %v, expected %v", j.OK, e.OK)
 				}
+
+				if j.TotalPrice != e.TotalPrice {
+					t.Errorf("AvailabilityJSON total price: got %d, expected %d", j.TotalPrice, e.TotalPrice)
+				}
 			}
 		})
 	}
 }
 
+func TestRepository_PostAvailability(t *testing.T) {
+	search := func(start, end string, party ...string) url.Values {
+		v := url.Values{"start": {start}, "end": {end}, "adults": {"2"}}
+		if len(party) == 2 {
+			v.Set("adults", party[0])
+			v.Set("children", party[1])
+		}
+		return v
+	}
+
+	tests := []struct {
+		name             string
+		postedData       url.Values
+		expectedCode     int
+		expectedLocation string
+		expectedText     []string
+		unexpectedText   string
+	}{
+		// a week of General's Quarters is in the summer season, with the length of stay discount
+		{"rooms", search("2050-01-03", "2050-01-10"), http.StatusOK, "", []string{"$1,111.75</strong> for 7 nights", "$1,403.62</strong> for 7 nights"}, ""},
+		{"no availability", search("2049-01-01", "2049-01-02"), http.StatusSeeOther, "/waitlist?adults=2&children=0&end=2049-01-02&start=2049-01-01", nil, ""},
+		{"missing fields", url.Values{}, http.StatusOK, "", []string{"This field cannot be blank"}, ""},
+		{"invalid date", search("2050-01-01", "soon"), http.StatusOK, "", []string{"Invalid date"}, ""},
+		{"no nights", search("2050-01-01", "2050-01-01"), http.StatusOK, "", []string{"must be after the arrival date"}, ""},
+		{"in the past", search("2020-01-01", "2020-01-02"), http.StatusOK, "", []string{"can&#39;t be in the past"}, ""},
+		{"no adults", search("2050-01-01", "2050-01-02", "0", "2"), http.StatusOK, "", []string{"at least 1"}, ""},
+		{"invalid children", search("2050-01-01", "2050-01-02", "2", "-1"), http.StatusOK, "", []string{"0 or more"}, ""},
+		// only Major's Suite sleeps children
+		{"party with children", search("2050-01-03", "2050-01-05", "1", "2"), http.StatusOK, "", []string{"Book Major&#39;s Suite"}, "Book General"},
+		{"party too large", search("2050-01-03", "2050-01-05", "3", "0"), http.StatusSeeOther, "/waitlist?adults=3&children=0&end=2050-01-05&start=2050-01-03", nil, ""},
+		// arriving on a Sunday breaks the rules of Major's Suite only
+		{"some rooms restricted", search("2050-02-06", "2050-02-08"), http.StatusOK, "", []string{"Book General&#39;s Quarters"}, "Book Major"},
+		{"all rooms restricted", search("2050-02-06", "2050-02-27"), http.StatusOK, "", []string{
+			"Stays arriving on 2050-02-06 can be at most 14 nights",
+			"Arrivals aren&#39;t possible on Sundays",
+		}, ""},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", "/search-availability", strings.NewReader(e.postedData.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.ParseForm()
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.PostAvailability).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedCode {
+			t.Errorf("%s: expected %d, got %d", e.name, e.expectedCode, rr.Code)
+		}
+
+		if e.expectedLocation != "" && rr.Header().Get("Location") != e.expectedLocation {
+			t.Errorf("%s: expected location %s, got %s", e.name, e.expectedLocation, rr.Header().Get("Location"))
+		}
+
+		for _, text := range e.expectedText {
+			if !strings.Contains(rr.Body.String(), text) {
+				t.Errorf("%s: expected %q in the response", e.name, text)
+			}
+		}
+
+		if e.unexpectedText != "" && strings.Contains(rr.Body.String(), e.unexpectedText) {
+			t.Errorf("%s: didn't expect %q in the response", e.name, e.unexpectedText)
+		}
+	}
+}
+
+func TestRepository_PostReservationMail(t *testing.T) {
+	// use an outbox of our own to find the messages queued by this reservation
+	saved := app.Mail
+	defer func() { app.Mail = saved }()
+	app.Mail = mailer.NewOutbox(mailer.NewMemoryStore(), nil, nil)
+	app.Mail.Templates = saved.Templates
+
+	postedData := url.Values{
+		"start_date": {"2050-01-01"},
+		"end_date":   {"2050-01-02"},
+		"first_name": {"<b>Johnny</b>"},
+		"last_name":  {"Smith"},
+		"email":      {"john@smith.com"},
+		"phone":      {"123456789"},
+		"room_id":    {"1"},
+		"adults":     {"2"},
+	}
+
+	req, _ := http.NewRequest("POST", "/make-reservation", strings.NewReader(postedData.Encode()))
+	req = req.WithContext(getCtx(req))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(Repo.PostReservation).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusSeeOther {
+		t.Fatalf("PostReservation handler returned %d, wanted %d", rr.Code, http.StatusSeeOther)
+	}
+
+	messages, _ := app.Mail.Store.List("", 0)
+	if len(messages) != 2 {
+		t.Fatalf("expected 2 messages to be queued but got %d", len(messages))
+	}
+
+	// messages are listed newest first
+	staff, guest := messages[0].Mail, messages[1].Mail
+
+	if len(guest.Attachments) != 1 || len(staff.Attachments) != 0 {
+		t.Fatalf("expected only the guest confirmation to have an invite attached")
+	}
+
+	var tests = []struct {
+		name     string
+		got      string
+		expected string
+	}{
+		{"guest address", guest.To, "john@smith.com"},
+		{"guest html escapes name", guest.Content, "Dear &lt;b&gt;Johnny&lt;/b&gt;"},
+		{"guest html manage link", guest.Content, "http://localhost:8080/manage-booking"},
+		{"guest text", guest.Text, "Dear <b>Johnny</b>,"},
+		{"guest text dates", guest.Text, "2050-01-01"},
+		{"staff address", staff.To, "me@here.com"},
+		{"staff html", staff.Content, "A reservation has been made"},
+		{"staff text", staff.Text, "Phone:             123456789"},
+		{"guest invite", string(guest.Attachments[0].Data), "METHOD:REQUEST"},
+	}
+
+	for _, e := range tests {
+		if !strings.Contains(e.got, e.expected) {
+			t.Errorf("%s: expected %q in %q", e.name, e.expected, e.got)
+		}
+	}
+}
+
 func getCtx(req *http.Request) context.Context {
 	ctx, err := session.Load(req.Context(), req.Header.Get("X-Session"))
 	if err != nil {
@@ -371,3 +632,315 @@ func TestAddMessages(t *testing.T) {
 		t.Error("error not added to session")
 	}
 }
+
+func TestRepository_AdminReservationsCalendar(t *testing.T) {
+	tests := []struct {
+		name               string
+		url                string
+		expectedStatusCode int
+		expectedText       string
+	}{
+		{"current month", "/admin/reservations-calendar", http.StatusOK, ""},
+		{"given month", "/admin/reservations-calendar?y=2050&m=01", http.StatusOK, `name="block_1_2050-01-04" value="1"`},
+		{"external booking", "/admin/reservations-calendar?y=2050&m=01", http.StatusOK, "Booked on an external calendar"},
+		{"invalid year", "/admin/reservations-calendar?y=invalid&m=01", http.StatusBadRequest, ""},
+		{"invalid month", "/admin/reservations-calendar?y=2050&m=13", http.StatusBadRequest, ""},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", e.url, nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminReservationsCalendar)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected %d, got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+
+		if e.expectedText != "" && !strings.Contains(rr.Body.String(), e.expectedText) {
+			t.Errorf("%s: expected %q in the response", e.name, e.expectedText)
+		}
+	}
+}
+
+func TestRepository_AdminPostReservationsCalendar(t *testing.T) {
+	tests := []struct {
+		name               string
+		postedData         url.Values
+		expectedStatusCode int
+		expectedLocation   string
+	}{
+		{
+			name: "add and remove blocks",
+			postedData: url.Values{
+				"y":                  {"2050"},
+				"m":                  {"01"},
+				"block_1_2050-01-10": {"1"},
+				"block_2_2050-01-11": {"1"},
+			},
+			expectedStatusCode: http.StatusSeeOther,
+			expectedLocation:   "/admin/reservations-calendar?y=2050&m=1",
+		},
+		{
+			name: "keep existing block",
+			postedData: url.Values{
+				"y":                  {"2050"},
+				"m":                  {"01"},
+				"block_1_2050-01-04": {"1"},
+			},
+			expectedStatusCode: http.StatusSeeOther,
+			expectedLocation:   "/admin/reservations-calendar?y=2050&m=1",
+		},
+		{
+			name: "invalid month",
+			postedData: url.Values{
+				"y": {"2050"},
+				"m": {"invalid"},
+			},
+			expectedStatusCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", "/admin/reservations-calendar", strings.NewReader(e.postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminPostReservationsCalendar)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected %d, got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+
+		if e.expectedLocation != "" {
+			if loc := rr.Header().Get("Location"); loc != e.expectedLocation {
+				t.Errorf("%s: expected location %s, got %s", e.name, e.expectedLocation, loc)
+			}
+		}
+	}
+}
+
+func TestRepository_AdminPostReservationsCalendarSplitsBlock(t *testing.T) {
+	// untick the 11th of the block from the 10th to the 13th, keeping the block on the 4th
+	postedData := url.Values{
+		"y":                  {"2050"},
+		"m":                  {"02"},
+		"block_1_2050-02-04": {"1"},
+		"block_1_2050-02-10": {"1"},
+		"block_1_2050-02-12": {"1"},
+	}
+
+	req, _ := http.NewRequest("POST", "/admin/reservations-calendar", strings.NewReader(postedData.Encode()))
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	dbrepo.AuditLog = nil
+	defer func() { dbrepo.AuditLog = nil }()
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(Repo.AdminPostReservationsCalendar).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusSeeOther {
+		t.Fatalf("expected %d, got %d", http.StatusSeeOther, rr.Code)
+	}
+
+	var changes []string
+	for _, a := range dbrepo.AuditLog {
+		changes = append(changes, fmt.Sprintf("%s %d %s", a.Action, a.EntityID, a.Before+a.After))
+	}
+
+	expected := []string{
+		`delete 4 {"end_date":"2050-02-13","room_id":1,"start_date":"2050-02-10"}{}`,
+		`create 10 {}{"end_date":"2050-02-11","room_id":1,"start_date":"2050-02-10"}`,
+		`create 11 {}{"end_date":"2050-02-13","room_id":1,"start_date":"2050-02-12"}`,
+	}
+	if strings.Join(changes, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("expected only the unticked night to be unblocked, got %v", changes)
+	}
+}
+
+func TestRepository_AdminReservationsByStatus(t *testing.T) {
+	var tests = []struct {
+		name               string
+		handler            http.HandlerFunc
+		url                string
+		expectedStatusCode int
+		expectedLinks      []string
+		unexpectedLinks    []string
+	}{
+		{"all", Repo.AdminAllReservations, "/admin/reservations-all", http.StatusOK,
+			[]string{"/admin/reservations/all/1", "/admin/reservations/all/2", "/admin/reservations/all/5"}, nil},
+		{"all checked in", Repo.AdminAllReservations, "/admin/reservations-all?status=checked-in", http.StatusOK,
+			[]string{"/admin/reservations/all/5"}, []string{"/admin/reservations/all/1", "/admin/reservations/all/2"}},
+		{"all in an unknown state", Repo.AdminAllReservations, "/admin/reservations-all?status=processed", http.StatusBadRequest, nil, nil},
+		{"new", Repo.AdminNewReservations, "/admin/reservations-new", http.StatusOK,
+			nil, []string{"/admin/reservations/new/1", "/admin/reservations/new/5"}},
+		{"new confirmed", Repo.AdminNewReservations, "/admin/reservations-new?status=confirmed", http.StatusOK,
+			[]string{"/admin/reservations/new/1"}, []string{"/admin/reservations/new/5"}},
+		{"new cancelled", Repo.AdminNewReservations, "/admin/reservations-new?status=cancelled", http.StatusBadRequest, nil, nil},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", e.url, nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		rr := httptest.NewRecorder()
+
+		e.handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected %d, got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+
+		for _, link := range e.expectedLinks {
+			if !strings.Contains(rr.Body.String(), link) {
+				t.Errorf("%s: expected a link to %s", e.name, link)
+			}
+		}
+
+		for _, link := range e.unexpectedLinks {
+			if strings.Contains(rr.Body.String(), link) {
+				t.Errorf("%s: expected no link to %s", e.name, link)
+			}
+		}
+	}
+}
+
+func TestRepository_AdminTransitionReservation(t *testing.T) {
+	mux := chi.NewRouter()
+	mux.Use(SessionLoad)
+	mux.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			u := models.User{ID: 1, AccessLevel: models.AccessLevelStaff}
+			next.ServeHTTP(w, r.WithContext(helpers.ContextWithUser(r.Context(), u)))
+		})
+	})
+	mux.Get("/admin/reservations/{src}/{id}", Repo.AdminShowReservation)
+	mux.Post("/admin/reservations/{src}/{id}/status", Repo.AdminTransitionReservation)
+
+	c := &sessionClient{handler: mux}
+
+	var tests = []struct {
+		name               string
+		url                string
+		status             string
+		expectedStatusCode int
+		expectedLocation   string
+		expectedMessage    string
+	}{
+		{"check in", "/admin/reservations/all/1/status", models.ReservationCheckedIn, http.StatusSeeOther, "/admin/reservations/all/1", "Guest checked in"},
+		{"check out before checking in", "/admin/reservations/all/1/status", models.ReservationCheckedOut, http.StatusSeeOther, "/admin/reservations/all/1", "changed to checked out"},
+		{"check out", "/admin/reservations/new/5/status", models.ReservationCheckedOut, http.StatusSeeOther, "/admin/reservations/new/5", "Guest checked out"},
+		{"no-show after checking in", "/admin/reservations/new/5/status", models.ReservationNoShow, http.StatusSeeOther, "/admin/reservations/new/5", "changed to no-show"},
+		{"cancel", "/admin/reservations/all/1/status", models.ReservationCancelled, http.StatusBadRequest, "", ""},
+		{"unknown state", "/admin/reservations/all/1/status", "processed", http.StatusBadRequest, "", ""},
+		{"unknown reservation", "/admin/reservations/all/1000/status", models.ReservationCheckedIn, http.StatusNotFound, "", ""},
+		{"invalid id", "/admin/reservations/all/x/status", models.ReservationCheckedIn, http.StatusBadRequest, "", ""},
+	}
+
+	for _, e := range tests {
+		rr := c.do("POST", e.url, url.Values{"status": {e.status}})
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected %d, got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+
+		if loc := rr.Header().Get("Location"); loc != e.expectedLocation {
+			t.Errorf("%s: expected location %q, got %q", e.name, e.expectedLocation, loc)
+		}
+
+		if e.expectedMessage != "" {
+			rr = c.do("GET", e.expectedLocation, nil)
+			if !strings.Contains(rr.Body.String(), e.expectedMessage) {
+				t.Errorf("%s: expected %q to be shown", e.name, e.expectedMessage)
+			}
+		}
+	}
+
+	// the reservation page offers the next steps of the stay and lists its history
+	var pages = []struct {
+		url      string
+		expected []string
+	}{
+		{"/admin/reservations/all/1", []string{"Check In", "Mark as No-Show", "Pending &rarr; Confirmed"}},
+		{"/admin/reservations/all/5", []string{"Check Out"}},
+	}
+
+	for _, p := range pages {
+		rr := c.do("GET", p.url, nil)
+		for _, text := range p.expected {
+			if !strings.Contains(rr.Body.String(), text) {
+				t.Errorf("%s: expected %q in the response", p.url, text)
+			}
+		}
+	}
+}
+
+func TestRepository_AdminTrash(t *testing.T) {
+	mux := chi.NewRouter()
+	mux.Use(SessionLoad)
+	mux.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			u := models.User{ID: 1, AccessLevel: models.AccessLevelAdmin}
+			next.ServeHTTP(w, r.WithContext(helpers.ContextWithUser(r.Context(), u)))
+		})
+	})
+	mux.Get("/admin/reservations-all", Repo.AdminAllReservations)
+	mux.Get("/admin/reservations/{src}/{id}", Repo.AdminShowReservation)
+	mux.Post("/admin/reservations/{src}/{id}/delete", Repo.AdminDeleteReservation)
+	mux.Get("/admin/reservations-trash", Repo.AdminTrash)
+	mux.Post("/admin/reservations-trash/{id}/restore", Repo.AdminRestoreReservation)
+
+	c := &sessionClient{handler: mux}
+
+	var tests = []struct {
+		name               string
+		method             string
+		url                string
+		expectedStatusCode int
+		expectedLocation   string
+		expectedMessage    string
+	}{
+		{"delete", "POST", "/admin/reservations/all/1/delete", http.StatusSeeOther, "/admin/reservations-all", "Reservation moved to the trash"},
+		{"delete with get", "GET", "/admin/reservations/all/1/delete", http.StatusMethodNotAllowed, "", ""},
+		{"delete unknown reservation", "POST", "/admin/reservations/all/1000/delete", http.StatusNotFound, "", ""},
+		{"delete invalid id", "POST", "/admin/reservations/all/x/delete", http.StatusBadRequest, "", ""},
+		{"restore", "POST", "/admin/reservations-trash/6/restore", http.StatusSeeOther, "/admin/reservations/all/6", "Reservation restored"},
+		{"restore room taken", "POST", "/admin/reservations-trash/7/restore", http.StatusSeeOther, "/admin/reservations-trash", "booked for these dates since"},
+		{"restore reservation not in trash", "POST", "/admin/reservations-trash/1/restore", http.StatusNotFound, "", ""},
+		{"restore invalid id", "POST", "/admin/reservations-trash/x/restore", http.StatusBadRequest, "", ""},
+	}
+
+	for _, e := range tests {
+		rr := c.do(e.method, e.url, url.Values{})
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected %d, got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+
+		if loc := rr.Header().Get("Location"); loc != e.expectedLocation {
+			t.Errorf("%s: expected location %q, got %q", e.name, e.expectedLocation, loc)
+		}
+
+		if e.expectedMessage != "" {
+			rr = c.do("GET", e.expectedLocation, nil)
+			if !strings.Contains(rr.Body.String(), e.expectedMessage) {
+				t.Errorf("%s: expected %q to be shown", e.name, e.expectedMessage)
+			}
+		}
+	}
+
+	rr := c.do("GET", "/admin/reservations-trash", nil)
+	for _, text := range []string{"/admin/reservations-trash/6/restore", "/admin/reservations-trash/7/restore", "2049-12-20 09:00"} {
+		if !strings.Contains(rr.Body.String(), text) {
+			t.Errorf("expected %q in the trash", text)
+		}
+	}
+}
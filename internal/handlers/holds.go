@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/dhanekom/bookings/internal/models"
+)
+
+// placeHold holds the room of res for its dates while the guest fills in the reservation form, and keeps the
+// hold in the session. A hold the guest placed before is released first
+func (m *Repository) placeHold(r *http.Request, res models.Reservation) error {
+	m.releaseHold(r)
+
+	hold, err := m.newHold(r, res)
+	if err != nil || hold.ID == 0 {
+		return err
+	}
+
+	m.App.Session.Put(r.Context(), "hold", hold)
+	return nil
+}
+
+// newHold holds the room of res for its dates. No hold is placed, and a hold with an id of 0 is returned, if
+// holds are turned off or the stay has no nights, which the reservation form reports
+func (m *Repository) newHold(r *http.Request, res models.Reservation) (models.RoomRestriction, error) {
+	if m.App.HoldDuration <= 0 || !res.EndDate.After(res.StartDate) {
+		return models.RoomRestriction{}, nil
+	}
+
+	hold := models.RoomRestriction{
+		StartDate:     res.StartDate,
+		EndDate:       res.EndDate,
+		RoomID:        res.RoomID,
+		RestrictionID: models.RestrictionHold,
+		ExpiresAt:     time.Now().Add(m.App.HoldDuration),
+	}
+
+	id, err := m.DB.PlaceHold(r.Context(), hold)
+	if err != nil {
+		return models.RoomRestriction{}, err
+	}
+	hold.ID = id
+
+	return hold, nil
+}
+
+// releaseHold releases the hold kept in the session, if there is one. The hold may already have been swept
+func (m *Repository) releaseHold(r *http.Request) {
+	hold, ok := m.App.Session.Pop(r.Context(), "hold").(models.RoomRestriction)
+	if !ok {
+		return
+	}
+
+	if err := m.DB.ReleaseHold(hold.ID); err != nil {
+		m.App.ErrorLog.Println(err)
+	}
+}
+
+// sessionHold returns the hold kept in the session if it is for the room and dates of res
+func (m *Repository) sessionHold(r *http.Request, res models.Reservation) (models.RoomRestriction, bool) {
+	hold, ok := m.App.Session.Get(r.Context(), "hold").(models.RoomRestriction)
+	if !ok || hold.RoomID != res.RoomID || !hold.StartDate.Equal(res.StartDate) || !hold.EndDate.Equal(res.EndDate) {
+		return models.RoomRestriction{}, false
+	}
+
+	return hold, true
+}
@@ -0,0 +1,119 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dhanekom/bookings/internal/models"
+	"github.com/go-chi/chi/v5"
+)
+
+func TestRoomHolds(t *testing.T) {
+	var tests = []struct {
+		name             string
+		url              string
+		roomID           string
+		expectedLocation string
+		expectedHold     int
+	}{
+		{"book room", "/book-room?id=2&s=2050-01-01&e=2050-01-03&a=2", "", "/make-reservation", 102},
+		{"book room without nights", "/book-room?id=2&s=2050-01-01&e=2050-01-01&a=2", "", "/make-reservation", 0},
+		{"book room taken", "/book-room?id=1001&s=2050-01-01&e=2050-01-03&a=2", "", "/search-availability", 0},
+		{"choose room", "/choose-room/1", "1", "/make-reservation", 101},
+		{"choose room taken", "/choose-room/1001", "1001", "/search-availability", 0},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", e.url, nil)
+		ctx := getCtx(req)
+
+		handler := Repo.BookRoom
+		if e.roomID != "" {
+			handler = Repo.ChooseRoom
+			rctx := chi.NewRouteContext()
+			rctx.URLParams.Add("id", e.roomID)
+			ctx = context.WithValue(ctx, chi.RouteCtxKey, rctx)
+			session.Put(ctx, "reservation", models.Reservation{
+				StartDate: time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC),
+				EndDate:   time.Date(2050, 1, 3, 0, 0, 0, 0, time.UTC),
+			})
+		}
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(handler).ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != e.expectedLocation {
+			t.Errorf("%s: expected a redirect to %s, got %d %s", e.name, e.expectedLocation, rr.Code, rr.Header().Get("Location"))
+		}
+
+		hold, _ := session.Get(ctx, "hold").(models.RoomRestriction)
+		if hold.ID != e.expectedHold {
+			t.Errorf("%s: expected hold %d in the session, got %d", e.name, e.expectedHold, hold.ID)
+		}
+
+		if hold.ID != 0 && !hold.ExpiresAt.After(time.Now()) {
+			t.Errorf("%s: expected the hold to expire later, got %s", e.name, hold.ExpiresAt)
+		}
+	}
+}
+
+func TestRoomHoldCountdown(t *testing.T) {
+	reservation := models.Reservation{
+		RoomID:    1,
+		StartDate: time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC),
+		EndDate:   time.Date(2050, 1, 3, 0, 0, 0, 0, time.UTC),
+	}
+
+	var tests = []struct {
+		name     string
+		hold     models.RoomRestriction
+		expected bool
+	}{
+		{"held", models.RoomRestriction{ID: 101, RoomID: 1, StartDate: reservation.StartDate, EndDate: reservation.EndDate, ExpiresAt: time.Now().Add(10 * time.Minute)}, true},
+		{"held for other dates", models.RoomRestriction{ID: 101, RoomID: 1, StartDate: reservation.StartDate, EndDate: reservation.StartDate.AddDate(0, 0, 1), ExpiresAt: time.Now().Add(10 * time.Minute)}, false},
+		{"not held", models.RoomRestriction{}, false},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", "/make-reservation", nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		session.Put(ctx, "reservation", reservation)
+		if e.hold.ID != 0 {
+			session.Put(ctx, "hold", e.hold)
+		}
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.Reservation).ServeHTTP(rr, req)
+
+		if got := strings.Contains(rr.Body.String(), `id="hold-countdown"`); got != e.expected {
+			t.Errorf("%s: expected countdown %v, got %v", e.name, e.expected, got)
+		}
+	}
+}
+
+func TestPostReservationUsesHold(t *testing.T) {
+	reqBody := "start_date=2050-01-01&end_date=2050-01-02&first_name=John&last_name=Smith&email=john@smith.com&phone=123456789&adults=2&room_id=1"
+
+	req, _ := http.NewRequest("POST", "/make-reservation", strings.NewReader(reqBody))
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	session.Put(ctx, "hold", models.RoomRestriction{ID: 101, RoomID: 1})
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(Repo.PostReservation).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != "/reservation-summary" {
+		t.Fatalf("expected a redirect to the summary, got %d %s", rr.Code, rr.Header().Get("Location"))
+	}
+
+	if session.Exists(ctx, "hold") {
+		t.Error("expected the hold to be removed from the session once it was booked")
+	}
+}
@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/dhanekom/bookings/internal/helpers"
+	"github.com/dhanekom/bookings/internal/mailer"
+	"github.com/dhanekom/bookings/internal/models"
+	"github.com/dhanekom/bookings/internal/render"
+	"github.com/go-chi/chi/v5"
+)
+
+// mailQueueLimit is the number of messages shown on the mail queue page
+const mailQueueLimit = 200
+
+// AdminMailQueue lists the messages in the outbox, optionally only those with the status in the query string
+func (m *Repository) AdminMailQueue(w http.ResponseWriter, r *http.Request) {
+	status := r.URL.Query().Get("status")
+	switch status {
+	case "", mailer.StatusPending, mailer.StatusSent, mailer.StatusDead:
+	default:
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	messages, err := m.App.Mail.Store.List(status, mailQueueLimit)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	counts, err := m.App.Mail.Store.Counts()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["messages"] = messages
+	data["counts"] = counts
+
+	stringMap := make(map[string]string)
+	stringMap["status"] = status
+
+	render.Template(w, r, "admin-mail-queue.page.tmpl", &models.TemplateData{
+		StringMap: stringMap,
+		Data:      data,
+	})
+}
+
+// AdminResendMail queues a message that failed to send again
+func (m *Repository) AdminResendMail(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	err = m.App.Mail.Resend(id)
+	if errors.Is(err, mailer.ErrNotResendable) {
+		m.AddError(r, "The message was already sent")
+	} else if err != nil {
+		helpers.ServerError(w, err)
+		return
+	} else {
+		m.AddFlash(r, "The message was queued to be sent again")
+	}
+
+	http.Redirect(w, r, "/admin/mail-queue", http.StatusSeeOther)
+}
@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dhanekom/bookings/internal/mailer"
+	"github.com/dhanekom/bookings/internal/models"
+	"github.com/go-chi/chi/v5"
+)
+
+func TestMailQueue(t *testing.T) {
+	// use an outbox of our own, so that mail queued by other tests doesn't show up
+	saved := app.Mail
+	defer func() { app.Mail = saved }()
+	app.Mail = mailer.NewOutbox(mailer.NewMemoryStore(), nil, nil)
+
+	Repo.SendMail(models.MailData{To: "sent@here.com", Subject: "Sent message"})
+	Repo.SendMail(models.MailData{To: "dead@here.com", Subject: "Dead message"})
+
+	now := time.Now()
+	app.Mail.Store.MarkSent(1, now)
+	app.Mail.Store.MarkFailed(2, "connection refused", now, true)
+
+	mux := chi.NewRouter()
+	mux.Use(SessionLoad)
+	mux.Get("/admin/mail-queue", Repo.AdminMailQueue)
+	mux.Post("/admin/mail-queue/{id}/resend", Repo.AdminResendMail)
+
+	var tests = []struct {
+		name               string
+		method             string
+		url                string
+		expectedStatusCode int
+		expectedLocation   string
+		expectedText       string
+	}{
+		{"all", "GET", "/admin/mail-queue", http.StatusOK, "", "sent@here.com"},
+		{"dead", "GET", "/admin/mail-queue?status=dead", http.StatusOK, "", "connection refused"},
+		{"pending", "GET", "/admin/mail-queue?status=pending", http.StatusOK, "", "No messages"},
+		{"invalid status", "GET", "/admin/mail-queue?status=lost", http.StatusBadRequest, "", ""},
+		{"resend", "POST", "/admin/mail-queue/2/resend", http.StatusSeeOther, "/admin/mail-queue", ""},
+		{"resend sent", "POST", "/admin/mail-queue/1/resend", http.StatusSeeOther, "/admin/mail-queue", ""},
+		{"resend invalid id", "POST", "/admin/mail-queue/x/resend", http.StatusBadRequest, "", ""},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest(e.method, e.url, nil)
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected %d, got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+
+		if e.expectedLocation != "" && rr.Header().Get("Location") != e.expectedLocation {
+			t.Errorf("%s: expected location %s, got %s", e.name, e.expectedLocation, rr.Header().Get("Location"))
+		}
+
+		if e.expectedText != "" && !strings.Contains(rr.Body.String(), e.expectedText) {
+			t.Errorf("%s: expected %q in the response", e.name, e.expectedText)
+		}
+	}
+
+	counts, _ := app.Mail.Store.Counts()
+	if counts[mailer.StatusPending] != 1 || counts[mailer.StatusDead] != 0 {
+		t.Errorf("expected the dead message to be pending again, got %v", counts)
+	}
+}
@@ -0,0 +1,320 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/dhanekom/bookings/internal/audit"
+	"github.com/dhanekom/bookings/internal/cancellation"
+	"github.com/dhanekom/bookings/internal/forms"
+	"github.com/dhanekom/bookings/internal/helpers"
+	"github.com/dhanekom/bookings/internal/ical"
+	"github.com/dhanekom/bookings/internal/lifecycle"
+	"github.com/dhanekom/bookings/internal/models"
+	"github.com/dhanekom/bookings/internal/render"
+	"github.com/dhanekom/bookings/internal/repository"
+)
+
+// ManageBooking shows the form where guests look up their reservation
+func (m *Repository) ManageBooking(w http.ResponseWriter, r *http.Request) {
+	render.Template(w, r, "manage-booking.page.tmpl", &models.TemplateData{
+		Form: forms.New(nil),
+	})
+}
+
+// PostManageBooking looks up a reservation by confirmation code and email address
+func (m *Repository) PostManageBooking(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("confirmation_code", "email")
+	form.IsEmail("email")
+
+	if !form.Valid() {
+		render.Template(w, r, "manage-booking.page.tmpl", &models.TemplateData{
+			Form: form,
+		})
+		return
+	}
+
+	res, err := m.DB.GetReservationByCode(form.Get("confirmation_code"), form.Get("email"))
+	if errors.Is(err, sql.ErrNoRows) {
+		form.Errors.Add("confirmation_code", "No reservation matches this confirmation code and email address")
+		render.Template(w, r, "manage-booking.page.tmpl", &models.TemplateData{
+			Form: form,
+		})
+		return
+	} else if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.RenewToken(r.Context())
+	m.App.Session.Put(r.Context(), "manage_reservation_id", res.ID)
+	http.Redirect(w, r, "/manage-booking/reservation", http.StatusSeeOther)
+}
+
+// managedReservation returns the reservation the guest looked up, redirecting to the look up form if there
+// is none
+func (m *Repository) managedReservation(w http.ResponseWriter, r *http.Request) (models.Reservation, bool) {
+	id, ok := m.App.Session.Get(r.Context(), "manage_reservation_id").(int)
+	if !ok {
+		m.AddError(r, "Enter your confirmation code and email address to find your reservation")
+		http.Redirect(w, r, "/manage-booking", http.StatusSeeOther)
+		return models.Reservation{}, false
+	}
+
+	res, err := m.DB.GetReservationByID(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		m.App.Session.Remove(r.Context(), "manage_reservation_id")
+		m.AddError(r, "The reservation no longer exists")
+		http.Redirect(w, r, "/manage-booking", http.StatusSeeOther)
+		return res, false
+	} else if err != nil {
+		helpers.ServerError(w, err)
+		return res, false
+	}
+
+	return res, true
+}
+
+// activeManagedReservation is like managedReservation, but also redirects if the reservation was cancelled or
+// the stay has already started
+func (m *Repository) activeManagedReservation(w http.ResponseWriter, r *http.Request) (models.Reservation, bool) {
+	res, ok := m.managedReservation(w, r)
+	if !ok {
+		return res, false
+	}
+
+	if !res.CancelledAt.IsZero() {
+		m.AddError(r, "This reservation was cancelled and can't be changed")
+		http.Redirect(w, r, "/manage-booking/reservation", http.StatusSeeOther)
+		return res, false
+	}
+
+	if !lifecycle.Open(res.Status) {
+		m.AddError(r, "This reservation can't be changed once your stay has started")
+		http.Redirect(w, r, "/manage-booking/reservation", http.StatusSeeOther)
+		return res, false
+	}
+
+	return res, true
+}
+
+// renderManagedReservation renders the guest's view of their reservation
+func (m *Repository) renderManagedReservation(w http.ResponseWriter, r *http.Request, res models.Reservation, form *forms.Form) {
+	data := make(map[string]interface{})
+	data["reservation"] = res
+	data["open"] = lifecycle.Open(res.Status)
+
+	stringMap := make(map[string]string)
+	stringMap["start_date"] = res.StartDate.Format("2006-01-02")
+	stringMap["end_date"] = res.EndDate.Format("2006-01-02")
+
+	// guests see what cancelling would cost before they cancel
+	if lifecycle.Open(res.Status) {
+		policy, err := m.cancellationPolicy(res)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+
+		paid, err := m.DB.PaymentsForReservation(res.ID)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+
+		stringMap["cancellation_policy"] = cancellation.Describe(policy)
+		data["settlement"] = cancellation.Settle(policy, res, paid, time.Now())
+	}
+
+	render.Template(w, r, "manage-booking-reservation.page.tmpl", &models.TemplateData{
+		StringMap: stringMap,
+		Data:      data,
+		Form:      form,
+	})
+}
+
+// ManageBookingReservation shows the reservation the guest looked up
+func (m *Repository) ManageBookingReservation(w http.ResponseWriter, r *http.Request) {
+	res, ok := m.managedReservation(w, r)
+	if !ok {
+		return
+	}
+
+	m.renderManagedReservation(w, r, res, forms.New(nil))
+}
+
+// PostManageBookingContact changes the guest's contact details
+func (m *Repository) PostManageBookingContact(w http.ResponseWriter, r *http.Request) {
+	res, ok := m.activeManagedReservation(w, r)
+	if !ok {
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("first_name", "last_name", "email")
+	form.MinLength("first_name", 3)
+	form.IsEmail("email")
+
+	before := audit.Reservation(res)
+	res.FirstName = form.Get("first_name")
+	res.LastName = form.Get("last_name")
+	res.Email = form.Get("email")
+	res.Phone = form.Get("phone")
+
+	if !form.Valid() {
+		m.renderManagedReservation(w, r, res, form)
+		return
+	}
+
+	err = m.DB.UpdateReservation(res)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	m.audit(r, models.AuditUpdate, models.AuditEntityReservation, res.ID, before, audit.Reservation(res))
+
+	m.AddFlash(r, "Your contact details were saved")
+	http.Redirect(w, r, "/manage-booking/reservation", http.StatusSeeOther)
+}
+
+// PostManageBookingDates moves the reservation to new dates if the room is available on them
+func (m *Repository) PostManageBookingDates(w http.ResponseWriter, r *http.Request) {
+	res, ok := m.activeManagedReservation(w, r)
+	if !ok {
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("start_date", "end_date")
+
+	const layout = "2006-01-02"
+	startDate, err := time.Parse(layout, form.Get("start_date"))
+	if err != nil {
+		form.Errors.Add("start_date", "Invalid date")
+	}
+
+	endDate, err := time.Parse(layout, form.Get("end_date"))
+	if err != nil {
+		form.Errors.Add("end_date", "Invalid date")
+	}
+
+	if form.Valid() {
+		violations, err := m.checkStay(res.RoomID, startDate, endDate)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+		addStayErrors(form, violations, "start_date", "end_date")
+	}
+
+	if !form.Valid() {
+		m.renderManagedReservation(w, r, res, form)
+		return
+	}
+
+	// the stay is priced again for the new dates
+	_, quote, err := m.quoteRoom(res.RoomID, startDate, endDate)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	err = m.DB.ChangeReservationDates(r.Context(), res.ID, startDate, endDate, quote)
+	if errors.Is(err, repository.ErrRoomUnavailable) {
+		form.Errors.Add("start_date", "Sorry, the room isn't available on these dates")
+		m.renderManagedReservation(w, r, res, form)
+		return
+	} else if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	// nights of the old dates that the new ones don't cover have been freed
+	m.matchWaitlist()
+
+	// the guest gets an update of their calendar invite, which replaces the one for the old dates
+	changed := res
+	changed.StartDate = startDate
+	changed.EndDate = endDate
+	changed.Quote = quote
+	changed.CalendarSequence++
+	m.audit(r, models.AuditUpdate, models.AuditEntityReservation, res.ID, audit.Reservation(res), audit.Reservation(changed))
+
+	m.SendMail(models.MailData{
+		To:       res.Email,
+		From:     "me@here.com",
+		Subject:  "Reservation Changed",
+		Template: "reservation-updated",
+		Data: map[string]interface{}{
+			"reservation": changed,
+		},
+		Attachments: []models.Attachment{m.reservationInvite(changed, ical.MethodRequest)},
+	})
+
+	m.SendMail(models.MailData{
+		To:       "me@here.com",
+		From:     "me@here.com",
+		Subject:  "Reservation Changed",
+		Template: "reservation-changed",
+		Data: map[string]interface{}{
+			"reservation": res,
+			"start_date":  startDate,
+			"end_date":    endDate,
+		},
+	})
+
+	m.AddFlash(r, "Your reservation dates were changed")
+	http.Redirect(w, r, "/manage-booking/reservation", http.StatusSeeOther)
+}
+
+// PostManageBookingCancel cancels the reservation, refunding what its cancellation policy allows, and lets the
+// property know
+func (m *Repository) PostManageBookingCancel(w http.ResponseWriter, r *http.Request) {
+	res, ok := m.activeManagedReservation(w, r)
+	if !ok {
+		return
+	}
+
+	s, _, err := m.cancelReservation(r, res, "Cancelled by the guest")
+	if errors.Is(err, sql.ErrNoRows) {
+		m.AddError(r, "This reservation was already cancelled")
+		http.Redirect(w, r, "/manage-booking/reservation", http.StatusSeeOther)
+		return
+	} else if errors.Is(err, repository.ErrInvalidTransition) {
+		m.AddError(r, "This reservation can't be cancelled once your stay has started")
+		http.Redirect(w, r, "/manage-booking/reservation", http.StatusSeeOther)
+		return
+	} else if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	msg := "Your reservation was cancelled"
+	if s.Refund > 0 {
+		msg = fmt.Sprintf("Your reservation was cancelled, and %s will be refunded", render.FormatPrice(s.Refund))
+	}
+
+	m.AddFlash(r, msg)
+	http.Redirect(w, r, "/manage-booking/reservation", http.StatusSeeOther)
+}
@@ -0,0 +1,153 @@
+package handlers
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/dhanekom/bookings/internal/repository/dbrepo"
+	"github.com/go-chi/chi/v5"
+)
+
+func getManageBookingRoutes() http.Handler {
+	mux := chi.NewRouter()
+
+	mux.Use(SessionLoad)
+
+	mux.Get("/manage-booking", Repo.ManageBooking)
+	mux.Post("/manage-booking", Repo.PostManageBooking)
+	mux.Get("/manage-booking/reservation", Repo.ManageBookingReservation)
+	mux.Post("/manage-booking/contact", Repo.PostManageBookingContact)
+	mux.Post("/manage-booking/dates", Repo.PostManageBookingDates)
+	mux.Post("/manage-booking/cancel", Repo.PostManageBookingCancel)
+
+	return mux
+}
+
+var manageBookingTests = []struct {
+	name               string
+	method             string
+	url                string
+	postedData         url.Values
+	expectedStatusCode int
+	expectedLocation   string
+	expectedText       string
+}{
+	{"view", "GET", "/manage-booking/reservation", nil, http.StatusOK, "", dbrepo.TestConfirmationCode},
+	{"view the cancellation policy", "GET", "/manage-booking/reservation", nil, http.StatusOK, "", "$300.00 of the $300.00 you paid will be refunded"},
+	{"change contact details", "POST", "/manage-booking/contact", url.Values{
+		"first_name": {"John"},
+		"last_name":  {"Smith"},
+		"email":      {"john@smith.com"},
+		"phone":      {"555-1234"},
+	}, http.StatusSeeOther, "/manage-booking/reservation", ""},
+	{"invalid contact details", "POST", "/manage-booking/contact", url.Values{
+		"first_name": {"John"},
+		"last_name":  {"Smith"},
+		"email":      {"john"},
+	}, http.StatusOK, "", "Invalid email address"},
+	{"change dates", "POST", "/manage-booking/dates", url.Values{
+		"start_date": {"2050-02-01"},
+		"end_date":   {"2050-02-03"},
+	}, http.StatusSeeOther, "/manage-booking/reservation", ""},
+	{"change to unavailable dates", "POST", "/manage-booking/dates", url.Values{
+		"start_date": {"2051-02-01"},
+		"end_date":   {"2051-02-03"},
+	}, http.StatusOK, "", "isn&#39;t available"},
+	{"change to dates in the past", "POST", "/manage-booking/dates", url.Values{
+		"start_date": {"2000-02-01"},
+		"end_date":   {"2000-02-03"},
+	}, http.StatusOK, "", "in the past"},
+	{"change to end before start", "POST", "/manage-booking/dates", url.Values{
+		"start_date": {"2050-02-03"},
+		"end_date":   {"2050-02-01"},
+	}, http.StatusOK, "", "must be after"},
+	{"change to dates breaking the stay rules", "POST", "/manage-booking/dates", url.Values{
+		"start_date": {"2050-02-01"},
+		"end_date":   {"2050-02-20"},
+	}, http.StatusOK, "", "can be at most 14 nights"},
+	{"change to invalid dates", "POST", "/manage-booking/dates", url.Values{
+		"start_date": {"invalid"},
+		"end_date":   {"2050-02-01"},
+	}, http.StatusOK, "", "Invalid date"},
+	{"cancel", "POST", "/manage-booking/cancel", nil, http.StatusSeeOther, "/manage-booking/reservation", ""},
+}
+
+func TestManageBooking(t *testing.T) {
+	c := &sessionClient{handler: getManageBookingRoutes()}
+
+	rr := c.do("POST", "/manage-booking", url.Values{
+		"confirmation_code": {dbrepo.TestConfirmationCode},
+		"email":             {"john@smith.com"},
+	})
+	if loc := rr.Header().Get("Location"); loc != "/manage-booking/reservation" {
+		t.Fatalf("expected the look up to redirect to the reservation, got %s", loc)
+	}
+
+	for _, e := range manageBookingTests {
+		rr := c.do(e.method, e.url, e.postedData)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected %d, got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+
+		if e.expectedLocation != "" {
+			if loc := rr.Header().Get("Location"); loc != e.expectedLocation {
+				t.Errorf("%s: expected location %s, got %s", e.name, e.expectedLocation, loc)
+			}
+		}
+
+		if e.expectedText != "" && !strings.Contains(rr.Body.String(), e.expectedText) {
+			t.Errorf("%s: expected body to contain %q", e.name, e.expectedText)
+		}
+	}
+}
+
+func TestManageBooking_LookUp(t *testing.T) {
+	var tests = []struct {
+		name               string
+		postedData         url.Values
+		expectedStatusCode int
+		expectedText       string
+	}{
+		{"wrong email", url.Values{
+			"confirmation_code": {dbrepo.TestConfirmationCode},
+			"email":             {"jane@smith.com"},
+		}, http.StatusOK, "No reservation matches"},
+		{"wrong code", url.Values{
+			"confirmation_code": {"XXXXXXXXXX"},
+			"email":             {"john@smith.com"},
+		}, http.StatusOK, "No reservation matches"},
+		{"missing code", url.Values{
+			"email": {"john@smith.com"},
+		}, http.StatusOK, "This field cannot be blank"},
+	}
+
+	for _, e := range tests {
+		c := &sessionClient{handler: getManageBookingRoutes()}
+
+		rr := c.do("POST", "/manage-booking", e.postedData)
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected %d, got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+
+		if !strings.Contains(rr.Body.String(), e.expectedText) {
+			t.Errorf("%s: expected body to contain %q", e.name, e.expectedText)
+		}
+	}
+
+	// without looking up a reservation first, guests are sent to the look up form
+	c := &sessionClient{handler: getManageBookingRoutes()}
+	for _, path := range []string{"/manage-booking/reservation", "/manage-booking/cancel"} {
+		method := "GET"
+		if path == "/manage-booking/cancel" {
+			method = "POST"
+		}
+
+		rr := c.do(method, path, nil)
+		if loc := rr.Header().Get("Location"); loc != "/manage-booking" {
+			t.Errorf("%s: expected redirect to /manage-booking, got %s", path, loc)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/dhanekom/bookings/internal/forms"
+	"github.com/dhanekom/bookings/internal/helpers"
+	"github.com/dhanekom/bookings/internal/models"
+	"github.com/dhanekom/bookings/internal/render"
+	"github.com/dhanekom/bookings/internal/repository"
+)
+
+// passwordResetLifetime is how long a password reset link stays valid
+const passwordResetLifetime = time.Hour
+
+// forgotPasswordMessage is shown whether or not the email address belongs to a user
+const forgotPasswordMessage = "If the email address belongs to an account, a link to reset your password has been sent to it"
+
+// ForgotPassword shows the form to request a password reset link
+func (m *Repository) ForgotPassword(w http.ResponseWriter, r *http.Request) {
+	render.Template(w, r, "forgot-password.page.tmpl", &models.TemplateData{
+		Form: forms.New(nil),
+	})
+}
+
+// PostForgotPassword emails a password reset link to the user with the posted email address
+func (m *Repository) PostForgotPassword(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("email")
+	form.IsEmail("email")
+
+	if !form.Valid() {
+		render.Template(w, r, "forgot-password.page.tmpl", &models.TemplateData{
+			Form: form,
+		})
+		return
+	}
+
+	u, err := m.DB.GetUserByEmail(form.Get("email"))
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	// unknown and deactivated users get the same response so that it can't be used to find accounts
+	if err == nil && u.DeactivatedAt.IsZero() {
+		expires := time.Now().Add(passwordResetLifetime)
+		token, err := helpers.NewSignedToken(m.App.SecretKey, expires)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+
+		err = m.DB.InsertPasswordReset(u.ID, helpers.HashToken(token), expires)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+
+		link := fmt.Sprintf("%s/user/reset-password?token=%s", m.App.BaseURL, url.QueryEscape(token))
+
+		m.SendMail(models.MailData{
+			To:       u.Email,
+			From:     "me@here.com",
+			Subject:  "Password Reset",
+			Template: "password-reset",
+			Data: map[string]interface{}{
+				"first_name": u.FirstName,
+				"link":       link,
+			},
+		})
+	}
+
+	m.AddFlash(r, forgotPasswordMessage)
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
+
+// ResetPassword shows the form to choose a new password
+func (m *Repository) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	if helpers.VerifySignedToken(m.App.SecretKey, token, time.Now()) != nil {
+		m.AddError(r, "The password reset link is invalid or has expired")
+		http.Redirect(w, r, "/user/forgot-password", http.StatusSeeOther)
+		return
+	}
+
+	stringMap := make(map[string]string)
+	stringMap["token"] = token
+
+	render.Template(w, r, "reset-password.page.tmpl", &models.TemplateData{
+		StringMap: stringMap,
+		Form:      forms.New(nil),
+	})
+}
+
+// PostResetPassword sets a new password using a password reset token
+func (m *Repository) PostResetPassword(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	token := r.Form.Get("token")
+	if helpers.VerifySignedToken(m.App.SecretKey, token, time.Now()) != nil {
+		m.AddError(r, "The password reset link is invalid or has expired")
+		http.Redirect(w, r, "/user/forgot-password", http.StatusSeeOther)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	validatePasswordForm(form)
+
+	if !form.Valid() {
+		stringMap := make(map[string]string)
+		stringMap["token"] = token
+
+		render.Template(w, r, "reset-password.page.tmpl", &models.TemplateData{
+			StringMap: stringMap,
+			Form:      form,
+		})
+		return
+	}
+
+	userID, err := m.DB.UsePasswordReset(helpers.HashToken(token), form.Get("password"))
+	if errors.Is(err, sql.ErrNoRows) {
+		m.AddError(r, "The password reset link has already been used or has expired")
+		http.Redirect(w, r, "/user/forgot-password", http.StatusSeeOther)
+		return
+	} else if errors.Is(err, repository.ErrUserDeactivated) {
+		m.AddError(r, "Your account has been deactivated, so its password can't be changed")
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	} else if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	m.audit(r, models.AuditPassword, models.AuditEntityUser, userID, nil, nil)
+
+	m.AddFlash(r, "Your password has been changed. Log in with your new password")
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
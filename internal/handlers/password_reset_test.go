@@ -0,0 +1,136 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dhanekom/bookings/internal/helpers"
+	"github.com/dhanekom/bookings/internal/repository/dbrepo"
+	"github.com/go-chi/chi/v5"
+)
+
+func getPasswordResetRoutes() http.Handler {
+	mux := chi.NewRouter()
+
+	mux.Use(SessionLoad)
+
+	mux.Get("/user/forgot-password", Repo.ForgotPassword)
+	mux.Post("/user/forgot-password", Repo.PostForgotPassword)
+	mux.Get("/user/reset-password", Repo.ResetPassword)
+	mux.Post("/user/reset-password", Repo.PostResetPassword)
+
+	return mux
+}
+
+func TestPasswordReset(t *testing.T) {
+	validToken, _ := helpers.NewSignedToken(app.SecretKey, time.Now().Add(time.Hour))
+	expiredToken, _ := helpers.NewSignedToken(app.SecretKey, time.Now().Add(-time.Minute))
+	foreignToken, _ := helpers.NewSignedToken([]byte("another-secret"), time.Now().Add(time.Hour))
+
+	var tests = []struct {
+		name               string
+		method             string
+		url                string
+		postedData         url.Values
+		expectedStatusCode int
+		expectedLocation   string
+		expectedText       string
+	}{
+		{"forgot password form", "GET", "/user/forgot-password", nil, http.StatusOK, "", "Send Reset Link"},
+		{"forgot password known user", "POST", "/user/forgot-password", url.Values{
+			"email": {"admin@admin.com"},
+		}, http.StatusSeeOther, "/user/login", ""},
+		{"forgot password unknown user", "POST", "/user/forgot-password", url.Values{
+			"email": {"nobody@here.com"},
+		}, http.StatusSeeOther, "/user/login", ""},
+		{"forgot password invalid email", "POST", "/user/forgot-password", url.Values{
+			"email": {"nobody"},
+		}, http.StatusOK, "", "Invalid email address"},
+		{"reset password form", "GET", "/user/reset-password?token=" + url.QueryEscape(validToken), nil, http.StatusOK, "", validToken},
+		{"reset password form expired token", "GET", "/user/reset-password?token=" + url.QueryEscape(expiredToken), nil, http.StatusSeeOther, "/user/forgot-password", ""},
+		{"reset password form foreign token", "GET", "/user/reset-password?token=" + url.QueryEscape(foreignToken), nil, http.StatusSeeOther, "/user/forgot-password", ""},
+		{"reset password form missing token", "GET", "/user/reset-password", nil, http.StatusSeeOther, "/user/forgot-password", ""},
+		{"reset password", "POST", "/user/reset-password", url.Values{
+			"token":            {validToken},
+			"password":         {"password123"},
+			"password_confirm": {"password123"},
+		}, http.StatusSeeOther, "/user/login", ""},
+		{"reset password mismatch", "POST", "/user/reset-password", url.Values{
+			"token":            {validToken},
+			"password":         {"password123"},
+			"password_confirm": {"password456"},
+		}, http.StatusOK, "", "do not match"},
+		{"reset password tampered token", "POST", "/user/reset-password", url.Values{
+			"token":            {validToken + "x"},
+			"password":         {"password123"},
+			"password_confirm": {"password123"},
+		}, http.StatusSeeOther, "/user/forgot-password", ""},
+		{"reset password expired token", "POST", "/user/reset-password", url.Values{
+			"token":            {expiredToken},
+			"password":         {"password123"},
+			"password_confirm": {"password123"},
+		}, http.StatusSeeOther, "/user/forgot-password", ""},
+	}
+
+	routes := getPasswordResetRoutes()
+
+	for _, e := range tests {
+		req, _ := http.NewRequest(e.method, e.url, strings.NewReader(e.postedData.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		rr := httptest.NewRecorder()
+		routes.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected %d, got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+
+		if e.expectedLocation != "" {
+			if loc := rr.Header().Get("Location"); loc != e.expectedLocation {
+				t.Errorf("%s: expected location %s, got %s", e.name, e.expectedLocation, loc)
+			}
+		}
+
+		if e.expectedText != "" && !strings.Contains(rr.Body.String(), e.expectedText) {
+			t.Errorf("%s: expected body to contain %q", e.name, e.expectedText)
+		}
+	}
+}
+
+func TestPasswordResetDeactivatedUser(t *testing.T) {
+	token, _ := helpers.NewSignedToken(app.SecretKey, time.Now().Add(time.Hour))
+	postedData := url.Values{
+		"token":            {token},
+		"password":         {"deactivated1"},
+		"password_confirm": {"deactivated1"},
+	}
+
+	req, _ := http.NewRequest("POST", "/user/reset-password", strings.NewReader(postedData.Encode()))
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	dbrepo.AuditLog = nil
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(Repo.PostResetPassword).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != "/user/login" {
+		t.Errorf("expected a redirect to the login page, got %d %s", rr.Code, rr.Header().Get("Location"))
+	}
+
+	if msg := session.PopString(ctx, "flash"); msg != "" {
+		t.Errorf("expected the password not to be reported as changed, got %q", msg)
+	}
+
+	if msg := session.PopString(ctx, "error"); !strings.Contains(msg, "deactivated") {
+		t.Errorf("expected the user to be told their account is deactivated, got %q", msg)
+	}
+
+	if len(dbrepo.AuditLog) != 0 {
+		t.Errorf("expected no password change to be audited, got %+v", dbrepo.AuditLog)
+	}
+}
@@ -0,0 +1,318 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/dhanekom/bookings/internal/audit"
+	"github.com/dhanekom/bookings/internal/helpers"
+	"github.com/dhanekom/bookings/internal/models"
+	"github.com/dhanekom/bookings/internal/payments"
+	"github.com/dhanekom/bookings/internal/render"
+	"github.com/dhanekom/bookings/internal/repository"
+)
+
+// maxWebhookSize is the largest webhook body that is read
+const maxWebhookSize = 1 << 20
+
+// deposit returns the amount taken when a reservation with total is booked, which is 0 if payments are turned off
+func (m *Repository) deposit(total int) int {
+	if m.App.Payments == nil {
+		return 0
+	}
+
+	return total * m.App.DepositPercent / 100
+}
+
+// reserveForPayment starts a checkout for the deposit of res and inserts res awaiting payment, holding its room
+// while the guest pays. It returns the id of the reservation and the URL of the checkout page
+func (m *Repository) reserveForPayment(ctx context.Context, res models.Reservation, holdID int) (int, string, error) {
+	gateway := m.App.Payments
+	amount := m.deposit(res.Quote.Total)
+
+	checkout, err := gateway.Authorize(ctx, payments.CheckoutRequest{
+		Reference: res.ConfirmationCode,
+		Amount:    amount,
+		Currency:  m.App.PaymentCurrency,
+		Email:     res.Email,
+		Description: fmt.Sprintf("%s, %s to %s", res.Room.RoomName,
+			res.StartDate.Format("2006-01-02"), res.EndDate.Format("2006-01-02")),
+		SuccessURL: m.App.BaseURL + "/reservation-summary",
+		CancelURL:  m.App.BaseURL + "/search-availability",
+	})
+	if err != nil {
+		return 0, "", err
+	}
+
+	id, err := m.DB.ReserveForPayment(ctx, res, holdID, time.Now().Add(m.App.PaymentWindow), models.Payment{
+		Provider:    gateway.Name(),
+		ProviderRef: checkout.PaymentID,
+		Kind:        models.PaymentKindPayment,
+		Status:      models.PaymentPending,
+		Amount:      amount,
+		Currency:    m.App.PaymentCurrency,
+	})
+	if err != nil {
+		return 0, "", err
+	}
+
+	return id, checkout.URL, nil
+}
+
+// PaymentWebhook handles the webhooks the payment provider sends when a payment's status changes. Webhooks
+// that can't be handled get an error, so that the provider sends them again
+func (m *Repository) PaymentWebhook(w http.ResponseWriter, r *http.Request) {
+	if m.App.Payments == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxWebhookSize))
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	e, err := m.App.Payments.VerifyWebhook(r.Header, body)
+	if err != nil {
+		m.App.ErrorLog.Println(err)
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	err = m.handlePaymentEvent(r, e)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// handlePaymentEvent records the payment status reported by the verified webhook r. A reservation is confirmed,
+// and its deposit captured, once its payment is authorized. Webhooks the provider sends again are ignored
+func (m *Repository) handlePaymentEvent(r *http.Request, e payments.Event) error {
+	var status string
+	switch e.Type {
+	case payments.EventAuthorized:
+		return m.confirmPayment(r, e)
+	case payments.EventCaptured:
+		status = models.PaymentCaptured
+	case payments.EventFailed:
+		status = models.PaymentFailed
+	case payments.EventRefunded:
+		status = models.PaymentRefunded
+	default:
+		// events that don't change a payment are acknowledged, so they aren't sent again
+		return nil
+	}
+
+	err := m.updatePaymentStatus(r, e.PaymentID, status)
+	if errors.Is(err, sql.ErrNoRows) {
+		m.App.ErrorLog.Printf("payment webhook for unknown payment %s", e.PaymentID)
+		return nil
+	}
+
+	return err
+}
+
+// confirmPayment confirms the reservation of an authorized payment, emails the confirmation and captures the
+// deposit. If the reservation was closed while the guest paid, or the room was taken after the guest's hold
+// expired, the payment is voided instead, so that the guest isn't charged for a stay they don't have
+func (m *Repository) confirmPayment(r *http.Request, e payments.Event) error {
+	ctx := r.Context()
+	gateway := m.App.Payments
+
+	p, err := m.DB.ConfirmPayment(ctx, gateway.Name(), e.PaymentID)
+	switch {
+	case errors.Is(err, repository.ErrPaymentProcessed):
+		return nil
+	case errors.Is(err, sql.ErrNoRows):
+		m.App.ErrorLog.Printf("payment webhook for unknown payment %s", e.PaymentID)
+		return nil
+	case errors.Is(err, repository.ErrReservationClosed):
+		m.App.ErrorLog.Printf("reservation %d was closed before payment %s was authorized", p.ReservationID, e.PaymentID)
+		return m.voidPayment(r, p)
+	case errors.Is(err, repository.ErrRoomUnavailable):
+		m.App.ErrorLog.Printf("room of reservation %d was taken before payment %s was authorized", p.ReservationID, e.PaymentID)
+		return m.releaseReservation(r, p)
+	case err != nil:
+		return err
+	}
+
+	pending := p
+	pending.Status = models.PaymentPending
+	m.auditPayment(r, pending, p)
+
+	res, err := m.DB.GetReservationByID(p.ReservationID)
+	if err != nil {
+		return err
+	}
+	m.sendReservationConfirmation(res)
+
+	// the reservation stands even if the capture fails, so the deposit can be taken later
+	err = gateway.Capture(ctx, p.ProviderRef, p.Amount)
+	if err != nil {
+		m.App.ErrorLog.Printf("payment %s couldn't be captured: %s", p.ProviderRef, err)
+		return nil
+	}
+
+	return m.updatePaymentStatus(r, p.ProviderRef, models.PaymentCaptured)
+}
+
+// releaseReservation cancels the reservation of payment p, whose room was taken before p was authorized, voids
+// p and lets the guest know. The reservation is cancelled first, so that a webhook the provider sends again
+// finds it closed and voids p if voiding it failed
+func (m *Repository) releaseReservation(r *http.Request, p models.Payment) error {
+	res, err := m.DB.GetReservationByID(p.ReservationID)
+	if err != nil {
+		return err
+	}
+
+	reason := "room was taken before the payment was authorized"
+	err = m.DB.CancelReservation(res.ID, reason, 0)
+	if err != nil {
+		return err
+	}
+	m.matchWaitlist()
+
+	before := audit.Reservation(res)
+	res.Status = models.ReservationCancelled
+	res.CancelledAt = time.Now()
+	res.CalendarSequence++
+	res.CancellationReason = reason
+	m.recordAudit(models.AuditEntry{
+		Actor:    "payment provider " + p.Provider,
+		Action:   models.AuditCancel,
+		Entity:   models.AuditEntityReservation,
+		EntityID: res.ID,
+		IP:       helpers.ClientIP(r),
+	}, before, audit.Reservation(res))
+
+	err = m.voidPayment(r, p)
+	if err != nil {
+		return err
+	}
+
+	m.SendMail(models.MailData{
+		To:       res.Email,
+		From:     "me@here.com",
+		Subject:  "Reservation Unavailable",
+		Template: "payment-released",
+		Data: map[string]interface{}{
+			"reservation": res,
+			"amount":      render.FormatPrice(p.Amount),
+		},
+	})
+
+	return nil
+}
+
+// voidPayment releases the authorized payment p through the payment gateway, so that it isn't captured, and
+// records it as voided
+func (m *Repository) voidPayment(r *http.Request, p models.Payment) error {
+	err := m.App.Payments.Void(r.Context(), p.ProviderRef)
+	if err != nil {
+		return err
+	}
+
+	return m.updatePaymentStatus(r, p.ProviderRef, models.PaymentVoided)
+}
+
+// updatePaymentStatus sets the status of the payment the provider knows by ref, as reported by the webhook r,
+// and audits the change
+func (m *Repository) updatePaymentStatus(r *http.Request, ref, status string) error {
+	before, err := m.DB.UpdatePaymentStatus(m.App.Payments.Name(), ref, status)
+	if err != nil {
+		return err
+	}
+
+	after := before
+	after.Status = status
+	m.auditPayment(r, before, after)
+
+	return nil
+}
+
+// FakeCheckout shows the checkout page of the fake payment gateway, which is used for local runs
+func (m *Repository) FakeCheckout(w http.ResponseWriter, r *http.Request) {
+	fake, ok := m.App.Payments.(*payments.Fake)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	p, ok := fake.Payment(r.URL.Query().Get("payment"))
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["payment"] = p
+
+	stringMap := make(map[string]string)
+	stringMap["payment_id"] = r.URL.Query().Get("payment")
+
+	render.Template(w, r, "fake-checkout.page.tmpl", &models.TemplateData{
+		Data:      data,
+		StringMap: stringMap,
+	})
+}
+
+// PostFakeCheckout pays or declines a payment of the fake payment gateway, and handles the webhook the gateway
+// returns the way PaymentWebhook would
+func (m *Repository) PostFakeCheckout(w http.ResponseWriter, r *http.Request) {
+	fake, ok := m.App.Payments.(*payments.Fake)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	id := r.Form.Get("payment")
+	p, ok := fake.Payment(id)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	complete, next := fake.Pay, p.Request.SuccessURL
+	if r.Form.Get("action") == "decline" {
+		complete, next = fake.Decline, p.Request.CancelURL
+	}
+
+	header, body, err := complete(id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	e, err := fake.VerifyWebhook(header, body)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	err = m.handlePaymentEvent(r, e)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	if e.Type == payments.EventFailed {
+		m.AddError(r, "Your payment was declined")
+	}
+	http.Redirect(w, r, next, http.StatusSeeOther)
+}
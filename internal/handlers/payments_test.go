@@ -0,0 +1,299 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dhanekom/bookings/internal/helpers"
+	"github.com/dhanekom/bookings/internal/models"
+	"github.com/dhanekom/bookings/internal/payments"
+	"github.com/dhanekom/bookings/internal/repository/dbrepo"
+	"github.com/go-chi/chi/v5"
+)
+
+// useFakePayments takes deposits of 20% through a fake gateway until the returned function is called
+func useFakePayments() (*payments.Fake, func()) {
+	fake := payments.NewFake(app.BaseURL+"/payments/fake-checkout", []byte("webhook-secret"))
+	app.Payments = fake
+	app.PaymentCurrency = "USD"
+	app.DepositPercent = 20
+	app.PaymentWindow = 30 * time.Minute
+
+	return fake, func() {
+		app.Payments = nil
+	}
+}
+
+// voidRecorder is a fake gateway that records the payments it voids, whichever payments they are
+type voidRecorder struct {
+	*payments.Fake
+	voided []string
+}
+
+func (g *voidRecorder) Void(ctx context.Context, paymentID string) error {
+	g.voided = append(g.voided, paymentID)
+	return nil
+}
+
+func TestPostReservationTakesDeposit(t *testing.T) {
+	fake, done := useFakePayments()
+	defer done()
+
+	reqBody := "start_date=2050-01-01&end_date=2050-01-02&first_name=John&last_name=Smith&email=john@smith.com&phone=123456789&adults=2&room_id=1"
+
+	req, _ := http.NewRequest("POST", "/make-reservation", strings.NewReader(reqBody))
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	before, _ := app.Mail.Store.List("", 0)
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(Repo.PostReservation).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusSeeOther || !strings.HasPrefix(rr.Header().Get("Location"), fake.CheckoutURL+"?payment=") {
+		t.Fatalf("expected a redirect to the checkout, got %d %s", rr.Code, rr.Header().Get("Location"))
+	}
+
+	res, _ := session.Get(ctx, "reservation").(models.Reservation)
+	if !res.AwaitingPayment || res.ID != 1 {
+		t.Errorf("expected the reservation to await payment, got %+v", res)
+	}
+
+	checkout, _ := url.Parse(rr.Header().Get("Location"))
+	p, ok := fake.Payment(checkout.Query().Get("payment"))
+	if !ok || p.Request.Amount != res.Quote.Total*20/100 || p.Request.Amount == 0 || p.Request.Reference != res.ConfirmationCode {
+		t.Errorf("expected a checkout for a 20%% deposit of %d, got %+v", res.Quote.Total, p.Request)
+	}
+
+	after, _ := app.Mail.Store.List("", 0)
+	if len(after) != len(before) {
+		t.Errorf("expected no confirmation before the payment, got %d messages", len(after)-len(before))
+	}
+}
+
+func TestPaymentWebhook(t *testing.T) {
+	fake, done := useFakePayments()
+	defer done()
+
+	gateway := &voidRecorder{Fake: fake}
+	app.Payments = gateway
+
+	checkout, _ := fake.Authorize(context.Background(), payments.CheckoutRequest{Amount: 1000})
+	_, _, _ = fake.Pay(checkout.PaymentID)
+
+	webhook := func(t payments.EventType, id string) (http.Header, []byte) {
+		header, body, _ := fake.Webhook(payments.Event{Type: t, PaymentID: id, Amount: 1000})
+		return header, body
+	}
+
+	forged, body := webhook(payments.EventAuthorized, checkout.PaymentID)
+	forged.Set(payments.SignatureHeader, "t=1,v1=abc")
+
+	var tests = []struct {
+		name              string
+		header            http.Header
+		body              []byte
+		expectedCode      int
+		expectedMessages  int
+		expectedStatuses  []string
+		expectedCancelled bool
+		expectedVoided    bool
+	}{
+		{"authorized", nil, nil, http.StatusOK, 2, []string{models.PaymentAuthorized, models.PaymentCaptured}, false, false},
+		{"forged", forged, body, http.StatusBadRequest, 0, nil, false, false},
+		{"already confirmed", nil, nil, http.StatusOK, 0, nil, false, false},
+		{"reservation closed", nil, nil, http.StatusOK, 0, []string{models.PaymentVoided}, false, true},
+		{"room taken", nil, nil, http.StatusOK, 1, []string{models.PaymentVoided}, true, true},
+		{"unknown payment", nil, nil, http.StatusOK, 0, nil, false, false},
+		{"captured", nil, nil, http.StatusOK, 0, []string{models.PaymentCaptured}, false, false},
+		{"failed", nil, nil, http.StatusOK, 0, nil, false, false},
+	}
+
+	tests[0].header, tests[0].body = webhook(payments.EventAuthorized, checkout.PaymentID)
+	tests[2].header, tests[2].body = webhook(payments.EventAuthorized, "processed")
+	tests[3].header, tests[3].body = webhook(payments.EventAuthorized, "closed")
+	tests[4].header, tests[4].body = webhook(payments.EventAuthorized, "taken")
+	tests[5].header, tests[5].body = webhook(payments.EventAuthorized, "unknown")
+	tests[6].header, tests[6].body = webhook(payments.EventCaptured, checkout.PaymentID)
+	tests[7].header, tests[7].body = webhook(payments.EventFailed, "unknown")
+
+	for _, e := range tests {
+		before, _ := app.Mail.Store.List("", 0)
+		dbrepo.AuditLog = nil
+		gateway.voided = nil
+
+		req, _ := http.NewRequest("POST", "/payments/webhook", bytes.NewReader(e.body))
+		req.Header = e.header
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.PaymentWebhook).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedCode {
+			t.Errorf("%s: expected %d, got %d", e.name, e.expectedCode, rr.Code)
+		}
+
+		after, _ := app.Mail.Store.List("", 0)
+		if len(after)-len(before) != e.expectedMessages {
+			t.Errorf("%s: expected %d messages to be queued, got %d", e.name, e.expectedMessages, len(after)-len(before))
+		}
+
+		if voided := len(gateway.voided) > 0; voided != e.expectedVoided {
+			t.Errorf("%s: expected the payment voided to be %t, got %v", e.name, e.expectedVoided, gateway.voided)
+		}
+
+		// each change is audited as made by the provider
+		var statuses []string
+		cancelled := false
+		for _, a := range dbrepo.AuditLog {
+			if a.Actor != "payment provider fake" {
+				t.Errorf("%s: expected a change made by the provider, got %+v", e.name, a)
+			}
+
+			switch {
+			case a.Entity == models.AuditEntityPayment:
+				statuses = append(statuses, a.After)
+			case a.Entity == models.AuditEntityReservation && a.Action == models.AuditCancel:
+				cancelled = true
+			default:
+				t.Errorf("%s: expected a payment change or a cancellation, got %+v", e.name, a)
+			}
+		}
+
+		if cancelled != e.expectedCancelled {
+			t.Errorf("%s: expected the reservation cancelled to be %t", e.name, e.expectedCancelled)
+		}
+
+		if len(statuses) != len(e.expectedStatuses) {
+			t.Errorf("%s: expected %d payment changes to be audited, got %v", e.name, len(e.expectedStatuses), statuses)
+			continue
+		}
+
+		for i, status := range e.expectedStatuses {
+			if !strings.Contains(statuses[i], `"status":"`+status+`"`) {
+				t.Errorf("%s: expected the payment to be %s, got %s", e.name, status, statuses[i])
+			}
+		}
+	}
+	dbrepo.AuditLog = nil
+
+	// the test repo confirms payments of 100 cents
+	if p, _ := fake.Payment(checkout.PaymentID); p.Captured != 100 {
+		t.Errorf("expected the deposit to be captured once confirmed, got %d", p.Captured)
+	}
+
+	done()
+	req, _ := http.NewRequest("POST", "/payments/webhook", bytes.NewReader(tests[0].body))
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(Repo.PaymentWebhook).ServeHTTP(rr, req)
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("expected webhooks to be rejected without payments, got %d", rr.Code)
+	}
+}
+
+func TestFakeCheckout(t *testing.T) {
+	fake, done := useFakePayments()
+	defer done()
+
+	newCheckout := func() string {
+		checkout, _ := fake.Authorize(context.Background(), payments.CheckoutRequest{
+			Reference:  "ABCDEFGHJK",
+			Amount:     1000,
+			SuccessURL: "/reservation-summary",
+			CancelURL:  "/search-availability",
+		})
+		return checkout.PaymentID
+	}
+
+	paid, declined := newCheckout(), newCheckout()
+
+	mux := chi.NewRouter()
+	mux.Use(SessionLoad)
+	mux.Get("/payments/fake-checkout", Repo.FakeCheckout)
+	mux.Post("/payments/fake-checkout", Repo.PostFakeCheckout)
+
+	var tests = []struct {
+		name             string
+		method           string
+		url              string
+		postedData       url.Values
+		expectedCode     int
+		expectedLocation string
+		expectedText     string
+	}{
+		{"checkout page", "GET", "/payments/fake-checkout?payment=" + paid, nil, http.StatusOK, "", "ABCDEFGHJK"},
+		{"unknown checkout page", "GET", "/payments/fake-checkout?payment=unknown", nil, http.StatusNotFound, "", ""},
+		{"pay", "POST", "/payments/fake-checkout", url.Values{"payment": {paid}, "action": {"pay"}}, http.StatusSeeOther, "/reservation-summary", ""},
+		{"decline", "POST", "/payments/fake-checkout", url.Values{"payment": {declined}, "action": {"decline"}}, http.StatusSeeOther, "/search-availability", ""},
+		{"paid checkout page", "GET", "/payments/fake-checkout?payment=" + paid, nil, http.StatusOK, "", "already been completed"},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest(e.method, e.url, strings.NewReader(e.postedData.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedCode {
+			t.Errorf("%s: expected %d, got %d", e.name, e.expectedCode, rr.Code)
+		}
+
+		if e.expectedLocation != "" && rr.Header().Get("Location") != e.expectedLocation {
+			t.Errorf("%s: expected location %s, got %s", e.name, e.expectedLocation, rr.Header().Get("Location"))
+		}
+
+		if e.expectedText != "" && !strings.Contains(rr.Body.String(), e.expectedText) {
+			t.Errorf("%s: expected %q in the response", e.name, e.expectedText)
+		}
+	}
+
+	if p, _ := fake.Payment(paid); !p.Authorized {
+		t.Error("expected the payment to be authorized")
+	}
+
+	if p, _ := fake.Payment(declined); !p.Declined {
+		t.Error("expected the payment to be declined")
+	}
+}
+
+func TestAdminShowReservationPayments(t *testing.T) {
+	mux := chi.NewRouter()
+	mux.Use(SessionLoad)
+	mux.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			u := models.User{ID: 1, AccessLevel: models.AccessLevelAdmin}
+			next.ServeHTTP(w, r.WithContext(helpers.ContextWithUser(r.Context(), u)))
+		})
+	})
+	mux.Get("/admin/reservations/{src}/{id}", Repo.AdminShowReservation)
+
+	var tests = []struct {
+		name     string
+		url      string
+		expected bool
+	}{
+		{"paid", "/admin/reservations/all/1", true},
+		{"not paid", "/admin/reservations/all/3", false},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", e.url, nil)
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusOK {
+			t.Errorf("%s: expected %d, got %d", e.name, http.StatusOK, rr.Code)
+		}
+
+		if got := strings.Contains(rr.Body.String(), "fake fake_1"); got != e.expected {
+			t.Errorf("%s: expected the payment shown %v, got %v", e.name, e.expected, got)
+		}
+	}
+}
@@ -0,0 +1,292 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dhanekom/bookings/internal/forms"
+	"github.com/dhanekom/bookings/internal/helpers"
+	"github.com/dhanekom/bookings/internal/models"
+	"github.com/dhanekom/bookings/internal/pricing"
+	"github.com/dhanekom/bookings/internal/render"
+	"github.com/go-chi/chi/v5"
+)
+
+// feeKinds are the kinds of fee that can be added, with their names
+var feeKinds = map[string]string{
+	models.FeePercent:  "Percentage",
+	models.FeePerNight: "Per night",
+	models.FeePerStay:  "Per stay",
+}
+
+// quote prices a stay from start to end in each of rooms, in the same order. The rooms need their prices, so
+// they should be loaded with GetRoomByID or one of the other room queries
+func (m *Repository) quote(start, end time.Time, rooms ...models.Room) ([]models.Quote, error) {
+	discounts, err := m.DB.AllStayDiscounts()
+	if err != nil {
+		return nil, err
+	}
+
+	fees, err := m.DB.AllFees()
+	if err != nil {
+		return nil, err
+	}
+
+	quotes := make([]models.Quote, 0, len(rooms))
+	for _, room := range rooms {
+		seasons, err := m.DB.SeasonalRatesForRoom(room.ID, start, end)
+		if err != nil {
+			return nil, err
+		}
+
+		rules := pricing.Rules{
+			Room:      room,
+			Seasons:   seasons,
+			Discounts: discounts,
+			Fees:      fees,
+		}
+
+		q, err := rules.Quote(start, end)
+		if err != nil {
+			return nil, err
+		}
+
+		quotes = append(quotes, q)
+	}
+
+	return quotes, nil
+}
+
+// quoteRoom prices a stay from start to end in the room with roomID, returning the room as well
+func (m *Repository) quoteRoom(roomID int, start, end time.Time) (models.Room, models.Quote, error) {
+	room, err := m.DB.GetRoomByID(roomID)
+	if err != nil {
+		return room, models.Quote{}, err
+	}
+
+	quotes, err := m.quote(start, end, room)
+	if err != nil {
+		return room, models.Quote{}, err
+	}
+
+	return room, quotes[0], nil
+}
+
+// renderAdminPricing renders the pricing page with form, which holds the values and errors of the last post
+func (m *Repository) renderAdminPricing(w http.ResponseWriter, r *http.Request, form *forms.Form) {
+	rooms, err := m.DB.AllRooms()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	seasons, err := m.DB.AllSeasonalRates()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	discounts, err := m.DB.AllStayDiscounts()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	fees, err := m.DB.AllFees()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["rooms"] = rooms
+	data["seasons"] = seasons
+	data["discounts"] = discounts
+	data["fees"] = fees
+	data["fee_kinds"] = feeKinds
+
+	render.Template(w, r, "admin-pricing.page.tmpl", &models.TemplateData{
+		Data: data,
+		Form: form,
+	})
+}
+
+// AdminPricing shows the seasonal rates, length of stay discounts and fees
+func (m *Repository) AdminPricing(w http.ResponseWriter, r *http.Request) {
+	m.renderAdminPricing(w, r, forms.New(nil))
+}
+
+// AdminPostSeasonalRate adds a seasonal rate for a room
+func (m *Repository) AdminPostSeasonalRate(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("season_room_id", "season_name", "season_start", "season_end", "season_price")
+
+	roomID, err := strconv.Atoi(form.Get("season_room_id"))
+	if err == nil {
+		_, err = m.DB.GetRoomByID(roomID)
+	}
+	if err != nil && form.Errors.Get("season_room_id") == "" {
+		form.Errors.Add("season_room_id", "Choose a room")
+	}
+
+	const layout = "2006-01-02"
+	startDate, err := time.Parse(layout, form.Get("season_start"))
+	if err != nil && form.Errors.Get("season_start") == "" {
+		form.Errors.Add("season_start", "Invalid date")
+	}
+
+	endDate, err := time.Parse(layout, form.Get("season_end"))
+	if err != nil && form.Errors.Get("season_end") == "" {
+		form.Errors.Add("season_end", "Invalid date")
+	}
+
+	if form.Errors.Get("season_start") == "" && form.Errors.Get("season_end") == "" && endDate.Before(startDate) {
+		form.Errors.Add("season_end", "The last night can't be before the first")
+	}
+
+	price, err := parseDecimal(strings.TrimSpace(form.Get("season_price")))
+	if err != nil && form.Errors.Get("season_price") == "" {
+		form.Errors.Add("season_price", "Enter a price such as 120 or 120.50")
+	}
+
+	if !form.Valid() {
+		m.renderAdminPricing(w, r, form)
+		return
+	}
+
+	_, err = m.DB.InsertSeasonalRate(models.SeasonalRate{
+		RoomID:    roomID,
+		Name:      strings.TrimSpace(form.Get("season_name")),
+		StartDate: startDate,
+		EndDate:   endDate,
+		Price:     price,
+	})
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.AddFlash(r, "Seasonal rate added")
+	http.Redirect(w, r, "/admin/pricing", http.StatusSeeOther)
+}
+
+// AdminPostStayDiscount adds a length of stay discount
+func (m *Repository) AdminPostStayDiscount(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("discount_min_nights", "discount_percent")
+
+	minNights, err := strconv.Atoi(form.Get("discount_min_nights"))
+	if (err != nil || minNights < 1) && form.Errors.Get("discount_min_nights") == "" {
+		form.Errors.Add("discount_min_nights", "Enter a number of nights of at least 1")
+	}
+
+	percent, err := parseDecimal(strings.TrimSpace(form.Get("discount_percent")))
+	if (err != nil || percent == 0 || percent > 10000) && form.Errors.Get("discount_percent") == "" {
+		form.Errors.Add("discount_percent", "Enter a percentage between 0 and 100, such as 10 or 12.5")
+	}
+
+	if !form.Valid() {
+		m.renderAdminPricing(w, r, form)
+		return
+	}
+
+	_, err = m.DB.InsertStayDiscount(models.StayDiscount{
+		MinNights: minNights,
+		Percent:   percent,
+	})
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.AddFlash(r, "Discount added")
+	http.Redirect(w, r, "/admin/pricing", http.StatusSeeOther)
+}
+
+// AdminPostFee adds a tax or fee
+func (m *Repository) AdminPostFee(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("fee_name", "fee_kind", "fee_amount")
+
+	kind := form.Get("fee_kind")
+	if _, ok := feeKinds[kind]; !ok && form.Errors.Get("fee_kind") == "" {
+		form.Errors.Add("fee_kind", "Choose a kind of fee")
+	}
+
+	// percentages are stored in basis points and amounts in cents, so both are parsed as hundredths
+	amount, err := parseDecimal(strings.TrimSpace(form.Get("fee_amount")))
+	if err != nil && form.Errors.Get("fee_amount") == "" {
+		form.Errors.Add("fee_amount", "Enter an amount such as 15 or 12.50")
+	}
+
+	if !form.Valid() {
+		m.renderAdminPricing(w, r, form)
+		return
+	}
+
+	_, err = m.DB.InsertFee(models.Fee{
+		Name:   strings.TrimSpace(form.Get("fee_name")),
+		Kind:   kind,
+		Amount: amount,
+	})
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.AddFlash(r, "Fee added")
+	http.Redirect(w, r, "/admin/pricing", http.StatusSeeOther)
+}
+
+// deleteRule deletes the rule with the id in the URL using del, and returns to the page at url
+func (m *Repository) deleteRule(w http.ResponseWriter, r *http.Request, del func(id int) error, msg, url string) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	err = del(id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.AddFlash(r, msg)
+	http.Redirect(w, r, url, http.StatusSeeOther)
+}
+
+// AdminDeleteSeasonalRate deletes a seasonal rate
+func (m *Repository) AdminDeleteSeasonalRate(w http.ResponseWriter, r *http.Request) {
+	m.deleteRule(w, r, m.DB.DeleteSeasonalRate, "Seasonal rate deleted", "/admin/pricing")
+}
+
+// AdminDeleteStayDiscount deletes a length of stay discount
+func (m *Repository) AdminDeleteStayDiscount(w http.ResponseWriter, r *http.Request) {
+	m.deleteRule(w, r, m.DB.DeleteStayDiscount, "Discount deleted", "/admin/pricing")
+}
+
+// AdminDeleteFee deletes a tax or fee
+func (m *Repository) AdminDeleteFee(w http.ResponseWriter, r *http.Request) {
+	m.deleteRule(w, r, m.DB.DeleteFee, "Fee deleted", "/admin/pricing")
+}
@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func TestAdminPricing(t *testing.T) {
+	mux := chi.NewRouter()
+	mux.Use(SessionLoad)
+	mux.Get("/admin/pricing", Repo.AdminPricing)
+	mux.Post("/admin/pricing/seasons", Repo.AdminPostSeasonalRate)
+	mux.Post("/admin/pricing/seasons/{id}/delete", Repo.AdminDeleteSeasonalRate)
+	mux.Post("/admin/pricing/discounts", Repo.AdminPostStayDiscount)
+	mux.Post("/admin/pricing/discounts/{id}/delete", Repo.AdminDeleteStayDiscount)
+	mux.Post("/admin/pricing/fees", Repo.AdminPostFee)
+	mux.Post("/admin/pricing/fees/{id}/delete", Repo.AdminDeleteFee)
+
+	season := url.Values{
+		"season_room_id": {"1"},
+		"season_name":    {"Winter"},
+		"season_start":   {"2050-06-01"},
+		"season_end":     {"2050-08-31"},
+		"season_price":   {"95.50"},
+	}
+
+	with := func(key, value string) url.Values {
+		v := url.Values{}
+		for k, values := range season {
+			v[k] = values
+		}
+		v.Set(key, value)
+		return v
+	}
+
+	var tests = []struct {
+		name               string
+		url                string
+		postedData         url.Values
+		expectedStatusCode int
+		expectedLocation   string
+		expectedText       string
+	}{
+		{"season", "/admin/pricing/seasons", season, http.StatusSeeOther, "/admin/pricing", ""},
+		{"season missing fields", "/admin/pricing/seasons", url.Values{}, http.StatusOK, "", "This field cannot be blank"},
+		{"season unknown room", "/admin/pricing/seasons", with("season_room_id", "0"), http.StatusOK, "", "Choose a room"},
+		{"season invalid date", "/admin/pricing/seasons", with("season_start", "June"), http.StatusOK, "", "Invalid date"},
+		{"season ends before it starts", "/admin/pricing/seasons", with("season_end", "2050-05-31"), http.StatusOK, "", "can&#39;t be before the first"},
+		{"season invalid price", "/admin/pricing/seasons", with("season_price", "-1"), http.StatusOK, "", "such as 120 or 120.50"},
+		{"discount", "/admin/pricing/discounts", url.Values{"discount_min_nights": {"14"}, "discount_percent": {"15"}}, http.StatusSeeOther, "/admin/pricing", ""},
+		{"discount no nights", "/admin/pricing/discounts", url.Values{"discount_min_nights": {"0"}, "discount_percent": {"15"}}, http.StatusOK, "", "at least 1"},
+		{"discount over 100%", "/admin/pricing/discounts", url.Values{"discount_min_nights": {"14"}, "discount_percent": {"100.01"}}, http.StatusOK, "", "between 0 and 100"},
+		{"fee", "/admin/pricing/fees", url.Values{"fee_name": {"Tourism levy"}, "fee_kind": {"night"}, "fee_amount": {"1.50"}}, http.StatusSeeOther, "/admin/pricing", ""},
+		{"fee unknown kind", "/admin/pricing/fees", url.Values{"fee_name": {"Tourism levy"}, "fee_kind": {"guest"}, "fee_amount": {"1.50"}}, http.StatusOK, "", "Choose a kind of fee"},
+		{"fee invalid amount", "/admin/pricing/fees", url.Values{"fee_name": {"Tourism levy"}, "fee_kind": {"night"}, "fee_amount": {"lots"}}, http.StatusOK, "", "such as 15 or 12.50"},
+		{"delete season", "/admin/pricing/seasons/1/delete", nil, http.StatusSeeOther, "/admin/pricing", ""},
+		{"delete discount", "/admin/pricing/discounts/1/delete", nil, http.StatusSeeOther, "/admin/pricing", ""},
+		{"delete fee", "/admin/pricing/fees/1/delete", nil, http.StatusSeeOther, "/admin/pricing", ""},
+		{"delete invalid id", "/admin/pricing/fees/x/delete", nil, http.StatusBadRequest, "", ""},
+	}
+
+	req, _ := http.NewRequest("GET", "/admin/pricing", nil)
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+
+	for _, text := range []string{"Summer", "General&#39;s Quarters", "10%", "VAT", "15%", "$25.00"} {
+		if !strings.Contains(rr.Body.String(), text) {
+			t.Errorf("list: expected %q in the response", text)
+		}
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", e.url, strings.NewReader(e.postedData.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected %d, got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+
+		if e.expectedLocation != "" && rr.Header().Get("Location") != e.expectedLocation {
+			t.Errorf("%s: expected location %s, got %s", e.name, e.expectedLocation, rr.Header().Get("Location"))
+		}
+
+		if e.expectedText != "" && !strings.Contains(rr.Body.String(), e.expectedText) {
+			t.Errorf("%s: expected %q in the response", e.name, e.expectedText)
+		}
+	}
+}
@@ -0,0 +1,241 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/dhanekom/bookings/internal/audit"
+	"github.com/dhanekom/bookings/internal/calsync"
+	"github.com/dhanekom/bookings/internal/forms"
+	"github.com/dhanekom/bookings/internal/helpers"
+	"github.com/dhanekom/bookings/internal/ical"
+	"github.com/dhanekom/bookings/internal/models"
+	"github.com/dhanekom/bookings/internal/render"
+	"github.com/go-chi/chi/v5"
+)
+
+// RoomCalendarFeed serves the restrictions of the room with the token in the URL as an iCalendar feed, for
+// booking channels to import. Events only say that the room isn't available, not who booked it
+func (m *Repository) RoomCalendarFeed(w http.ResponseWriter, r *http.Request) {
+	room, err := m.DB.GetRoomByCalendarToken(chi.URLParam(r, "token"))
+	if errors.Is(err, sql.ErrNoRows) {
+		http.NotFound(w, r)
+		return
+	} else if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	today := time.Now().Truncate(24 * time.Hour)
+	restrictions, err := m.DB.GetRestrictionsForRoomByDate(room.ID, today.AddDate(0, 0, -30), today.AddDate(2, 0, 0))
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	cal := ical.Calendar{
+		ProdID: calendarProdID,
+		Method: ical.MethodPublish,
+		Name:   fmt.Sprintf("%s - %s", m.App.PropertyName, room.RoomName),
+	}
+
+	for _, rr := range restrictions {
+		summary := rr.Restriction.RestrictionName
+		if summary == "" {
+			summary = "Not available"
+		}
+
+		cal.Events = append(cal.Events, ical.Event{
+			UID:     fmt.Sprintf("restriction-%d@%s", rr.ID, m.calendarHost()),
+			Stamp:   rr.UpdatedAt,
+			Start:   rr.StartDate,
+			End:     rr.EndDate,
+			Summary: summary,
+			Status:  ical.StatusConfirmed,
+		})
+	}
+
+	w.Header().Set("Content-Type", ical.ContentTypeFor(""))
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Write(cal.Bytes())
+}
+
+// renderAdminCalendars renders the calendars page with form
+func (m *Repository) renderAdminCalendars(w http.ResponseWriter, r *http.Request, form *forms.Form) {
+	rooms, err := m.DB.AllRooms()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	calendars, err := m.DB.AllRoomCalendars()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["rooms"] = rooms
+	data["calendars"] = calendars
+
+	stringMap := make(map[string]string)
+	stringMap["base_url"] = m.App.BaseURL
+
+	render.Template(w, r, "admin-calendars.page.tmpl", &models.TemplateData{
+		Data:      data,
+		StringMap: stringMap,
+		Form:      form,
+	})
+}
+
+// AdminCalendars lists the calendar feed of each room and the external calendars imported into rooms
+func (m *Repository) AdminCalendars(w http.ResponseWriter, r *http.Request) {
+	m.renderAdminCalendars(w, r, forms.New(nil))
+}
+
+// AdminPostRoomCalendarToken publishes the calendar feed of a room under a new URL. Any previous URL stops working
+func (m *Repository) AdminPostRoomCalendarToken(w http.ResponseWriter, r *http.Request) {
+	roomID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	room, err := m.DB.GetRoomByID(roomID)
+	if err != nil {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+
+	token, err := helpers.RandomToken(32)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	err = m.DB.SetRoomCalendarToken(roomID, token)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	if room.CalendarToken == "" {
+		m.AddFlash(r, "Calendar feed created")
+	} else {
+		m.AddFlash(r, "Calendar feed URL changed. The old URL no longer works")
+	}
+
+	http.Redirect(w, r, "/admin/calendars", http.StatusSeeOther)
+}
+
+// AdminPostRoomCalendar adds an external calendar to a room and imports its events
+func (m *Repository) AdminPostRoomCalendar(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("room_id", "name", "url")
+
+	roomID, err := strconv.Atoi(r.Form.Get("room_id"))
+	if err == nil {
+		_, err = m.DB.GetRoomByID(roomID)
+	}
+	if err != nil && form.Errors.Get("room_id") == "" {
+		form.Errors.Add("room_id", "Choose a room")
+	}
+
+	if form.Errors.Get("url") == "" {
+		if err := calsync.ValidateURL(r.Form.Get("url")); err != nil {
+			form.Errors.Add("url", "The URL "+err.Error())
+		}
+	}
+
+	if !form.Valid() {
+		m.renderAdminCalendars(w, r, form)
+		return
+	}
+
+	c := models.RoomCalendar{
+		RoomID: roomID,
+		Name:   r.Form.Get("name"),
+		URL:    r.Form.Get("url"),
+	}
+
+	c.ID, err = m.DB.InsertRoomCalendar(c)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	m.audit(r, models.AuditCreate, models.AuditEntityCalendar, c.ID, nil, audit.Calendar(c))
+
+	m.syncRoomCalendar(r, c, "Calendar added")
+	http.Redirect(w, r, "/admin/calendars", http.StatusSeeOther)
+}
+
+// AdminSyncRoomCalendar imports the events of an external calendar straight away
+func (m *Repository) AdminSyncRoomCalendar(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	c, err := m.DB.GetRoomCalendarByID(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	} else if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.syncRoomCalendar(r, c, "Calendar synced")
+	http.Redirect(w, r, "/admin/calendars", http.StatusSeeOther)
+}
+
+// syncRoomCalendar imports the events of c and reports the outcome, prefixed by done, to the user
+func (m *Repository) syncRoomCalendar(r *http.Request, c models.RoomCalendar, done string) {
+	err := m.App.CalendarSync.Sync(r.Context(), c)
+	if err != nil {
+		m.AddWarning(r, fmt.Sprintf("%s, but its events could not be imported: %s", done, err))
+		return
+	}
+
+	m.AddFlash(r, done)
+}
+
+// AdminDeleteRoomCalendar removes an external calendar and the external bookings imported from it
+func (m *Repository) AdminDeleteRoomCalendar(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	c, err := m.DB.GetRoomCalendarByID(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	} else if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	err = m.DB.DeleteRoomCalendar(id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	m.audit(r, models.AuditDelete, models.AuditEntityCalendar, id, audit.Calendar(c), nil)
+
+	m.matchWaitlist()
+	m.AddFlash(r, "Calendar removed along with its external bookings")
+	http.Redirect(w, r, "/admin/calendars", http.StatusSeeOther)
+}
@@ -0,0 +1,146 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/dhanekom/bookings/internal/ical"
+	"github.com/go-chi/chi/v5"
+)
+
+func TestRoomCalendarFeed(t *testing.T) {
+	mux := chi.NewRouter()
+	mux.Get("/calendars/{token}.ics", Repo.RoomCalendarFeed)
+
+	req, _ := http.NewRequest("GET", "/calendars/test-calendar-token.ics", nil)
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected %d, got %d", http.StatusOK, rr.Code)
+	}
+
+	if ct := rr.Header().Get("Content-Type"); !strings.HasPrefix(ct, ical.ContentType) {
+		t.Errorf("expected an iCalendar content type, got %s", ct)
+	}
+
+	events, err := ical.Parse(rr.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(events) != 3 {
+		t.Fatalf("expected an event for each restriction of the room, got %d", len(events))
+	}
+
+	if events[0].UID != "restriction-1@localhost" || events[0].Summary != "Not available" {
+		t.Errorf("unexpected event %+v", events[0])
+	}
+
+	req, _ = http.NewRequest("GET", "/calendars/unknown-token.ics", nil)
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("unknown token: expected %d, got %d", http.StatusNotFound, rr.Code)
+	}
+}
+
+// remoteTransport sends every request to the server at its URL, whatever the host of the request
+type remoteTransport struct {
+	url *url.URL
+}
+
+func (t remoteTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	r = r.Clone(r.Context())
+	r.URL.Scheme = t.url.Scheme
+	r.URL.Host = t.url.Host
+
+	return http.DefaultTransport.RoundTrip(r)
+}
+
+func TestAdminCalendars(t *testing.T) {
+	// the remote calendar of a booking channel
+	remote := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/room-1.ics" {
+			http.NotFound(w, r)
+			return
+		}
+
+		w.Header().Set("Content-Type", ical.ContentTypeFor(""))
+		w.Write([]byte("BEGIN:VCALENDAR\r\nVERSION:2.0\r\nBEGIN:VEVENT\r\nUID:1@channel\r\n" +
+			"DTSTART;VALUE=DATE:20500101\r\nDTEND;VALUE=DATE:20500103\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n"))
+	}))
+	defer remote.Close()
+
+	// calendars of the test repository are on channel.example.com, so send their requests to the remote server
+	remoteURL, _ := url.Parse(remote.URL)
+	saved := app.CalendarSync.Client
+	defer func() { app.CalendarSync.Client = saved }()
+	app.CalendarSync.Client = &http.Client{Transport: remoteTransport{url: remoteURL}}
+
+	mux := chi.NewRouter()
+	mux.Use(SessionLoad)
+	mux.Get("/admin/calendars", Repo.AdminCalendars)
+	mux.Post("/admin/calendars", Repo.AdminPostRoomCalendar)
+	mux.Post("/admin/calendars/rooms/{id}/token", Repo.AdminPostRoomCalendarToken)
+	mux.Post("/admin/calendars/{id}/sync", Repo.AdminSyncRoomCalendar)
+	mux.Post("/admin/calendars/{id}/delete", Repo.AdminDeleteRoomCalendar)
+
+	var tests = []struct {
+		name               string
+		method             string
+		url                string
+		postedData         url.Values
+		expectedStatusCode int
+		expectedLocation   string
+		expectedText       string
+	}{
+		{"list", "GET", "/admin/calendars", nil, http.StatusOK, "", "https://channel.example.com/room-1.ics"},
+		{"add", "POST", "/admin/calendars", url.Values{
+			"room_id": {"1"},
+			"name":    {"Channel"},
+			"url":     {remote.URL + "/room-1.ics"},
+		}, http.StatusSeeOther, "/admin/calendars", ""},
+		{"add missing fields", "POST", "/admin/calendars", url.Values{}, http.StatusOK, "", "This field cannot be blank"},
+		{"add invalid url", "POST", "/admin/calendars", url.Values{
+			"room_id": {"1"},
+			"name":    {"Channel"},
+			"url":     {"file:///etc/passwd"},
+		}, http.StatusOK, "", "must be an http or https URL"},
+		{"add unknown room", "POST", "/admin/calendars", url.Values{
+			"room_id": {"0"},
+			"name":    {"Channel"},
+			"url":     {remote.URL + "/room-1.ics"},
+		}, http.StatusOK, "", "Choose a room"},
+		{"new feed url", "POST", "/admin/calendars/rooms/1/token", nil, http.StatusSeeOther, "/admin/calendars", ""},
+		{"new feed url unknown room", "POST", "/admin/calendars/rooms/0/token", nil, http.StatusNotFound, "", ""},
+		{"sync", "POST", "/admin/calendars/1/sync", nil, http.StatusSeeOther, "/admin/calendars", ""},
+		{"sync unknown calendar", "POST", "/admin/calendars/2/sync", nil, http.StatusNotFound, "", ""},
+		{"delete", "POST", "/admin/calendars/1/delete", nil, http.StatusSeeOther, "/admin/calendars", ""},
+		{"delete invalid id", "POST", "/admin/calendars/x/delete", nil, http.StatusBadRequest, "", ""},
+		{"delete unknown calendar", "POST", "/admin/calendars/2/delete", nil, http.StatusNotFound, "", ""},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest(e.method, e.url, strings.NewReader(e.postedData.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected %d, got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+
+		if e.expectedLocation != "" && rr.Header().Get("Location") != e.expectedLocation {
+			t.Errorf("%s: expected location %s, got %s", e.name, e.expectedLocation, rr.Header().Get("Location"))
+		}
+
+		if e.expectedText != "" && !strings.Contains(rr.Body.String(), e.expectedText) {
+			t.Errorf("%s: expected %q in the response", e.name, e.expectedText)
+		}
+	}
+}
@@ -0,0 +1,460 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/dhanekom/bookings/internal/forms"
+	"github.com/dhanekom/bookings/internal/helpers"
+	"github.com/dhanekom/bookings/internal/media"
+	"github.com/dhanekom/bookings/internal/models"
+	"github.com/dhanekom/bookings/internal/render"
+	"github.com/dhanekom/bookings/internal/repository"
+	"github.com/go-chi/chi/v5"
+)
+
+// maxPhotoSize is the largest room photo in bytes that can be uploaded
+const maxPhotoSize = 10 << 20
+
+// roomPhotoFolder is the folder of the media library room photos are stored in
+const roomPhotoFolder = "rooms"
+
+// decimalPattern matches a number with up to two decimals, such as 120 or 120.50
+var decimalPattern = regexp.MustCompile(`^[0-9]+(\.[0-9]{1,2})?$`)
+
+// parseDecimal returns a number such as 120.50 in hundredths, which is cents for prices and basis points
+// for percentages
+func parseDecimal(s string) (int, error) {
+	if !decimalPattern.MatchString(s) {
+		return 0, errors.New("invalid number")
+	}
+
+	parts := strings.SplitN(s+".", ".", 3)
+	whole, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, err
+	}
+
+	fraction, _ := strconv.Atoi((parts[1] + "00")[:2])
+	return whole*100 + fraction, nil
+}
+
+// formatDecimal formats hundredths as parsed by parseDecimal
+func formatDecimal(n int) string {
+	return fmt.Sprintf("%d.%02d", n/100, n%100)
+}
+
+// splitLines returns the non-blank lines of s, trimmed
+func splitLines(s string) []string {
+	var lines []string
+	for _, line := range strings.Split(s, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+
+	return lines
+}
+
+// validateRoomForm checks the room detail fields and copies them to room
+func validateRoomForm(form *forms.Form, room *models.Room) {
+	form.Required("room_name", "slug", "capacity", "max_adults", "max_children", "base_price")
+	if form.Get("slug") != "" {
+		form.IsSlug("slug")
+	}
+
+	capacity, err := strconv.Atoi(form.Get("capacity"))
+	if form.Get("capacity") != "" && (err != nil || capacity < 1) {
+		form.Errors.Add("capacity", "Enter a number of guests of at least 1")
+	}
+
+	maxAdults, err := strconv.Atoi(form.Get("max_adults"))
+	if form.Get("max_adults") != "" && (err != nil || maxAdults < 1) {
+		form.Errors.Add("max_adults", "Enter a number of adults of at least 1")
+	} else if form.Errors.Get("capacity") == "" && maxAdults > capacity {
+		form.Errors.Add("max_adults", "The room can't sleep more adults than guests")
+	}
+
+	maxChildren, err := strconv.Atoi(form.Get("max_children"))
+	if form.Get("max_children") != "" && (err != nil || maxChildren < 0) {
+		form.Errors.Add("max_children", "Enter a number of children of 0 or more")
+	} else if form.Errors.Get("capacity") == "" && maxChildren > capacity {
+		form.Errors.Add("max_children", "The room can't sleep more children than guests")
+	}
+
+	price, err := parseDecimal(strings.TrimSpace(form.Get("base_price")))
+	if form.Get("base_price") != "" && err != nil {
+		form.Errors.Add("base_price", "Enter a price such as 120 or 120.50")
+	}
+
+	// the weekend uplift is optional
+	uplift := 0
+	if form.Get("weekend_uplift") != "" {
+		uplift, err = parseDecimal(strings.TrimSpace(form.Get("weekend_uplift")))
+		if err != nil {
+			form.Errors.Add("weekend_uplift", "Enter a percentage such as 10 or 12.5")
+		}
+	}
+
+	room.RoomName = strings.TrimSpace(form.Get("room_name"))
+	room.Slug = form.Get("slug")
+	room.Description = strings.TrimSpace(form.Get("description"))
+	room.Capacity = capacity
+	room.MaxAdults = maxAdults
+	room.MaxChildren = maxChildren
+	room.Amenities = splitLines(form.Get("amenities"))
+	room.BasePrice = price
+	room.WeekendUplift = uplift
+}
+
+// roomForm returns a form filled in with the details of room
+func roomForm(room models.Room) *forms.Form {
+	form := forms.New(url.Values{})
+	form.Set("room_name", room.RoomName)
+	form.Set("slug", room.Slug)
+	form.Set("description", room.Description)
+	form.Set("capacity", strconv.Itoa(room.Capacity))
+	form.Set("max_adults", strconv.Itoa(room.MaxAdults))
+	form.Set("max_children", strconv.Itoa(room.MaxChildren))
+	form.Set("amenities", strings.Join(room.Amenities, "\n"))
+	form.Set("base_price", formatDecimal(room.BasePrice))
+	form.Set("weekend_uplift", formatDecimal(room.WeekendUplift))
+
+	return form
+}
+
+// renderRoomForm renders the room edit page
+func (m *Repository) renderRoomForm(w http.ResponseWriter, r *http.Request, room models.Room, form *forms.Form) {
+	data := make(map[string]interface{})
+	data["room"] = room
+
+	render.Template(w, r, "admin-room.page.tmpl", &models.TemplateData{
+		Data: data,
+		Form: form,
+	})
+}
+
+// roomFromURL loads the room named by the id URL parameter, writing an error response if it can't
+func (m *Repository) roomFromURL(w http.ResponseWriter, r *http.Request) (models.Room, bool) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusNotFound)
+		return models.Room{}, false
+	}
+
+	room, err := m.DB.GetRoomByID(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.ClientError(w, http.StatusNotFound)
+		return room, false
+	} else if err != nil {
+		helpers.ServerError(w, err)
+		return room, false
+	}
+
+	return room, true
+}
+
+// Rooms lists the rooms
+func (m *Repository) Rooms(w http.ResponseWriter, r *http.Request) {
+	rooms, err := m.DB.AllRooms()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["rooms"] = rooms
+
+	render.Template(w, r, "rooms.page.tmpl", &models.TemplateData{
+		Data: data,
+	})
+}
+
+// Room renders the page of the room with the slug in the URL
+func (m *Repository) Room(w http.ResponseWriter, r *http.Request) {
+	room, err := m.DB.GetRoomBySlug(chi.URLParam(r, "slug"))
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	} else if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["room"] = room
+
+	render.Template(w, r, "room.page.tmpl", &models.TemplateData{
+		Data: data,
+	})
+}
+
+// AdminRooms lists the rooms for editing
+func (m *Repository) AdminRooms(w http.ResponseWriter, r *http.Request) {
+	rooms, err := m.DB.AllRooms()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["rooms"] = rooms
+
+	render.Template(w, r, "admin-rooms.page.tmpl", &models.TemplateData{
+		Data: data,
+	})
+}
+
+// AdminNewRoom shows the form for a new room
+func (m *Repository) AdminNewRoom(w http.ResponseWriter, r *http.Request) {
+	m.renderRoomForm(w, r, models.Room{}, roomForm(models.Room{Capacity: 2, MaxAdults: 2}))
+}
+
+// AdminPostNewRoom creates a new room and shows it, so that photos can be added
+func (m *Repository) AdminPostNewRoom(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	var room models.Room
+	form := forms.New(r.PostForm)
+	validateRoomForm(form, &room)
+
+	if !form.Valid() {
+		m.renderRoomForm(w, r, models.Room{}, form)
+		return
+	}
+
+	id, err := m.DB.InsertRoom(room)
+	if errors.Is(err, repository.ErrDuplicateSlug) {
+		form.Errors.Add("slug", "This slug is already used by another room")
+		m.renderRoomForm(w, r, models.Room{}, form)
+		return
+	} else if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.AddFlash(r, "Room created")
+	http.Redirect(w, r, fmt.Sprintf("/admin/rooms/%d", id), http.StatusSeeOther)
+}
+
+// AdminShowRoom shows the form to edit a room and its photos
+func (m *Repository) AdminShowRoom(w http.ResponseWriter, r *http.Request) {
+	room, ok := m.roomFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	m.renderRoomForm(w, r, room, roomForm(room))
+}
+
+// AdminPostShowRoom updates the details of a room
+func (m *Repository) AdminPostShowRoom(w http.ResponseWriter, r *http.Request) {
+	room, ok := m.roomFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	updated := room
+	form := forms.New(r.PostForm)
+	validateRoomForm(form, &updated)
+
+	if !form.Valid() {
+		m.renderRoomForm(w, r, room, form)
+		return
+	}
+
+	err = m.DB.UpdateRoom(updated)
+	if errors.Is(err, repository.ErrDuplicateSlug) {
+		form.Errors.Add("slug", "This slug is already used by another room")
+		m.renderRoomForm(w, r, room, form)
+		return
+	} else if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.AddFlash(r, "Room saved")
+	http.Redirect(w, r, fmt.Sprintf("/admin/rooms/%d", room.ID), http.StatusSeeOther)
+}
+
+// AdminDeleteRoom deletes a room without reservations and its photos
+func (m *Repository) AdminDeleteRoom(w http.ResponseWriter, r *http.Request) {
+	room, ok := m.roomFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	err := m.DB.DeleteRoom(room.ID)
+	if errors.Is(err, repository.ErrRoomInUse) {
+		m.AddError(r, "The room has reservations and can't be deleted")
+		http.Redirect(w, r, fmt.Sprintf("/admin/rooms/%d", room.ID), http.StatusSeeOther)
+		return
+	} else if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	for _, p := range room.Photos {
+		if err := m.App.Media.Remove(p.Filename); err != nil {
+			m.App.ErrorLog.Println(err)
+		}
+	}
+
+	m.AddFlash(r, "Room deleted")
+	http.Redirect(w, r, "/admin/rooms", http.StatusSeeOther)
+}
+
+// AdminPostRoomPhoto uploads a photo of a room, which is shown after its other photos
+func (m *Repository) AdminPostRoomPhoto(w http.ResponseWriter, r *http.Request) {
+	room, ok := m.roomFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	roomURL := fmt.Sprintf("/admin/rooms/%d", room.ID)
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxPhotoSize+1<<20)
+	err := r.ParseMultipartForm(1 << 20)
+	if err != nil {
+		m.AddError(r, fmt.Sprintf("Photos can be at most %d MB", maxPhotoSize>>20))
+		http.Redirect(w, r, roomURL, http.StatusSeeOther)
+		return
+	}
+
+	file, _, err := r.FormFile("photo")
+	if err != nil {
+		m.AddError(r, "Choose a photo to upload")
+		http.Redirect(w, r, roomURL, http.StatusSeeOther)
+		return
+	}
+	defer file.Close()
+
+	data, err := ioutil.ReadAll(io.LimitReader(file, maxPhotoSize+1))
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	if len(data) > maxPhotoSize {
+		m.AddError(r, fmt.Sprintf("Photos can be at most %d MB", maxPhotoSize>>20))
+		http.Redirect(w, r, roomURL, http.StatusSeeOther)
+		return
+	}
+
+	name, err := m.App.Media.SaveImage(roomPhotoFolder, data)
+	if errors.Is(err, media.ErrUnsupportedType) {
+		m.AddError(r, "Only JPEG, PNG, GIF and WebP images can be uploaded")
+		http.Redirect(w, r, roomURL, http.StatusSeeOther)
+		return
+	} else if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	_, err = m.DB.InsertRoomPhoto(models.RoomPhoto{
+		RoomID:   room.ID,
+		Filename: name,
+		Caption:  strings.TrimSpace(r.FormValue("caption")),
+	})
+	if err != nil {
+		m.App.Media.Remove(name)
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.AddFlash(r, "Photo added")
+	http.Redirect(w, r, roomURL, http.StatusSeeOther)
+}
+
+// AdminDeleteRoomPhoto deletes a photo of a room
+func (m *Repository) AdminDeleteRoomPhoto(w http.ResponseWriter, r *http.Request) {
+	room, ok := m.roomFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	photoID, err := strconv.Atoi(chi.URLParam(r, "photoID"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+
+	p, err := m.DB.DeleteRoomPhoto(room.ID, photoID)
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	} else if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	if err := m.App.Media.Remove(p.Filename); err != nil {
+		m.App.ErrorLog.Println(err)
+	}
+
+	m.AddFlash(r, "Photo deleted")
+	http.Redirect(w, r, fmt.Sprintf("/admin/rooms/%d", room.ID), http.StatusSeeOther)
+}
+
+// AdminMoveRoomPhoto moves a photo of a room one place up or down, as posted in direction
+func (m *Repository) AdminMoveRoomPhoto(w http.ResponseWriter, r *http.Request) {
+	room, ok := m.roomFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	photoID, err := strconv.Atoi(chi.URLParam(r, "photoID"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+
+	ids := make([]int, len(room.Photos))
+	index := -1
+	for i, p := range room.Photos {
+		ids[i] = p.ID
+		if p.ID == photoID {
+			index = i
+		}
+	}
+
+	if index < 0 {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+
+	other := index + 1
+	if r.FormValue("direction") == "up" {
+		other = index - 1
+	}
+
+	if other >= 0 && other < len(ids) {
+		ids[index], ids[other] = ids[other], ids[index]
+
+		err = m.DB.SetRoomPhotoOrder(room.ID, ids)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("/admin/rooms/%d", room.ID), http.StatusSeeOther)
+}
@@ -0,0 +1,178 @@
+package handlers
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func TestRoom(t *testing.T) {
+	mux := chi.NewRouter()
+	mux.Use(SessionLoad)
+	mux.Get("/rooms/{slug}", Repo.Room)
+
+	var tests = []struct {
+		name               string
+		url                string
+		expectedStatusCode int
+		expectedText       string
+	}{
+		{"room", "/rooms/generals-quarters", http.StatusOK, "A quiet room with a view of the sea"},
+		{"amenities", "/rooms/generals-quarters", http.StatusOK, "Queen bed"},
+		{"price", "/rooms/majors-suite", http.StatusOK, "$180.00 per night"},
+		{"photo", "/rooms/generals-quarters", http.StatusOK, "/media/rooms/generals-quarters.png"},
+		{"unknown room", "/rooms/attic", http.StatusNotFound, ""},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", e.url, nil)
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected %d, got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+
+		if e.expectedText != "" && !strings.Contains(rr.Body.String(), e.expectedText) {
+			t.Errorf("%s: expected %q in the response", e.name, e.expectedText)
+		}
+	}
+}
+
+func TestAdminRooms(t *testing.T) {
+	mux := chi.NewRouter()
+	mux.Use(SessionLoad)
+	mux.Get("/admin/rooms", Repo.AdminRooms)
+	mux.Get("/admin/rooms/new", Repo.AdminNewRoom)
+	mux.Post("/admin/rooms/new", Repo.AdminPostNewRoom)
+	mux.Get("/admin/rooms/{id}", Repo.AdminShowRoom)
+	mux.Post("/admin/rooms/{id}", Repo.AdminPostShowRoom)
+	mux.Post("/admin/rooms/{id}/delete", Repo.AdminDeleteRoom)
+	mux.Post("/admin/rooms/{id}/photos/{photoID}/delete", Repo.AdminDeleteRoomPhoto)
+	mux.Post("/admin/rooms/{id}/photos/{photoID}/move", Repo.AdminMoveRoomPhoto)
+
+	room := url.Values{
+		"room_name":    {"Colonel's Loft"},
+		"slug":         {"colonels-loft"},
+		"capacity":     {"3"},
+		"max_adults":   {"2"},
+		"max_children": {"1"},
+		"base_price":   {"150.50"},
+		"amenities":    {"Balcony\r\n\r\nBath"},
+	}
+
+	with := func(key, value string) url.Values {
+		v := url.Values{}
+		for k, values := range room {
+			v[k] = values
+		}
+		v.Set(key, value)
+		return v
+	}
+
+	var tests = []struct {
+		name               string
+		method             string
+		url                string
+		postedData         url.Values
+		expectedStatusCode int
+		expectedLocation   string
+		expectedText       string
+	}{
+		{"list", "GET", "/admin/rooms", nil, http.StatusOK, "", "$120.00"},
+		{"new", "GET", "/admin/rooms/new", nil, http.StatusOK, "", "New Room"},
+		{"create", "POST", "/admin/rooms/new", room, http.StatusSeeOther, "/admin/rooms/3", ""},
+		{"create missing fields", "POST", "/admin/rooms/new", url.Values{}, http.StatusOK, "", "This field cannot be blank"},
+		{"create invalid slug", "POST", "/admin/rooms/new", with("slug", "Colonel's Loft"), http.StatusOK, "", "single hyphens"},
+		{"create duplicate slug", "POST", "/admin/rooms/new", with("slug", "majors-suite"), http.StatusOK, "", "already used by another room"},
+		{"create invalid capacity", "POST", "/admin/rooms/new", with("capacity", "0"), http.StatusOK, "", "at least 1"},
+		{"create too many adults", "POST", "/admin/rooms/new", with("max_adults", "4"), http.StatusOK, "", "more adults than guests"},
+		{"create invalid children", "POST", "/admin/rooms/new", with("max_children", "-1"), http.StatusOK, "", "0 or more"},
+		{"create invalid price", "POST", "/admin/rooms/new", with("base_price", "1.234"), http.StatusOK, "", "such as 120 or 120.50"},
+		{"create invalid weekend uplift", "POST", "/admin/rooms/new", with("weekend_uplift", "ten"), http.StatusOK, "", "such as 10 or 12.5"},
+		{"show", "GET", "/admin/rooms/1", nil, http.StatusOK, "", "generals-quarters"},
+		{"show invalid id", "GET", "/admin/rooms/x", nil, http.StatusNotFound, "", ""},
+		{"update", "POST", "/admin/rooms/1", with("slug", "generals-quarters"), http.StatusSeeOther, "/admin/rooms/1", ""},
+		{"update duplicate slug", "POST", "/admin/rooms/1", with("slug", "majors-suite"), http.StatusOK, "", "already used by another room"},
+		{"delete room with reservations", "POST", "/admin/rooms/1/delete", nil, http.StatusSeeOther, "/admin/rooms/1", ""},
+		{"delete", "POST", "/admin/rooms/2/delete", nil, http.StatusSeeOther, "/admin/rooms", ""},
+		{"delete photo", "POST", "/admin/rooms/1/photos/1/delete", nil, http.StatusSeeOther, "/admin/rooms/1", ""},
+		{"delete unknown photo", "POST", "/admin/rooms/1/photos/9/delete", nil, http.StatusNotFound, "", ""},
+		{"move photo", "POST", "/admin/rooms/1/photos/2/move", url.Values{"direction": {"up"}}, http.StatusSeeOther, "/admin/rooms/1", ""},
+		{"move first photo up", "POST", "/admin/rooms/1/photos/1/move", url.Values{"direction": {"up"}}, http.StatusSeeOther, "/admin/rooms/1", ""},
+		{"move unknown photo", "POST", "/admin/rooms/1/photos/9/move", url.Values{"direction": {"up"}}, http.StatusNotFound, "", ""},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest(e.method, e.url, strings.NewReader(e.postedData.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected %d, got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+
+		if e.expectedLocation != "" && rr.Header().Get("Location") != e.expectedLocation {
+			t.Errorf("%s: expected location %s, got %s", e.name, e.expectedLocation, rr.Header().Get("Location"))
+		}
+
+		if e.expectedText != "" && !strings.Contains(rr.Body.String(), e.expectedText) {
+			t.Errorf("%s: expected %q in the response", e.name, e.expectedText)
+		}
+	}
+}
+
+func TestAdminPostRoomPhoto(t *testing.T) {
+	// the session is loaded into the request context, so messages can be read after the request
+	mux := chi.NewRouter()
+	mux.Post("/admin/rooms/{id}/photos", Repo.AdminPostRoomPhoto)
+
+	var img bytes.Buffer
+	png.Encode(&img, image.NewRGBA(image.Rect(0, 0, 1, 1)))
+
+	var tests = []struct {
+		name          string
+		photo         []byte
+		expectedError bool
+	}{
+		{"png", img.Bytes(), false},
+		{"not an image", []byte("#!/bin/sh\necho hello\n"), true},
+		{"no photo", nil, true},
+	}
+
+	for _, e := range tests {
+		var body bytes.Buffer
+		mw := multipart.NewWriter(&body)
+		mw.WriteField("caption", "The view")
+		if e.photo != nil {
+			fw, _ := mw.CreateFormFile("photo", "photo.png")
+			fw.Write(e.photo)
+		}
+		mw.Close()
+
+		req, _ := http.NewRequest("POST", "/admin/rooms/1/photos", &body)
+		req.Header.Set("Content-Type", mw.FormDataContentType())
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != "/admin/rooms/1" {
+			t.Errorf("%s: expected a redirect to the room, got %d %s", e.name, rr.Code, rr.Header().Get("Location"))
+		}
+
+		if msg := session.PopString(ctx, "error"); (msg != "") != e.expectedError {
+			t.Errorf("%s: expected error %v, got %q", e.name, e.expectedError, msg)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"encoding/gob"
+	"io/ioutil"
 	"log"
 	"net/http"
 	"os"
@@ -9,10 +10,16 @@ import (
 	"time"
 
 	"github.com/alexedwards/scs/v2"
+	"github.com/dhanekom/bookings/internal/calsync"
 	"github.com/dhanekom/bookings/internal/config"
+	"github.com/dhanekom/bookings/internal/helpers"
+	"github.com/dhanekom/bookings/internal/mailer"
+	"github.com/dhanekom/bookings/internal/media"
 	"github.com/dhanekom/bookings/internal/models"
 	"github.com/dhanekom/bookings/internal/render"
 	"github.com/dhanekom/bookings/internal/repository/dbrepo"
+	"github.com/dhanekom/bookings/internal/throttle"
+	"github.com/dhanekom/bookings/internal/waitlist"
 	"github.com/go-chi/chi"
 	"github.com/go-chi/chi/middleware"
 	"github.com/justinas/nosurf"
@@ -23,6 +30,7 @@ var session *scs.SessionManager
 
 func TestMain(m *testing.M) {
 	gob.Register(models.Reservation{})
+	gob.Register(models.RoomRestriction{})
 
 	app.TemplatePath = "../../templates"
 
@@ -32,6 +40,10 @@ func TestMain(m *testing.M) {
 	}
 
 	app.InProduction = false
+	app.SecretKey = []byte("test-secret-key")
+	app.BaseURL = "http://localhost:8080"
+	app.PropertyName = "Fort Smythe"
+	app.PropertyAddress = "1 Main Road, Fort Smythe"
 
 	infoLog := log.New(os.Stdout, "INFO\n", log.Ldate|log.Ltime)
 	app.InfoLog = infoLog
@@ -47,27 +59,32 @@ func TestMain(m *testing.M) {
 
 	app.Session = session
 
-	mailChan := make(chan models.MailData)
-	app.MailChan = mailChan
-	defer close(mailChan)
-
-	listenForMail()
-
 	app.UseCache = false
+	app.LoginThrottle = throttle.New(throttle.NewMemoryStore(), nil)
+	// mail is queued but never sent, so tests can look at what was queued
+	app.Mail = mailer.NewOutbox(mailer.NewMemoryStore(), nil, nil)
+	app.Mail.Templates, err = mailer.NewTemplates("../../email-templates", true)
+	if err != nil {
+		log.Printf("unable to create mail template cache - %s", err)
+	}
 	app.TemplateCache = tc
 	render.NewRendered(&app)
+	helpers.NewHelpers(&app)
 	myDBRepo := dbrepo.NewTestDBRepo(&app)
 	NewRepo(&app, myDBRepo)
+	app.CalendarSync = calsync.New(myDBRepo, nil)
+	app.HoldDuration = 15 * time.Minute
+	app.Waitlist = waitlist.New(myDBRepo, Repo.OfferWaitlist, nil)
 
-	os.Exit(m.Run())
-}
+	mediaDir, err := ioutil.TempDir("", "media")
+	if err != nil {
+		log.Fatal(err)
+	}
+	app.Media = media.New(mediaDir)
 
-func listenForMail() {
-	go func() {
-		for {
-			<-app.MailChan
-		}
-	}()
+	code := m.Run()
+	os.RemoveAll(mediaDir)
+	os.Exit(code)
 }
 
 func getRoutes() http.Handler {
@@ -79,8 +96,7 @@ func getRoutes() http.Handler {
 
 	mux.Get("/", Repo.Home)
 	mux.Get("/about", Repo.About)
-	mux.Get("/generals-quarters", Repo.Generals)
-	mux.Get("/majors-suite", Repo.Majors)
+	mux.Get("/rooms", Repo.Rooms)
 
 	mux.Get("/search-availability", Repo.Availability)
 	mux.Post("/search-availability", Repo.PostAvailability)
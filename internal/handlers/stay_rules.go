@@ -0,0 +1,211 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/dhanekom/bookings/internal/forms"
+	"github.com/dhanekom/bookings/internal/helpers"
+	"github.com/dhanekom/bookings/internal/models"
+	"github.com/dhanekom/bookings/internal/render"
+	"github.com/dhanekom/bookings/internal/stayrules"
+)
+
+// checkStay returns the stay rules of the room with roomID that a stay from start to end breaks, if it's
+// booked today
+func (m *Repository) checkStay(roomID int, start, end time.Time) ([]stayrules.Violation, error) {
+	rules, err := m.DB.StayRulesForRoom(roomID, start, end)
+	if err != nil {
+		return nil, err
+	}
+
+	return stayrules.Check(rules, start, end, time.Now()), nil
+}
+
+// addStayErrors adds violations to the errors of form, as errors of the arrival or departure date field, or of
+// the adults or children field
+func addStayErrors(form *forms.Form, violations []stayrules.Violation, arrivalField, departureField string) {
+	for _, v := range violations {
+		field := v.Field
+		switch v.Field {
+		case stayrules.Arrival:
+			field = arrivalField
+		case stayrules.Departure:
+			field = departureField
+		}
+
+		form.Errors.Add(field, v.Message)
+	}
+}
+
+// parseParty parses the number of adults and children staying from the adults and children fields of form,
+// adding errors to the form if they're invalid. The number of children is optional
+func parseParty(form *forms.Form) (adults, children int) {
+	adults, err := strconv.Atoi(form.Get("adults"))
+	if err != nil || adults < 1 {
+		form.Errors.Add("adults", "Enter a number of adults of at least 1")
+	}
+
+	if form.Get("children") != "" {
+		children, err = strconv.Atoi(form.Get("children"))
+		if err != nil || children < 0 {
+			form.Errors.Add("children", "Enter a number of children of 0 or more")
+		}
+	}
+
+	return adults, children
+}
+
+// parseWeekdays parses the days checked in the field of form, adding an error to the form if any are invalid
+func parseWeekdays(form *forms.Form, field string) []time.Weekday {
+	var days []time.Weekday
+	for _, v := range form.Values[field] {
+		d, err := strconv.Atoi(v)
+		if err != nil || d < int(time.Sunday) || d > int(time.Saturday) {
+			form.Errors.Add(field, "Choose days of the week")
+			return nil
+		}
+
+		days = append(days, time.Weekday(d))
+	}
+
+	return days
+}
+
+// renderAdminStayRules renders the stay rules page with form, which holds the values and errors of the last post
+func (m *Repository) renderAdminStayRules(w http.ResponseWriter, r *http.Request, form *forms.Form) {
+	rooms, err := m.DB.AllRooms()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	rules, err := m.DB.AllStayRules()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	// checked days are looked up by weekday
+	checked := make(map[string]map[time.Weekday]bool)
+	for _, field := range []string{"closed_to_arrival", "closed_to_departure"} {
+		checked[field] = make(map[time.Weekday]bool)
+		for _, v := range form.Values[field] {
+			if d, err := strconv.Atoi(v); err == nil {
+				checked[field][time.Weekday(d)] = true
+			}
+		}
+	}
+
+	var days []time.Weekday
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		days = append(days, d)
+	}
+
+	data := make(map[string]interface{})
+	data["rooms"] = rooms
+	data["rules"] = rules
+	data["weekdays"] = days
+	data["checked"] = checked
+
+	render.Template(w, r, "admin-stay-rules.page.tmpl", &models.TemplateData{
+		Data: data,
+		Form: form,
+	})
+}
+
+// AdminStayRules shows the stay rules of all rooms
+func (m *Repository) AdminStayRules(w http.ResponseWriter, r *http.Request) {
+	m.renderAdminStayRules(w, r, forms.New(nil))
+}
+
+// AdminPostStayRule adds a stay rule for a room
+func (m *Repository) AdminPostStayRule(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("room_id", "start_date", "end_date")
+
+	roomID, err := strconv.Atoi(form.Get("room_id"))
+	if err == nil {
+		_, err = m.DB.GetRoomByID(roomID)
+	}
+	if err != nil && form.Errors.Get("room_id") == "" {
+		form.Errors.Add("room_id", "Choose a room")
+	}
+
+	const layout = "2006-01-02"
+	startDate, err := time.Parse(layout, form.Get("start_date"))
+	if err != nil && form.Errors.Get("start_date") == "" {
+		form.Errors.Add("start_date", "Invalid date")
+	}
+
+	endDate, err := time.Parse(layout, form.Get("end_date"))
+	if err != nil && form.Errors.Get("end_date") == "" {
+		form.Errors.Add("end_date", "Invalid date")
+	}
+
+	if form.Errors.Get("start_date") == "" && form.Errors.Get("end_date") == "" && endDate.Before(startDate) {
+		form.Errors.Add("end_date", "The last date can't be before the first")
+	}
+
+	// the limits are optional, and a blank one doesn't restrict stays
+	limits := make(map[string]int)
+	for _, field := range []string{"min_nights", "max_nights", "min_advance_days", "max_advance_days"} {
+		if form.Get(field) == "" {
+			continue
+		}
+
+		n, err := strconv.Atoi(form.Get(field))
+		if err != nil || n < 0 {
+			form.Errors.Add(field, "Enter a whole number of 0 or more")
+			continue
+		}
+		limits[field] = n
+	}
+
+	if limits["max_nights"] > 0 && limits["max_nights"] < limits["min_nights"] {
+		form.Errors.Add("max_nights", "The maximum can't be less than the minimum")
+	}
+
+	if limits["max_advance_days"] > 0 && limits["max_advance_days"] < limits["min_advance_days"] {
+		form.Errors.Add("max_advance_days", "The maximum can't be less than the minimum")
+	}
+
+	closedToArrival := parseWeekdays(form, "closed_to_arrival")
+	closedToDeparture := parseWeekdays(form, "closed_to_departure")
+
+	if !form.Valid() {
+		m.renderAdminStayRules(w, r, form)
+		return
+	}
+
+	_, err = m.DB.InsertStayRule(models.StayRule{
+		RoomID:            roomID,
+		StartDate:         startDate,
+		EndDate:           endDate,
+		MinNights:         limits["min_nights"],
+		MaxNights:         limits["max_nights"],
+		ClosedToArrival:   closedToArrival,
+		ClosedToDeparture: closedToDeparture,
+		MinAdvanceDays:    limits["min_advance_days"],
+		MaxAdvanceDays:    limits["max_advance_days"],
+	})
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.AddFlash(r, "Stay rule added")
+	http.Redirect(w, r, "/admin/stay-rules", http.StatusSeeOther)
+}
+
+// AdminDeleteStayRule deletes a stay rule
+func (m *Repository) AdminDeleteStayRule(w http.ResponseWriter, r *http.Request) {
+	m.deleteRule(w, r, m.DB.DeleteStayRule, "Stay rule deleted", "/admin/stay-rules")
+}
@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func TestAdminStayRules(t *testing.T) {
+	mux := chi.NewRouter()
+	mux.Use(SessionLoad)
+	mux.Get("/admin/stay-rules", Repo.AdminStayRules)
+	mux.Post("/admin/stay-rules", Repo.AdminPostStayRule)
+	mux.Post("/admin/stay-rules/{id}/delete", Repo.AdminDeleteStayRule)
+
+	rule := url.Values{
+		"room_id":             {"1"},
+		"start_date":          {"2050-12-01"},
+		"end_date":            {"2050-12-31"},
+		"min_nights":          {"3"},
+		"max_nights":          {"21"},
+		"closed_to_arrival":   {"0", "6"},
+		"closed_to_departure": {"0"},
+		"min_advance_days":    {""},
+		"max_advance_days":    {"365"},
+	}
+
+	with := func(key string, values ...string) url.Values {
+		v := url.Values{}
+		for k, values := range rule {
+			v[k] = values
+		}
+		v[key] = values
+		return v
+	}
+
+	var tests = []struct {
+		name               string
+		method             string
+		url                string
+		postedData         url.Values
+		expectedStatusCode int
+		expectedLocation   string
+		expectedText       string
+	}{
+		{"list", "GET", "/admin/stay-rules", nil, http.StatusOK, "", "at least 2"},
+		{"list closed days", "GET", "/admin/stay-rules", nil, http.StatusOK, "", "<td>Sunday</td>"},
+		{"add", "POST", "/admin/stay-rules", rule, http.StatusSeeOther, "/admin/stay-rules", ""},
+		{"add missing fields", "POST", "/admin/stay-rules", url.Values{}, http.StatusOK, "", "This field cannot be blank"},
+		{"add unknown room", "POST", "/admin/stay-rules", with("room_id", "0"), http.StatusOK, "", "Choose a room"},
+		{"add ends before it starts", "POST", "/admin/stay-rules", with("end_date", "2050-11-30"), http.StatusOK, "", "can&#39;t be before the first"},
+		{"add negative limit", "POST", "/admin/stay-rules", with("min_nights", "-1"), http.StatusOK, "", "0 or more"},
+		{"add maximum below minimum", "POST", "/admin/stay-rules", with("max_nights", "2"), http.StatusOK, "", "less than the minimum"},
+		{"add invalid weekday", "POST", "/admin/stay-rules", with("closed_to_arrival", "7"), http.StatusOK, "", "Choose days of the week"},
+		{"add keeps checked days", "POST", "/admin/stay-rules", with("room_id", "0"), http.StatusOK, "", `value="6" checked`},
+		{"delete", "POST", "/admin/stay-rules/1/delete", nil, http.StatusSeeOther, "/admin/stay-rules", ""},
+		{"delete invalid id", "POST", "/admin/stay-rules/x/delete", nil, http.StatusBadRequest, "", ""},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest(e.method, e.url, strings.NewReader(e.postedData.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected %d, got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+
+		if e.expectedLocation != "" && rr.Header().Get("Location") != e.expectedLocation {
+			t.Errorf("%s: expected location %s, got %s", e.name, e.expectedLocation, rr.Header().Get("Location"))
+		}
+
+		if e.expectedText != "" && !strings.Contains(rr.Body.String(), e.expectedText) {
+			t.Errorf("%s: expected %q in the response", e.name, e.expectedText)
+		}
+	}
+}
@@ -0,0 +1,431 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dhanekom/bookings/internal/audit"
+	"github.com/dhanekom/bookings/internal/forms"
+	"github.com/dhanekom/bookings/internal/helpers"
+	"github.com/dhanekom/bookings/internal/models"
+	"github.com/dhanekom/bookings/internal/render"
+	"github.com/dhanekom/bookings/internal/throttle"
+	"github.com/dhanekom/bookings/internal/totp"
+)
+
+const (
+	// twoFactorLevelSetting holds the lowest access level that must use two-factor authentication
+	twoFactorLevelSetting = "two_factor_access_level"
+	// twoFactorLoginTimeout is how long a user has to enter a code after entering their password
+	twoFactorLoginTimeout = 5 * time.Minute
+	// recoveryCodeCount is the number of recovery codes a user gets
+	recoveryCodeCount = 10
+	// totpIssuer names the site in authenticator apps
+	totpIssuer = "Bookings"
+)
+
+// TwoFactorRequired reports whether u must use two-factor authentication
+func (m *Repository) TwoFactorRequired(u models.User) (bool, error) {
+	level, err := m.twoFactorLevel()
+	if err != nil {
+		return false, err
+	}
+
+	return level > 0 && u.AccessLevel >= level, nil
+}
+
+// twoFactorLevel returns the lowest access level that must use two-factor authentication, 0 if none must
+func (m *Repository) twoFactorLevel() (int, error) {
+	value, err := m.DB.GetSetting(twoFactorLevelSetting)
+	if err != nil || value == "" {
+		return 0, err
+	}
+
+	return strconv.Atoi(value)
+}
+
+// checkSecondFactor checks a TOTP or recovery code for a user. Each code can only be used once
+func (m *Repository) checkSecondFactor(userID int, code string) (bool, error) {
+	secret, err := m.DB.GetTOTPSecret(userID)
+	if err != nil {
+		return false, err
+	}
+
+	if step, ok := totp.Validate(secret, code, time.Now()); ok {
+		return m.DB.UseTOTPStep(userID, step)
+	}
+
+	return m.DB.UseRecoveryCode(userID, helpers.HashToken(totp.NormalizeRecoveryCode(code)))
+}
+
+// newRecoveryCodes returns new recovery codes along with their hashes
+func newRecoveryCodes() ([]string, []string, error) {
+	codes, err := totp.NewRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	hashes := make([]string, len(codes))
+	for i, code := range codes {
+		hashes[i] = helpers.HashToken(totp.NormalizeRecoveryCode(code))
+	}
+
+	return codes, hashes, nil
+}
+
+// logIn puts the user into the session
+func (m *Repository) logIn(r *http.Request, userID int) {
+	m.App.Session.Put(r.Context(), "user_id", userID)
+	m.App.Session.Put(r.Context(), "login_at", time.Now().UnixNano())
+}
+
+// pendingTwoFactorUser returns the user who entered their password but not yet their code
+func (m *Repository) pendingTwoFactorUser(r *http.Request) (models.User, bool, error) {
+	id, ok := m.App.Session.Get(r.Context(), "two_factor_user_id").(int)
+	if !ok {
+		return models.User{}, false, nil
+	}
+
+	startedAt, _ := m.App.Session.Get(r.Context(), "two_factor_started_at").(int64)
+	if time.Since(time.Unix(startedAt, 0)) > twoFactorLoginTimeout {
+		return models.User{}, false, nil
+	}
+
+	u, err := m.DB.GetUserByID(id)
+	if err != nil {
+		return u, false, err
+	}
+
+	return u, u.DeactivatedAt.IsZero(), nil
+}
+
+// clearPendingTwoFactor forgets the user who entered their password but not yet their code
+func (m *Repository) clearPendingTwoFactor(r *http.Request) {
+	m.App.Session.Remove(r.Context(), "two_factor_user_id")
+	m.App.Session.Remove(r.Context(), "two_factor_started_at")
+}
+
+// ShowLoginTwoFactor shows the second login step, asking for a code
+func (m *Repository) ShowLoginTwoFactor(w http.ResponseWriter, r *http.Request) {
+	_, ok, err := m.pendingTwoFactorUser(r)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	if !ok {
+		m.AddError(r, "Log in first!")
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+
+	render.Template(w, r, "login-two-factor.page.tmpl", &models.TemplateData{
+		Form: forms.New(nil),
+	})
+}
+
+// PostLoginTwoFactor checks the code of the second login step and logs the user in
+func (m *Repository) PostLoginTwoFactor(w http.ResponseWriter, r *http.Request) {
+	u, ok, err := m.pendingTwoFactorUser(r)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	if !ok {
+		m.clearPendingTwoFactor(r)
+		m.AddError(r, "Log in first!")
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+
+	err = r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("code")
+
+	if !form.Valid() {
+		render.Template(w, r, "login-two-factor.page.tmpl", &models.TemplateData{
+			Form: form,
+		})
+		return
+	}
+
+	ip := helpers.ClientIP(r)
+	_, err = m.App.LoginThrottle.Attempt(u.Email, ip)
+	if errors.Is(err, throttle.ErrLocked) || errors.Is(err, throttle.ErrThrottled) {
+		m.clearPendingTwoFactor(r)
+		m.AddError(r, "Too many failed logins. Try again later")
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	} else if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	valid, err := m.checkSecondFactor(u.ID, form.Get("code"))
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	if !valid {
+		// the attempt was counted as a failure when it was made
+		form.Errors.Add("code", "Invalid code")
+		render.Template(w, r, "login-two-factor.page.tmpl", &models.TemplateData{
+			Form: form,
+		})
+		return
+	}
+
+	err = m.App.LoginThrottle.Success(u.Email, ip)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.clearPendingTwoFactor(r)
+	m.App.Session.RenewToken(r.Context())
+	m.logIn(r, u.ID)
+	m.AddFlash(r, "Logged in successfully")
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// AdminTwoFactor shows the logged in user's two-factor authentication settings, and how to set it up if it
+// isn't enabled yet
+func (m *Repository) AdminTwoFactor(w http.ResponseWriter, r *http.Request) {
+	u, _ := helpers.UserFromContext(r.Context())
+
+	required, err := m.TwoFactorRequired(u)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["required"] = required
+
+	stringMap := make(map[string]string)
+	intMap := make(map[string]int)
+
+	if u.TOTPEnabledAt.IsZero() {
+		// keep the secret in the session until a first code proves the authenticator app was set up
+		secret := m.App.Session.GetString(r.Context(), "totp_secret")
+		if secret == "" {
+			secret, err = totp.GenerateSecret()
+			if err != nil {
+				helpers.ServerError(w, err)
+				return
+			}
+			m.App.Session.Put(r.Context(), "totp_secret", secret)
+		}
+
+		stringMap["secret"] = secret
+		// html/template would otherwise refuse the otpauth scheme
+		uri := totp.URI(totpIssuer, u.Email, secret)
+		data["uri"] = template.URL(uri)
+
+		qr, err := totp.QRCode(uri, 200)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+		data["qr"] = template.URL(qr)
+	} else {
+		count, err := m.DB.CountRecoveryCodes(u.ID)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+		intMap["recovery_codes_left"] = count
+
+		// new recovery codes are only shown once
+		if codes := m.App.Session.PopString(r.Context(), "recovery_codes"); codes != "" {
+			data["recovery_codes"] = strings.Split(codes, "\n")
+		}
+	}
+
+	render.Template(w, r, "admin-two-factor.page.tmpl", &models.TemplateData{
+		StringMap: stringMap,
+		IntMap:    intMap,
+		Data:      data,
+		Form:      forms.New(nil),
+	})
+}
+
+// AdminPostEnableTwoFactor enables two-factor authentication once the first code from the authenticator app
+// checks out
+func (m *Repository) AdminPostEnableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	u, _ := helpers.UserFromContext(r.Context())
+
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	secret := m.App.Session.GetString(r.Context(), "totp_secret")
+	step, ok := totp.Validate(secret, r.Form.Get("code"), time.Now())
+	if secret == "" || !ok {
+		m.AddError(r, "The code is invalid. Check the time on your device and try again")
+		http.Redirect(w, r, "/admin/two-factor", http.StatusSeeOther)
+		return
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	err = m.DB.EnableTOTP(u.ID, secret, hashes)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	before := audit.User(u)
+	u.TOTPEnabledAt = time.Now()
+	m.audit(r, models.AuditUpdate, models.AuditEntityUser, u.ID, before, audit.User(u))
+
+	// the code used to enable two-factor authentication can't be used to log in
+	_, err = m.DB.UseTOTPStep(u.ID, step)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Remove(r.Context(), "totp_secret")
+	m.App.Session.Put(r.Context(), "recovery_codes", strings.Join(codes, "\n"))
+	m.AddFlash(r, "Two-factor authentication enabled")
+	http.Redirect(w, r, "/admin/two-factor", http.StatusSeeOther)
+}
+
+// AdminPostDisableTwoFactor disables two-factor authentication for the logged in user
+func (m *Repository) AdminPostDisableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	u, _ := helpers.UserFromContext(r.Context())
+
+	required, err := m.TwoFactorRequired(u)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	if required {
+		m.AddError(r, "Two-factor authentication is required for your account")
+		http.Redirect(w, r, "/admin/two-factor", http.StatusSeeOther)
+		return
+	}
+
+	err = r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	valid, err := m.checkSecondFactor(u.ID, r.Form.Get("code"))
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	if !valid {
+		m.AddError(r, "Invalid code")
+		http.Redirect(w, r, "/admin/two-factor", http.StatusSeeOther)
+		return
+	}
+
+	err = m.DB.DisableTOTP(u.ID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	before := audit.User(u)
+	u.TOTPEnabledAt = time.Time{}
+	m.audit(r, models.AuditUpdate, models.AuditEntityUser, u.ID, before, audit.User(u))
+
+	m.AddFlash(r, "Two-factor authentication disabled")
+	http.Redirect(w, r, "/admin/two-factor", http.StatusSeeOther)
+}
+
+// AdminPostRecoveryCodes replaces the logged in user's recovery codes
+func (m *Repository) AdminPostRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	u, _ := helpers.UserFromContext(r.Context())
+
+	if u.TOTPEnabledAt.IsZero() {
+		http.Redirect(w, r, "/admin/two-factor", http.StatusSeeOther)
+		return
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	err = m.DB.ReplaceRecoveryCodes(u.ID, hashes)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "recovery_codes", strings.Join(codes, "\n"))
+	m.AddFlash(r, "New recovery codes created")
+	http.Redirect(w, r, "/admin/two-factor", http.StatusSeeOther)
+}
+
+// AdminPostTwoFactorPolicy sets the lowest access level that must use two-factor authentication
+func (m *Repository) AdminPostTwoFactorPolicy(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	level, err := strconv.Atoi(r.Form.Get("two_factor_level"))
+	if _, ok := accessLevels[level]; err != nil || (level != 0 && !ok) {
+		m.AddError(r, "Invalid access level")
+		http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+		return
+	}
+
+	err = m.DB.SetSetting(twoFactorLevelSetting, strconv.Itoa(level))
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.AddFlash(r, "Two-factor authentication policy saved")
+	http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+}
+
+// AdminResetUserTwoFactor turns off two-factor authentication for a user who lost their device
+func (m *Repository) AdminResetUserTwoFactor(w http.ResponseWriter, r *http.Request) {
+	u, ok := m.userFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	err := m.DB.DisableTOTP(u.ID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	before := audit.User(u)
+	u.TOTPEnabledAt = time.Time{}
+	m.audit(r, models.AuditUpdate, models.AuditEntityUser, u.ID, before, audit.User(u))
+
+	m.AddFlash(r, "Two-factor authentication reset. The user has to set it up again")
+	http.Redirect(w, r, fmt.Sprintf("/admin/users/%d", u.ID), http.StatusSeeOther)
+}
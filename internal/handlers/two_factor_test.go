@@ -0,0 +1,168 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dhanekom/bookings/internal/helpers"
+	"github.com/dhanekom/bookings/internal/models"
+	"github.com/dhanekom/bookings/internal/repository/dbrepo"
+	"github.com/dhanekom/bookings/internal/totp"
+	"github.com/go-chi/chi/v5"
+)
+
+func getTwoFactorRoutes(u models.User) http.Handler {
+	mux := chi.NewRouter()
+
+	mux.Use(SessionLoad)
+	mux.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r.WithContext(helpers.ContextWithUser(r.Context(), u)))
+		})
+	})
+
+	mux.Post("/user/login", Repo.PostShowLogin)
+	mux.Get("/user/login/two-factor", Repo.ShowLoginTwoFactor)
+	mux.Post("/user/login/two-factor", Repo.PostLoginTwoFactor)
+	mux.Get("/admin/two-factor", Repo.AdminTwoFactor)
+	mux.Post("/admin/two-factor/enable", Repo.AdminPostEnableTwoFactor)
+	mux.Post("/admin/two-factor/disable", Repo.AdminPostDisableTwoFactor)
+	mux.Post("/admin/two-factor/recovery-codes", Repo.AdminPostRecoveryCodes)
+
+	return mux
+}
+
+// sessionClient sends requests to a handler, keeping the session cookie between them
+type sessionClient struct {
+	handler http.Handler
+	cookies []*http.Cookie
+}
+
+func (c *sessionClient) do(method, url string, postedData url.Values) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest(method, url, strings.NewReader(postedData.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	for _, cookie := range c.cookies {
+		req.AddCookie(cookie)
+	}
+
+	rr := httptest.NewRecorder()
+	c.handler.ServeHTTP(rr, req)
+
+	if cookies := rr.Result().Cookies(); len(cookies) > 0 {
+		c.cookies = cookies
+	}
+
+	return rr
+}
+
+func TestLoginTwoFactor(t *testing.T) {
+	login := url.Values{
+		"email":    {"totp@here.com"},
+		"password": {"password"},
+	}
+	validCode, _ := totp.Code(dbrepo.TestTOTPSecret, totp.Step(time.Now()))
+
+	var tests = []struct {
+		name               string
+		code               string
+		expectedStatusCode int
+		expectedLocation   string
+	}{
+		{"valid code", validCode, http.StatusSeeOther, "/"},
+		{"recovery code", strings.ToUpper(dbrepo.TestRecoveryCode), http.StatusSeeOther, "/"},
+		{"invalid code", "123", http.StatusOK, ""},
+		{"missing code", "", http.StatusOK, ""},
+	}
+
+	for _, e := range tests {
+		c := &sessionClient{handler: getTwoFactorRoutes(models.User{})}
+
+		rr := c.do("POST", "/user/login", login)
+		if loc := rr.Header().Get("Location"); loc != "/user/login/two-factor" {
+			t.Fatalf("%s: expected redirect to the second login step, got %s", e.name, loc)
+		}
+
+		rr = c.do("GET", "/user/login/two-factor", nil)
+		if rr.Code != http.StatusOK {
+			t.Errorf("%s: expected the code form, got %d", e.name, rr.Code)
+		}
+
+		rr = c.do("POST", "/user/login/two-factor", url.Values{"code": {e.code}})
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected %d, got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+
+		if e.expectedLocation != "" && rr.Header().Get("Location") != e.expectedLocation {
+			t.Errorf("%s: expected location %s, got %s", e.name, e.expectedLocation, rr.Header().Get("Location"))
+		}
+	}
+
+	// without entering a password first there is no second step
+	c := &sessionClient{handler: getTwoFactorRoutes(models.User{})}
+	rr := c.do("GET", "/user/login/two-factor", nil)
+	if loc := rr.Header().Get("Location"); loc != "/user/login" {
+		t.Errorf("expected redirect to /user/login without a pending login, got %s", loc)
+	}
+
+	rr = c.do("POST", "/user/login/two-factor", url.Values{"code": {validCode}})
+	if loc := rr.Header().Get("Location"); loc != "/user/login" {
+		t.Errorf("expected a code without a pending login to be refused, got redirect to %s", loc)
+	}
+}
+
+func TestAdminEnableTwoFactor(t *testing.T) {
+	c := &sessionClient{handler: getTwoFactorRoutes(models.User{ID: 2, Email: "staff@here.com"})}
+
+	rr := c.do("GET", "/admin/two-factor", nil)
+	if !strings.Contains(rr.Body.String(), "otpauth://totp/") {
+		t.Fatalf("expected the otpauth URI on the enrolment page")
+	}
+
+	// the QR code is rendered on the server, so the key isn't handed to a script from another site
+	if !strings.Contains(rr.Body.String(), `src="data:image/png;base64,`) || strings.Contains(rr.Body.String(), "qrcode") {
+		t.Error("expected a server rendered QR code on the enrolment page")
+	}
+
+	match := regexp.MustCompile(`Key: <code>([A-Z2-7]+)</code>`).FindStringSubmatch(rr.Body.String())
+	if match == nil {
+		t.Fatal("expected the key on the enrolment page")
+	}
+
+	rr = c.do("POST", "/admin/two-factor/enable", url.Values{"code": {"000000x"}})
+	if rr.Header().Get("Location") != "/admin/two-factor" {
+		t.Errorf("expected an invalid code to redirect back, got %s", rr.Header().Get("Location"))
+	}
+
+	code, _ := totp.Code(match[1], totp.Step(time.Now()))
+	rr = c.do("POST", "/admin/two-factor/enable", url.Values{"code": {code}})
+	if rr.Header().Get("Location") != "/admin/two-factor" {
+		t.Errorf("expected enabling to redirect back, got %s", rr.Header().Get("Location"))
+	}
+
+	// the recovery codes are shown once, on the page of a user with two-factor authentication enabled
+	c.handler = getTwoFactorRoutes(models.User{ID: 2, Email: "staff@here.com", TOTPEnabledAt: time.Now()})
+	rr = c.do("GET", "/admin/two-factor", nil)
+	if !strings.Contains(rr.Body.String(), "recovery codes somewhere safe") {
+		t.Errorf("expected the new recovery codes to be shown")
+	}
+
+	rr = c.do("GET", "/admin/two-factor", nil)
+	if strings.Contains(rr.Body.String(), "recovery codes somewhere safe") {
+		t.Errorf("expected the recovery codes to be shown only once")
+	}
+
+	rr = c.do("POST", "/admin/two-factor/recovery-codes", nil)
+	if rr.Header().Get("Location") != "/admin/two-factor" {
+		t.Errorf("expected new recovery codes to redirect back, got %s", rr.Header().Get("Location"))
+	}
+
+	rr = c.do("POST", "/admin/two-factor/disable", url.Values{"code": {dbrepo.TestRecoveryCode}})
+	if rr.Header().Get("Location") != "/admin/two-factor" {
+		t.Errorf("expected disabling to redirect back, got %s", rr.Header().Get("Location"))
+	}
+}
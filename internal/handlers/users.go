@@ -0,0 +1,335 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/dhanekom/bookings/internal/audit"
+	"github.com/dhanekom/bookings/internal/forms"
+	"github.com/dhanekom/bookings/internal/helpers"
+	"github.com/dhanekom/bookings/internal/models"
+	"github.com/dhanekom/bookings/internal/render"
+	"github.com/dhanekom/bookings/internal/repository"
+	"github.com/go-chi/chi/v5"
+)
+
+// minPasswordLength is the shortest password accepted for a user
+const minPasswordLength = 8
+
+// accessLevels maps each access level to its display name
+var accessLevels = map[int]string{
+	models.AccessLevelStaff:   "Staff",
+	models.AccessLevelManager: "Manager",
+	models.AccessLevelAdmin:   "Admin",
+}
+
+// validateUserForm checks the user detail fields and returns the posted access level
+func validateUserForm(form *forms.Form) int {
+	form.Required("first_name", "last_name", "email", "access_level")
+	form.IsEmail("email")
+
+	accessLevel, err := strconv.Atoi(form.Get("access_level"))
+	if _, ok := accessLevels[accessLevel]; err != nil || !ok {
+		form.Errors.Add("access_level", "Invalid access level")
+	}
+
+	return accessLevel
+}
+
+// validatePasswordForm checks the password and password_confirm fields
+func validatePasswordForm(form *forms.Form) {
+	form.Required("password", "password_confirm")
+	form.MinLength("password", minPasswordLength)
+	form.Matches("password", "password_confirm")
+}
+
+// renderUserForm renders the user edit page
+func (m *Repository) renderUserForm(w http.ResponseWriter, r *http.Request, u models.User, form *forms.Form) {
+	data := make(map[string]interface{})
+	data["user"] = u
+	data["access_levels"] = accessLevels
+
+	render.Template(w, r, "admin-user.page.tmpl", &models.TemplateData{
+		Data: data,
+		Form: form,
+	})
+}
+
+// userFromURL loads the user named by the id URL parameter, writing an error response if it can't
+func (m *Repository) userFromURL(w http.ResponseWriter, r *http.Request) (models.User, bool) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusNotFound)
+		return models.User{}, false
+	}
+
+	u, err := m.DB.GetUserByID(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.ClientError(w, http.StatusNotFound)
+		return u, false
+	} else if err != nil {
+		helpers.ServerError(w, err)
+		return u, false
+	}
+
+	return u, true
+}
+
+// AdminUsers lists all users
+func (m *Repository) AdminUsers(w http.ResponseWriter, r *http.Request) {
+	users, err := m.DB.AllUsers()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	twoFactorLevel, err := m.twoFactorLevel()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["users"] = users
+	data["access_levels"] = accessLevels
+
+	intMap := make(map[string]int)
+	intMap["two_factor_level"] = twoFactorLevel
+
+	render.Template(w, r, "admin-users.page.tmpl", &models.TemplateData{
+		IntMap: intMap,
+		Data:   data,
+	})
+}
+
+// AdminNewUser shows the form for a new user
+func (m *Repository) AdminNewUser(w http.ResponseWriter, r *http.Request) {
+	m.renderUserForm(w, r, models.User{AccessLevel: models.AccessLevelStaff}, forms.New(nil))
+}
+
+// AdminPostNewUser creates a new user
+func (m *Repository) AdminPostNewUser(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	accessLevel := validateUserForm(form)
+	validatePasswordForm(form)
+
+	u := models.User{
+		FirstName:   r.Form.Get("first_name"),
+		LastName:    r.Form.Get("last_name"),
+		Email:       r.Form.Get("email"),
+		AccessLevel: accessLevel,
+	}
+
+	if !form.Valid() {
+		m.renderUserForm(w, r, u, form)
+		return
+	}
+
+	u.ID, err = m.DB.InsertUser(u, r.Form.Get("password"))
+	if errors.Is(err, repository.ErrDuplicateEmail) {
+		form.Errors.Add("email", "This email address is already in use")
+		m.renderUserForm(w, r, u, form)
+		return
+	} else if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	m.audit(r, models.AuditCreate, models.AuditEntityUser, u.ID, nil, audit.User(u))
+
+	m.AddFlash(r, "User created")
+	http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+}
+
+// AdminShowUser shows the form to edit a user
+func (m *Repository) AdminShowUser(w http.ResponseWriter, r *http.Request) {
+	u, ok := m.userFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	m.renderUserForm(w, r, u, forms.New(nil))
+}
+
+// AdminPostShowUser updates a user's details and access level
+func (m *Repository) AdminPostShowUser(w http.ResponseWriter, r *http.Request) {
+	u, ok := m.userFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	accessLevel := validateUserForm(form)
+
+	before := audit.User(u)
+	u.FirstName = r.Form.Get("first_name")
+	u.LastName = r.Form.Get("last_name")
+	u.Email = r.Form.Get("email")
+	u.AccessLevel = accessLevel
+
+	if !form.Valid() {
+		m.renderUserForm(w, r, u, form)
+		return
+	}
+
+	err = m.DB.UpdateUser(u)
+	if errors.Is(err, repository.ErrLastAdmin) {
+		form.Errors.Add("access_level", "This is the last active admin and can't be demoted")
+		m.renderUserForm(w, r, u, form)
+		return
+	} else if errors.Is(err, repository.ErrDuplicateEmail) {
+		form.Errors.Add("email", "This email address is already in use")
+		m.renderUserForm(w, r, u, form)
+		return
+	} else if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	m.audit(r, models.AuditUpdate, models.AuditEntityUser, u.ID, before, audit.User(u))
+
+	m.AddFlash(r, "Changes saved")
+	http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+}
+
+// AdminPostUserPassword sets a new password for a user
+func (m *Repository) AdminPostUserPassword(w http.ResponseWriter, r *http.Request) {
+	u, ok := m.userFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	validatePasswordForm(form)
+	if !form.Valid() {
+		m.renderUserForm(w, r, u, form)
+		return
+	}
+
+	err = m.DB.UpdateUserPassword(u.ID, r.Form.Get("password"))
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	m.audit(r, models.AuditPassword, models.AuditEntityUser, u.ID, nil, nil)
+
+	// changing a password logs out the user's sessions, so keep the current one if it's their own
+	if current, ok := helpers.UserFromContext(r.Context()); ok && current.ID == u.ID {
+		m.App.Session.Put(r.Context(), "login_at", time.Now().UnixNano())
+	}
+
+	m.AddFlash(r, "Password changed")
+	http.Redirect(w, r, fmt.Sprintf("/admin/users/%d", u.ID), http.StatusSeeOther)
+}
+
+// AdminDeactivateUser stops a user from logging in
+func (m *Repository) AdminDeactivateUser(w http.ResponseWriter, r *http.Request) {
+	u, ok := m.userFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	if current, ok := helpers.UserFromContext(r.Context()); ok && current.ID == u.ID {
+		m.AddError(r, "You can't deactivate your own account")
+		http.Redirect(w, r, fmt.Sprintf("/admin/users/%d", u.ID), http.StatusSeeOther)
+		return
+	}
+
+	err := m.DB.SetUserActive(u.ID, false)
+	if errors.Is(err, repository.ErrLastAdmin) {
+		m.AddError(r, "This is the last active admin and can't be deactivated")
+		http.Redirect(w, r, fmt.Sprintf("/admin/users/%d", u.ID), http.StatusSeeOther)
+		return
+	} else if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	before := audit.User(u)
+	u.DeactivatedAt = time.Now()
+	m.audit(r, models.AuditUpdate, models.AuditEntityUser, u.ID, before, audit.User(u))
+
+	m.AddFlash(r, "User deactivated")
+	http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+}
+
+// AdminActivateUser allows a deactivated user to log in again
+func (m *Repository) AdminActivateUser(w http.ResponseWriter, r *http.Request) {
+	u, ok := m.userFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	err := m.DB.SetUserActive(u.ID, true)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	before := audit.User(u)
+	u.DeactivatedAt = time.Time{}
+	m.audit(r, models.AuditUpdate, models.AuditEntityUser, u.ID, before, audit.User(u))
+
+	m.AddFlash(r, "User activated")
+	http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+}
+
+// AdminLockedAccounts lists the accounts locked because of failed logins
+func (m *Repository) AdminLockedAccounts(w http.ResponseWriter, r *http.Request) {
+	accounts, err := m.App.LoginThrottle.LockedAccounts()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["locked_accounts"] = accounts
+
+	render.Template(w, r, "admin-locked-accounts.page.tmpl", &models.TemplateData{
+		Data: data,
+	})
+}
+
+// AdminUnlockAccount unlocks an account locked because of failed logins
+func (m *Repository) AdminUnlockAccount(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	email := r.Form.Get("email")
+	if email == "" {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	err = m.App.LoginThrottle.Unlock(email)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.AddFlash(r, fmt.Sprintf("Unlocked %s", email))
+	http.Redirect(w, r, "/admin/locked-accounts", http.StatusSeeOther)
+}
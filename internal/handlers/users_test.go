@@ -0,0 +1,212 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dhanekom/bookings/internal/helpers"
+	"github.com/dhanekom/bookings/internal/models"
+	"github.com/dhanekom/bookings/internal/throttle"
+	"github.com/go-chi/chi/v5"
+)
+
+func getUserRoutes() http.Handler {
+	mux := chi.NewRouter()
+
+	mux.Use(SessionLoad)
+	mux.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			u := models.User{ID: 1, AccessLevel: models.AccessLevelAdmin}
+			next.ServeHTTP(w, r.WithContext(helpers.ContextWithUser(r.Context(), u)))
+		})
+	})
+
+	mux.Get("/admin/users", Repo.AdminUsers)
+	mux.Get("/admin/users/new", Repo.AdminNewUser)
+	mux.Post("/admin/users/new", Repo.AdminPostNewUser)
+	mux.Get("/admin/users/{id}", Repo.AdminShowUser)
+	mux.Post("/admin/users/{id}", Repo.AdminPostShowUser)
+	mux.Post("/admin/users/{id}/password", Repo.AdminPostUserPassword)
+	mux.Post("/admin/users/{id}/deactivate", Repo.AdminDeactivateUser)
+	mux.Post("/admin/users/{id}/activate", Repo.AdminActivateUser)
+	mux.Post("/admin/users/two-factor-policy", Repo.AdminPostTwoFactorPolicy)
+	mux.Post("/admin/users/{id}/two-factor/reset", Repo.AdminResetUserTwoFactor)
+	mux.Get("/admin/locked-accounts", Repo.AdminLockedAccounts)
+	mux.Post("/admin/locked-accounts/unlock", Repo.AdminUnlockAccount)
+	mux.Post("/user/login", Repo.PostShowLogin)
+
+	return mux
+}
+
+var userTests = []struct {
+	name               string
+	method             string
+	url                string
+	postedData         url.Values
+	expectedStatusCode int
+	expectedLocation   string
+	expectedText       string
+}{
+	{"list users", "GET", "/admin/users", nil, http.StatusOK, "", "staff@here.com"},
+	{"new user form", "GET", "/admin/users/new", nil, http.StatusOK, "", "Confirm password"},
+	{"create user", "POST", "/admin/users/new", url.Values{
+		"first_name":       {"Jane"},
+		"last_name":        {"Doe"},
+		"email":            {"jane@here.com"},
+		"access_level":     {"1"},
+		"password":         {"password123"},
+		"password_confirm": {"password123"},
+	}, http.StatusSeeOther, "/admin/users", ""},
+	{"create user short password", "POST", "/admin/users/new", url.Values{
+		"first_name":       {"Jane"},
+		"last_name":        {"Doe"},
+		"email":            {"jane@here.com"},
+		"access_level":     {"1"},
+		"password":         {"short"},
+		"password_confirm": {"short"},
+	}, http.StatusOK, "", "at least 8 characters"},
+	{"create user password mismatch", "POST", "/admin/users/new", url.Values{
+		"first_name":       {"Jane"},
+		"last_name":        {"Doe"},
+		"email":            {"jane@here.com"},
+		"access_level":     {"1"},
+		"password":         {"password123"},
+		"password_confirm": {"password456"},
+	}, http.StatusOK, "", "do not match"},
+	{"create user invalid access level", "POST", "/admin/users/new", url.Values{
+		"first_name":       {"Jane"},
+		"last_name":        {"Doe"},
+		"email":            {"jane@here.com"},
+		"access_level":     {"9"},
+		"password":         {"password123"},
+		"password_confirm": {"password123"},
+	}, http.StatusOK, "", "Invalid access level"},
+	{"create user duplicate email", "POST", "/admin/users/new", url.Values{
+		"first_name":       {"Jane"},
+		"last_name":        {"Doe"},
+		"email":            {"exists@here.com"},
+		"access_level":     {"1"},
+		"password":         {"password123"},
+		"password_confirm": {"password123"},
+	}, http.StatusOK, "", "already in use"},
+	{"show user", "GET", "/admin/users/2", nil, http.StatusOK, "", "Reset Password"},
+	{"show missing user", "GET", "/admin/users/1000", nil, http.StatusNotFound, "", ""},
+	{"show invalid user id", "GET", "/admin/users/x", nil, http.StatusNotFound, "", ""},
+	{"update user", "POST", "/admin/users/2", url.Values{
+		"first_name":   {"Staff"},
+		"last_name":    {"User"},
+		"email":        {"staff@here.com"},
+		"access_level": {"2"},
+	}, http.StatusSeeOther, "/admin/users", ""},
+	{"demote last admin", "POST", "/admin/users/1", url.Values{
+		"first_name":   {"Admin"},
+		"last_name":    {"User"},
+		"email":        {"admin@admin.com"},
+		"access_level": {"1"},
+	}, http.StatusOK, "", "last active admin"},
+	{"set password", "POST", "/admin/users/2/password", url.Values{
+		"password":         {"password123"},
+		"password_confirm": {"password123"},
+	}, http.StatusSeeOther, "/admin/users/2", ""},
+	{"set invalid password", "POST", "/admin/users/2/password", url.Values{
+		"password":         {"short"},
+		"password_confirm": {"short"},
+	}, http.StatusOK, "", "at least 8 characters"},
+	{"deactivate user", "POST", "/admin/users/2/deactivate", nil, http.StatusSeeOther, "/admin/users", ""},
+	{"deactivate self", "POST", "/admin/users/1/deactivate", nil, http.StatusSeeOther, "/admin/users/1", ""},
+	{"activate user", "POST", "/admin/users/2/activate", nil, http.StatusSeeOther, "/admin/users", ""},
+	{"two-factor policy", "POST", "/admin/users/two-factor-policy", url.Values{
+		"two_factor_level": {"2"},
+	}, http.StatusSeeOther, "/admin/users", ""},
+	{"invalid two-factor policy", "POST", "/admin/users/two-factor-policy", url.Values{
+		"two_factor_level": {"9"},
+	}, http.StatusSeeOther, "/admin/users", ""},
+	{"reset two-factor", "POST", "/admin/users/3/two-factor/reset", nil, http.StatusSeeOther, "/admin/users/3", ""},
+	{"locked accounts", "GET", "/admin/locked-accounts", nil, http.StatusOK, "", "No accounts are locked"},
+	{"unlock account", "POST", "/admin/locked-accounts/unlock", url.Values{
+		"email": {"staff@here.com"},
+	}, http.StatusSeeOther, "/admin/locked-accounts", ""},
+	{"unlock without email", "POST", "/admin/locked-accounts/unlock", nil, http.StatusBadRequest, "", ""},
+}
+
+func TestUserHandlers(t *testing.T) {
+	routes := getUserRoutes()
+
+	for _, e := range userTests {
+		req, _ := http.NewRequest(e.method, e.url, strings.NewReader(e.postedData.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		rr := httptest.NewRecorder()
+		routes.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected %d, got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+
+		if e.expectedLocation != "" {
+			if loc := rr.Header().Get("Location"); loc != e.expectedLocation {
+				t.Errorf("%s: expected location %s, got %s", e.name, e.expectedLocation, loc)
+			}
+		}
+
+		if e.expectedText != "" && !strings.Contains(rr.Body.String(), e.expectedText) {
+			t.Errorf("%s: expected body to contain %q", e.name, e.expectedText)
+		}
+	}
+}
+
+func TestLoginThrottle(t *testing.T) {
+	saved := app.LoginThrottle
+	defer func() { app.LoginThrottle = saved }()
+
+	th := throttle.New(throttle.NewMemoryStore(), nil)
+	th.AccountPolicy = throttle.Policy{FreeAttempts: 100, LockAfter: 3, LockFor: time.Hour, Window: time.Hour}
+	app.LoginThrottle = th
+
+	routes := getUserRoutes()
+
+	login := func(password string) *httptest.ResponseRecorder {
+		postedData := url.Values{
+			"email":    {"staff@here.com"},
+			"password": {password},
+		}
+		req, _ := http.NewRequest("POST", "/user/login", strings.NewReader(postedData.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.RemoteAddr = "10.0.0.1:1234"
+
+		rr := httptest.NewRecorder()
+		routes.ServeHTTP(rr, req)
+		return rr
+	}
+
+	for i := 0; i < 3; i++ {
+		if rr := login("wrong"); rr.Header().Get("Location") != "/user/login" {
+			t.Fatalf("failed login %d: expected redirect to /user/login, got %s", i+1, rr.Header().Get("Location"))
+		}
+	}
+
+	// the right password is refused while the account is locked
+	if rr := login("password"); rr.Header().Get("Location") != "/user/login" {
+		t.Errorf("expected a locked account to be refused, got redirect to %s", rr.Header().Get("Location"))
+	}
+
+	req, _ := http.NewRequest("GET", "/admin/locked-accounts", nil)
+	rr := httptest.NewRecorder()
+	routes.ServeHTTP(rr, req)
+	if !strings.Contains(rr.Body.String(), "staff@here.com") {
+		t.Errorf("expected staff@here.com in the locked accounts list")
+	}
+
+	err := th.Unlock("staff@here.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rr := login("password"); rr.Header().Get("Location") != "/" {
+		t.Errorf("expected login to succeed after unlocking, got redirect to %s", rr.Header().Get("Location"))
+	}
+}
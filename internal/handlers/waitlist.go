@@ -0,0 +1,198 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/dhanekom/bookings/internal/forms"
+	"github.com/dhanekom/bookings/internal/helpers"
+	"github.com/dhanekom/bookings/internal/models"
+	"github.com/dhanekom/bookings/internal/render"
+	"github.com/dhanekom/bookings/internal/stayrules"
+)
+
+// waitlistURL returns the link to the waitlist form for a stay no room was available for
+func waitlistURL(start, end time.Time, adults, children int) string {
+	v := url.Values{}
+	v.Set("start", start.Format("2006-01-02"))
+	v.Set("end", end.Format("2006-01-02"))
+	v.Set("adults", strconv.Itoa(adults))
+	v.Set("children", strconv.Itoa(children))
+	return "/waitlist?" + v.Encode()
+}
+
+// matchWaitlist asks the waitlist matcher to offer the rooms that may just have become available
+func (m *Repository) matchWaitlist() {
+	if m.App.Waitlist != nil {
+		m.App.Waitlist.Trigger()
+	}
+}
+
+// Waitlist shows the form to join the waitlist, filled in with the stay of the query string
+func (m *Repository) Waitlist(w http.ResponseWriter, r *http.Request) {
+	render.Template(w, r, "waitlist.page.tmpl", &models.TemplateData{
+		Form: forms.New(r.URL.Query()),
+	})
+}
+
+// PostWaitlist adds the posted guest to the waitlist for a stay
+func (m *Repository) PostWaitlist(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("first_name", "email", "start", "end", "adults")
+	form.IsEmail("email")
+
+	layout := "2006-01-02"
+
+	startDate, err := time.Parse(layout, form.Get("start"))
+	if err != nil && form.Errors.Get("start") == "" {
+		form.Errors.Add("start", "Invalid date")
+	}
+
+	endDate, err := time.Parse(layout, form.Get("end"))
+	if err != nil && form.Errors.Get("end") == "" {
+		form.Errors.Add("end", "Invalid date")
+	}
+
+	if form.Errors.Get("start") == "" && form.Errors.Get("end") == "" {
+		addStayErrors(form, stayrules.Check(nil, startDate, endDate, time.Now()), "start", "end")
+	}
+
+	adults, children := 0, 0
+	if form.Get("adults") != "" {
+		adults, children = parseParty(form)
+	}
+
+	if !form.Valid() {
+		render.Template(w, r, "waitlist.page.tmpl", &models.TemplateData{
+			Form: form,
+		})
+		return
+	}
+
+	_, err = m.DB.InsertWaitlistEntry(models.WaitlistEntry{
+		FirstName: form.Get("first_name"),
+		Email:     form.Get("email"),
+		StartDate: startDate,
+		EndDate:   endDate,
+		Adults:    adults,
+		Children:  children,
+	})
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.AddFlash(r, "You're on the waitlist. We'll email you if a room becomes available for your dates.")
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// OfferWaitlist offers room to the guest of a waitlist entry. The room is held for the guest and they are
+// emailed a link to book it, which both expire after the offer duration of the matcher
+func (m *Repository) OfferWaitlist(ctx context.Context, e models.WaitlistEntry, room models.Room) error {
+	now := time.Now()
+	expires := now.Add(m.App.Waitlist.OfferDuration)
+
+	holdID, err := m.DB.PlaceHold(ctx, models.RoomRestriction{
+		StartDate:     e.StartDate,
+		EndDate:       e.EndDate,
+		RoomID:        room.ID,
+		RestrictionID: models.RestrictionHold,
+		ExpiresAt:     expires,
+	})
+	if err != nil {
+		return err
+	}
+
+	token, err := helpers.NewSignedToken(m.App.SecretKey, expires)
+	if err != nil {
+		_ = m.DB.ReleaseHold(holdID)
+		return err
+	}
+
+	e.RoomID = room.ID
+	e.Room = room
+	e.HoldID = holdID
+	e.OfferedAt = now
+	e.OfferExpiresAt = expires
+
+	err = m.DB.OfferWaitlistEntry(ctx, e, helpers.HashToken(token))
+	if err != nil {
+		_ = m.DB.ReleaseHold(holdID)
+		return err
+	}
+
+	link := fmt.Sprintf("%s/waitlist/offer?token=%s", m.App.BaseURL, url.QueryEscape(token))
+
+	m.SendMail(models.MailData{
+		To:       e.Email,
+		From:     "me@here.com",
+		Subject:  "A Room Is Available",
+		Template: "waitlist-offer",
+		Data: map[string]interface{}{
+			"entry": e,
+			"link":  link,
+		},
+	})
+
+	return nil
+}
+
+// WaitlistOffer starts a reservation for the room a waiting guest was offered, using the booking link they were
+// emailed, and keeps the room's hold in the session
+func (m *Repository) WaitlistOffer(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	if helpers.VerifySignedToken(m.App.SecretKey, token, time.Now()) != nil {
+		m.AddError(r, "The booking link is invalid or has expired")
+		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+		return
+	}
+
+	e, err := m.DB.GetWaitlistEntryByToken(helpers.HashToken(token))
+	if errors.Is(err, sql.ErrNoRows) {
+		m.AddError(r, "The booking link is invalid or has expired")
+		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+		return
+	} else if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	res := models.Reservation{
+		FirstName: e.FirstName,
+		Email:     e.Email,
+		StartDate: e.StartDate,
+		EndDate:   e.EndDate,
+		Adults:    e.Adults,
+		Children:  e.Children,
+		RoomID:    e.RoomID,
+	}
+	res.Room.RoomName = e.Room.RoomName
+
+	// the offer's hold replaces any the guest placed while browsing
+	m.releaseHold(r)
+	if e.HoldID != 0 {
+		m.App.Session.Put(r.Context(), "hold", models.RoomRestriction{
+			ID:            e.HoldID,
+			StartDate:     e.StartDate,
+			EndDate:       e.EndDate,
+			RoomID:        e.RoomID,
+			RestrictionID: models.RestrictionHold,
+			ExpiresAt:     e.OfferExpiresAt,
+		})
+	}
+
+	m.App.Session.Put(r.Context(), "reservation", res)
+	http.Redirect(w, r, "/make-reservation", http.StatusSeeOther)
+}
@@ -0,0 +1,161 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dhanekom/bookings/internal/helpers"
+	"github.com/dhanekom/bookings/internal/models"
+	"github.com/go-chi/chi/v5"
+)
+
+func getWaitlistRoutes() http.Handler {
+	mux := chi.NewRouter()
+
+	mux.Use(SessionLoad)
+
+	mux.Get("/waitlist", Repo.Waitlist)
+	mux.Post("/waitlist", Repo.PostWaitlist)
+	mux.Get("/waitlist/offer", Repo.WaitlistOffer)
+
+	return mux
+}
+
+func TestWaitlist(t *testing.T) {
+	validToken, _ := helpers.NewSignedToken(app.SecretKey, time.Now().Add(time.Hour))
+	expiredToken, _ := helpers.NewSignedToken(app.SecretKey, time.Now().Add(-time.Minute))
+	foreignToken, _ := helpers.NewSignedToken([]byte("another-secret"), time.Now().Add(time.Hour))
+
+	join := func(email, start, end string) url.Values {
+		return url.Values{
+			"first_name": {"John"},
+			"email":      {email},
+			"start":      {start},
+			"end":        {end},
+			"adults":     {"2"},
+			"children":   {"1"},
+		}
+	}
+
+	var tests = []struct {
+		name               string
+		method             string
+		url                string
+		postedData         url.Values
+		expectedStatusCode int
+		expectedLocation   string
+		expectedText       string
+	}{
+		{"waitlist form", "GET", "/waitlist?start=2050-01-01&end=2050-01-03&adults=3&children=0", nil, http.StatusOK, "", `value="2050-01-03"`},
+		{"join", "POST", "/waitlist", join("john@smith.com", "2050-01-01", "2050-01-03"), http.StatusSeeOther, "/", ""},
+		{"join missing fields", "POST", "/waitlist", url.Values{}, http.StatusOK, "", "This field cannot be blank"},
+		{"join invalid email", "POST", "/waitlist", join("john", "2050-01-01", "2050-01-03"), http.StatusOK, "", "Invalid email address"},
+		{"join invalid date", "POST", "/waitlist", join("john@smith.com", "2050-01-01", "soon"), http.StatusOK, "", "Invalid date"},
+		{"join in the past", "POST", "/waitlist", join("john@smith.com", "2020-01-01", "2020-01-03"), http.StatusOK, "", "can&#39;t be in the past"},
+		{"join fails", "POST", "/waitlist", join("error@here.com", "2050-01-01", "2050-01-03"), http.StatusInternalServerError, "", ""},
+		{"offer", "GET", "/waitlist/offer?token=" + url.QueryEscape(validToken), nil, http.StatusSeeOther, "/make-reservation", ""},
+		{"offer expired token", "GET", "/waitlist/offer?token=" + url.QueryEscape(expiredToken), nil, http.StatusSeeOther, "/search-availability", ""},
+		{"offer foreign token", "GET", "/waitlist/offer?token=" + url.QueryEscape(foreignToken), nil, http.StatusSeeOther, "/search-availability", ""},
+		{"offer missing token", "GET", "/waitlist/offer", nil, http.StatusSeeOther, "/search-availability", ""},
+	}
+
+	routes := getWaitlistRoutes()
+
+	for _, e := range tests {
+		req, _ := http.NewRequest(e.method, e.url, strings.NewReader(e.postedData.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		rr := httptest.NewRecorder()
+		routes.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected %d, got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+
+		if e.expectedLocation != "" {
+			if loc := rr.Header().Get("Location"); loc != e.expectedLocation {
+				t.Errorf("%s: expected location %s, got %s", e.name, e.expectedLocation, loc)
+			}
+		}
+
+		if e.expectedText != "" && !strings.Contains(rr.Body.String(), e.expectedText) {
+			t.Errorf("%s: expected body to contain %q", e.name, e.expectedText)
+		}
+	}
+}
+
+func TestWaitlistOfferFillsReservation(t *testing.T) {
+	token, _ := helpers.NewSignedToken(app.SecretKey, time.Now().Add(time.Hour))
+
+	req, _ := http.NewRequest("GET", "/waitlist/offer?token="+url.QueryEscape(token), nil)
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(Repo.WaitlistOffer).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusSeeOther {
+		t.Fatalf("expected a redirect, got %d", rr.Code)
+	}
+
+	res, ok := session.Get(ctx, "reservation").(models.Reservation)
+	if !ok || res.RoomID != 1 || res.FirstName != "John" || res.Email != "john@smith.com" || res.Adults != 2 {
+		t.Errorf("expected the offered reservation in the session, got %+v", res)
+	}
+
+	hold, ok := session.Get(ctx, "hold").(models.RoomRestriction)
+	if !ok || hold.ID != 101 || hold.RoomID != 1 || !hold.StartDate.Equal(res.StartDate) {
+		t.Errorf("expected the offer's hold in the session, got %+v", hold)
+	}
+}
+
+func TestOfferWaitlist(t *testing.T) {
+	e := models.WaitlistEntry{
+		ID:        1,
+		FirstName: "John",
+		Email:     "john@smith.com",
+		StartDate: time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC),
+		EndDate:   time.Date(2050, 1, 3, 0, 0, 0, 0, time.UTC),
+		Adults:    2,
+	}
+
+	var tests = []struct {
+		name          string
+		room          models.Room
+		expectedError bool
+	}{
+		{"offered", models.Room{ID: 1, RoomName: "General's Quarters"}, false},
+		{"room taken", models.Room{ID: 1001}, true},
+	}
+
+	for _, test := range tests {
+		before, _ := app.Mail.Store.List("", 0)
+
+		err := Repo.OfferWaitlist(context.Background(), e, test.room)
+		if (err != nil) != test.expectedError {
+			t.Errorf("%s: expected error %v, got %v", test.name, test.expectedError, err)
+		}
+
+		after, _ := app.Mail.Store.List("", 0)
+		if test.expectedError {
+			if len(after) != len(before) {
+				t.Errorf("%s: expected no mail to be queued", test.name)
+			}
+			continue
+		}
+
+		if len(after)-len(before) != 1 {
+			t.Fatalf("%s: expected 1 message to be queued, got %d", test.name, len(after)-len(before))
+		}
+
+		msg := after[0].Mail
+		if msg.To != e.Email || !strings.Contains(msg.Text, app.BaseURL+"/waitlist/offer?token=") || !strings.Contains(msg.Text, "General's Quarters") {
+			t.Errorf("%s: expected a booking link for the room to be mailed, got %+v", test.name, msg)
+		}
+	}
+}
@@ -1,11 +1,30 @@
 package helpers
 
 import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"net"
 	"net/http"
 	"runtime/debug"
+	"strings"
+	"time"
 
 	"github.com/dhanekom/bookings/internal/config"
+	"github.com/dhanekom/bookings/internal/models"
+)
+
+type contextKey string
+
+const (
+	userContextKey   contextKey = "user"
+	apiKeyContextKey contextKey = "api_key"
 )
 
 var app *config.AppConfig
@@ -29,3 +48,122 @@ func IsAuthenticated(r *http.Request) bool {
 	exists := app.Session.Exists(r.Context(), "user_id")
 	return exists
 }
+
+// ContextWithUser returns a copy of ctx that carries the logged in user
+func ContextWithUser(ctx context.Context, u models.User) context.Context {
+	return context.WithValue(ctx, userContextKey, u)
+}
+
+// UserFromContext returns the logged in user stored in ctx, if there is one
+func UserFromContext(ctx context.Context) (models.User, bool) {
+	u, ok := ctx.Value(userContextKey).(models.User)
+	return u, ok
+}
+
+// ContextWithAPIKey returns a copy of ctx that carries the API key of the request
+func ContextWithAPIKey(ctx context.Context, k models.APIKey) context.Context {
+	return context.WithValue(ctx, apiKeyContextKey, k)
+}
+
+// APIKeyFromContext returns the API key stored in ctx, if there is one
+func APIKeyFromContext(ctx context.Context) (models.APIKey, bool) {
+	k, ok := ctx.Value(apiKeyContextKey).(models.APIKey)
+	return k, ok
+}
+
+// HasAccessLevel returns true if the logged in user has at least the given access level
+func HasAccessLevel(r *http.Request, level int) bool {
+	u, ok := UserFromContext(r.Context())
+	return ok && u.AccessLevel >= level
+}
+
+// ClientIP returns the IP address of the client that made the request
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+// RandomToken returns a URL safe random token built from n random bytes
+func RandomToken(n int) (string, error) {
+	b := make([]byte, n)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// HashToken returns the hex encoded SHA-256 hash of a token, for storing secrets in the database
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// confirmationCodeAlphabet leaves out characters that are easily confused, like 0 and O
+const confirmationCodeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+// NewConfirmationCode returns a random code that guests use to find their reservation
+func NewConfirmationCode() (string, error) {
+	b := make([]byte, 10)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	for i := range b {
+		b[i] = confirmationCodeAlphabet[int(b[i])%len(confirmationCodeAlphabet)]
+	}
+
+	return string(b), nil
+}
+
+// ErrInvalidToken is returned for signed tokens that were tampered with or have expired
+var ErrInvalidToken = errors.New("invalid or expired token")
+
+// NewSignedToken returns a random token that expires at the given time, signed with an HMAC of secret
+func NewSignedToken(secret []byte, expires time.Time) (string, error) {
+	payload := make([]byte, 40)
+	_, err := rand.Read(payload[:32])
+	if err != nil {
+		return "", err
+	}
+	binary.BigEndian.PutUint64(payload[32:], uint64(expires.Unix()))
+
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + signToken(secret, encoded), nil
+}
+
+// VerifySignedToken checks the signature and expiry of a token created by NewSignedToken
+func VerifySignedToken(secret []byte, token string, now time.Time) error {
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 {
+		return ErrInvalidToken
+	}
+
+	if !hmac.Equal([]byte(parts[1]), []byte(signToken(secret, parts[0]))) {
+		return ErrInvalidToken
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil || len(payload) != 40 {
+		return ErrInvalidToken
+	}
+
+	expires := time.Unix(int64(binary.BigEndian.Uint64(payload[32:])), 0)
+	if !now.Before(expires) {
+		return ErrInvalidToken
+	}
+
+	return nil
+}
+
+// signToken returns the base64 encoded HMAC-SHA256 of payload
+func signToken(secret []byte, payload string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
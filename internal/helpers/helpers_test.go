@@ -0,0 +1,71 @@
+package helpers
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSignedToken(t *testing.T) {
+	secret := []byte("secret")
+	now := time.Now()
+
+	token, err := NewSignedToken(secret, now.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := VerifySignedToken(secret, token, now); err != nil {
+		t.Errorf("valid token failed verification - %v", err)
+	}
+
+	if err := VerifySignedToken(secret, token, now.Add(2*time.Hour)); err != ErrInvalidToken {
+		t.Error("expired token passed verification")
+	}
+
+	if err := VerifySignedToken([]byte("other secret"), token, now); err != ErrInvalidToken {
+		t.Error("token signed with a different secret passed verification")
+	}
+
+	tampered := "A" + token[1:]
+	if tampered == token {
+		tampered = "B" + token[1:]
+	}
+	if err := VerifySignedToken(secret, tampered, now); err != ErrInvalidToken {
+		t.Error("tampered token passed verification")
+	}
+
+	if err := VerifySignedToken(secret, strings.Split(token, ".")[0], now); err != ErrInvalidToken {
+		t.Error("unsigned token passed verification")
+	}
+}
+
+func TestHashToken(t *testing.T) {
+	if HashToken("a") == HashToken("b") {
+		t.Error("different tokens have the same hash")
+	}
+
+	if len(HashToken("a")) != 64 {
+		t.Errorf("expected 64 character hash, got %d", len(HashToken("a")))
+	}
+}
+
+func TestNewConfirmationCode(t *testing.T) {
+	code, err := NewConfirmationCode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(code) != 10 {
+		t.Errorf("expected a 10 character code, got %s", code)
+	}
+
+	if strings.Trim(code, confirmationCodeAlphabet) != "" {
+		t.Errorf("expected only characters from the alphabet, got %s", code)
+	}
+
+	other, _ := NewConfirmationCode()
+	if code == other {
+		t.Error("expected codes to be random")
+	}
+}
@@ -0,0 +1,73 @@
+// Package holds sweeps expired room holds, the short-lived restrictions that keep a room for a guest while
+// they fill in the reservation form or pay the deposit
+package holds
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/dhanekom/bookings/internal/background"
+)
+
+// Store holds room holds
+type Store interface {
+	// DeleteExpiredHolds deletes the holds that expired before now and returns how many were deleted
+	DeleteExpiredHolds(ctx context.Context, now time.Time) (int, error)
+	// CancelUnpaidReservations cancels the reservations whose hold expired before now while they were awaiting
+	// payment, and returns how many were cancelled
+	CancelUnpaidReservations(ctx context.Context, now time.Time) (int, error)
+}
+
+// Sweeper deletes expired holds, and cancels the reservations that weren't paid for before their hold expired
+type Sweeper struct {
+	Store Store
+	Clock background.Clock
+	// Interval is the time between sweeps
+	Interval time.Duration
+	// ErrorLog logs sweeps that failed. Nothing is logged if it is nil
+	ErrorLog *log.Logger
+}
+
+// New returns a sweeper with default settings. A nil clock uses the system time
+func New(store Store, clock background.Clock) *Sweeper {
+	if clock == nil {
+		clock = background.SystemClock{}
+	}
+
+	return &Sweeper{
+		Store:    store,
+		Clock:    clock,
+		Interval: time.Minute,
+	}
+}
+
+// Sweep cancels the unpaid reservations and deletes the holds that have expired, and returns how many holds were
+// deleted
+func (s *Sweeper) Sweep(ctx context.Context) (int, error) {
+	now := s.Clock.Now()
+
+	_, err := s.Store.CancelUnpaidReservations(ctx, now)
+	if err != nil {
+		return 0, err
+	}
+
+	return s.Store.DeleteExpiredHolds(ctx, now)
+}
+
+// Start sweeps straight away and then every Interval until ctx is done. The returned wait group is done once
+// sweeping has stopped
+func (s *Sweeper) Start(ctx context.Context) *sync.WaitGroup {
+	var wg sync.WaitGroup
+	background.Job{
+		Run: func(ctx context.Context) error {
+			_, err := s.Sweep(ctx)
+			return err
+		},
+		Interval: s.Interval,
+		ErrorLog: s.ErrorLog,
+	}.Start(ctx, &wg)
+
+	return &wg
+}
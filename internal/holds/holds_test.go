@@ -0,0 +1,141 @@
+package holds
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+var testNow = time.Date(2050, 1, 1, 12, 0, 0, 0, time.UTC)
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (c fakeClock) Now() time.Time {
+	return c.now
+}
+
+// fakeStore holds the expiry times of holds, and of the holds of reservations awaiting payment, in memory
+type fakeStore struct {
+	mu        sync.Mutex
+	holds     []time.Time
+	unpaid    []time.Time
+	cancelled int
+	sweeps    int
+	fail      error
+}
+
+func (s *fakeStore) CancelUnpaidReservations(ctx context.Context, now time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.fail != nil {
+		return 0, s.fail
+	}
+
+	var kept []time.Time
+	for _, expires := range s.unpaid {
+		if expires.After(now) {
+			kept = append(kept, expires)
+		}
+	}
+
+	cancelled := len(s.unpaid) - len(kept)
+	s.unpaid = kept
+	s.cancelled += cancelled
+	return cancelled, nil
+}
+
+func (s *fakeStore) DeleteExpiredHolds(ctx context.Context, now time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sweeps++
+	if s.fail != nil {
+		return 0, s.fail
+	}
+
+	var kept []time.Time
+	for _, expires := range s.holds {
+		if expires.After(now) {
+			kept = append(kept, expires)
+		}
+	}
+
+	deleted := len(s.holds) - len(kept)
+	s.holds = kept
+	return deleted, nil
+}
+
+func (s *fakeStore) count() (holds, sweeps int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.holds), s.sweeps
+}
+
+func TestSweep(t *testing.T) {
+	store := &fakeStore{
+		holds: []time.Time{
+			testNow.Add(-time.Minute),
+			testNow,
+			testNow.Add(time.Minute),
+		},
+		unpaid: []time.Time{
+			testNow.Add(-time.Minute),
+			testNow.Add(time.Minute),
+		},
+	}
+	s := New(store, fakeClock{testNow})
+
+	deleted, err := s.Sweep(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if deleted != 2 {
+		t.Errorf("expected the holds that expired by now to be deleted, got %d", deleted)
+	}
+
+	if n, _ := store.count(); n != 1 {
+		t.Errorf("expected 1 hold to be kept, got %d", n)
+	}
+
+	if store.cancelled != 1 || len(store.unpaid) != 1 {
+		t.Errorf("expected the reservation whose hold expired unpaid to be cancelled, got %d cancelled", store.cancelled)
+	}
+
+	store.fail = errors.New("some error")
+	if _, err := s.Sweep(context.Background()); err == nil {
+		t.Error("expected the store error to be returned")
+	}
+}
+
+func TestStartSweepsUntilDone(t *testing.T) {
+	store := &fakeStore{holds: []time.Time{testNow.Add(-time.Minute)}}
+	s := New(store, fakeClock{testNow})
+	s.Interval = 10 * time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	wg := s.Start(ctx)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for _, sweeps := store.count(); sweeps < 3 && time.Now().Before(deadline); _, sweeps = store.count() {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	cancel()
+	wg.Wait()
+
+	holds, sweeps := store.count()
+	if sweeps < 3 {
+		t.Errorf("expected repeated sweeps, got %d", sweeps)
+	}
+
+	if holds != 0 {
+		t.Errorf("expected the expired hold to be swept, got %d holds", holds)
+	}
+}
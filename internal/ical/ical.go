@@ -0,0 +1,172 @@
+// Package ical writes iCalendar (RFC 5545) calendars of all-day events and reads the events of calendars
+// published by other systems
+package ical
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Calendar methods (RFC 5546)
+const (
+	MethodPublish = "PUBLISH"
+	MethodRequest = "REQUEST"
+	MethodCancel  = "CANCEL"
+)
+
+// Event statuses
+const (
+	StatusConfirmed = "CONFIRMED"
+	StatusCancelled = "CANCELLED"
+)
+
+// ContentType is the MIME type of an iCalendar file
+const ContentType = "text/calendar"
+
+// Person is an organizer or attendee of an event
+type Person struct {
+	Name  string
+	Email string
+}
+
+// Event is an all-day event from the date of Start up to, but not including, the date of End
+type Event struct {
+	UID         string
+	Sequence    int
+	Stamp       time.Time
+	Start       time.Time
+	End         time.Time
+	Summary     string
+	Description string
+	Location    string
+	Status      string
+	Organizer   Person
+	Attendee    Person
+}
+
+// Calendar is a set of events
+type Calendar struct {
+	ProdID string
+	Method string
+	Name   string
+	Events []Event
+}
+
+// Bytes returns the calendar in iCalendar format
+func (c Calendar) Bytes() []byte {
+	var w writer
+
+	w.line("BEGIN:VCALENDAR")
+	w.line("VERSION:2.0")
+	w.line("PRODID:" + c.ProdID)
+	w.line("CALSCALE:GREGORIAN")
+	if c.Method != "" {
+		w.line("METHOD:" + c.Method)
+	}
+	if c.Name != "" {
+		w.line("X-WR-CALNAME:" + escapeText(c.Name))
+	}
+
+	for _, e := range c.Events {
+		w.event(e)
+	}
+
+	w.line("END:VCALENDAR")
+
+	return w.buf.Bytes()
+}
+
+// ContentTypeFor returns the MIME type of a calendar sent with method
+func ContentTypeFor(method string) string {
+	if method == "" {
+		return ContentType + "; charset=utf-8"
+	}
+
+	return fmt.Sprintf("%s; charset=utf-8; method=%s", ContentType, method)
+}
+
+type writer struct {
+	buf bytes.Buffer
+}
+
+func (w *writer) event(e Event) {
+	stamp := e.Stamp
+	if stamp.IsZero() {
+		stamp = time.Now()
+	}
+
+	w.line("BEGIN:VEVENT")
+	w.line("UID:" + e.UID)
+	w.line("DTSTAMP:" + stamp.UTC().Format("20060102T150405Z"))
+	w.line(fmt.Sprintf("SEQUENCE:%d", e.Sequence))
+	w.line("DTSTART;VALUE=DATE:" + e.Start.Format("20060102"))
+	w.line("DTEND;VALUE=DATE:" + e.End.Format("20060102"))
+	w.line("SUMMARY:" + escapeText(e.Summary))
+	if e.Location != "" {
+		w.line("LOCATION:" + escapeText(e.Location))
+	}
+	if e.Description != "" {
+		w.line("DESCRIPTION:" + escapeText(e.Description))
+	}
+	if e.Status != "" {
+		w.line("STATUS:" + e.Status)
+	}
+	if e.Organizer.Email != "" {
+		w.line("ORGANIZER" + commonName(e.Organizer.Name) + ":mailto:" + e.Organizer.Email)
+	}
+	if e.Attendee.Email != "" {
+		w.line("ATTENDEE" + commonName(e.Attendee.Name) + ";ROLE=REQ-PARTICIPANT:mailto:" + e.Attendee.Email)
+	}
+	w.line("TRANSP:OPAQUE")
+	w.line("END:VEVENT")
+}
+
+// line writes a content line, folded so that no line is longer than 75 octets
+func (w *writer) line(s string) {
+	const max = 75
+
+	limit := max
+	for len(s) > limit {
+		// don't split a multi-byte character
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(s[cut]) {
+			cut--
+		}
+
+		w.buf.WriteString(s[:cut])
+		w.buf.WriteString("\r\n ")
+		s = s[cut:]
+
+		// continuation lines start with a space, which counts towards their length
+		limit = max - 1
+	}
+
+	w.buf.WriteString(s)
+	w.buf.WriteString("\r\n")
+}
+
+var textEscaper = strings.NewReplacer(
+	`\`, `\\`,
+	";", `\;`,
+	",", `\,`,
+	"\r\n", `\n`,
+	"\n", `\n`,
+)
+
+// escapeText escapes a TEXT property value
+func escapeText(s string) string {
+	return textEscaper.Replace(s)
+}
+
+// commonName returns a CN parameter for name, or nothing if name is empty
+func commonName(name string) string {
+	name = strings.NewReplacer(`"`, "", "\r", "", "\n", " ").Replace(name)
+	if name == "" {
+		return ""
+	}
+
+	return `;CN="` + name + `"`
+}
@@ -0,0 +1,90 @@
+package ical
+
+import (
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+func testEvent() Event {
+	return Event{
+		UID:         "reservation-1@example.com",
+		Sequence:    2,
+		Stamp:       time.Date(2050, 1, 1, 8, 30, 0, 0, time.UTC),
+		Start:       time.Date(2050, 2, 1, 0, 0, 0, 0, time.UTC),
+		End:         time.Date(2050, 2, 3, 0, 0, 0, 0, time.UTC),
+		Summary:     "Stay at Fort Smythe; General's Quarters",
+		Description: "Confirmation code: ABCDEFGHJK\nRoom: General's Quarters, first floor",
+		Location:    `1 Main Road\Fort Smythe`,
+		Status:      StatusConfirmed,
+		Organizer:   Person{Name: "Fort Smythe", Email: "me@here.com"},
+		Attendee:    Person{Name: `John "Jack" Smith`, Email: "john@smith.com"},
+	}
+}
+
+func TestCalendarBytes(t *testing.T) {
+	c := Calendar{ProdID: "-//Test//EN", Method: MethodRequest, Events: []Event{testEvent()}}
+	out := string(c.Bytes())
+
+	var tests = []struct {
+		name     string
+		expected string
+	}{
+		{"begins", "BEGIN:VCALENDAR\r\nVERSION:2.0\r\nPRODID:-//Test//EN\r\n"},
+		{"method", "\r\nMETHOD:REQUEST\r\n"},
+		{"uid", "\r\nUID:reservation-1@example.com\r\n"},
+		{"stamp", "\r\nDTSTAMP:20500101T083000Z\r\n"},
+		{"sequence", "\r\nSEQUENCE:2\r\n"},
+		{"start", "\r\nDTSTART;VALUE=DATE:20500201\r\n"},
+		{"end", "\r\nDTEND;VALUE=DATE:20500203\r\n"},
+		{"escaped summary", `SUMMARY:Stay at Fort Smythe\; General's Quarters`},
+		{"escaped location", `LOCATION:1 Main Road\\Fort Smythe`},
+		{"escaped description", `DESCRIPTION:Confirmation code: ABCDEFGHJK\nRoom: General's Quarters\, fi`},
+		{"status", "\r\nSTATUS:CONFIRMED\r\n"},
+		{"organizer", "\r\nORGANIZER;CN=\"Fort Smythe\":mailto:me@here.com\r\n"},
+		{"attendee strips quotes", "ATTENDEE;CN=\"John Jack Smith\";ROLE=REQ-PARTICIPANT:mailto:john@smith.com"},
+		{"ends", "END:VEVENT\r\nEND:VCALENDAR\r\n"},
+	}
+
+	for _, e := range tests {
+		if !strings.Contains(out, e.expected) {
+			t.Errorf("%s: expected %q in\n%s", e.name, e.expected, out)
+		}
+	}
+
+	if strings.Contains(strings.ReplaceAll(out, "\r\n", ""), "\n") {
+		t.Error("expected every line to end with CRLF")
+	}
+}
+
+func TestLineFolding(t *testing.T) {
+	e := testEvent()
+	e.Description = strings.Repeat("Überraschung ", 30)
+
+	out := string(Calendar{ProdID: "-//Test//EN", Events: []Event{e}}.Bytes())
+
+	for _, line := range strings.Split(strings.TrimSuffix(out, "\r\n"), "\r\n") {
+		if len(line) > 75 {
+			t.Errorf("line is %d octets long: %q", len(line), line)
+		}
+		if !utf8.ValidString(line) {
+			t.Errorf("line splits a character: %q", line)
+		}
+	}
+
+	unfolded := strings.ReplaceAll(out, "\r\n ", "")
+	if !strings.Contains(unfolded, "DESCRIPTION:"+e.Description+"\r\n") {
+		t.Error("expected the description to unfold to its original value")
+	}
+}
+
+func TestContentTypeFor(t *testing.T) {
+	if got := ContentTypeFor(MethodCancel); got != "text/calendar; charset=utf-8; method=CANCEL" {
+		t.Errorf("unexpected content type %q", got)
+	}
+
+	if got := ContentTypeFor(""); got != "text/calendar; charset=utf-8" {
+		t.Errorf("unexpected content type %q", got)
+	}
+}
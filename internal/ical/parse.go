@@ -0,0 +1,239 @@
+package ical
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrNotCalendar is returned by Parse when its input isn't an iCalendar file
+var ErrNotCalendar = errors.New("not an iCalendar file")
+
+// maxLineLength caps the length of an unfolded content line that Parse accepts
+const maxLineLength = 64 * 1024
+
+// Parse reads the events of an iCalendar file. Date-time values are kept in their time zone. Events without an
+// end get one from their duration, or last a day if they have neither
+func Parse(r io.Reader) ([]Event, error) {
+	lines, err := unfold(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(lines) == 0 || !strings.EqualFold(lines[0], "BEGIN:VCALENDAR") {
+		return nil, ErrNotCalendar
+	}
+
+	var events []Event
+	var e *Event
+	var duration time.Duration
+	depth := 0
+
+	for n, line := range lines {
+		name, params, value, err := parseLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", n+1, err)
+		}
+
+		switch name {
+		case "BEGIN":
+			depth++
+			if strings.EqualFold(value, "VEVENT") && e == nil {
+				e = &Event{}
+				duration = 0
+			}
+			continue
+		case "END":
+			depth--
+			if strings.EqualFold(value, "VEVENT") && e != nil {
+				if e.End.IsZero() {
+					if duration > 0 {
+						e.End = e.Start.Add(duration)
+					} else {
+						e.End = e.Start.AddDate(0, 0, 1)
+					}
+				}
+				events = append(events, *e)
+				e = nil
+			}
+			continue
+		}
+
+		// only properties of the event itself matter, not those of nested components such as alarms
+		if e == nil || depth != 2 {
+			continue
+		}
+
+		switch name {
+		case "UID":
+			e.UID = value
+		case "SEQUENCE":
+			e.Sequence, _ = strconv.Atoi(value)
+		case "DTSTAMP":
+			e.Stamp, _ = parseTime(value, params)
+		case "DTSTART":
+			e.Start, err = parseTime(value, params)
+		case "DTEND":
+			e.End, err = parseTime(value, params)
+		case "DURATION":
+			duration, err = parseDuration(value)
+		case "SUMMARY":
+			e.Summary = unescapeText(value)
+		case "DESCRIPTION":
+			e.Description = unescapeText(value)
+		case "LOCATION":
+			e.Location = unescapeText(value)
+		case "STATUS":
+			e.Status = strings.ToUpper(value)
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", n+1, err)
+		}
+	}
+
+	return events, nil
+}
+
+// unfold returns the content lines of r with folded lines joined and blank lines removed
+func unfold(r io.Reader) ([]string, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 4096), maxLineLength)
+
+	var lines []string
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if line == "" {
+			continue
+		}
+
+		if (line[0] == ' ' || line[0] == '\t') && len(lines) > 0 {
+			last := len(lines) - 1
+			if len(lines[last])+len(line) > maxLineLength {
+				return nil, bufio.ErrTooLong
+			}
+			lines[last] += line[1:]
+			continue
+		}
+
+		lines = append(lines, line)
+	}
+
+	return lines, scanner.Err()
+}
+
+// parseLine splits a content line into its upper case name, its parameters and its value
+func parseLine(line string) (string, map[string]string, string, error) {
+	// the value starts at the first colon that isn't inside a quoted parameter value
+	quoted := false
+	colon := -1
+	for i, c := range line {
+		if c == '"' {
+			quoted = !quoted
+		} else if c == ':' && !quoted {
+			colon = i
+			break
+		}
+	}
+
+	if colon < 0 {
+		return "", nil, "", errors.New("missing value")
+	}
+
+	parts := strings.Split(line[:colon], ";")
+	params := make(map[string]string)
+	for _, p := range parts[1:] {
+		kv := strings.SplitN(p, "=", 2)
+		if len(kv) == 2 {
+			params[strings.ToUpper(kv[0])] = strings.Trim(kv[1], `"`)
+		}
+	}
+
+	return strings.ToUpper(parts[0]), params, line[colon+1:], nil
+}
+
+// parseTime parses a DATE or DATE-TIME value
+func parseTime(value string, params map[string]string) (time.Time, error) {
+	if params["VALUE"] == "DATE" || len(value) == 8 {
+		return time.Parse("20060102", value)
+	}
+
+	if strings.HasSuffix(value, "Z") {
+		return time.Parse("20060102T150405Z", value)
+	}
+
+	loc := time.UTC
+	if tzid := params["TZID"]; tzid != "" {
+		if l, err := time.LoadLocation(tzid); err == nil {
+			loc = l
+		}
+	}
+
+	return time.ParseInLocation("20060102T150405", value, loc)
+}
+
+// parseDuration parses a DURATION value such as P2D, P1W or PT12H30M
+func parseDuration(value string) (time.Duration, error) {
+	s := strings.TrimPrefix(strings.TrimPrefix(value, "+"), "P")
+	if s == value || s == "" {
+		return 0, fmt.Errorf("invalid duration %q", value)
+	}
+
+	var d time.Duration
+	inTime := false
+	num := ""
+	for _, c := range s {
+		switch {
+		case c >= '0' && c <= '9':
+			num += string(c)
+			continue
+		case c == 'T':
+			inTime = true
+			continue
+		}
+
+		n, err := strconv.Atoi(num)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q", value)
+		}
+		num = ""
+
+		switch {
+		case c == 'W' && !inTime:
+			d += time.Duration(n) * 7 * 24 * time.Hour
+		case c == 'D' && !inTime:
+			d += time.Duration(n) * 24 * time.Hour
+		case c == 'H' && inTime:
+			d += time.Duration(n) * time.Hour
+		case c == 'M' && inTime:
+			d += time.Duration(n) * time.Minute
+		case c == 'S' && inTime:
+			d += time.Duration(n) * time.Second
+		default:
+			return 0, fmt.Errorf("invalid duration %q", value)
+		}
+	}
+
+	if num != "" {
+		return 0, fmt.Errorf("invalid duration %q", value)
+	}
+
+	return d, nil
+}
+
+var textUnescaper = strings.NewReplacer(
+	`\\`, `\`,
+	`\;`, ";",
+	`\,`, ",",
+	`\n`, "\n",
+	`\N`, "\n",
+)
+
+// unescapeText reverses escapeText
+func unescapeText(s string) string {
+	return textUnescaper.Replace(s)
+}
@@ -0,0 +1,112 @@
+package ical
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+const testFeed = "BEGIN:VCALENDAR\r\n" +
+	"VERSION:2.0\r\n" +
+	"PRODID:-//Channel//EN\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"UID:all-day@channel\r\n" +
+	"DTSTART;VALUE=DATE:20500201\r\n" +
+	"DTEND;VALUE=DATE:20500204\r\n" +
+	"SUMMARY:Reserved\\, not available\r\n" +
+	"DESCRIPTION:Guest: Jane\\nNights: 3 and a very long description that is\r\n" +
+	"  folded\r\n" +
+	"BEGIN:VALARM\r\n" +
+	"DESCRIPTION:Reminder\r\n" +
+	"END:VALARM\r\n" +
+	"END:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"UID:utc@channel\r\n" +
+	"DTSTART:20500301T140000Z\r\n" +
+	"DTEND:20500302T100000Z\r\n" +
+	"STATUS:cancelled\r\n" +
+	"END:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"UID:zoned@channel\r\n" +
+	"DTSTART;TZID=\"Africa/Johannesburg\":20500401T140000\r\n" +
+	"DURATION:P2DT2H\r\n" +
+	"END:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"UID:no-end@channel\n" +
+	"DTSTART:20500501\n" +
+	"END:VEVENT\r\n" +
+	"END:VCALENDAR\r\n"
+
+func TestParse(t *testing.T) {
+	events, err := Parse(strings.NewReader(testFeed))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(events) != 4 {
+		t.Fatalf("expected 4 events, got %d", len(events))
+	}
+
+	sast := time.FixedZone("SAST", 2*60*60)
+
+	var tests = []struct {
+		name     string
+		got      interface{}
+		expected interface{}
+	}{
+		{"uid", events[0].UID, "all-day@channel"},
+		{"date start", events[0].Start, time.Date(2050, 2, 1, 0, 0, 0, 0, time.UTC)},
+		{"date end", events[0].End, time.Date(2050, 2, 4, 0, 0, 0, 0, time.UTC)},
+		{"unescaped summary", events[0].Summary, "Reserved, not available"},
+		{"unfolded description", events[0].Description, "Guest: Jane\nNights: 3 and a very long description that is folded"},
+		{"utc start", events[1].Start.Equal(time.Date(2050, 3, 1, 14, 0, 0, 0, time.UTC)), true},
+		{"status", events[1].Status, StatusCancelled},
+		{"zoned start", events[2].Start.Equal(time.Date(2050, 4, 1, 14, 0, 0, 0, sast)), true},
+		{"end from duration", events[2].End.Equal(time.Date(2050, 4, 3, 16, 0, 0, 0, sast)), true},
+		{"day without end", events[3].End, time.Date(2050, 5, 2, 0, 0, 0, 0, time.UTC)},
+	}
+
+	for _, e := range tests {
+		if e.got != e.expected {
+			t.Errorf("%s: expected %v, got %v", e.name, e.expected, e.got)
+		}
+	}
+}
+
+func TestParseRoundTrip(t *testing.T) {
+	want := testEvent()
+
+	events, err := Parse(strings.NewReader(string(Calendar{ProdID: "-//Test//EN", Events: []Event{want}}.Bytes())))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(events) != 1 {
+		t.Fatalf("expected 1 event, got %d", len(events))
+	}
+
+	got := events[0]
+	if got.UID != want.UID || got.Summary != want.Summary || got.Description != want.Description ||
+		got.Location != want.Location || !got.Start.Equal(want.Start) || !got.End.Equal(want.End) {
+		t.Errorf("expected %+v, got %+v", want, got)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	var tests = []struct {
+		name  string
+		input string
+	}{
+		{"empty", ""},
+		{"not a calendar", "<html><body>Not found</body></html>"},
+		{"invalid date", "BEGIN:VCALENDAR\r\nBEGIN:VEVENT\r\nDTSTART:2050-02-01\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n"},
+		{"invalid duration", "BEGIN:VCALENDAR\r\nBEGIN:VEVENT\r\nDTSTART:20500201\r\nDURATION:2D\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n"},
+		{"missing value", "BEGIN:VCALENDAR\r\nBEGIN:VEVENT\r\nDTSTART\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n"},
+	}
+
+	for _, e := range tests {
+		if _, err := Parse(strings.NewReader(e.input)); err == nil {
+			t.Errorf("%s: expected an error", e.name)
+		}
+	}
+}
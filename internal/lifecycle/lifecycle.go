@@ -0,0 +1,82 @@
+// Package lifecycle defines the states a reservation moves through, from pending until the guest checks out, is
+// cancelled or doesn't show up, and which changes of state are allowed
+package lifecycle
+
+import "github.com/dhanekom/bookings/internal/models"
+
+// States lists the reservation states in the order a stay moves through them
+var States = []string{
+	models.ReservationPending,
+	models.ReservationConfirmed,
+	models.ReservationCheckedIn,
+	models.ReservationCheckedOut,
+	models.ReservationCancelled,
+	models.ReservationNoShow,
+}
+
+// transitions maps each state to the states it can change to. Checked out, cancelled and no-show are final
+var transitions = map[string][]string{
+	models.ReservationPending:   {models.ReservationConfirmed, models.ReservationCancelled},
+	models.ReservationConfirmed: {models.ReservationCheckedIn, models.ReservationNoShow, models.ReservationCancelled},
+	models.ReservationCheckedIn: {models.ReservationCheckedOut},
+}
+
+var labels = map[string]string{
+	models.ReservationPending:    "Pending",
+	models.ReservationConfirmed:  "Confirmed",
+	models.ReservationCheckedIn:  "Checked in",
+	models.ReservationCheckedOut: "Checked out",
+	models.ReservationCancelled:  "Cancelled",
+	models.ReservationNoShow:     "No-show",
+}
+
+var actions = map[string]string{
+	models.ReservationConfirmed:  "Confirm",
+	models.ReservationCheckedIn:  "Check In",
+	models.ReservationCheckedOut: "Check Out",
+	models.ReservationCancelled:  "Cancel",
+	models.ReservationNoShow:     "Mark as No-Show",
+}
+
+// Valid returns true if status is a reservation state
+func Valid(status string) bool {
+	_, ok := labels[status]
+	return ok
+}
+
+// Next returns the states a reservation in status can change to
+func Next(status string) []string {
+	return transitions[status]
+}
+
+// Can returns true if a reservation in state from can change to state to
+func Can(from, to string) bool {
+	for _, s := range transitions[from] {
+		if s == to {
+			return true
+		}
+	}
+	return false
+}
+
+// Open returns true if the stay of a reservation in status is still ahead, so that the guest can change or
+// cancel it
+func Open(status string) bool {
+	return status == models.ReservationPending || status == models.ReservationConfirmed
+}
+
+// Action returns the name of the action that changes a reservation to status, such as "Check In"
+func Action(status string) string {
+	if a, ok := actions[status]; ok {
+		return a
+	}
+	return Label(status)
+}
+
+// Label returns the name of status shown to people, such as "Checked in"
+func Label(status string) string {
+	if l, ok := labels[status]; ok {
+		return l
+	}
+	return status
+}
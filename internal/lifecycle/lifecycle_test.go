@@ -0,0 +1,58 @@
+package lifecycle
+
+import (
+	"testing"
+
+	"github.com/dhanekom/bookings/internal/models"
+)
+
+func TestCan(t *testing.T) {
+	var tests = []struct {
+		from     string
+		to       string
+		expected bool
+	}{
+		{models.ReservationPending, models.ReservationConfirmed, true},
+		{models.ReservationPending, models.ReservationCancelled, true},
+		{models.ReservationPending, models.ReservationCheckedIn, false},
+		{models.ReservationConfirmed, models.ReservationCheckedIn, true},
+		{models.ReservationConfirmed, models.ReservationNoShow, true},
+		{models.ReservationConfirmed, models.ReservationCancelled, true},
+		{models.ReservationConfirmed, models.ReservationCheckedOut, false},
+		{models.ReservationCheckedIn, models.ReservationCheckedOut, true},
+		{models.ReservationCheckedIn, models.ReservationCancelled, false},
+		{models.ReservationCheckedOut, models.ReservationCheckedIn, false},
+		{models.ReservationCancelled, models.ReservationConfirmed, false},
+		{models.ReservationNoShow, models.ReservationCheckedIn, false},
+		{models.ReservationConfirmed, models.ReservationConfirmed, false},
+		{"unknown", models.ReservationConfirmed, false},
+	}
+
+	for _, e := range tests {
+		if got := Can(e.from, e.to); got != e.expected {
+			t.Errorf("%s to %s: expected %v, got %v", e.from, e.to, e.expected, got)
+		}
+	}
+}
+
+func TestStates(t *testing.T) {
+	for _, s := range States {
+		if !Valid(s) {
+			t.Errorf("expected %s to be valid", s)
+		}
+
+		for _, next := range Next(s) {
+			if !Valid(next) {
+				t.Errorf("%s changes to the unknown state %s", s, next)
+			}
+		}
+	}
+
+	if Valid("processed") {
+		t.Error("expected processed not to be a state")
+	}
+
+	if Label(models.ReservationCheckedIn) != "Checked in" || Label("unknown") != "unknown" {
+		t.Error("unexpected labels")
+	}
+}
@@ -0,0 +1,100 @@
+// Package mailer sends email through SMTP from a persistent, retrying outbox
+package mailer
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/dhanekom/bookings/internal/models"
+	mail "github.com/xhit/go-simple-mail/v2"
+)
+
+// Sender sends a single message
+type Sender interface {
+	Send(m models.MailData) error
+}
+
+// Config holds the settings of the SMTP server mail is sent through
+type Config struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	// Encryption is one of none, starttls or ssl
+	Encryption string
+	Timeout    time.Duration
+}
+
+// DefaultConfig sends unauthenticated, unencrypted mail to a local development server
+var DefaultConfig = Config{
+	Host:       "localhost",
+	Port:       1025,
+	Encryption: "none",
+	Timeout:    10 * time.Second,
+}
+
+// ParseEncryption converts an encryption setting to its go-simple-mail type
+func ParseEncryption(s string) (mail.Encryption, error) {
+	switch strings.ToLower(s) {
+	case "", "none":
+		return mail.EncryptionNone, nil
+	case "starttls":
+		return mail.EncryptionSTARTTLS, nil
+	case "ssl", "tls":
+		return mail.EncryptionSSLTLS, nil
+	}
+
+	return mail.EncryptionNone, fmt.Errorf("unknown smtp encryption %q", s)
+}
+
+// SMTPSender sends mail through an SMTP server, opening a new connection for every message
+type SMTPSender struct {
+	Config     Config
+	encryption mail.Encryption
+}
+
+// NewSMTPSender returns a sender for the SMTP server described by c
+func NewSMTPSender(c Config) (*SMTPSender, error) {
+	encryption, err := ParseEncryption(c.Encryption)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SMTPSender{Config: c, encryption: encryption}, nil
+}
+
+// Send sends m with its HTML content, its plain text alternative if it has one and its attachments
+func (s *SMTPSender) Send(m models.MailData) error {
+	server := mail.NewSMTPClient()
+	server.Host = s.Config.Host
+	server.Port = s.Config.Port
+	server.Username = s.Config.Username
+	server.Password = s.Config.Password
+	server.Encryption = s.encryption
+	server.KeepAlive = false
+	if s.Config.Timeout > 0 {
+		server.ConnectTimeout = s.Config.Timeout
+		server.SendTimeout = s.Config.Timeout
+	}
+
+	client, err := server.Connect()
+	if err != nil {
+		return err
+	}
+
+	email := mail.NewMSG()
+	email.SetFrom(m.From).AddTo(m.To).SetSubject(m.Subject)
+	if m.Text != "" {
+		email.SetBody(mail.TextPlain, m.Text)
+		email.AddAlternative(mail.TextHTML, m.Content)
+	} else {
+		email.SetBody(mail.TextHTML, m.Content)
+	}
+
+	for _, a := range m.Attachments {
+		email.Attach(&mail.File{Name: a.Filename, MimeType: a.ContentType, Data: a.Data})
+	}
+
+	return email.Send(client)
+}
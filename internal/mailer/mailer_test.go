@@ -0,0 +1,248 @@
+package mailer
+
+import (
+	"bufio"
+	"encoding/base64"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/dhanekom/bookings/internal/models"
+)
+
+// fakeMessage is a message received by a fakeSMTPServer
+type fakeMessage struct {
+	From string
+	To   []string
+	Data string
+}
+
+// fakeSMTPServer is a minimal SMTP server that accepts mail on a local port
+type fakeSMTPServer struct {
+	listener net.Listener
+	// username and password are required with AUTH PLAIN if username is set
+	username string
+	password string
+	// reject makes the server reject every recipient
+	reject bool
+
+	mu       sync.Mutex
+	messages []fakeMessage
+}
+
+func newFakeSMTPServer(t *testing.T) *fakeSMTPServer {
+	s := &fakeSMTPServer{}
+	s.start(t)
+	return s
+}
+
+// start listens on a free local port until the test is done
+func (s *fakeSMTPServer) start(t *testing.T) {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s.listener = l
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+}
+
+// config returns a sender config that points at the server
+func (s *fakeSMTPServer) config() Config {
+	host, port, _ := net.SplitHostPort(s.listener.Addr().String())
+	p, _ := strconv.Atoi(port)
+
+	c := DefaultConfig
+	c.Host = host
+	c.Port = p
+	return c
+}
+
+func (s *fakeSMTPServer) received() []fakeMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]fakeMessage(nil), s.messages...)
+}
+
+func (s *fakeSMTPServer) serve(conn net.Conn) {
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+	reply := func(line string) {
+		conn.Write([]byte(line + "\r\n"))
+	}
+
+	authenticated := s.username == ""
+	var msg fakeMessage
+
+	reply("220 fake ESMTP")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		verb := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+
+		switch {
+		case verb == "EHLO" || verb == "HELO":
+			if s.username != "" {
+				reply("250-fake")
+				reply("250 AUTH PLAIN")
+			} else {
+				reply("250 fake")
+			}
+		case verb == "AUTH":
+			fields := strings.Fields(line)
+			creds, _ := base64.StdEncoding.DecodeString(fields[len(fields)-1])
+			if string(creds) == "\x00"+s.username+"\x00"+s.password {
+				authenticated = true
+				reply("235 authenticated")
+			} else {
+				reply("535 authentication failed")
+			}
+		case !authenticated && (verb == "MAIL" || verb == "RCPT" || verb == "DATA"):
+			reply("530 authentication required")
+		case verb == "MAIL":
+			msg = fakeMessage{From: strings.TrimSuffix(strings.TrimPrefix(line[5:], "FROM:<"), ">")}
+			reply("250 ok")
+		case verb == "RCPT":
+			if s.reject {
+				reply("550 mailbox unavailable")
+				continue
+			}
+			msg.To = append(msg.To, strings.TrimSuffix(strings.TrimPrefix(line[5:], "TO:<"), ">"))
+			reply("250 ok")
+		case verb == "DATA":
+			reply("354 go ahead")
+			var data strings.Builder
+			for {
+				l, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if l == ".\r\n" {
+					break
+				}
+				data.WriteString(l)
+			}
+			msg.Data = data.String()
+			s.mu.Lock()
+			s.messages = append(s.messages, msg)
+			s.mu.Unlock()
+			reply("250 queued")
+		case verb == "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("250 ok")
+		}
+	}
+}
+
+func TestSMTPSender(t *testing.T) {
+	var tests = []struct {
+		name     string
+		username string
+		password string
+		reject   bool
+		login    string
+		text     string
+		attach   bool
+		wantErr  bool
+		wantBody string
+	}{
+		{name: "no auth", wantBody: "Hello"},
+		{name: "text alternative", text: "Plain hello", wantBody: "multipart/alternative"},
+		{name: "text part", text: "Plain hello", wantBody: "Plain hello"},
+		{name: "attachment", attach: true, wantBody: "Content-Type: text/calendar; method=REQUEST"},
+		{name: "attachment name", attach: true, wantBody: `filename="invite.ics"`},
+		{name: "auth", username: "mailer", password: "secret", login: "secret", wantBody: "Hello"},
+		{name: "wrong password", username: "mailer", password: "secret", login: "wrong", wantErr: true},
+		{name: "rejected", reject: true, wantErr: true},
+	}
+
+	for _, e := range tests {
+		server := &fakeSMTPServer{username: e.username, password: e.password, reject: e.reject}
+		server.start(t)
+
+		c := server.config()
+		if e.username != "" {
+			c.Username = e.username
+			c.Password = e.login
+		}
+
+		sender, err := NewSMTPSender(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		m := models.MailData{
+			To:      "guest@here.com",
+			From:    "me@here.com",
+			Subject: "Test",
+			Content: "<p>Hello</p>",
+			Text:    e.text,
+		}
+		if e.attach {
+			m.Attachments = []models.Attachment{
+				{Filename: "invite.ics", ContentType: "text/calendar; method=REQUEST", Data: []byte("BEGIN:VCALENDAR")},
+			}
+		}
+
+		err = sender.Send(m)
+
+		if e.wantErr {
+			if err == nil {
+				t.Errorf("%s: expected an error", e.name)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", e.name, err)
+			continue
+		}
+
+		received := server.received()
+		if len(received) != 1 {
+			t.Errorf("%s: expected 1 message to be received but got %d", e.name, len(received))
+			continue
+		}
+
+		if received[0].From != "me@here.com" || len(received[0].To) != 1 || received[0].To[0] != "guest@here.com" {
+			t.Errorf("%s: unexpected envelope %+v", e.name, received[0])
+		}
+
+		if !strings.Contains(received[0].Data, e.wantBody) {
+			t.Errorf("%s: expected the message to contain %q but got %s", e.name, e.wantBody, received[0].Data)
+		}
+	}
+}
+
+func TestParseEncryption(t *testing.T) {
+	for _, s := range []string{"", "none", "starttls", "STARTTLS", "ssl", "tls"} {
+		if _, err := ParseEncryption(s); err != nil {
+			t.Errorf("unexpected error for %q: %s", s, err)
+		}
+	}
+
+	if _, err := ParseEncryption("rot13"); err == nil {
+		t.Error("expected an error for an unknown encryption")
+	}
+}
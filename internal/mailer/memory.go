@@ -0,0 +1,164 @@
+package mailer
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/dhanekom/bookings/internal/models"
+)
+
+// errUnknownMessage is returned when a message that was claimed disappeared from a memory store
+var errUnknownMessage = errors.New("unknown message")
+
+type memoryStore struct {
+	mu       sync.Mutex
+	messages []Message
+}
+
+// NewMemoryStore returns a store that keeps the outbox in memory. Queued mail is lost on restart
+func NewMemoryStore() Store {
+	return &memoryStore{}
+}
+
+func (m *memoryStore) Insert(mail models.MailData, now time.Time) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	msg := Message{
+		ID:            len(m.messages) + 1,
+		Mail:          mail,
+		Status:        StatusPending,
+		NextAttemptAt: now,
+		CreateAt:      now,
+		UpdatedAt:     now,
+	}
+	m.messages = append(m.messages, msg)
+
+	return msg.ID, nil
+}
+
+// get returns the message with id, or nil if there is none. The caller must hold the lock
+func (m *memoryStore) get(id int) *Message {
+	if id < 1 || id > len(m.messages) {
+		return nil
+	}
+
+	return &m.messages[id-1]
+}
+
+func (m *memoryStore) Claim(now, lockUntil time.Time) (Message, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var next *Message
+	for i := range m.messages {
+		msg := &m.messages[i]
+		if msg.Status != StatusPending || msg.NextAttemptAt.After(now) || msg.LockedUntil.After(now) {
+			continue
+		}
+
+		if next == nil || msg.NextAttemptAt.Before(next.NextAttemptAt) {
+			next = msg
+		}
+	}
+
+	if next == nil {
+		return Message{}, ErrNoMessage
+	}
+
+	next.Attempts++
+	next.LockedUntil = lockUntil
+	next.UpdatedAt = now
+
+	return *next, nil
+}
+
+func (m *memoryStore) MarkSent(id int, now time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	msg := m.get(id)
+	if msg == nil {
+		return errUnknownMessage
+	}
+
+	msg.Status = StatusSent
+	msg.SentAt = now
+	msg.LockedUntil = time.Time{}
+	msg.UpdatedAt = now
+
+	return nil
+}
+
+func (m *memoryStore) MarkFailed(id int, lastError string, next time.Time, dead bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	msg := m.get(id)
+	if msg == nil {
+		return errUnknownMessage
+	}
+
+	msg.LastError = lastError
+	msg.NextAttemptAt = next
+	msg.LockedUntil = time.Time{}
+	if dead {
+		msg.Status = StatusDead
+	}
+
+	return nil
+}
+
+func (m *memoryStore) Resend(id int, now time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	msg := m.get(id)
+	if msg == nil || msg.Status == StatusSent {
+		return ErrNotResendable
+	}
+
+	msg.Status = StatusPending
+	msg.Attempts = 0
+	msg.NextAttemptAt = now
+	msg.LockedUntil = time.Time{}
+	msg.UpdatedAt = now
+
+	return nil
+}
+
+func (m *memoryStore) List(status string, limit int) ([]Message, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var messages []Message
+	for _, msg := range m.messages {
+		if status == "" || msg.Status == status {
+			messages = append(messages, msg)
+		}
+	}
+
+	sort.Slice(messages, func(i, j int) bool {
+		return messages[i].ID > messages[j].ID
+	})
+
+	if limit > 0 && len(messages) > limit {
+		messages = messages[:limit]
+	}
+
+	return messages, nil
+}
+
+func (m *memoryStore) Counts() (map[string]int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	counts := make(map[string]int)
+	for _, msg := range m.messages {
+		counts[msg.Status]++
+	}
+
+	return counts, nil
+}
@@ -0,0 +1,226 @@
+package mailer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/dhanekom/bookings/internal/background"
+	"github.com/dhanekom/bookings/internal/models"
+)
+
+// Message statuses
+const (
+	StatusPending = "pending"
+	StatusSent    = "sent"
+	StatusDead    = "dead"
+)
+
+// ErrNoMessage is returned by Store.Claim when no message is due to be sent
+var ErrNoMessage = errors.New("no message is due to be sent")
+
+// ErrNotResendable is returned by Store.Resend when the message doesn't exist or was already sent
+var ErrNotResendable = errors.New("message can't be resent")
+
+// Message is a message in the outbox
+type Message struct {
+	ID            int
+	Mail          models.MailData
+	Status        string
+	Attempts      int
+	LastError     string
+	NextAttemptAt time.Time
+	LockedUntil   time.Time
+	SentAt        time.Time
+	CreateAt      time.Time
+	UpdatedAt     time.Time
+}
+
+// Store holds the outbox. Implementations must be safe for concurrent use
+type Store interface {
+	// Insert adds a pending message that is due at now and returns its id
+	Insert(m models.MailData, now time.Time) (int, error)
+	// Claim locks the next pending message that is due at now until lockUntil, counts an attempt to send it
+	// and returns it. It returns ErrNoMessage if no message is due
+	Claim(now, lockUntil time.Time) (Message, error)
+	// MarkSent records that the message was sent at now
+	MarkSent(id int, now time.Time) error
+	// MarkFailed records why sending the message failed. A dead message is never retried, otherwise it is
+	// retried at next
+	MarkFailed(id int, lastError string, next time.Time, dead bool) error
+	// Resend makes an unsent message due again at now with no attempts counted
+	Resend(id int, now time.Time) error
+	// List returns the latest limit messages with the given status, or of any status if status is empty
+	List(status string, limit int) ([]Message, error)
+	// Counts returns the number of messages with each status
+	Counts() (map[string]int, error)
+}
+
+// Outbox queues mail and sends it from a pool of workers, retrying failures with an exponential backoff until
+// a message is dead-lettered after MaxAttempts
+type Outbox struct {
+	Store  Store
+	Sender Sender
+	Clock  background.Clock
+	// Templates renders messages that have a template when they are queued
+	Templates *Templates
+	// Workers is the number of messages sent at the same time
+	Workers int
+	// PollInterval is how often idle workers look for messages that are due
+	PollInterval time.Duration
+	// LockFor is how long a claimed message is hidden from other workers while it is sent
+	LockFor time.Duration
+	// MaxAttempts is the number of attempts after which a message is dead
+	MaxAttempts int
+	// BaseDelay is the delay after the first failed attempt, doubling with each further failure
+	BaseDelay time.Duration
+	// MaxDelay caps the retry delay
+	MaxDelay time.Duration
+	ErrorLog *log.Logger
+
+	wake chan struct{}
+}
+
+// NewOutbox returns an outbox with default retry settings. A nil clock uses the system time
+func NewOutbox(store Store, sender Sender, clock background.Clock) *Outbox {
+	if clock == nil {
+		clock = background.SystemClock{}
+	}
+
+	return &Outbox{
+		Store:        store,
+		Sender:       sender,
+		Clock:        clock,
+		Workers:      2,
+		PollInterval: 10 * time.Second,
+		LockFor:      5 * time.Minute,
+		MaxAttempts:  8,
+		BaseDelay:    30 * time.Second,
+		MaxDelay:     2 * time.Hour,
+		wake:         make(chan struct{}, 1),
+	}
+}
+
+// Queue renders m if it has a template and adds it to the outbox. It doesn't wait for the message to be sent
+func (o *Outbox) Queue(m models.MailData) error {
+	if m.Template != "" {
+		if o.Templates == nil {
+			return fmt.Errorf("no mail templates to render %s", m.Template)
+		}
+
+		var err error
+		m, err = o.Templates.Render(m)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err := o.Store.Insert(m, o.Clock.Now())
+	if err != nil {
+		return err
+	}
+
+	o.notify()
+	return nil
+}
+
+// Resend makes the message with id due again, resetting its attempts
+func (o *Outbox) Resend(id int) error {
+	err := o.Store.Resend(id, o.Clock.Now())
+	if err != nil {
+		return err
+	}
+
+	o.notify()
+	return nil
+}
+
+// notify wakes up an idle worker, if any
+func (o *Outbox) notify() {
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Delay returns the retry delay after the given number of failed attempts
+func (o *Outbox) Delay(attempts int) time.Duration {
+	if attempts <= 0 {
+		return 0
+	}
+
+	delay := o.BaseDelay
+	for i := 1; i < attempts && delay < o.MaxDelay; i++ {
+		delay *= 2
+	}
+
+	if delay > o.MaxDelay {
+		delay = o.MaxDelay
+	}
+
+	return delay
+}
+
+// SendNext sends the next message that is due. It returns ErrNoMessage if there is none. A failure to send
+// the message is recorded against it and isn't returned
+func (o *Outbox) SendNext() error {
+	now := o.Clock.Now()
+
+	msg, err := o.Store.Claim(now, now.Add(o.LockFor))
+	if err != nil {
+		return err
+	}
+
+	sendErr := o.Sender.Send(msg.Mail)
+	now = o.Clock.Now()
+
+	if sendErr == nil {
+		return o.Store.MarkSent(msg.ID, now)
+	}
+
+	dead := msg.Attempts >= o.MaxAttempts
+	if o.ErrorLog != nil {
+		if dead {
+			o.ErrorLog.Printf("mail %d to %s is dead after %d attempts: %s", msg.ID, msg.Mail.To, msg.Attempts, sendErr)
+		} else {
+			o.ErrorLog.Printf("mail %d to %s failed, attempt %d: %s", msg.ID, msg.Mail.To, msg.Attempts, sendErr)
+		}
+	}
+
+	return o.Store.MarkFailed(msg.ID, sendErr.Error(), now.Add(o.Delay(msg.Attempts)), dead)
+}
+
+// Start runs the workers until ctx is done. The returned wait group is done once all workers have stopped
+func (o *Outbox) Start(ctx context.Context) *sync.WaitGroup {
+	var wg sync.WaitGroup
+
+	for i := 0; i < o.Workers; i++ {
+		background.Job{
+			Run:      o.sendDue,
+			Interval: o.PollInterval,
+			Trigger:  o.wake,
+			ErrorLog: o.ErrorLog,
+		}.Start(ctx, &wg)
+	}
+
+	return &wg
+}
+
+// sendDue sends messages until none are due, or one fails to send
+func (o *Outbox) sendDue(ctx context.Context) error {
+	for ctx.Err() == nil {
+		err := o.SendNext()
+		if errors.Is(err, ErrNoMessage) {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,237 @@
+package mailer
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dhanekom/bookings/internal/models"
+)
+
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = c.now.Add(d)
+}
+
+// fakeSender fails the first failures sends
+type fakeSender struct {
+	mu       sync.Mutex
+	failures int
+	sent     []models.MailData
+}
+
+func (s *fakeSender) Send(m models.MailData) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.failures > 0 {
+		s.failures--
+		return errors.New("connection refused")
+	}
+
+	s.sent = append(s.sent, m)
+	return nil
+}
+
+func newTestOutbox(sender Sender) (*Outbox, *fakeClock) {
+	clock := &fakeClock{now: time.Date(2050, 1, 1, 12, 0, 0, 0, time.UTC)}
+	o := NewOutbox(NewMemoryStore(), sender, clock)
+	o.MaxAttempts = 3
+	o.BaseDelay = time.Minute
+	o.MaxDelay = 10 * time.Minute
+
+	return o, clock
+}
+
+func TestOutboxDelay(t *testing.T) {
+	o, _ := newTestOutbox(&fakeSender{})
+
+	var tests = []struct {
+		attempts int
+		expected time.Duration
+	}{
+		{0, 0},
+		{1, time.Minute},
+		{2, 2 * time.Minute},
+		{3, 4 * time.Minute},
+		{4, 8 * time.Minute},
+		{5, 10 * time.Minute},
+		{50, 10 * time.Minute},
+	}
+
+	for _, e := range tests {
+		if got := o.Delay(e.attempts); got != e.expected {
+			t.Errorf("Delay(%d): expected %s but got %s", e.attempts, e.expected, got)
+		}
+	}
+}
+
+func TestOutboxRetry(t *testing.T) {
+	sender := &fakeSender{failures: 2}
+	o, clock := newTestOutbox(sender)
+
+	err := o.Queue(models.MailData{To: "guest@here.com", Subject: "Test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// first attempt fails and is retried after a minute
+	if err := o.SendNext(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := o.SendNext(); !errors.Is(err, ErrNoMessage) {
+		t.Errorf("expected ErrNoMessage before the retry delay but got %v", err)
+	}
+
+	clock.Advance(time.Minute)
+	if err := o.SendNext(); err != nil {
+		t.Fatal(err)
+	}
+
+	// second failure doubles the delay
+	clock.Advance(time.Minute)
+	if err := o.SendNext(); !errors.Is(err, ErrNoMessage) {
+		t.Errorf("expected ErrNoMessage before the doubled retry delay but got %v", err)
+	}
+
+	clock.Advance(time.Minute)
+	if err := o.SendNext(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(sender.sent) != 1 {
+		t.Fatalf("expected 1 message to be sent but got %d", len(sender.sent))
+	}
+
+	messages, _ := o.Store.List("", 0)
+	if messages[0].Status != StatusSent || messages[0].Attempts != 3 || messages[0].SentAt.IsZero() {
+		t.Errorf("unexpected message after sending %+v", messages[0])
+	}
+}
+
+func TestOutboxDeadLetterAndResend(t *testing.T) {
+	sender := &fakeSender{failures: 3}
+	o, clock := newTestOutbox(sender)
+
+	err := o.Queue(models.MailData{To: "guest@here.com", Subject: "Test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < o.MaxAttempts; i++ {
+		if err := o.SendNext(); err != nil {
+			t.Fatal(err)
+		}
+		clock.Advance(time.Hour)
+	}
+
+	if err := o.SendNext(); !errors.Is(err, ErrNoMessage) {
+		t.Errorf("expected a dead message not to be retried but got %v", err)
+	}
+
+	dead, _ := o.Store.List(StatusDead, 0)
+	if len(dead) != 1 || dead[0].LastError != "connection refused" {
+		t.Fatalf("expected 1 dead message with its last error but got %+v", dead)
+	}
+
+	counts, _ := o.Store.Counts()
+	if counts[StatusDead] != 1 || counts[StatusPending] != 0 {
+		t.Errorf("unexpected counts %v", counts)
+	}
+
+	// a resent message starts over and is sent straight away
+	if err := o.Resend(dead[0].ID); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := o.SendNext(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(sender.sent) != 1 {
+		t.Fatalf("expected the resent message to be sent but got %d", len(sender.sent))
+	}
+
+	if err := o.Resend(dead[0].ID); !errors.Is(err, ErrNotResendable) {
+		t.Errorf("expected a sent message not to be resendable but got %v", err)
+	}
+
+	if err := o.Resend(99); !errors.Is(err, ErrNotResendable) {
+		t.Errorf("expected an unknown message not to be resendable but got %v", err)
+	}
+}
+
+func TestOutboxClaimLocks(t *testing.T) {
+	o, clock := newTestOutbox(&fakeSender{})
+
+	o.Queue(models.MailData{To: "guest@here.com"})
+
+	now := clock.Now()
+	if _, err := o.Store.Claim(now, now.Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := o.Store.Claim(now, now.Add(time.Minute)); !errors.Is(err, ErrNoMessage) {
+		t.Errorf("expected a claimed message to be locked but got %v", err)
+	}
+
+	// a worker that dies while sending loses its claim when the lock expires
+	if _, err := o.Store.Claim(now.Add(time.Minute), now.Add(2*time.Minute)); err != nil {
+		t.Errorf("expected the message to be claimable once the lock expired but got %v", err)
+	}
+}
+
+func TestOutboxWorkersSendOverSMTP(t *testing.T) {
+	server := newFakeSMTPServer(t)
+
+	sender, err := NewSMTPSender(server.config())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	o := NewOutbox(NewMemoryStore(), sender, nil)
+	o.PollInterval = 10 * time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	wg := o.Start(ctx)
+
+	for _, to := range []string{"a@here.com", "b@here.com", "c@here.com"} {
+		if err := o.Queue(models.MailData{To: to, From: "me@here.com", Subject: "Test", Content: "Hello"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(server.received()) < 3 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	cancel()
+	wg.Wait()
+
+	if n := len(server.received()); n != 3 {
+		t.Errorf("expected 3 messages to be received but got %d", n)
+	}
+
+	counts, _ := o.Store.Counts()
+	if counts[StatusSent] != 3 {
+		t.Errorf("expected 3 sent messages but got %v", counts)
+	}
+}
@@ -0,0 +1,230 @@
+package mailer
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/dhanekom/bookings/internal/models"
+)
+
+type postgresStore struct {
+	DB *sql.DB
+}
+
+// NewPostgresStore returns a store that keeps the outbox in the outbox table
+func NewPostgresStore(db *sql.DB) Store {
+	return &postgresStore{DB: db}
+}
+
+const messageColumns = `id, to_address, from_address, subject, content, text_content, template, attachments,
+	status, attempts, last_error, next_attempt_at, locked_until, sent_at, created_at, updated_at`
+
+func (m *postgresStore) Insert(mail models.MailData, now time.Time) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	attachments, err := encodeAttachments(mail.Attachments)
+	if err != nil {
+		return 0, err
+	}
+
+	stmt := `insert into outbox (to_address, from_address, subject, content, text_content, template, attachments,
+	         status, attempts, last_error, next_attempt_at, created_at, updated_at)
+	         values ($1, $2, $3, $4, $5, $6, $7, $8, 0, '', $9, $10, $10) returning id`
+
+	var id int
+	err = m.DB.QueryRowContext(ctx, stmt, mail.To, mail.From, mail.Subject, mail.Content, mail.Text, mail.Template,
+		attachments, StatusPending, now, time.Now()).Scan(&id)
+
+	return id, err
+}
+
+func (m *postgresStore) Claim(now, lockUntil time.Time) (Message, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	// skip locked lets several workers, in this or other instances, claim different messages at the same time
+	stmt := `update outbox set attempts = attempts + 1, locked_until = $1, updated_at = $2
+	         where id = (
+	           select id from outbox
+	           where status = $3 and next_attempt_at <= $4 and (locked_until is null or locked_until <= $4)
+	           order by next_attempt_at, id
+	           limit 1
+	           for update skip locked
+	         )
+	         returning ` + messageColumns
+
+	msg, err := scanMessage(m.DB.QueryRowContext(ctx, stmt, lockUntil, time.Now(), StatusPending, now))
+	if errors.Is(err, sql.ErrNoRows) {
+		return msg, ErrNoMessage
+	}
+
+	return msg, err
+}
+
+func (m *postgresStore) MarkSent(id int, now time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	stmt := `update outbox set status = $1, sent_at = $2, locked_until = null, updated_at = $3 where id = $4`
+
+	_, err := m.DB.ExecContext(ctx, stmt, StatusSent, now, time.Now(), id)
+	return err
+}
+
+func (m *postgresStore) MarkFailed(id int, lastError string, next time.Time, dead bool) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	status := StatusPending
+	if dead {
+		status = StatusDead
+	}
+
+	stmt := `update outbox set status = $1, last_error = $2, next_attempt_at = $3, locked_until = null,
+	         updated_at = $4 where id = $5`
+
+	_, err := m.DB.ExecContext(ctx, stmt, status, lastError, next, time.Now(), id)
+	return err
+}
+
+func (m *postgresStore) Resend(id int, now time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	stmt := `update outbox set status = $1, attempts = 0, next_attempt_at = $2, locked_until = null,
+	         updated_at = $3 where id = $4 and status <> $5`
+
+	result, err := m.DB.ExecContext(ctx, stmt, StatusPending, now, time.Now(), id, StatusSent)
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return ErrNotResendable
+	}
+
+	return nil
+}
+
+func (m *postgresStore) List(status string, limit int) ([]Message, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	query := `select ` + messageColumns + ` from outbox
+	          where ($1 = '' or status = $1)
+	          order by id desc
+	          limit $2`
+
+	if limit <= 0 {
+		limit = 1000
+	}
+
+	rows, err := m.DB.QueryContext(ctx, query, status, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var messages []Message
+	for rows.Next() {
+		msg, err := scanMessage(rows)
+		if err != nil {
+			return messages, err
+		}
+		messages = append(messages, msg)
+	}
+
+	return messages, rows.Err()
+}
+
+func (m *postgresStore) Counts() (map[string]int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, `select status, count(*) from outbox group by status`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make(map[string]int)
+	for rows.Next() {
+		var status string
+		var n int
+		err := rows.Scan(&status, &n)
+		if err != nil {
+			return counts, err
+		}
+		counts[status] = n
+	}
+
+	return counts, rows.Err()
+}
+
+// scanner is implemented by *sql.Row and *sql.Rows
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanMessage(row scanner) (Message, error) {
+	var msg Message
+	var lockedUntil, sentAt sql.NullTime
+	var attachments string
+
+	err := row.Scan(
+		&msg.ID,
+		&msg.Mail.To,
+		&msg.Mail.From,
+		&msg.Mail.Subject,
+		&msg.Mail.Content,
+		&msg.Mail.Text,
+		&msg.Mail.Template,
+		&attachments,
+		&msg.Status,
+		&msg.Attempts,
+		&msg.LastError,
+		&msg.NextAttemptAt,
+		&lockedUntil,
+		&sentAt,
+		&msg.CreateAt,
+		&msg.UpdatedAt,
+	)
+	if err != nil {
+		return msg, err
+	}
+
+	msg.LockedUntil = lockedUntil.Time
+	msg.SentAt = sentAt.Time
+
+	msg.Mail.Attachments, err = decodeAttachments(attachments)
+	return msg, err
+}
+
+// encodeAttachments stores attachments as JSON, or as an empty string if there are none
+func encodeAttachments(attachments []models.Attachment) (string, error) {
+	if len(attachments) == 0 {
+		return "", nil
+	}
+
+	data, err := json.Marshal(attachments)
+	return string(data), err
+}
+
+func decodeAttachments(s string) ([]models.Attachment, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	var attachments []models.Attachment
+	err := json.Unmarshal([]byte(s), &attachments)
+	return attachments, err
+}
@@ -0,0 +1,149 @@
+package mailer
+
+import (
+	"bytes"
+	"fmt"
+	htmltemplate "html/template"
+	"path/filepath"
+	"strings"
+	texttemplate "text/template"
+	"time"
+
+	"github.com/dhanekom/bookings/internal/models"
+)
+
+// functions are available in all mail templates
+var functions = map[string]interface{}{
+	"humanDate":  humanDate,
+	"formatDate": formatDate,
+}
+
+// humanDate formats a time as YYYY-MM-DD
+func humanDate(t time.Time) string {
+	return t.Format("2006-01-02")
+}
+
+// formatDate formats a time using the given layout
+func formatDate(t time.Time, f string) string {
+	return t.Format(f)
+}
+
+// Templates renders messages from the templates in a directory. A message named "name" has its HTML part in
+// name.html and its plain text part in name.txt. Both may use the layouts in *.layout.html and *.layout.txt and
+// the partials in *.partial.html and *.partial.txt
+type Templates struct {
+	Path string
+	// UseCache renders from the templates parsed by NewTemplates instead of parsing them for every message
+	UseCache bool
+
+	html map[string]*htmltemplate.Template
+	text map[string]*texttemplate.Template
+}
+
+// NewTemplates parses the templates in path
+func NewTemplates(path string, useCache bool) (*Templates, error) {
+	t := &Templates{Path: path, UseCache: useCache}
+
+	var err error
+	t.html, t.text, err = createTemplateCache(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// createTemplateCache parses the HTML and plain text templates in path, keyed by message name
+func createTemplateCache(path string) (map[string]*htmltemplate.Template, map[string]*texttemplate.Template, error) {
+	htmlCache := map[string]*htmltemplate.Template{}
+	textCache := map[string]*texttemplate.Template{}
+
+	pages, err := filepath.Glob(filepath.Join(path, "*.html"))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, page := range pages {
+		file := filepath.Base(page)
+		if strings.HasSuffix(file, ".layout.html") || strings.HasSuffix(file, ".partial.html") {
+			continue
+		}
+		name := strings.TrimSuffix(file, ".html")
+
+		ht, err := htmltemplate.New(file).Funcs(functions).ParseFiles(page)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		for _, pattern := range []string{"*.layout.html", "*.partial.html"} {
+			matches, err := filepath.Glob(filepath.Join(path, pattern))
+			if err != nil {
+				return nil, nil, err
+			}
+			if len(matches) > 0 {
+				ht, err = ht.ParseFiles(matches...)
+				if err != nil {
+					return nil, nil, err
+				}
+			}
+		}
+		htmlCache[name] = ht
+
+		textPage := filepath.Join(path, name+".txt")
+		tt, err := texttemplate.New(name + ".txt").Funcs(functions).ParseFiles(textPage)
+		if err != nil {
+			return nil, nil, fmt.Errorf("mail template %s has no plain text part - %s", name, err)
+		}
+
+		for _, pattern := range []string{"*.layout.txt", "*.partial.txt"} {
+			matches, err := filepath.Glob(filepath.Join(path, pattern))
+			if err != nil {
+				return nil, nil, err
+			}
+			if len(matches) > 0 {
+				tt, err = tt.ParseFiles(matches...)
+				if err != nil {
+					return nil, nil, err
+				}
+			}
+		}
+		textCache[name] = tt
+	}
+
+	return htmlCache, textCache, nil
+}
+
+// Render returns m with its HTML content and plain text rendered from m.Template and m.Data
+func (t *Templates) Render(m models.MailData) (models.MailData, error) {
+	htmlCache, textCache := t.html, t.text
+	if !t.UseCache {
+		var err error
+		htmlCache, textCache, err = createTemplateCache(t.Path)
+		if err != nil {
+			return m, err
+		}
+	}
+
+	ht, ok := htmlCache[m.Template]
+	if !ok {
+		return m, fmt.Errorf("unable to find mail template %s", m.Template)
+	}
+
+	var buf bytes.Buffer
+	err := ht.Execute(&buf, m)
+	if err != nil {
+		return m, err
+	}
+	html := strings.TrimSpace(buf.String())
+
+	buf.Reset()
+	err = textCache[m.Template].Execute(&buf, m)
+	if err != nil {
+		return m, err
+	}
+
+	m.Content = html
+	m.Text = strings.TrimSpace(buf.String())
+
+	return m, nil
+}
@@ -0,0 +1,153 @@
+package mailer
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dhanekom/bookings/internal/models"
+)
+
+var testTemplates = map[string]string{
+	"base.layout.html":   `{{define "base"}}<html><title>{{.Subject}}</title><body>{{template "content" .}}</body></html>{{end}}`,
+	"base.layout.txt":    `{{define "base"}}{{template "content" .}}` + "\n-- \nFort Smythe{{end}}",
+	"guest.partial.html": `{{define "guest"}}<b>{{.}}</b>{{end}}`,
+	"guest.partial.txt":  `{{define "guest"}}{{.}}{{end}}`,
+	"hello.html":         `{{template "base" .}}{{define "content"}}<p>Dear {{template "guest" (index .Data "name")}}, see you on {{humanDate (index .Data "date")}}</p>{{end}}`,
+	"hello.txt":          `{{template "base" .}}{{define "content"}}Dear {{template "guest" (index .Data "name")}}, see you on {{humanDate (index .Data "date")}}{{end}}`,
+}
+
+func writeTestTemplates(t *testing.T, files map[string]string) string {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "email-templates")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	for name, content := range files {
+		err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return dir
+}
+
+func TestTemplatesRender(t *testing.T) {
+	dir := writeTestTemplates(t, testTemplates)
+
+	templates, err := NewTemplates(dir, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := templates.Render(models.MailData{
+		Subject:  "Hello",
+		Template: "hello",
+		Data: map[string]interface{}{
+			"name": "<script>Bob</script>",
+			"date": time.Date(2050, 1, 2, 0, 0, 0, 0, time.UTC),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = []struct {
+		name     string
+		got      string
+		expected string
+	}{
+		{"html layout", m.Content, "<title>Hello</title>"},
+		{"html partial escapes data", m.Content, "<b>&lt;script&gt;Bob&lt;/script&gt;</b>"},
+		{"html function", m.Content, "see you on 2050-01-02"},
+		{"text layout", m.Text, "-- \nFort Smythe"},
+		{"text isn't escaped", m.Text, "Dear <script>Bob</script>, see you on 2050-01-02"},
+	}
+
+	for _, e := range tests {
+		if !strings.Contains(e.got, e.expected) {
+			t.Errorf("%s: expected %q in %q", e.name, e.expected, e.got)
+		}
+	}
+
+	_, err = templates.Render(models.MailData{Template: "missing"})
+	if err == nil {
+		t.Error("expected an error for a missing template")
+	}
+}
+
+func TestTemplatesCache(t *testing.T) {
+	dir := writeTestTemplates(t, testTemplates)
+
+	cached, err := NewTemplates(dir, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	uncached, err := NewTemplates(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ioutil.WriteFile(filepath.Join(dir, "hello.txt"), []byte(`{{template "base" .}}{{define "content"}}Changed{{end}}`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := models.MailData{Template: "hello", Data: map[string]interface{}{"date": time.Now()}}
+
+	if got, _ := cached.Render(m); strings.Contains(got.Text, "Changed") {
+		t.Error("expected cached templates not to be parsed again")
+	}
+
+	if got, _ := uncached.Render(m); !strings.Contains(got.Text, "Changed") {
+		t.Error("expected uncached templates to be parsed again")
+	}
+}
+
+func TestTemplatesRequireTextPart(t *testing.T) {
+	files := map[string]string{}
+	for name, content := range testTemplates {
+		if name != "hello.txt" {
+			files[name] = content
+		}
+	}
+
+	_, err := NewTemplates(writeTestTemplates(t, files), true)
+	if err == nil {
+		t.Error("expected an error for a template without a plain text part")
+	}
+}
+
+func TestOutboxRendersTemplates(t *testing.T) {
+	templates, err := NewTemplates(writeTestTemplates(t, testTemplates), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	o, _ := newTestOutbox(&fakeSender{})
+
+	err = o.Queue(models.MailData{Template: "hello", Data: map[string]interface{}{"name": "Bob", "date": time.Now()}})
+	if err == nil {
+		t.Error("expected an error when queuing a template without templates")
+	}
+
+	o.Templates = templates
+	err = o.Queue(models.MailData{Template: "hello", Data: map[string]interface{}{"name": "Bob", "date": time.Now()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	messages, _ := o.Store.List("", 0)
+	if len(messages) != 1 || !strings.Contains(messages[0].Mail.Content, "<b>Bob</b>") ||
+		!strings.Contains(messages[0].Mail.Text, "Dear Bob") {
+		t.Errorf("expected the queued message to be rendered, got %+v", messages)
+	}
+}
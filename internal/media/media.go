@@ -0,0 +1,109 @@
+// Package media stores uploaded images, such as photos of rooms, in a directory that is served to the public
+package media
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// ErrUnsupportedType is returned when a file that isn't a supported image is saved
+var ErrUnsupportedType = errors.New("only JPEG, PNG, GIF and WebP images can be uploaded")
+
+// ErrInvalidName is returned for names that are outside the library
+var ErrInvalidName = errors.New("invalid media file name")
+
+// imageTypes maps the content types of supported images to their file extension
+var imageTypes = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+}
+
+// Library stores files in a directory. Files are named by slash separated paths relative to the directory
+type Library struct {
+	Dir string
+}
+
+// New returns a library that stores files in dir
+func New(dir string) *Library {
+	return &Library{Dir: dir}
+}
+
+// SaveImage stores an image in folder under a random name and returns its name. The type of the image is
+// detected from its content, and ErrUnsupportedType is returned if it isn't a supported image
+func (l *Library) SaveImage(folder string, data []byte) (string, error) {
+	ext, ok := imageTypes[http.DetectContentType(data)]
+	if !ok {
+		return "", ErrUnsupportedType
+	}
+
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	name := path.Join(folder, hex.EncodeToString(b)+ext)
+	file, err := l.path(name)
+	if err != nil {
+		return "", err
+	}
+
+	err = os.MkdirAll(filepath.Dir(file), 0755)
+	if err != nil {
+		return "", err
+	}
+
+	err = ioutil.WriteFile(file, data, 0644)
+	if err != nil {
+		return "", err
+	}
+
+	return name, nil
+}
+
+// Remove deletes a file. Removing a file that doesn't exist isn't an error
+func (l *Library) Remove(name string) error {
+	file, err := l.path(name)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(file)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	return err
+}
+
+// Handler serves the files in the library. Directories aren't listed
+func (l *Library) Handler() http.Handler {
+	fileServer := http.FileServer(http.Dir(l.Dir))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/") {
+			http.NotFound(w, r)
+			return
+		}
+
+		fileServer.ServeHTTP(w, r)
+	})
+}
+
+// path returns the file system path of name, which must be inside the library
+func (l *Library) path(name string) (string, error) {
+	clean := path.Clean("/" + name)
+	if name == "" || clean == "/" || clean != "/"+name {
+		return "", ErrInvalidName
+	}
+
+	return filepath.Join(l.Dir, filepath.FromSlash(clean)), nil
+}
@@ -0,0 +1,124 @@
+package media
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func testPNG(t *testing.T) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 2, 2)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+func newTestLibrary(t *testing.T) *Library {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "media")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	return New(dir)
+}
+
+func TestSaveImage(t *testing.T) {
+	l := newTestLibrary(t)
+	data := testPNG(t)
+
+	name, err := l.SaveImage("rooms", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(name, "rooms/") || !strings.HasSuffix(name, ".png") {
+		t.Errorf("unexpected name %q", name)
+	}
+
+	saved, err := ioutil.ReadFile(filepath.Join(l.Dir, filepath.FromSlash(name)))
+	if err != nil || !bytes.Equal(saved, data) {
+		t.Errorf("expected the image to be saved, got %v", err)
+	}
+
+	other, _ := l.SaveImage("rooms", data)
+	if other == name {
+		t.Error("expected every image to get its own name")
+	}
+
+	_, err = l.SaveImage("rooms", []byte("<html><script>alert(1)</script></html>"))
+	if err != ErrUnsupportedType {
+		t.Errorf("expected ErrUnsupportedType, got %v", err)
+	}
+}
+
+func TestRemove(t *testing.T) {
+	l := newTestLibrary(t)
+
+	name, err := l.SaveImage("rooms", testPNG(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := l.Remove(name); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(filepath.Join(l.Dir, filepath.FromSlash(name))); !os.IsNotExist(err) {
+		t.Error("expected the file to be removed")
+	}
+
+	if err := l.Remove(name); err != nil {
+		t.Errorf("expected removing a missing file to succeed, got %v", err)
+	}
+
+	for _, name := range []string{"", "../media_test.go", "rooms/../../secret", "/etc/passwd", "rooms/"} {
+		if err := l.Remove(name); err != ErrInvalidName {
+			t.Errorf("%q: expected ErrInvalidName, got %v", name, err)
+		}
+	}
+}
+
+func TestHandler(t *testing.T) {
+	l := newTestLibrary(t)
+
+	name, err := l.SaveImage("rooms", testPNG(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = []struct {
+		name               string
+		url                string
+		expectedStatusCode int
+	}{
+		{"image", "/" + name, http.StatusOK},
+		{"missing", "/rooms/missing.png", http.StatusNotFound},
+		{"directory", "/rooms/", http.StatusNotFound},
+		{"root", "/", http.StatusNotFound},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", e.url, nil)
+		rr := httptest.NewRecorder()
+		l.Handler().ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected %d, got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+	}
+}
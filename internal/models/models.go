@@ -4,26 +4,183 @@ import (
 	"time"
 )
 
+// Access levels stored in users.access_level
+const (
+	AccessLevelStaff   = 1
+	AccessLevelManager = 2
+	AccessLevelAdmin   = 3
+)
+
 // User is the user model
 type User struct {
-	ID          int
-	FirstName   string
-	LastName    string
-	Email       string
-	Password    string
-	AccessLevel int
-	CreateAt    time.Time
-	UpdatedAt   time.Time
+	ID                int
+	FirstName         string
+	LastName          string
+	Email             string
+	Password          string
+	AccessLevel       int
+	DeactivatedAt     time.Time
+	PasswordChangedAt time.Time
+	TOTPEnabledAt     time.Time
+	CreateAt          time.Time
+	UpdatedAt         time.Time
 }
 
-// Room is the room model
+// Room is the room model. Capacity is the most guests the room sleeps, of whom at most MaxAdults can be adults
+// and MaxChildren children. BasePrice is the price per night in cents and WeekendUplift is added to the price
+// of Friday and Saturday nights, in basis points
 type Room struct {
+	ID            int
+	RoomName      string
+	Slug          string
+	Description   string
+	Capacity      int
+	MaxAdults     int
+	MaxChildren   int
+	Amenities     []string
+	BasePrice     int
+	WeekendUplift int
+	CalendarToken string
+	CreateAt      time.Time
+	UpdatedAt     time.Time
+	Photos        []RoomPhoto
+}
+
+// RoomPhoto is a photo of a room. Filename is relative to the media directory
+type RoomPhoto struct {
+	ID        int
+	RoomID    int
+	Filename  string
+	Caption   string
+	SortOrder int
+	CreateAt  time.Time
+	UpdatedAt time.Time
+}
+
+// SeasonalRate replaces the base price of a room on the nights from StartDate to EndDate, inclusive.
+// Price is in cents
+type SeasonalRate struct {
+	ID        int
+	RoomID    int
+	Name      string
+	StartDate time.Time
+	EndDate   time.Time
+	Price     int
+	CreateAt  time.Time
+	UpdatedAt time.Time
+	Room      Room
+}
+
+// StayDiscount takes Percent, in basis points, off the price of stays of at least MinNights nights
+type StayDiscount struct {
 	ID        int
-	RoomName  string
+	MinNights int
+	Percent   int
 	CreateAt  time.Time
 	UpdatedAt time.Time
 }
 
+// Kinds of fee
+const (
+	FeePercent  = "percent"
+	FeePerNight = "night"
+	FeePerStay  = "stay"
+)
+
+// Fee is a tax or fee added to the price of every stay. Amount is in basis points of the discounted price
+// for FeePercent, and in cents otherwise
+type Fee struct {
+	ID        int
+	Name      string
+	Kind      string
+	Amount    int
+	CreateAt  time.Time
+	UpdatedAt time.Time
+}
+
+// Quote is the itemised price of a stay. Amounts are in cents
+type Quote struct {
+	Nights          []QuoteNight `json:"nights"`
+	Subtotal        int          `json:"subtotal"`
+	DiscountPercent int          `json:"discount_percent"`
+	Discount        int          `json:"discount"`
+	Fees            []QuoteFee   `json:"fees"`
+	Total           int          `json:"total"`
+}
+
+// QuoteNight is the price of a night of a stay. Season is empty if the base price applies, and Uplift is the
+// weekend uplift included in Amount
+type QuoteNight struct {
+	Date   time.Time `json:"date"`
+	Season string    `json:"season,omitempty"`
+	Uplift int       `json:"uplift"`
+	Amount int       `json:"amount"`
+}
+
+// QuoteFee is a tax or fee added to a quote
+type QuoteFee struct {
+	Name   string `json:"name"`
+	Amount int    `json:"amount"`
+}
+
+// StayRule restricts stays in a room that arrive from StartDate to EndDate, inclusive. ClosedToDeparture
+// applies to stays that depart in that range instead. Zero values don't restrict stays
+type StayRule struct {
+	ID                int
+	RoomID            int
+	StartDate         time.Time
+	EndDate           time.Time
+	MinNights         int
+	MaxNights         int
+	ClosedToArrival   []time.Weekday
+	ClosedToDeparture []time.Weekday
+	MinAdvanceDays    int
+	MaxAdvanceDays    int
+	CreateAt          time.Time
+	UpdatedAt         time.Time
+	Room              Room
+}
+
+// CancellationPolicy sets what cancelling a stay in a room costs. Cancelling at least FreeDays before arrival
+// is free, cancelling at least NonRefundableDays before arrival costs PenaltyPercent of the price of the stay,
+// and cancelling later costs all of it. A policy with a SeasonalRateID applies to stays that arrive in that
+// season instead of the room's standard policy
+type CancellationPolicy struct {
+	ID                int
+	RoomID            int
+	SeasonalRateID    int
+	FreeDays          int
+	PenaltyPercent    int
+	NonRefundableDays int
+	CreateAt          time.Time
+	UpdatedAt         time.Time
+	Room              Room
+	SeasonalRate      SeasonalRate
+}
+
+// RoomCalendar is an external iCalendar feed whose events are imported as external bookings of a room
+type RoomCalendar struct {
+	ID           int
+	RoomID       int
+	Name         string
+	URL          string
+	LastSyncedAt time.Time
+	LastError    string
+	EventCount   int
+	Conflicts    int
+	CreateAt     time.Time
+	UpdatedAt    time.Time
+	Room         Room
+}
+
+// Restriction ids as seeded in the restrictions table
+const (
+	RestrictionReservation     = 1
+	RestrictionOwnerBlock      = 2
+	RestrictionExternalBooking = 3
+	RestrictionHold            = 4
+)
+
 // Restriction is the restriction model
 type Restriction struct {
 	ID              int
@@ -34,40 +191,231 @@ type Restriction struct {
 
 // Reservation is the reservation model
 type Reservation struct {
-	ID        int
-	FirstName string
-	LastName  string
-	Email     string
-	Phone     string
-	StartDate time.Time
-	EndDate   time.Time
-	RoomID    int
-	CreateAt  time.Time
-	UpdatedAt time.Time
-	Room      Room
-	Processed int
+	ID               int
+	FirstName        string
+	LastName         string
+	Email            string
+	Phone            string
+	StartDate        time.Time
+	EndDate          time.Time
+	RoomID           int
+	Adults           int
+	Children         int
+	BookingID        int
+	ConfirmationCode string
+	CalendarSequence int
+	Quote            Quote
+	CancelledAt      time.Time
+	// CancellationReason records why the reservation was cancelled, and CancellationFee what cancelling cost,
+	// in cents
+	CancellationReason string
+	CancellationFee    int
+	CreateAt           time.Time
+	UpdatedAt          time.Time
+	Room               Room
+	// Status is where the reservation is in its lifecycle, one of the Reservation states
+	Status string
+	// AwaitingPayment is true from when the guest is sent to pay until the payment is authorized. The room is
+	// held for the reservation in the meantime
+	AwaitingPayment bool
+	// DeletedAt is when the reservation was moved to the trash, or zero if it wasn't. Deleted reservations have
+	// released their room and are purged once they have been in the trash for the retention period
+	DeletedAt time.Time
 }
 
-// RoomRestriction is the room restriction model
-type RoomRestriction struct {
+// Reservation states. Reservations are pending until staff confirm them, and end checked out, cancelled or as a
+// no-show
+const (
+	ReservationPending    = "pending"
+	ReservationConfirmed  = "confirmed"
+	ReservationCheckedIn  = "checked-in"
+	ReservationCheckedOut = "checked-out"
+	ReservationCancelled  = "cancelled"
+	ReservationNoShow     = "no-show"
+)
+
+// ReservationEvent records a change of the state of a reservation. FromStatus is empty for the event that
+// records the reservation being made
+type ReservationEvent struct {
 	ID            int
-	StartDate     time.Time
-	EndDate       time.Time
-	RoomID        int
 	ReservationID int
-	RestrictionID int
+	FromStatus    string
+	ToStatus      string
 	CreateAt      time.Time
 	UpdatedAt     time.Time
-	Room          Room
-	Reservation   Reservation
-	Restriction   Restriction
 }
 
-// MailData holds an email message
+// Booking groups the reservations of several rooms that a guest books together. TotalPrice is the sum of the
+// totals of their quotes, in cents
+type Booking struct {
+	ID           int
+	FirstName    string
+	LastName     string
+	Email        string
+	Phone        string
+	TotalPrice   int
+	Reservations []Reservation
+	CreateAt     time.Time
+	UpdatedAt    time.Time
+}
+
+// Cart holds the rooms a guest has chosen to book together, each with its own dates and guests
+type Cart struct {
+	Items []CartItem
+}
+
+// CartItem is a room in a cart, with the hold that keeps the room for the guest until they check out
+type CartItem struct {
+	Reservation Reservation
+	Hold        RoomRestriction
+}
+
+// WaitlistEntry is a guest waiting for a room to become available for a stay. A guest is offered a room once,
+// and the room is held for them until OfferExpiresAt. RoomID and HoldID are 0 until then
+type WaitlistEntry struct {
+	ID             int
+	FirstName      string
+	Email          string
+	StartDate      time.Time
+	EndDate        time.Time
+	Adults         int
+	Children       int
+	RoomID         int
+	HoldID         int
+	OfferedAt      time.Time
+	OfferExpiresAt time.Time
+	CreateAt       time.Time
+	UpdatedAt      time.Time
+	Room           Room
+}
+
+// Payment kinds
+const (
+	PaymentKindPayment = "payment"
+	PaymentKindRefund  = "refund"
+)
+
+// Payment statuses. Refunds are recorded as refunded once they've been paid out, and authorized payments that
+// are released without being captured as voided
+const (
+	PaymentPending    = "pending"
+	PaymentAuthorized = "authorized"
+	PaymentCaptured   = "captured"
+	PaymentFailed     = "failed"
+	PaymentRefunded   = "refunded"
+	PaymentVoided     = "voided"
+)
+
+// Payment is a payment taken for a reservation, or a refund of one, through a payment provider. ProviderRef is
+// the provider's id for it, and Amount is in cents
+type Payment struct {
+	ID            int
+	ReservationID int
+	Provider      string
+	ProviderRef   string
+	Kind          string
+	Status        string
+	Amount        int
+	Currency      string
+	CreateAt      time.Time
+	UpdatedAt     time.Time
+}
+
+// RoomRestriction is the room restriction model
+type RoomRestriction struct {
+	ID             int
+	StartDate      time.Time
+	EndDate        time.Time
+	RoomID         int
+	ReservationID  int
+	RestrictionID  int
+	RoomCalendarID int
+	ExternalUID    string
+	// ExpiresAt is when a hold stops blocking the room. It is zero for other restrictions
+	ExpiresAt   time.Time
+	CreateAt    time.Time
+	UpdatedAt   time.Time
+	Room        Room
+	Reservation Reservation
+	Restriction Restriction
+}
+
+// APIKey is the API key model. Only a hash of the key itself is stored
+type APIKey struct {
+	ID         int
+	Name       string
+	KeyHash    string
+	LastUsedAt time.Time
+	RevokedAt  time.Time
+	CreateAt   time.Time
+	UpdatedAt  time.Time
+}
+
+// Audited entities
+const (
+	AuditEntityReservation = "reservation"
+	AuditEntityUser        = "user"
+	AuditEntityBlock       = "block"
+	AuditEntityCalendar    = "calendar"
+	AuditEntityPayment     = "payment"
+	AuditEntityAPIKey      = "api_key"
+)
+
+// Audited actions. Reservations moved to another state, such as checked-in, are recorded as a transition with
+// the change of status
+const (
+	AuditCreate     = "create"
+	AuditUpdate     = "update"
+	AuditDelete     = "delete"
+	AuditCancel     = "cancel"
+	AuditRestore    = "restore"
+	AuditPassword   = "password"
+	AuditTransition = "transition"
+	AuditPurge      = "purge"
+	AuditRevoke     = "revoke"
+)
+
+// AuditEntry records a change made to an audited entity. ActorID is the user who made it, or 0 for guests, API
+// clients and payment providers, and Actor describes who made it. Before and After are JSON objects of the fields that
+// changed, with their values before and after the change
+type AuditEntry struct {
+	ID        int
+	ActorID   int
+	Actor     string
+	Action    string
+	Entity    string
+	EntityID  int
+	Before    string
+	After     string
+	IP        string
+	CreateAt  time.Time
+	UpdatedAt time.Time
+}
+
+// AuditFilter selects audit log entries. Empty fields select every entry
+type AuditFilter struct {
+	Entity   string
+	EntityID int
+	ActorID  int
+	Action   string
+	Limit    int
+}
+
+// MailData holds an email message. If Template is set, Content and Text are rendered from the template and Data
 type MailData struct {
-	To       string
-	From     string
-	Subject  string
-	Content  string
-	Template string
+	To          string
+	From        string
+	Subject     string
+	Content     string
+	Text        string
+	Template    string
+	Data        map[string]interface{}
+	Attachments []Attachment
+}
+
+// Attachment is a file attached to an email message
+type Attachment struct {
+	Filename    string
+	ContentType string
+	Data        []byte
 }
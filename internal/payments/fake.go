@@ -0,0 +1,189 @@
+package payments
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// FakePayment is a payment kept by a fake gateway
+type FakePayment struct {
+	Request    CheckoutRequest
+	Authorized bool
+	Declined   bool
+	Captured   int
+	Refunded   int
+	Voided     bool
+}
+
+// Fake is an in-memory gateway for tests and local runs. Its checkout page is served by the application, which
+// calls Pay or Decline to get the webhook the provider would have sent
+type Fake struct {
+	// CheckoutURL is the checkout page the guest is sent to, with the id of the payment in its payment parameter
+	CheckoutURL   string
+	WebhookSecret []byte
+
+	mu       sync.Mutex
+	payments map[string]*FakePayment
+	next     int
+}
+
+// NewFake returns a fake gateway with its checkout page at checkoutURL
+func NewFake(checkoutURL string, webhookSecret []byte) *Fake {
+	return &Fake{
+		CheckoutURL:   checkoutURL,
+		WebhookSecret: webhookSecret,
+		payments:      make(map[string]*FakePayment),
+	}
+}
+
+// Name identifies the provider
+func (f *Fake) Name() string {
+	return "fake"
+}
+
+// Authorize starts a checkout on the fake checkout page
+func (f *Fake) Authorize(ctx context.Context, req CheckoutRequest) (Checkout, error) {
+	if req.Amount <= 0 {
+		return Checkout{}, ErrInvalidAmount
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.next++
+	id := fmt.Sprintf("fake_%d", f.next)
+	f.payments[id] = &FakePayment{Request: req}
+
+	return Checkout{PaymentID: id, URL: f.CheckoutURL + "?payment=" + url.QueryEscape(id)}, nil
+}
+
+// Capture takes amount of an authorized payment
+func (f *Fake) Capture(ctx context.Context, paymentID string, amount int) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	p, ok := f.payments[paymentID]
+	if !ok || !p.Authorized || p.Voided {
+		return ErrUnknownPayment
+	}
+
+	if amount <= 0 || p.Captured+amount > p.Request.Amount {
+		return ErrInvalidAmount
+	}
+
+	p.Captured += amount
+	return nil
+}
+
+// Refund pays back amount of a captured payment
+func (f *Fake) Refund(ctx context.Context, paymentID string, amount int) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	p, ok := f.payments[paymentID]
+	if !ok {
+		return "", ErrUnknownPayment
+	}
+
+	if amount <= 0 || p.Refunded+amount > p.Captured {
+		return "", ErrInvalidAmount
+	}
+
+	p.Refunded += amount
+	f.next++
+	return fmt.Sprintf("fake_refund_%d", f.next), nil
+}
+
+// Void releases an authorized payment that hasn't been captured
+func (f *Fake) Void(ctx context.Context, paymentID string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	p, ok := f.payments[paymentID]
+	if !ok || !p.Authorized {
+		return ErrUnknownPayment
+	}
+
+	if p.Captured > 0 {
+		return ErrPaymentCaptured
+	}
+
+	p.Voided = true
+	return nil
+}
+
+// VerifyWebhook checks the signature of a webhook returned by Pay or Decline
+func (f *Fake) VerifyWebhook(header http.Header, body []byte) (Event, error) {
+	var e Event
+
+	err := verify(f.WebhookSecret, header.Get(SignatureHeader), body, time.Now())
+	if err != nil {
+		return e, err
+	}
+
+	err = json.Unmarshal(body, &e)
+	if err != nil {
+		return e, err
+	}
+
+	return e, nil
+}
+
+// Payment returns a copy of a payment, and false if there is no such payment
+func (f *Fake) Payment(paymentID string) (FakePayment, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	p, ok := f.payments[paymentID]
+	if !ok {
+		return FakePayment{}, false
+	}
+
+	return *p, true
+}
+
+// Pay authorizes a payment as if the guest paid on the checkout page, and returns the signed webhook that
+// reports it
+func (f *Fake) Pay(paymentID string) (http.Header, []byte, error) {
+	return f.complete(paymentID, EventAuthorized)
+}
+
+// Decline declines a payment as if the guest's card was refused, and returns the signed webhook that reports it
+func (f *Fake) Decline(paymentID string) (http.Header, []byte, error) {
+	return f.complete(paymentID, EventFailed)
+}
+
+func (f *Fake) complete(paymentID string, t EventType) (http.Header, []byte, error) {
+	f.mu.Lock()
+	p, ok := f.payments[paymentID]
+	if ok && !p.Authorized && !p.Declined {
+		p.Authorized = t == EventAuthorized
+		p.Declined = t == EventFailed
+	}
+	f.mu.Unlock()
+
+	if !ok {
+		return nil, nil, ErrUnknownPayment
+	}
+
+	return f.Webhook(Event{Type: t, PaymentID: paymentID, Amount: p.Request.Amount})
+}
+
+// Webhook returns a webhook for e, signed now
+func (f *Fake) Webhook(e Event) (http.Header, []byte, error) {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	header := make(http.Header)
+	header.Set("Content-Type", "application/json")
+	header.Set(SignatureHeader, sign(f.WebhookSecret, time.Now(), body))
+
+	return header, body, nil
+}
@@ -0,0 +1,151 @@
+package payments
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Hosted is a gateway for a hosted checkout provider with a JSON API. Checkouts are created with
+// POST /v1/checkouts, and payments are captured and refunded with POST /v1/payments/{id}/capture and
+// POST /v1/payments/{id}/refunds. Requests authenticate with a bearer API key, and webhooks are signed with a
+// separate secret
+type Hosted struct {
+	BaseURL       string
+	APIKey        string
+	WebhookSecret []byte
+	Client        *http.Client
+	// Now tells the time webhooks are checked against
+	Now func() time.Time
+}
+
+// NewHosted returns a gateway for the provider at baseURL
+func NewHosted(baseURL, apiKey string, webhookSecret []byte) *Hosted {
+	return &Hosted{
+		BaseURL:       strings.TrimSuffix(baseURL, "/"),
+		APIKey:        apiKey,
+		WebhookSecret: webhookSecret,
+		Client:        &http.Client{Timeout: 30 * time.Second},
+		Now:           time.Now,
+	}
+}
+
+// Name identifies the provider
+func (h *Hosted) Name() string {
+	return "hosted"
+}
+
+// Authorize creates a checkout whose payment is only captured by Capture
+func (h *Hosted) Authorize(ctx context.Context, req CheckoutRequest) (Checkout, error) {
+	var resp struct {
+		ID  string `json:"id"`
+		URL string `json:"url"`
+	}
+
+	err := h.post(ctx, "/v1/checkouts", map[string]interface{}{
+		"reference":      req.Reference,
+		"amount":         req.Amount,
+		"currency":       req.Currency,
+		"customer_email": req.Email,
+		"description":    req.Description,
+		"success_url":    req.SuccessURL,
+		"cancel_url":     req.CancelURL,
+		"capture":        "manual",
+	}, &resp)
+	if err != nil {
+		return Checkout{}, err
+	}
+
+	if resp.ID == "" || resp.URL == "" {
+		return Checkout{}, fmt.Errorf("payment provider returned a checkout without an id or url")
+	}
+
+	return Checkout{PaymentID: resp.ID, URL: resp.URL}, nil
+}
+
+// Capture takes amount of an authorized payment
+func (h *Hosted) Capture(ctx context.Context, paymentID string, amount int) error {
+	return h.post(ctx, "/v1/payments/"+url.PathEscape(paymentID)+"/capture", map[string]interface{}{
+		"amount": amount,
+	}, nil)
+}
+
+// Refund pays back amount of a captured payment
+func (h *Hosted) Refund(ctx context.Context, paymentID string, amount int) (string, error) {
+	var resp struct {
+		ID string `json:"id"`
+	}
+
+	err := h.post(ctx, "/v1/payments/"+url.PathEscape(paymentID)+"/refunds", map[string]interface{}{
+		"amount": amount,
+	}, &resp)
+	if err != nil {
+		return "", err
+	}
+
+	return resp.ID, nil
+}
+
+// Void releases an authorized payment that hasn't been captured
+func (h *Hosted) Void(ctx context.Context, paymentID string) error {
+	return h.post(ctx, "/v1/payments/"+url.PathEscape(paymentID)+"/void", map[string]interface{}{}, nil)
+}
+
+// VerifyWebhook checks the signature of a webhook and returns the event it reports
+func (h *Hosted) VerifyWebhook(header http.Header, body []byte) (Event, error) {
+	var e Event
+
+	err := verify(h.WebhookSecret, header.Get(SignatureHeader), body, h.Now())
+	if err != nil {
+		return e, err
+	}
+
+	err = json.Unmarshal(body, &e)
+	if err != nil {
+		return e, err
+	}
+
+	return e, nil
+}
+
+// post sends a JSON request to the provider, and decodes the response into v unless it is nil
+func (h *Hosted) post(ctx context.Context, path string, payload interface{}, v interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.BaseURL+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+h.APIKey)
+
+	resp, err := h.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return ErrUnknownPayment
+	case resp.StatusCode == http.StatusUnprocessableEntity:
+		return ErrInvalidAmount
+	case resp.StatusCode < 200 || resp.StatusCode > 299:
+		return fmt.Errorf("payment provider returned %s", resp.Status)
+	}
+
+	if v == nil {
+		return nil
+	}
+
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v)
+}
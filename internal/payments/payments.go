@@ -0,0 +1,141 @@
+// Package payments takes payments for reservations through a payment gateway. The guest pays on a checkout page
+// hosted by the payment provider, and the provider reports the outcome with signed webhooks
+package payments
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrInvalidWebhook is returned for webhooks whose signature doesn't match or that are too old to be trusted
+var ErrInvalidWebhook = errors.New("invalid webhook signature")
+
+// ErrUnknownPayment is returned for payments the gateway has no record of
+var ErrUnknownPayment = errors.New("unknown payment")
+
+// ErrInvalidAmount is returned when more is captured or refunded than was authorized or captured
+var ErrInvalidAmount = errors.New("invalid payment amount")
+
+// ErrPaymentCaptured is returned when a payment that was already captured is voided
+var ErrPaymentCaptured = errors.New("payment has already been captured")
+
+// SignatureHeader is the header that holds the signature of a webhook
+const SignatureHeader = "Webhook-Signature"
+
+// webhookTolerance is how old a webhook can be before it is rejected, so that webhooks can't be replayed later
+const webhookTolerance = 5 * time.Minute
+
+// Gateway takes payments through a payment provider. Amounts are in cents
+type Gateway interface {
+	// Name identifies the provider, and is recorded with every payment
+	Name() string
+	// Authorize starts a checkout for a payment, which is authorized but not captured when the guest pays
+	Authorize(ctx context.Context, req CheckoutRequest) (Checkout, error)
+	// Capture takes amount of an authorized payment
+	Capture(ctx context.Context, paymentID string, amount int) error
+	// Refund pays back amount of a captured payment, and returns the id of the refund
+	Refund(ctx context.Context, paymentID string, amount int) (string, error)
+	// Void releases an authorized payment that hasn't been captured, so the guest isn't charged
+	Void(ctx context.Context, paymentID string) error
+	// VerifyWebhook checks the signature of a webhook sent by the provider and returns the event it reports
+	VerifyWebhook(header http.Header, body []byte) (Event, error)
+}
+
+// CheckoutRequest describes a payment the guest is asked to make
+type CheckoutRequest struct {
+	// Reference identifies the payment to the guest and in the provider's records, such as a confirmation code
+	Reference   string
+	Amount      int
+	Currency    string
+	Email       string
+	Description string
+	// SuccessURL is where the guest is sent after paying, and CancelURL where they're sent if they don't
+	SuccessURL string
+	CancelURL  string
+}
+
+// Checkout is a checkout started by Authorize. The guest pays on the page at URL
+type Checkout struct {
+	PaymentID string
+	URL       string
+}
+
+// EventType is the kind of event a webhook reports
+type EventType string
+
+const (
+	// EventAuthorized reports that the guest paid and the payment can be captured
+	EventAuthorized EventType = "payment.authorized"
+	// EventCaptured reports that a payment was captured
+	EventCaptured EventType = "payment.captured"
+	// EventFailed reports that the guest's payment was declined
+	EventFailed EventType = "payment.failed"
+	// EventRefunded reports that a refund was paid out
+	EventRefunded EventType = "payment.refunded"
+)
+
+// Event is an event reported by a webhook
+type Event struct {
+	Type      EventType `json:"type"`
+	PaymentID string    `json:"payment_id"`
+	Amount    int       `json:"amount"`
+}
+
+// sign returns the signature header of a webhook body sent at t, signed with an HMAC of secret
+func sign(secret []byte, t time.Time, body []byte) string {
+	ts := strconv.FormatInt(t.Unix(), 10)
+	return "t=" + ts + ",v1=" + signature(secret, ts, body)
+}
+
+// signature returns the hex encoded HMAC-SHA256 of a timestamp and body
+func signature(secret []byte, ts string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(ts + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// verify checks a signature header created by sign, and that it was signed at most webhookTolerance before now
+func verify(secret []byte, header string, body []byte, now time.Time) error {
+	var ts, sig string
+	for _, part := range strings.Split(header, ",") {
+		kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+
+		switch kv[0] {
+		case "t":
+			ts = kv[1]
+		case "v1":
+			sig = kv[1]
+		}
+	}
+
+	if ts == "" || sig == "" {
+		return ErrInvalidWebhook
+	}
+
+	if !hmac.Equal([]byte(sig), []byte(signature(secret, ts, body))) {
+		return ErrInvalidWebhook
+	}
+
+	unix, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return ErrInvalidWebhook
+	}
+
+	if age := now.Sub(time.Unix(unix, 0)); age > webhookTolerance || age < -webhookTolerance {
+		return fmt.Errorf("%w: signed %s ago", ErrInvalidWebhook, age)
+	}
+
+	return nil
+}
@@ -0,0 +1,251 @@
+package payments
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+var testSecret = []byte("webhook-secret")
+
+func TestVerify(t *testing.T) {
+	now := time.Date(2050, 1, 1, 12, 0, 0, 0, time.UTC)
+	body := []byte(`{"type":"payment.authorized","payment_id":"pay_1","amount":1000}`)
+
+	var tests = []struct {
+		name     string
+		header   string
+		body     []byte
+		expected bool
+	}{
+		{"valid", sign(testSecret, now, body), body, true},
+		{"recent", sign(testSecret, now.Add(-4*time.Minute), body), body, true},
+		{"too old", sign(testSecret, now.Add(-6*time.Minute), body), body, false},
+		{"from the future", sign(testSecret, now.Add(6*time.Minute), body), body, false},
+		{"other secret", sign([]byte("other"), now, body), body, false},
+		{"tampered body", sign(testSecret, now, body), []byte(`{"type":"payment.authorized","payment_id":"pay_1","amount":1}`), false},
+		{"missing signature", "", body, false},
+		{"malformed", "t=abc,v1=def", body, false},
+	}
+
+	for _, e := range tests {
+		err := verify(testSecret, e.header, e.body, now)
+		if (err == nil) != e.expected {
+			t.Errorf("%s: expected valid %v, got %v", e.name, e.expected, err)
+		}
+
+		if err != nil && !errors.Is(err, ErrInvalidWebhook) {
+			t.Errorf("%s: expected ErrInvalidWebhook, got %v", e.name, err)
+		}
+	}
+}
+
+func TestHosted(t *testing.T) {
+	var requests []string
+	var bodies []map[string]interface{}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer test-key" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		var body map[string]interface{}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		bodies = append(bodies, body)
+
+		switch r.URL.Path {
+		case "/v1/checkouts":
+			w.Write([]byte(`{"id":"pay_1","url":"https://checkout.example.com/pay_1"}`))
+		case "/v1/payments/pay_1/capture", "/v1/payments/pay_1/void":
+			w.Write([]byte(`{}`))
+		case "/v1/payments/pay_1/refunds":
+			if body["amount"].(float64) > 1000 {
+				w.WriteHeader(http.StatusUnprocessableEntity)
+				return
+			}
+			w.Write([]byte(`{"id":"re_1"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	h := NewHosted(srv.URL+"/", "test-key", testSecret)
+	ctx := context.Background()
+
+	checkout, err := h.Authorize(ctx, CheckoutRequest{Reference: "ABC", Amount: 1000, Currency: "USD"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if checkout.PaymentID != "pay_1" || checkout.URL != "https://checkout.example.com/pay_1" {
+		t.Errorf("unexpected checkout %+v", checkout)
+	}
+
+	if bodies[0]["capture"] != "manual" || bodies[0]["reference"] != "ABC" || bodies[0]["amount"].(float64) != 1000 {
+		t.Errorf("unexpected checkout request %v", bodies[0])
+	}
+
+	if err := h.Capture(ctx, "pay_1", 1000); err != nil {
+		t.Error(err)
+	}
+
+	refundID, err := h.Refund(ctx, "pay_1", 500)
+	if err != nil || refundID != "re_1" {
+		t.Errorf("expected refund re_1, got %q %v", refundID, err)
+	}
+
+	if _, err := h.Refund(ctx, "pay_1", 5000); !errors.Is(err, ErrInvalidAmount) {
+		t.Errorf("expected ErrInvalidAmount, got %v", err)
+	}
+
+	if err := h.Capture(ctx, "pay_2", 1000); !errors.Is(err, ErrUnknownPayment) {
+		t.Errorf("expected ErrUnknownPayment, got %v", err)
+	}
+
+	if err := h.Void(ctx, "pay_1"); err != nil {
+		t.Error(err)
+	}
+
+	expected := []string{"POST /v1/checkouts", "POST /v1/payments/pay_1/capture", "POST /v1/payments/pay_1/refunds",
+		"POST /v1/payments/pay_1/refunds", "POST /v1/payments/pay_2/capture", "POST /v1/payments/pay_1/void"}
+	if len(requests) != len(expected) {
+		t.Fatalf("expected requests %v, got %v", expected, requests)
+	}
+	for i := range expected {
+		if requests[i] != expected[i] {
+			t.Errorf("expected request %s, got %s", expected[i], requests[i])
+		}
+	}
+
+	h.APIKey = "wrong-key"
+	if _, err := h.Authorize(ctx, CheckoutRequest{Amount: 1000}); err == nil {
+		t.Error("expected an error for a rejected API key")
+	}
+}
+
+func TestHostedVerifyWebhook(t *testing.T) {
+	now := time.Date(2050, 1, 1, 12, 0, 0, 0, time.UTC)
+	h := NewHosted("https://payments.example.com", "test-key", testSecret)
+	h.Now = func() time.Time { return now }
+
+	body := []byte(`{"type":"payment.authorized","payment_id":"pay_1","amount":1000}`)
+	header := make(http.Header)
+	header.Set(SignatureHeader, sign(testSecret, now, body))
+
+	e, err := h.VerifyWebhook(header, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if e.Type != EventAuthorized || e.PaymentID != "pay_1" || e.Amount != 1000 {
+		t.Errorf("unexpected event %+v", e)
+	}
+
+	header.Set(SignatureHeader, sign([]byte("other"), now, body))
+	if _, err := h.VerifyWebhook(header, body); !errors.Is(err, ErrInvalidWebhook) {
+		t.Errorf("expected ErrInvalidWebhook, got %v", err)
+	}
+}
+
+func TestFake(t *testing.T) {
+	f := NewFake("http://localhost/payments/fake-checkout", testSecret)
+	ctx := context.Background()
+
+	checkout, err := f.Authorize(ctx, CheckoutRequest{Reference: "ABC", Amount: 1000})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if checkout.URL != "http://localhost/payments/fake-checkout?payment="+checkout.PaymentID {
+		t.Errorf("unexpected checkout url %s", checkout.URL)
+	}
+
+	if err := f.Capture(ctx, checkout.PaymentID, 1000); !errors.Is(err, ErrUnknownPayment) {
+		t.Errorf("expected payments to be captured only once authorized, got %v", err)
+	}
+
+	header, body, err := f.Pay(checkout.PaymentID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e, err := f.VerifyWebhook(header, body)
+	if err != nil || e.Type != EventAuthorized || e.PaymentID != checkout.PaymentID || e.Amount != 1000 {
+		t.Fatalf("expected a verified authorized event, got %+v %v", e, err)
+	}
+
+	var tests = []struct {
+		name     string
+		refund   bool
+		amount   int
+		expected error
+	}{
+		{"capture too much", false, 1001, ErrInvalidAmount},
+		{"refund before capture", true, 100, ErrInvalidAmount},
+		{"capture", false, 1000, nil},
+		{"refund", true, 600, nil},
+		{"refund too much", true, 500, ErrInvalidAmount},
+		{"refund the rest", true, 400, nil},
+	}
+
+	for _, test := range tests {
+		if test.refund {
+			_, err = f.Refund(ctx, checkout.PaymentID, test.amount)
+		} else {
+			err = f.Capture(ctx, checkout.PaymentID, test.amount)
+		}
+
+		if !errors.Is(err, test.expected) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, err)
+		}
+	}
+
+	p, _ := f.Payment(checkout.PaymentID)
+	if p.Captured != 1000 || p.Refunded != 1000 {
+		t.Errorf("expected 1000 captured and refunded, got %d and %d", p.Captured, p.Refunded)
+	}
+
+	declined, _ := f.Authorize(ctx, CheckoutRequest{Amount: 500})
+	header, body, _ = f.Decline(declined.PaymentID)
+	if e, err := f.VerifyWebhook(header, body); err != nil || e.Type != EventFailed {
+		t.Errorf("expected a verified failed event, got %+v %v", e, err)
+	}
+
+	if _, _, err := f.Pay("unknown"); !errors.Is(err, ErrUnknownPayment) {
+		t.Errorf("expected ErrUnknownPayment, got %v", err)
+	}
+
+	if err := f.Void(ctx, checkout.PaymentID); !errors.Is(err, ErrPaymentCaptured) {
+		t.Errorf("expected captured payments not to be voided, got %v", err)
+	}
+}
+
+func TestFakeVoid(t *testing.T) {
+	f := NewFake("http://localhost/payments/fake-checkout", testSecret)
+	ctx := context.Background()
+
+	checkout, _ := f.Authorize(ctx, CheckoutRequest{Amount: 1000})
+	if err := f.Void(ctx, checkout.PaymentID); !errors.Is(err, ErrUnknownPayment) {
+		t.Errorf("expected payments to be voided only once authorized, got %v", err)
+	}
+
+	_, _, _ = f.Pay(checkout.PaymentID)
+	if err := f.Void(ctx, checkout.PaymentID); err != nil {
+		t.Fatal(err)
+	}
+
+	if p, _ := f.Payment(checkout.PaymentID); !p.Voided {
+		t.Error("expected the payment to be voided")
+	}
+
+	if err := f.Capture(ctx, checkout.PaymentID, 1000); !errors.Is(err, ErrUnknownPayment) {
+		t.Errorf("expected voided payments not to be captured, got %v", err)
+	}
+}
@@ -0,0 +1,132 @@
+// Package pricing calculates the price of a stay in a room from its base price, seasonal rates, weekend
+// uplift, length of stay discounts and fees
+package pricing
+
+import (
+	"errors"
+	"time"
+
+	"github.com/dhanekom/bookings/internal/models"
+)
+
+// ErrNoNights is returned when a stay doesn't end after it starts
+var ErrNoNights = errors.New("a stay must be at least one night")
+
+// Rules are what the price of a stay in Room is calculated from. Seasons should be those of Room; when
+// seasons overlap, the one that starts last applies
+type Rules struct {
+	Room      models.Room
+	Seasons   []models.SeasonalRate
+	Discounts []models.StayDiscount
+	Fees      []models.Fee
+}
+
+// Quote returns the itemised price of staying from start to end. Each night is priced at the seasonal or
+// base price, with the weekend uplift added to Friday and Saturday nights. The largest discount the stay
+// is long enough for is taken off their sum, and fees are added to what remains
+func (r Rules) Quote(start, end time.Time) (models.Quote, error) {
+	var q models.Quote
+
+	start, end = dateOf(start), dateOf(end)
+	if !end.After(start) {
+		return q, ErrNoNights
+	}
+
+	for d := start; d.Before(end); d = d.AddDate(0, 0, 1) {
+		night := models.QuoteNight{Date: d}
+
+		price := r.Room.BasePrice
+		if s, ok := r.season(d); ok {
+			night.Season = s.Name
+			price = s.Price
+		}
+
+		if d.Weekday() == time.Friday || d.Weekday() == time.Saturday {
+			night.Uplift = percentOf(price, r.Room.WeekendUplift)
+		}
+
+		night.Amount = price + night.Uplift
+		q.Nights = append(q.Nights, night)
+		q.Subtotal += night.Amount
+	}
+
+	if d, ok := r.discount(len(q.Nights)); ok {
+		q.DiscountPercent = d.Percent
+		q.Discount = percentOf(q.Subtotal, d.Percent)
+	}
+
+	discounted := q.Subtotal - q.Discount
+	q.Total = discounted
+
+	for _, f := range r.Fees {
+		fee := models.QuoteFee{Name: f.Name}
+
+		switch f.Kind {
+		case models.FeePercent:
+			fee.Amount = percentOf(discounted, f.Amount)
+		case models.FeePerNight:
+			fee.Amount = f.Amount * len(q.Nights)
+		default:
+			fee.Amount = f.Amount
+		}
+
+		q.Fees = append(q.Fees, fee)
+		q.Total += fee.Amount
+	}
+
+	return q, nil
+}
+
+// season returns the season that applies to the night of d
+func (r Rules) season(d time.Time) (models.SeasonalRate, bool) {
+	var found models.SeasonalRate
+	ok := false
+
+	for _, s := range r.Seasons {
+		if d.Before(dateOf(s.StartDate)) || d.After(dateOf(s.EndDate)) {
+			continue
+		}
+
+		if !ok || s.StartDate.After(found.StartDate) {
+			found = s
+			ok = true
+		}
+	}
+
+	return found, ok
+}
+
+// discount returns the largest discount for a stay of nights
+func (r Rules) discount(nights int) (models.StayDiscount, bool) {
+	var found models.StayDiscount
+	ok := false
+
+	for _, d := range r.Discounts {
+		if d.MinNights > nights {
+			continue
+		}
+
+		if !ok || d.Percent > found.Percent {
+			found = d
+			ok = true
+		}
+	}
+
+	return found, ok
+}
+
+// percentOf returns basisPoints hundredths of a percent of amount, rounded to the nearest cent
+func percentOf(amount, basisPoints int) int {
+	n := amount * basisPoints
+	if n < 0 {
+		return -((-n + 5000) / 10000)
+	}
+
+	return (n + 5000) / 10000
+}
+
+// dateOf returns the date of t at midnight UTC, which is how dates are parsed and stored
+func dateOf(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
@@ -0,0 +1,116 @@
+package pricing
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/dhanekom/bookings/internal/models"
+)
+
+func date(day int) time.Time {
+	return time.Date(2050, 1, day, 0, 0, 0, 0, time.UTC)
+}
+
+var testRules = Rules{
+	Room: models.Room{ID: 1, BasePrice: 10000, WeekendUplift: 1000},
+	Seasons: []models.SeasonalRate{
+		{Name: "Summer", StartDate: date(5), EndDate: date(7), Price: 15000},
+		{Name: "Peak", StartDate: date(7), EndDate: date(7), Price: 20000},
+	},
+	Discounts: []models.StayDiscount{
+		{MinNights: 3, Percent: 500},
+		{MinNights: 7, Percent: 1000},
+	},
+	Fees: []models.Fee{
+		{Name: "VAT", Kind: models.FeePercent, Amount: 1500},
+		{Name: "Cleaning", Kind: models.FeePerStay, Amount: 2500},
+		{Name: "Levy", Kind: models.FeePerNight, Amount: 100},
+	},
+}
+
+func TestQuote(t *testing.T) {
+	// 2050-01-04 is a Tuesday, so the stay includes a Friday and a Saturday night
+	q, err := testRules.Quote(date(4), date(9))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := models.Quote{
+		Nights: []models.QuoteNight{
+			{Date: date(4), Amount: 10000},
+			{Date: date(5), Season: "Summer", Amount: 15000},
+			{Date: date(6), Season: "Summer", Amount: 15000},
+			{Date: date(7), Season: "Peak", Uplift: 2000, Amount: 22000},
+			{Date: date(8), Uplift: 1000, Amount: 11000},
+		},
+		Subtotal:        73000,
+		DiscountPercent: 500,
+		Discount:        3650,
+		Fees: []models.QuoteFee{
+			{Name: "VAT", Amount: 10403},
+			{Name: "Cleaning", Amount: 2500},
+			{Name: "Levy", Amount: 500},
+		},
+		Total: 82753,
+	}
+
+	if !reflect.DeepEqual(q, expected) {
+		t.Errorf("expected %+v, got %+v", expected, q)
+	}
+}
+
+func TestQuoteTotals(t *testing.T) {
+	var tests = []struct {
+		name     string
+		rules    Rules
+		start    time.Time
+		end      time.Time
+		expected int
+	}{
+		{"base price only", Rules{Room: models.Room{BasePrice: 12000}}, date(3), date(5), 24000},
+		{"uplift without a weekend", Rules{Room: models.Room{BasePrice: 12000, WeekendUplift: 5000}}, date(3), date(5), 24000},
+		{"longest discount", Rules{Room: models.Room{BasePrice: 10000}, Discounts: testRules.Discounts}, date(3), date(10), 63000},
+		{"times are ignored", Rules{Room: models.Room{BasePrice: 10000}}, date(3).Add(15 * time.Hour), date(4).Add(10 * time.Hour), 10000},
+	}
+
+	for _, e := range tests {
+		q, err := e.rules.Quote(e.start, e.end)
+		if err != nil {
+			t.Errorf("%s: %s", e.name, err)
+			continue
+		}
+
+		if q.Total != e.expected {
+			t.Errorf("%s: expected %d, got %d", e.name, e.expected, q.Total)
+		}
+	}
+}
+
+func TestQuoteNoNights(t *testing.T) {
+	for _, end := range []time.Time{date(4), date(3)} {
+		if _, err := testRules.Quote(date(4), end); err != ErrNoNights {
+			t.Errorf("ending on %s: expected ErrNoNights, got %v", end.Format("2006-01-02"), err)
+		}
+	}
+}
+
+func TestPercentOf(t *testing.T) {
+	var tests = []struct {
+		amount      int
+		basisPoints int
+		expected    int
+	}{
+		{10000, 1500, 1500},
+		{333, 5000, 167},
+		{333, 1000, 33},
+		{-333, 5000, -167},
+		{10000, 0, 0},
+	}
+
+	for _, e := range tests {
+		if got := percentOf(e.amount, e.basisPoints); got != e.expected {
+			t.Errorf("%d of %d: expected %d, got %d", e.basisPoints, e.amount, e.expected, got)
+		}
+	}
+}
@@ -6,15 +6,26 @@ import (
 	"html/template"
 	"net/http"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/dhanekom/bookings/internal/config"
+	"github.com/dhanekom/bookings/internal/helpers"
+	"github.com/dhanekom/bookings/internal/lifecycle"
 	"github.com/dhanekom/bookings/internal/models"
 	"github.com/justinas/nosurf"
 )
 
 var functions = template.FuncMap{
-	"humanDate": humanDate,
+	"humanDate":     humanDate,
+	"formatDate":    formatDate,
+	"iterate":       iterate,
+	"add":           add,
+	"formatPrice":   FormatPrice,
+	"formatPercent": formatPercent,
+	"formatGuests":  formatGuests,
+	"statusLabel":   lifecycle.Label,
+	"statusAction":  lifecycle.Action,
 }
 
 var app *config.AppConfig
@@ -24,6 +35,70 @@ func humanDate(t time.Time) string {
 	return t.Format("2006-01-02")
 }
 
+// formatDate formats a time using the given layout
+func formatDate(t time.Time, f string) string {
+	return t.Format(f)
+}
+
+// iterate returns a slice of ints from 0 to count-1
+func iterate(count int) []int {
+	items := make([]int, count)
+	for i := range items {
+		items[i] = i
+	}
+	return items
+}
+
+// add returns the sum of a and b
+func add(a, b int) int {
+	return a + b
+}
+
+// FormatPrice formats an amount in cents with the currency symbol, such as $1,250.00
+func FormatPrice(cents int) string {
+	sign := ""
+	if cents < 0 {
+		sign = "-"
+		cents = -cents
+	}
+
+	whole := fmt.Sprintf("%d", cents/100)
+	for i := len(whole) - 3; i > 0; i -= 3 {
+		whole = whole[:i] + "," + whole[i:]
+	}
+
+	currency := "$"
+	if app != nil && app.Currency != "" {
+		currency = app.Currency
+	}
+
+	return fmt.Sprintf("%s%s%s.%02d", sign, currency, whole, cents%100)
+}
+
+// formatPercent formats basis points as a percentage, such as 12.5%
+func formatPercent(basisPoints int) string {
+	s := fmt.Sprintf("%d.%02d", basisPoints/100, basisPoints%100)
+	s = strings.TrimRight(strings.TrimRight(s, "0"), ".")
+	return s + "%"
+}
+
+// formatGuests formats a party, such as 2 adults and 1 child
+func formatGuests(adults, children int) string {
+	s := fmt.Sprintf("%d adult", adults)
+	if adults != 1 {
+		s += "s"
+	}
+
+	switch {
+	case children == 1:
+		s += " and 1 child"
+	case children > 1:
+		s += fmt.Sprintf(" and %d children", children)
+	}
+
+	return s
+}
+
 // NewRendered sets the config for the template package
 func NewRendered(a *config.AppConfig) {
 	app = a
@@ -37,6 +112,9 @@ func AddDefaultData(td *models.TemplateData, r *http.Request) *models.TemplateDa
 	if app.Session.Exists(r.Context(), "user_id") {
 		td.IsAuthenticated = 1
 	}
+	if u, ok := helpers.UserFromContext(r.Context()); ok {
+		td.User = u
+	}
 	return td
 }
 
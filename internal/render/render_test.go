@@ -4,6 +4,7 @@ import (
 	"net/http"
 	"testing"
 
+	"github.com/dhanekom/bookings/internal/helpers"
 	"github.com/dhanekom/bookings/internal/models"
 )
 
@@ -22,6 +23,23 @@ func TestAddDefaultData(t *testing.T) {
 	}
 }
 
+func TestAddDefaultData_User(t *testing.T) {
+	var td models.TemplateData
+
+	r, err := getSession()
+	if err != nil {
+		t.Error(err)
+	}
+
+	u := models.User{ID: 1, FirstName: "Jane", AccessLevel: models.AccessLevelAdmin}
+	r = r.WithContext(helpers.ContextWithUser(r.Context(), u))
+
+	result := AddDefaultData(&td, r)
+	if result.User.ID != u.ID {
+		t.Errorf("User - expected id %d, got %d", u.ID, result.User.ID)
+	}
+}
+
 func TestRenderTemplate(t *testing.T) {
 	app.TemplatePath = "../../templates"
 
@@ -91,3 +109,61 @@ func TestCreateTemplateCache(t *testing.T) {
 	}
 
 }
+
+func TestFormatPrice(t *testing.T) {
+	var tests = []struct {
+		cents    int
+		expected string
+	}{
+		{0, "$0.00"},
+		{5, "$0.05"},
+		{12000, "$120.00"},
+		{123456, "$1,234.56"},
+		{123456789, "$1,234,567.89"},
+		{-2550, "-$25.50"},
+	}
+
+	for _, e := range tests {
+		if got := FormatPrice(e.cents); got != e.expected {
+			t.Errorf("%d: expected %s, got %s", e.cents, e.expected, got)
+		}
+	}
+}
+
+func TestFormatPercent(t *testing.T) {
+	var tests = []struct {
+		basisPoints int
+		expected    string
+	}{
+		{0, "0%"},
+		{1500, "15%"},
+		{1250, "12.5%"},
+		{825, "8.25%"},
+		{10000, "100%"},
+	}
+
+	for _, e := range tests {
+		if got := formatPercent(e.basisPoints); got != e.expected {
+			t.Errorf("%d: expected %s, got %s", e.basisPoints, e.expected, got)
+		}
+	}
+}
+
+func TestFormatGuests(t *testing.T) {
+	var tests = []struct {
+		adults   int
+		children int
+		expected string
+	}{
+		{1, 0, "1 adult"},
+		{2, 0, "2 adults"},
+		{2, 1, "2 adults and 1 child"},
+		{1, 3, "1 adult and 3 children"},
+	}
+
+	for _, e := range tests {
+		if got := formatGuests(e.adults, e.children); got != e.expected {
+			t.Errorf("%d and %d: expected %s, got %s", e.adults, e.children, e.expected, got)
+		}
+	}
+}
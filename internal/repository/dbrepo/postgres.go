@@ -2,15 +2,275 @@ package dbrepo
 
 import (
 	"context"
+	"database/sql"
+	"encoding/json"
 	"errors"
+	"sort"
+	"strings"
 	"time"
 
+	"github.com/dhanekom/bookings/internal/lifecycle"
+	"github.com/dhanekom/bookings/internal/mailer"
 	"github.com/dhanekom/bookings/internal/models"
+	"github.com/dhanekom/bookings/internal/repository"
+	"github.com/jackc/pgconn"
 	"golang.org/x/crypto/bcrypt"
 )
 
-func (m *postgresDBRepo) AllUsers() bool {
-	return true
+// passwordCost is the bcrypt cost used for user passwords
+const passwordCost = 12
+
+// AllUsers returns a slice of all users
+func (m *postgresDBRepo) AllUsers() ([]models.User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	var users []models.User
+
+	query := `select id, first_name, last_name, email, access_level, deactivated_at, totp_enabled_at,
+	          created_at, updated_at
+	          from users order by last_name, first_name`
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return users, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var u models.User
+		var deactivatedAt, totpEnabledAt sql.NullTime
+		err := rows.Scan(
+			&u.ID,
+			&u.FirstName,
+			&u.LastName,
+			&u.Email,
+			&u.AccessLevel,
+			&deactivatedAt,
+			&totpEnabledAt,
+			&u.CreateAt,
+			&u.UpdatedAt,
+		)
+
+		if err != nil {
+			return users, err
+		}
+
+		u.DeactivatedAt = deactivatedAt.Time
+		u.TOTPEnabledAt = totpEnabledAt.Time
+		users = append(users, u)
+	}
+
+	if err := rows.Err(); err != nil {
+		return users, err
+	}
+
+	return users, nil
+}
+
+// InsertUser inserts a user with a bcrypt hash of password into the database
+func (m *postgresDBRepo) InsertUser(u models.User, password string) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), passwordCost)
+	if err != nil {
+		return 0, err
+	}
+
+	var newID int
+
+	stmt := `insert into users (first_name, last_name, email, password, access_level, created_at, updated_at)
+	         values ($1, $2, $3, $4, $5, $6, $7) returning id`
+
+	err = m.DB.QueryRowContext(ctx, stmt,
+		u.FirstName,
+		u.LastName,
+		u.Email,
+		string(hashedPassword),
+		u.AccessLevel,
+		time.Now(),
+		time.Now(),
+	).Scan(&newID)
+
+	if isUniqueViolation(err) {
+		return 0, repository.ErrDuplicateEmail
+	} else if err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+// UpdateUserPassword replaces a user's password with a bcrypt hash of password
+func (m *postgresDBRepo) UpdateUserPassword(id int, password string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), passwordCost)
+	if err != nil {
+		return err
+	}
+
+	query := `update users set password = $1, password_changed_at = $2, updated_at = $2 where id = $3`
+
+	_, err = m.DB.ExecContext(ctx, query, string(hashedPassword), time.Now(), id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// InsertPasswordReset stores the hash of a password reset token for a user
+func (m *postgresDBRepo) InsertPasswordReset(userID int, tokenHash string, expires time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	stmt := `insert into password_resets (user_id, token_hash, expires_at, created_at, updated_at)
+	         values ($1, $2, $3, $4, $5)`
+
+	_, err := m.DB.ExecContext(ctx, stmt,
+		userID,
+		tokenHash,
+		expires,
+		time.Now(),
+		time.Now(),
+	)
+
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// UsePasswordReset sets a new password for the user a password reset token belongs to and returns the user id.
+// The token and any other outstanding tokens for the user are used up. sql.ErrNoRows is returned if the token
+// doesn't exist, has expired or was already used, and ErrUserDeactivated if the user was deactivated since the
+// token was sent, in which case nothing is changed
+func (m *postgresDBRepo) UsePasswordReset(tokenHash, password string) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), passwordCost)
+	if err != nil {
+		return 0, err
+	}
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var userID int
+	query := `update password_resets set used_at = $1, updated_at = $1
+	          where token_hash = $2 and used_at is null and expires_at > $1
+	          returning user_id`
+
+	err = tx.QueryRowContext(ctx, query, time.Now(), tokenHash).Scan(&userID)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.ExecContext(ctx, `update password_resets set used_at = $1, updated_at = $1
+	                              where user_id = $2 and used_at is null`, time.Now(), userID)
+	if err != nil {
+		return 0, err
+	}
+
+	result, err := tx.ExecContext(ctx, `update users set password = $1, password_changed_at = $2, updated_at = $2
+	                                   where id = $3 and deactivated_at is null`, string(hashedPassword), time.Now(), userID)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	if n == 0 {
+		return 0, repository.ErrUserDeactivated
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+
+	return userID, nil
+}
+
+// SetUserActive deactivates or reactivates a user. Deactivated users can't log in
+func (m *postgresDBRepo) SetUserActive(id int, active bool) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if !active {
+		err = checkNotLastAdmin(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+	}
+
+	var deactivatedAt sql.NullTime
+	if !active {
+		deactivatedAt = sql.NullTime{Time: time.Now(), Valid: true}
+	}
+
+	_, err = tx.ExecContext(ctx, `update users set deactivated_at = $1, updated_at = $2 where id = $3`,
+		deactivatedAt, time.Now(), id)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// checkNotLastAdmin returns ErrLastAdmin if the user is the only active admin. The admin rows are locked until
+// tx ends, so concurrent demotions can't both pass the check
+func checkNotLastAdmin(ctx context.Context, tx *sql.Tx, id int) error {
+	rows, err := tx.QueryContext(ctx, `select id from users
+	                                   where access_level >= $1 and deactivated_at is null
+	                                   for update`, models.AccessLevelAdmin)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var admins []int
+	for rows.Next() {
+		var adminID int
+		err := rows.Scan(&adminID)
+		if err != nil {
+			return err
+		}
+		admins = append(admins, adminID)
+	}
+
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if len(admins) == 1 && admins[0] == id {
+		return repository.ErrLastAdmin
+	}
+
+	return nil
+}
+
+// isUniqueViolation returns true if err was caused by a Postgres unique constraint
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505"
 }
 
 // InsertReservation inserts a reservation into the database
@@ -21,8 +281,8 @@ func (m *postgresDBRepo) InsertReservation(res models.Reservation) (int, error)
 	var newId int
 
 	stmt := `insert into reservations (first_name, last_name, email, phone, 
-		       start_date, end_date, room_id, created_at, updated_at)
-	         values ($1, $2, $3, $4, $5, $6, $7, $8, $9) returning id`
+		       start_date, end_date, room_id, confirmation_code, created_at, updated_at)
+	         values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10) returning id`
 
 	err := m.DB.QueryRowContext(ctx, stmt,
 		res.FirstName,
@@ -32,6 +292,7 @@ func (m *postgresDBRepo) InsertReservation(res models.Reservation) (int, error)
 		res.StartDate,
 		res.EndDate,
 		res.RoomID,
+		nullString(res.ConfirmationCode),
 		time.Now(),
 		time.Now(),
 	).Scan(&newId)
@@ -70,366 +331,3403 @@ func (m *postgresDBRepo) InsertRoomRestriction(r models.RoomRestriction) error {
 	return nil
 }
 
-// SearchAvailabilityByDatesByRoomID returns true if availability exists for roomID else returns false
-func (m *postgresDBRepo) SearchAvailabilityByDatesByRoomID(start, end time.Time, roomID int) (bool, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+// BookRoom inserts a reservation and its room restriction in a single transaction. The room row is locked and
+// availability re-checked first, so ErrRoomUnavailable is returned if the dates were taken in the meantime.
+// The hold with holdID, if it is for the room, is replaced by the reservation, and so doesn't make the room
+// unavailable. A holdID of 0 books without a hold
+func (m *postgresDBRepo) BookRoom(ctx context.Context, res models.Reservation, holdID int) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*3)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// lock the room so that concurrent bookings for it are serialised
+	var roomID int
+	err = tx.QueryRowContext(ctx, `select id from rooms where id = $1 for update`, res.RoomID).Scan(&roomID)
+	if err != nil {
+		return 0, err
+	}
+
+	newID, err := bookRoom(ctx, tx, res, holdID)
+	if err != nil {
+		return 0, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		if isExclusionViolation(err) {
+			return 0, repository.ErrRoomUnavailable
+		}
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+// BookRooms inserts a booking and its reservations, with their room restrictions, in a single transaction, and
+// returns the id of the booking and of each reservation. Like BookRoom, the rooms are locked and availability
+// re-checked, and nothing is booked if any of the rooms was taken in the meantime. holdIDs holds the hold of
+// each reservation, or 0
+func (m *postgresDBRepo) BookRooms(ctx context.Context, b models.Booking, holdIDs []int) (int, []int, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*3)
 	defer cancel()
 
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer tx.Rollback()
+
+	// lock the rooms in the same order as every other group booking, so that they can't deadlock
+	var roomIDs []int
+	for _, res := range b.Reservations {
+		roomIDs = append(roomIDs, res.RoomID)
+	}
+	sort.Ints(roomIDs)
+
+	for _, id := range roomIDs {
+		var roomID int
+		err = tx.QueryRowContext(ctx, `select id from rooms where id = $1 for update`, id).Scan(&roomID)
+		if err != nil {
+			return 0, nil, err
+		}
+	}
+
+	var bookingID int
+	stmt := `insert into bookings (first_name, last_name, email, phone, total_price, created_at, updated_at)
+	         values ($1, $2, $3, $4, $5, $6, $6) returning id`
+
+	err = tx.QueryRowContext(ctx, stmt,
+		b.FirstName,
+		b.LastName,
+		b.Email,
+		b.Phone,
+		b.TotalPrice,
+		time.Now(),
+	).Scan(&bookingID)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	// the restrictions of earlier reservations are visible to the availability checks of later ones, so a
+	// booking can't hold the same room twice on a night
+	var ids []int
+	for i, res := range b.Reservations {
+		res.BookingID = bookingID
+
+		var holdID int
+		if i < len(holdIDs) {
+			holdID = holdIDs[i]
+		}
+
+		id, err := bookRoom(ctx, tx, res, holdID)
+		if err != nil {
+			return 0, nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	if err = tx.Commit(); err != nil {
+		if isExclusionViolation(err) {
+			return 0, nil, repository.ErrRoomUnavailable
+		}
+		return 0, nil, err
+	}
+
+	return bookingID, ids, nil
+}
+
+// bookRoom inserts a reservation and its room restriction in tx, which has locked the room, replacing the
+// hold with holdID. ErrRoomUnavailable is returned if the room is taken on the dates of res
+func bookRoom(ctx context.Context, tx *sql.Tx, res models.Reservation, holdID int) (int, error) {
+	err := replaceHold(ctx, tx, res, holdID)
+	if err != nil {
+		return 0, err
+	}
+
+	newID, err := insertReservation(ctx, tx, res)
+	if err != nil {
+		return 0, err
+	}
+
+	err = insertRestriction(ctx, tx, models.RoomRestriction{
+		StartDate:     res.StartDate,
+		EndDate:       res.EndDate,
+		RoomID:        res.RoomID,
+		ReservationID: newID,
+		RestrictionID: models.RestrictionReservation,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+// replaceHold deletes the hold with holdID on the room of res in tx, which has locked the room, and returns
+// ErrRoomUnavailable if the room is still taken on the dates of res
+func replaceHold(ctx context.Context, tx *sql.Tx, res models.Reservation, holdID int) error {
+	_, err := tx.ExecContext(ctx, `delete from room_restrictions where id = $1 and room_id = $2 and restriction_id = $3`,
+		holdID, res.RoomID, models.RestrictionHold)
+	if err != nil {
+		return err
+	}
+
 	stmt := `
 	select count(id)
 	from room_restrictions
 	where room_id = $1
-	  and $2 < end_date and $3 > start_date`
+	  and $2 < end_date and $3 > start_date
+	  and ` + activeRestriction("$4")
 
 	var numRows int
-	err := m.DB.QueryRowContext(ctx, stmt, roomID, start, end).Scan(&numRows)
+	err = tx.QueryRowContext(ctx, stmt, res.RoomID, res.StartDate, res.EndDate, time.Now()).Scan(&numRows)
 	if err != nil {
-		return false, err
+		return err
 	}
-	return numRows == 0, nil
+
+	if numRows > 0 {
+		return repository.ErrRoomUnavailable
+	}
+
+	return nil
+}
+
+// insertReservation inserts res in tx and returns its id
+func insertReservation(ctx context.Context, tx *sql.Tx, res models.Reservation) (int, error) {
+	var newID int
+
+	quote, err := json.Marshal(res.Quote)
+	if err != nil {
+		return 0, err
+	}
+
+	stmt := `insert into reservations (first_name, last_name, email, phone, start_date, end_date, room_id,
+		       adults, children, booking_id, confirmation_code, total_price, quote, awaiting_payment, created_at, updated_at)
+	         values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16) returning id`
+
+	err = tx.QueryRowContext(ctx, stmt,
+		res.FirstName,
+		res.LastName,
+		res.Email,
+		res.Phone,
+		res.StartDate,
+		res.EndDate,
+		res.RoomID,
+		res.Adults,
+		res.Children,
+		nullInt(res.BookingID),
+		nullString(res.ConfirmationCode),
+		res.Quote.Total,
+		quote,
+		res.AwaitingPayment,
+		time.Now(),
+		time.Now(),
+	).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+
+	err = insertReservationEvent(ctx, tx, newID, "", models.ReservationPending)
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+// insertRestriction inserts a room restriction in tx. ErrRoomUnavailable is returned if it overlaps a
+// reservation
+func insertRestriction(ctx context.Context, tx *sql.Tx, r models.RoomRestriction) error {
+	var expiresAt sql.NullTime
+	if !r.ExpiresAt.IsZero() {
+		expiresAt = sql.NullTime{Time: r.ExpiresAt, Valid: true}
+	}
+
+	stmt := `insert into room_restrictions (start_date, end_date, room_id, reservation_id,
+		       created_at, updated_at, restriction_id, expires_at)
+	         values ($1, $2, $3, $4, $5, $6, $7, $8)`
+
+	_, err := tx.ExecContext(ctx, stmt,
+		r.StartDate,
+		r.EndDate,
+		r.RoomID,
+		nullInt(r.ReservationID),
+		time.Now(),
+		time.Now(),
+		r.RestrictionID,
+		expiresAt,
+	)
+	if err != nil {
+		if isExclusionViolation(err) {
+			return repository.ErrRoomUnavailable
+		}
+		return err
+	}
+
+	return nil
+}
+
+// activeRestriction is a condition on room_restrictions that leaves out holds that expired before the time
+// in the parameter it is applied to, but haven't been swept yet
+func activeRestriction(param string) string {
+	return "(expires_at is null or expires_at > " + param + ")"
+}
+
+// PlaceHold inserts a hold on a room for the dates of hold, which expires at hold.ExpiresAt, and returns its
+// id. Like BookRoom, the room is locked and ErrRoomUnavailable is returned if it is taken on the dates
+func (m *postgresDBRepo) PlaceHold(ctx context.Context, hold models.RoomRestriction) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*3)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// lock the room so that concurrent holds and bookings for it are serialised
+	_, err = tx.ExecContext(ctx, `select id from rooms where id = $1 for update`, hold.RoomID)
+	if err != nil {
+		return 0, err
+	}
+
+	stmt := `
+	select count(id)
+	from room_restrictions
+	where room_id = $1
+	  and $2 < end_date and $3 > start_date
+	  and ` + activeRestriction("$4")
+
+	var numRows int
+	err = tx.QueryRowContext(ctx, stmt, hold.RoomID, hold.StartDate, hold.EndDate, time.Now()).Scan(&numRows)
+	if err != nil {
+		return 0, err
+	}
+
+	if numRows > 0 {
+		return 0, repository.ErrRoomUnavailable
+	}
+
+	stmt = `insert into room_restrictions (start_date, end_date, room_id, restriction_id, expires_at,
+		       created_at, updated_at)
+	         values ($1, $2, $3, $4, $5, $6, $6) returning id`
+
+	var id int
+	err = tx.QueryRowContext(ctx, stmt,
+		hold.StartDate,
+		hold.EndDate,
+		hold.RoomID,
+		models.RestrictionHold,
+		hold.ExpiresAt,
+		time.Now(),
+	).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
+
+// ReleaseHold deletes a hold by id
+func (m *postgresDBRepo) ReleaseHold(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `delete from room_restrictions where id = $1 and restriction_id = $2`,
+		id, models.RestrictionHold)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// DeleteExpiredHolds deletes the holds that expired before now and returns how many were deleted
+func (m *postgresDBRepo) DeleteExpiredHolds(ctx context.Context, now time.Time) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*3)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, `delete from room_restrictions where restriction_id = $1 and expires_at <= $2`,
+		models.RestrictionHold, now)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(n), nil
+}
+
+// CancelUnpaidReservations cancels the reservations that are still awaiting payment after their hold expired
+// before now, and returns how many were cancelled. Their payments are left pending, so that one authorized
+// later finds its reservation closed
+func (m *postgresDBRepo) CancelUnpaidReservations(ctx context.Context, now time.Time) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*3)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	query := `select id, status from reservations r
+	          where awaiting_payment = true and deleted_at is null and status in ($1, $2)
+	            and not exists (select 1 from room_restrictions rr
+	                            where rr.reservation_id = r.id and rr.restriction_id = $3 and rr.expires_at > $4)
+	          for update`
+
+	rows, err := tx.QueryContext(ctx, query, models.ReservationPending, models.ReservationConfirmed,
+		models.RestrictionHold, now)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	var unpaid []models.Reservation
+	for rows.Next() {
+		var res models.Reservation
+		err = rows.Scan(&res.ID, &res.Status)
+		if err != nil {
+			return 0, err
+		}
+		unpaid = append(unpaid, res)
+	}
+
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+
+	const reason = "payment wasn't made in time"
+	for _, res := range unpaid {
+		_, err = tx.ExecContext(ctx, `update reservations set status = $1, cancelled_at = $2, updated_at = $2,
+		                              calendar_sequence = calendar_sequence + 1, cancellation_reason = $3,
+		                              awaiting_payment = false
+		                              where id = $4`, models.ReservationCancelled, time.Now(), reason, res.ID)
+		if err != nil {
+			return 0, err
+		}
+
+		err = insertReservationEvent(ctx, tx, res.ID, res.Status, models.ReservationCancelled)
+		if err != nil {
+			return 0, err
+		}
+
+		_, err = tx.ExecContext(ctx, `delete from room_restrictions where reservation_id = $1`, res.ID)
+		if err != nil {
+			return 0, err
+		}
+
+		before, _ := json.Marshal(map[string]string{"status": res.Status, "cancellation_reason": ""})
+		after, _ := json.Marshal(map[string]string{"status": models.ReservationCancelled, "cancellation_reason": reason})
+		err = insertAuditEntry(ctx, tx, models.AuditEntry{
+			Actor:    "holds",
+			Action:   models.AuditCancel,
+			Entity:   models.AuditEntityReservation,
+			EntityID: res.ID,
+			Before:   string(before),
+			After:    string(after),
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return len(unpaid), tx.Commit()
+}
+
+// isExclusionViolation returns true if err was caused by a Postgres exclusion constraint
+func isExclusionViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23P01"
+}
+
+// SearchAvailabilityByDatesByRoomID returns true if availability exists for roomID else returns false
+func (m *postgresDBRepo) SearchAvailabilityByDatesByRoomID(start, end time.Time, roomID int) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	stmt := `
+	select count(id)
+	from room_restrictions
+	where room_id = $1
+	  and $2 < end_date and $3 > start_date
+	  and ` + activeRestriction("$4")
+
+	var numRows int
+	err := m.DB.QueryRowContext(ctx, stmt, roomID, start, end, time.Now()).Scan(&numRows)
+	if err != nil {
+		return false, err
+	}
+	return numRows == 0, nil
+}
+
+// SearchAvailabilityForAllRooms returns a slice of available rooms if any for a given date range, that sleep
+// a party of adults and children
+func (m *postgresDBRepo) SearchAvailabilityForAllRooms(start, end time.Time, adults, children int) ([]models.Room, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	query := `
+	select ` + roomColumns + `
+	from rooms r
+	where not r.id in (select room_id
+										 from room_restrictions rr
+										 where $1 < end_date and $2 > start_date
+										   and ` + activeRestriction("$5") + `)
+	  and r.max_adults >= $3 and r.max_children >= $4 and r.capacity >= $3 + $4
+	order by r.room_name`
+
+	return m.queryRooms(ctx, query, start, end, adults, children, time.Now())
+}
+
+// roomColumns are the columns of the rooms table aliased r, in the order scanned by scanRoom
+const roomColumns = `r.id, r.room_name, r.slug, r.description, r.capacity, r.max_adults, r.max_children,
+	r.amenities, r.base_price, r.weekend_uplift, coalesce(r.calendar_token, ''), r.created_at, r.updated_at`
+
+func scanRoom(row scanner) (models.Room, error) {
+	var room models.Room
+	var amenities string
+
+	err := row.Scan(
+		&room.ID,
+		&room.RoomName,
+		&room.Slug,
+		&room.Description,
+		&room.Capacity,
+		&room.MaxAdults,
+		&room.MaxChildren,
+		&amenities,
+		&room.BasePrice,
+		&room.WeekendUplift,
+		&room.CalendarToken,
+		&room.CreateAt,
+		&room.UpdatedAt,
+	)
+
+	room.Amenities = splitAmenities(amenities)
+	return room, err
+}
+
+// splitAmenities splits the amenities column, which holds one amenity per line
+func splitAmenities(s string) []string {
+	var amenities []string
+	for _, a := range strings.Split(s, "\n") {
+		if a = strings.TrimSpace(a); a != "" {
+			amenities = append(amenities, a)
+		}
+	}
+
+	return amenities
+}
+
+// queryRooms returns the rooms selected by query, with their photos
+func (m *postgresDBRepo) queryRooms(ctx context.Context, query string, args ...interface{}) ([]models.Room, error) {
+	var rooms []models.Room
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return rooms, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		room, err := scanRoom(rows)
+		if err != nil {
+			return rooms, err
+		}
+
+		rooms = append(rooms, room)
+	}
+
+	if err := rows.Err(); err != nil {
+		return rooms, err
+	}
+
+	if len(rooms) == 0 {
+		return rooms, nil
+	}
+
+	ids := make([]int, len(rooms))
+	for i, room := range rooms {
+		ids[i] = room.ID
+	}
+
+	photos, err := m.roomPhotos(ctx, ids...)
+	if err != nil {
+		return rooms, err
+	}
+
+	for i := range rooms {
+		rooms[i].Photos = photos[rooms[i].ID]
+	}
+
+	return rooms, nil
+}
+
+// getRoom returns the room selected by query, with its photos
+func (m *postgresDBRepo) getRoom(ctx context.Context, query string, args ...interface{}) (models.Room, error) {
+	room, err := scanRoom(m.DB.QueryRowContext(ctx, query, args...))
+	if err != nil {
+		return room, err
+	}
+
+	photos, err := m.roomPhotos(ctx, room.ID)
+	if err != nil {
+		return room, err
+	}
+
+	room.Photos = photos[room.ID]
+	return room, nil
+}
+
+// roomPhotos returns the photos of rooms in order, by room id
+func (m *postgresDBRepo) roomPhotos(ctx context.Context, roomIDs ...int) (map[int][]models.RoomPhoto, error) {
+	photos := make(map[int][]models.RoomPhoto)
+
+	query := `select id, room_id, filename, caption, sort_order, created_at, updated_at
+	          from room_photos where room_id = any($1) order by room_id, sort_order, id`
+
+	rows, err := m.DB.QueryContext(ctx, query, roomIDs)
+	if err != nil {
+		return photos, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var p models.RoomPhoto
+		err := rows.Scan(
+			&p.ID,
+			&p.RoomID,
+			&p.Filename,
+			&p.Caption,
+			&p.SortOrder,
+			&p.CreateAt,
+			&p.UpdatedAt,
+		)
+
+		if err != nil {
+			return photos, err
+		}
+
+		photos[p.RoomID] = append(photos[p.RoomID], p)
+	}
+
+	return photos, rows.Err()
+}
+
+// GetRoomByID gets a room by id, with its photos
+func (m *postgresDBRepo) GetRoomByID(id int) (models.Room, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	return m.getRoom(ctx, `select `+roomColumns+` from rooms r where r.id = $1`, id)
+}
+
+// GetRoomBySlug gets a room by its slug, with its photos
+func (m *postgresDBRepo) GetRoomBySlug(slug string) (models.Room, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	return m.getRoom(ctx, `select `+roomColumns+` from rooms r where r.slug = $1`, slug)
+}
+
+// GetUserByID returns a user by id
+func (m *postgresDBRepo) GetUserByID(id int) (models.User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	query := `select id, first_name, last_name, email, password, access_level, deactivated_at,
+	          password_changed_at, totp_enabled_at, created_at, updated_at
+	          from users where id = $1`
+
+	return scanUser(m.DB.QueryRowContext(ctx, query, id))
+}
+
+// GetUserByEmail returns a user by email address
+func (m *postgresDBRepo) GetUserByEmail(email string) (models.User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	query := `select id, first_name, last_name, email, password, access_level, deactivated_at,
+	          password_changed_at, totp_enabled_at, created_at, updated_at
+	          from users where lower(email) = lower($1)`
+
+	return scanUser(m.DB.QueryRowContext(ctx, query, email))
+}
+
+// scanUser scans a row of user columns into a user
+func scanUser(row *sql.Row) (models.User, error) {
+	var u models.User
+	var deactivatedAt, passwordChangedAt, totpEnabledAt sql.NullTime
+	err := row.Scan(
+		&u.ID,
+		&u.FirstName,
+		&u.LastName,
+		&u.Email,
+		&u.Password,
+		&u.AccessLevel,
+		&deactivatedAt,
+		&passwordChangedAt,
+		&totpEnabledAt,
+		&u.CreateAt,
+		&u.UpdatedAt,
+	)
+
+	if err != nil {
+		return u, err
+	}
+
+	u.DeactivatedAt = deactivatedAt.Time
+	u.PasswordChangedAt = passwordChangedAt.Time
+	u.TOTPEnabledAt = totpEnabledAt.Time
+
+	return u, nil
+}
+
+// UpdateUser updates a user's details and access level. It returns ErrLastAdmin if this would demote the only
+// active admin
+func (m *postgresDBRepo) UpdateUser(u models.User) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if u.AccessLevel < models.AccessLevelAdmin {
+		err = checkNotLastAdmin(ctx, tx, u.ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	query := `update users set first_name = $1, last_name = $2, email = $3, access_level = $4, updated_at = $5
+	          where id = $6`
+
+	_, err = tx.ExecContext(ctx, query,
+		u.FirstName,
+		u.LastName,
+		u.Email,
+		u.AccessLevel,
+		time.Now(),
+		u.ID,
+	)
+
+	if isUniqueViolation(err) {
+		return repository.ErrDuplicateEmail
+	} else if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Authenticate authenticates an active user
+func (m *postgresDBRepo) Authenticate(email, testPassword string) (int, string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	var id int
+	var hashedPassword string
+
+	row := m.DB.QueryRowContext(ctx, `select id, password from users where email = $1 and deactivated_at is null`, email)
+	err := row.Scan(&id, &hashedPassword)
+	if err != nil {
+		return 0, "", err
+	}
+
+	err = bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(testPassword))
+	if err == bcrypt.ErrMismatchedHashAndPassword {
+		return 0, "", errors.New("incorrect password")
+	} else if err != nil {
+		return 0, "", err
+	}
+
+	return id, hashedPassword, nil
+}
+
+// AllReservations returns a slice of all reservations that haven't been deleted, or of the reservations in status
+// if it isn't empty
+func (m *postgresDBRepo) AllReservations(status string) ([]models.Reservation, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	query := `
+		select r.id, r.first_name, r.last_name, r.email, r.phone, r.start_date,
+		r.end_date, r.room_id, coalesce(r.booking_id, 0), r.confirmation_code, r.cancelled_at, r.created_at,
+		r.updated_at, r.status, r.awaiting_payment, r.deleted_at, rm.id, rm.room_name
+		from reservations r
+		left join rooms rm on
+		  rm.id = r.room_id
+		where (r.status = $1 or $1 = '') and r.deleted_at is null
+		order by r.start_date asc
+	`
+
+	return m.queryReservationList(ctx, query, status)
+}
+
+// AllNewReservations returns the reservations that are pending, confirmed or checked in and aren't awaiting
+// payment or deleted, or only those in status if it isn't empty
+func (m *postgresDBRepo) AllNewReservations(status string) ([]models.Reservation, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	query := `
+		select r.id, r.first_name, r.last_name, r.email, r.phone, r.start_date,
+		r.end_date, r.room_id, coalesce(r.booking_id, 0), r.confirmation_code, r.cancelled_at, r.created_at,
+		r.updated_at, r.status, r.awaiting_payment, r.deleted_at, rm.id, rm.room_name
+		from reservations r
+		left join rooms rm on
+		  rm.id = r.room_id
+		where r.status in ($2, $3, $4) and (r.status = $1 or $1 = '') and not r.awaiting_payment
+		  and r.deleted_at is null
+		order by r.start_date asc
+	`
+
+	return m.queryReservationList(ctx, query, status,
+		models.ReservationPending, models.ReservationConfirmed, models.ReservationCheckedIn)
+}
+
+// queryReservationList returns the reservations selected by query with the columns of the reservation lists
+func (m *postgresDBRepo) queryReservationList(ctx context.Context, query string, args ...interface{}) ([]models.Reservation, error) {
+	var reservations []models.Reservation
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return reservations, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var r models.Reservation
+		var confirmationCode sql.NullString
+		var cancelledAt, deletedAt sql.NullTime
+		err := rows.Scan(
+			&r.ID,
+			&r.FirstName,
+			&r.LastName,
+			&r.Email,
+			&r.Phone,
+			&r.StartDate,
+			&r.EndDate,
+			&r.RoomID,
+			&r.BookingID,
+			&confirmationCode,
+			&cancelledAt,
+			&r.CreateAt,
+			&r.UpdatedAt,
+			&r.Status,
+			&r.AwaitingPayment,
+			&deletedAt,
+			&r.Room.ID,
+			&r.Room.RoomName,
+		)
+
+		if err != nil {
+			return reservations, err
+		}
+
+		r.ConfirmationCode = confirmationCode.String
+		r.CancelledAt = cancelledAt.Time
+		r.DeletedAt = deletedAt.Time
+		reservations = append(reservations, r)
+	}
+
+	if err := rows.Err(); err != nil {
+		return reservations, err
+	}
+
+	return reservations, nil
+}
+
+// GetReservationByID returns a reservation by id. Deleted reservations aren't returned
+func (m *postgresDBRepo) GetReservationByID(id int) (models.Reservation, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	query := `
+	select r.id, r.first_name, r.last_name, r.email, r.phone, r.start_date,
+	r.end_date, r.room_id, r.adults, r.children, coalesce(r.booking_id, 0), r.confirmation_code,
+	r.calendar_sequence, r.quote, r.cancelled_at, r.cancellation_reason, r.cancellation_fee, r.created_at, r.updated_at,
+	r.status, r.awaiting_payment, rm.id, rm.room_name
+	from reservations r
+	left join rooms rm on
+		rm.id = r.room_id
+	where r.id = $1 and r.deleted_at is null`
+
+	return scanReservation(m.DB.QueryRowContext(ctx, query, id))
+}
+
+// GetReservationByCode returns the reservation with a confirmation code, as long as email matches as well and it
+// hasn't been deleted
+func (m *postgresDBRepo) GetReservationByCode(code, email string) (models.Reservation, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	query := `
+	select r.id, r.first_name, r.last_name, r.email, r.phone, r.start_date,
+	r.end_date, r.room_id, r.adults, r.children, coalesce(r.booking_id, 0), r.confirmation_code,
+	r.calendar_sequence, r.quote, r.cancelled_at, r.cancellation_reason, r.cancellation_fee, r.created_at, r.updated_at,
+	r.status, r.awaiting_payment, rm.id, rm.room_name
+	from reservations r
+	left join rooms rm on
+		rm.id = r.room_id
+	where r.confirmation_code = upper($1) and lower(r.email) = lower($2) and r.deleted_at is null`
+
+	return scanReservation(m.DB.QueryRowContext(ctx, query, strings.TrimSpace(code), strings.TrimSpace(email)))
+}
+
+// scanReservation scans a row of reservation columns, followed by the room id and name, into a reservation
+func scanReservation(row scanner) (models.Reservation, error) {
+	var r models.Reservation
+	var confirmationCode sql.NullString
+	var quote []byte
+	var cancelledAt sql.NullTime
+	err := row.Scan(
+		&r.ID,
+		&r.FirstName,
+		&r.LastName,
+		&r.Email,
+		&r.Phone,
+		&r.StartDate,
+		&r.EndDate,
+		&r.RoomID,
+		&r.Adults,
+		&r.Children,
+		&r.BookingID,
+		&confirmationCode,
+		&r.CalendarSequence,
+		&quote,
+		&cancelledAt,
+		&r.CancellationReason,
+		&r.CancellationFee,
+		&r.CreateAt,
+		&r.UpdatedAt,
+		&r.Status,
+		&r.AwaitingPayment,
+		&r.Room.ID,
+		&r.Room.RoomName,
+	)
+
+	if err != nil {
+		return r, err
+	}
+
+	r.ConfirmationCode = confirmationCode.String
+	r.CancelledAt = cancelledAt.Time
+
+	// reservations made before prices were quoted have no quote
+	if quote != nil {
+		if err := json.Unmarshal(quote, &r.Quote); err != nil {
+			return r, err
+		}
+	}
+
+	return r, nil
+}
+
+// GetBookingByID returns a booking with its reservations that haven't been deleted, in order of arrival
+func (m *postgresDBRepo) GetBookingByID(id int) (models.Booking, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	var b models.Booking
+
+	query := `select id, first_name, last_name, email, phone, total_price, created_at, updated_at
+	          from bookings where id = $1`
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&b.ID,
+		&b.FirstName,
+		&b.LastName,
+		&b.Email,
+		&b.Phone,
+		&b.TotalPrice,
+		&b.CreateAt,
+		&b.UpdatedAt,
+	)
+	if err != nil {
+		return b, err
+	}
+
+	query = `
+	select r.id, r.first_name, r.last_name, r.email, r.phone, r.start_date,
+	r.end_date, r.room_id, r.adults, r.children, coalesce(r.booking_id, 0), r.confirmation_code,
+	r.calendar_sequence, r.quote, r.cancelled_at, r.cancellation_reason, r.cancellation_fee, r.created_at, r.updated_at,
+	r.status, r.awaiting_payment, rm.id, rm.room_name
+	from reservations r
+	left join rooms rm on
+		rm.id = r.room_id
+	where r.booking_id = $1 and r.deleted_at is null
+	order by r.start_date, r.id`
+
+	rows, err := m.DB.QueryContext(ctx, query, id)
+	if err != nil {
+		return b, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		r, err := scanReservation(rows)
+		if err != nil {
+			return b, err
+		}
+		b.Reservations = append(b.Reservations, r)
+	}
+
+	if err := rows.Err(); err != nil {
+		return b, err
+	}
+
+	return b, nil
+}
+
+// ChangeReservationDates moves a reservation and its room restriction to new dates, replacing its quote with
+// one for the new dates. Like BookRoom, the room is locked and availability re-checked, ignoring the
+// reservation itself, and ErrRoomUnavailable is returned if the room is taken on the new dates. The calendar
+// sequence is incremented so that calendar invites sent for the new dates replace earlier ones. Only pending
+// and confirmed reservations that haven't been deleted can be moved; sql.ErrNoRows is returned for others
+func (m *postgresDBRepo) ChangeReservationDates(ctx context.Context, id int, start, end time.Time, quote models.Quote) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*3)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var roomID int
+	query := `select room_id from reservations where id = $1 and status in ($2, $3) and deleted_at is null`
+	err = tx.QueryRowContext(ctx, query, id, models.ReservationPending, models.ReservationConfirmed).Scan(&roomID)
+	if err != nil {
+		return err
+	}
+
+	// lock the room so that concurrent bookings for it are serialised
+	_, err = tx.ExecContext(ctx, `select id from rooms where id = $1 for update`, roomID)
+	if err != nil {
+		return err
+	}
+
+	stmt := `
+	select count(id)
+	from room_restrictions
+	where room_id = $1
+	  and $2 < end_date and $3 > start_date
+	  and (reservation_id is null or reservation_id <> $4)
+	  and ` + activeRestriction("$5")
+
+	var numRows int
+	err = tx.QueryRowContext(ctx, stmt, roomID, start, end, id, time.Now()).Scan(&numRows)
+	if err != nil {
+		return err
+	}
+
+	if numRows > 0 {
+		return repository.ErrRoomUnavailable
+	}
+
+	quoteJSON, err := json.Marshal(quote)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `update reservations set start_date = $1, end_date = $2, total_price = $3,
+	                              quote = $4, updated_at = $5, calendar_sequence = calendar_sequence + 1
+	                              where id = $6`, start, end, quote.Total, quoteJSON, time.Now(), id)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `update room_restrictions set start_date = $1, end_date = $2, updated_at = $3
+	                              where reservation_id = $4`, start, end, time.Now(), id)
+	if err != nil {
+		if isExclusionViolation(err) {
+			return repository.ErrRoomUnavailable
+		}
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		if isExclusionViolation(err) {
+			return repository.ErrRoomUnavailable
+		}
+		return err
+	}
+
+	return nil
+}
+
+// CancelReservation marks a reservation as cancelled for reason at a cost of fee, increments its calendar
+// sequence, releases its room restriction and records the change of state. sql.ErrNoRows is returned if the
+// reservation doesn't exist, was deleted or was already cancelled, and ErrInvalidTransition if the guest has already arrived
+func (m *postgresDBRepo) CancelReservation(id int, reason string, fee int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var from string
+	err = tx.QueryRowContext(ctx, `select status from reservations where id = $1 and deleted_at is null for update`,
+		id).Scan(&from)
+	if err != nil {
+		return err
+	}
+
+	if from == models.ReservationCancelled {
+		return sql.ErrNoRows
+	}
+
+	if !lifecycle.Can(from, models.ReservationCancelled) {
+		return repository.ErrInvalidTransition
+	}
+
+	_, err = tx.ExecContext(ctx, `update reservations set status = $1, cancelled_at = $2, updated_at = $2,
+	                              calendar_sequence = calendar_sequence + 1, cancellation_reason = $3,
+	                              cancellation_fee = $4
+	                              where id = $5`, models.ReservationCancelled, time.Now(), reason, fee, id)
+	if err != nil {
+		return err
+	}
+
+	err = insertReservationEvent(ctx, tx, id, from, models.ReservationCancelled)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `delete from room_restrictions where reservation_id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// nullString stores an empty string as null
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+// nullInt stores an id of 0 as null
+func nullInt(id int) sql.NullInt64 {
+	return sql.NullInt64{Int64: int64(id), Valid: id != 0}
+}
+
+// UpdateReservation updates a reservation in the database
+func (m *postgresDBRepo) UpdateReservation(r models.Reservation) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	query := `update reservations set first_name = $1, last_name = $2, email = $3, phone = $4, updated_at = $5
+	          where id = $6 and deleted_at is null`
+
+	_, err := m.DB.ExecContext(ctx, query,
+		r.FirstName,
+		r.LastName,
+		r.Email,
+		r.Phone,
+		time.Now(),
+		r.ID,
+	)
+
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// DeleteReservation moves a reservation to the trash and releases its room restriction, so that the room can
+// be booked again. sql.ErrNoRows is returned if the reservation doesn't exist or was already deleted
+func (m *postgresDBRepo) DeleteReservation(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `update reservations set deleted_at = $1, updated_at = $1
+	                                    where id = $2 and deleted_at is null`, time.Now(), id)
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+
+	_, err = tx.ExecContext(ctx, `delete from room_restrictions where reservation_id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// DeletedReservations returns the reservations in the trash, most recently deleted first
+func (m *postgresDBRepo) DeletedReservations() ([]models.Reservation, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	query := `
+		select r.id, r.first_name, r.last_name, r.email, r.phone, r.start_date,
+		r.end_date, r.room_id, coalesce(r.booking_id, 0), r.confirmation_code, r.cancelled_at, r.created_at,
+		r.updated_at, r.status, r.awaiting_payment, r.deleted_at, rm.id, rm.room_name
+		from reservations r
+		left join rooms rm on
+		  rm.id = r.room_id
+		where r.deleted_at is not null and r.purged_at is null
+		order by r.deleted_at desc
+	`
+
+	return m.queryReservationList(ctx, query)
+}
+
+// RestoreReservation takes a reservation out of the trash. If it's still open and not awaiting payment, its room
+// restriction is re-created, and like BookRoom the room is locked and availability re-checked first, so ErrRoomUnavailable is
+// returned if the room was booked in the meantime. sql.ErrNoRows is returned if the reservation isn't in the
+// trash
+func (m *postgresDBRepo) RestoreReservation(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var res models.Reservation
+	var awaitingPayment bool
+	err = tx.QueryRowContext(ctx, `select id, room_id, start_date, end_date, status, awaiting_payment
+	                               from reservations
+	                               where id = $1 and deleted_at is not null and purged_at is null
+	                               for update`, id).Scan(
+		&res.ID, &res.RoomID, &res.StartDate, &res.EndDate, &res.Status, &awaitingPayment)
+	if err != nil {
+		return err
+	}
+
+	// only open, paid reservations hold their room; unpaid ones are cancelled once their hold expires
+	if lifecycle.Open(res.Status) && !awaitingPayment {
+		// lock the room so that concurrent bookings for it are serialised
+		_, err = tx.ExecContext(ctx, `select id from rooms where id = $1 for update`, res.RoomID)
+		if err != nil {
+			return err
+		}
+
+		err = replaceHold(ctx, tx, res, 0)
+		if err != nil {
+			return err
+		}
+
+		err = insertRestriction(ctx, tx, models.RoomRestriction{
+			StartDate:     res.StartDate,
+			EndDate:       res.EndDate,
+			RoomID:        res.RoomID,
+			ReservationID: res.ID,
+			RestrictionID: models.RestrictionReservation,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `update reservations set deleted_at = null, updated_at = $1 where id = $2`,
+		time.Now(), id)
+	if err != nil {
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		if isExclusionViolation(err) {
+			return repository.ErrRoomUnavailable
+		}
+		return err
+	}
+
+	return nil
+}
+
+// guestFields are the audited fields of a reservation that identify its guest
+var guestFields = []string{"first_name", "last_name", "email", "phone"}
+
+// PurgeDeletedReservations purges the reservations that were moved to the trash before before, and returns how
+// many were purged. The guest's details are erased, from the reservation, from its audit log entries and from
+// mail to or about the guest that hasn't been sent, but the reservations are kept, with their history and
+// payments, and can no longer be restored. Each purge is recorded in the audit log
+func (m *postgresDBRepo) PurgeDeletedReservations(ctx context.Context, before time.Time) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*3)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	query := `select id, email from reservations
+	          where deleted_at is not null and deleted_at <= $1 and purged_at is null
+	          for update`
+
+	rows, err := tx.QueryContext(ctx, query, before)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	var purged []models.Reservation
+	for rows.Next() {
+		var res models.Reservation
+		err = rows.Scan(&res.ID, &res.Email)
+		if err != nil {
+			return 0, err
+		}
+		purged = append(purged, res)
+	}
+
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for _, res := range purged {
+		_, err = tx.ExecContext(ctx, `update reservations set first_name = '', last_name = '', email = '', phone = '',
+		                              purged_at = $1, updated_at = $1
+		                              where id = $2`, time.Now(), res.ID)
+		if err != nil {
+			return 0, err
+		}
+
+		err = redactAuditEntries(ctx, tx, models.AuditEntityReservation, res.ID, guestFields)
+		if err != nil {
+			return 0, err
+		}
+
+		err = redactUnsentMail(ctx, tx, res.Email)
+		if err != nil {
+			return 0, err
+		}
+
+		err = insertAuditEntry(ctx, tx, models.AuditEntry{
+			Actor:    "trash",
+			Action:   models.AuditPurge,
+			Entity:   models.AuditEntityReservation,
+			EntityID: res.ID,
+			Before:   "{}",
+			After:    `{"email":"","first_name":"","last_name":"","phone":""}`,
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return len(purged), tx.Commit()
+}
+
+// redactAuditEntries blanks fields in the before and after values of the audit log entries of an entity, as
+// part of tx
+func redactAuditEntries(ctx context.Context, tx *sql.Tx, entity string, id int, fields []string) error {
+	rows, err := tx.QueryContext(ctx, `select id, before, after from audit_log where entity = $1 and entity_id = $2
+	                                   for update`, entity, id)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var entries []models.AuditEntry
+	for rows.Next() {
+		var e models.AuditEntry
+		err = rows.Scan(&e.ID, &e.Before, &e.After)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, e)
+	}
+
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		before, err := redactJSON(e.Before, fields)
+		if err != nil {
+			return err
+		}
+
+		after, err := redactJSON(e.After, fields)
+		if err != nil {
+			return err
+		}
+
+		if before == e.Before && after == e.After {
+			continue
+		}
+
+		_, err = tx.ExecContext(ctx, `update audit_log set before = $1, after = $2, updated_at = $3 where id = $4`,
+			before, after, time.Now(), e.ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// redactJSON blanks fields in the JSON object s. Fields s doesn't have aren't added
+func redactJSON(s string, fields []string) (string, error) {
+	var values map[string]interface{}
+	err := json.Unmarshal([]byte(s), &values)
+	if err != nil {
+		return s, err
+	}
+
+	redacted := false
+	for _, f := range fields {
+		if v, ok := values[f]; ok && v != "" {
+			values[f] = ""
+			redacted = true
+		}
+	}
+
+	if !redacted {
+		return s, nil
+	}
+
+	b, err := json.Marshal(values)
+	return string(b), err
+}
+
+// redactUnsentMail erases the mail to or about the guest with email that is still queued or was dead-lettered,
+// as part of tx. The messages are kept as dead letters, so they're never sent
+func redactUnsentMail(ctx context.Context, tx *sql.Tx, email string) error {
+	if email == "" {
+		return nil
+	}
+
+	stmt := `update outbox set to_address = '', subject = '', content = '', text_content = '', attachments = '',
+	           status = $1, last_error = 'erased when the reservation was purged', locked_until = null,
+	           updated_at = $2
+	         where status <> $3
+	           and (lower(to_address) = lower($4) or strpos(content, $4) > 0 or strpos(text_content, $4) > 0)`
+
+	_, err := tx.ExecContext(ctx, stmt, mailer.StatusDead, time.Now(), mailer.StatusSent, email)
+	return err
+}
+
+// TransitionReservation changes the state of a reservation to status and records the change. ErrInvalidTransition
+// is returned if the reservation can't change to status from its current state. Reservations are cancelled
+// with CancelReservation, which also frees the room
+func (m *postgresDBRepo) TransitionReservation(id int, status string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var from string
+	err = tx.QueryRowContext(ctx, `select status from reservations where id = $1 and deleted_at is null for update`,
+		id).Scan(&from)
+	if err != nil {
+		return err
+	}
+
+	if status == models.ReservationCancelled || !lifecycle.Can(from, status) {
+		return repository.ErrInvalidTransition
+	}
+
+	_, err = tx.ExecContext(ctx, `update reservations set status = $1, updated_at = $2 where id = $3`,
+		status, time.Now(), id)
+	if err != nil {
+		return err
+	}
+
+	err = insertReservationEvent(ctx, tx, id, from, status)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// insertReservationEvent records in tx that the reservation with id changed from one state to another
+func insertReservationEvent(ctx context.Context, tx *sql.Tx, id int, from, to string) error {
+	stmt := `insert into reservation_events (reservation_id, from_status, to_status, created_at, updated_at)
+	         values ($1, $2, $3, $4, $5)`
+
+	_, err := tx.ExecContext(ctx, stmt, id, from, to, time.Now(), time.Now())
+	return err
+}
+
+// ReservationEvents returns the changes of state of a reservation, oldest first
+func (m *postgresDBRepo) ReservationEvents(reservationID int) ([]models.ReservationEvent, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	var events []models.ReservationEvent
+
+	query := `select id, reservation_id, from_status, to_status, created_at, updated_at
+	          from reservation_events where reservation_id = $1 order by created_at, id`
+
+	rows, err := m.DB.QueryContext(ctx, query, reservationID)
+	if err != nil {
+		return events, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var e models.ReservationEvent
+		err := rows.Scan(
+			&e.ID,
+			&e.ReservationID,
+			&e.FromStatus,
+			&e.ToStatus,
+			&e.CreateAt,
+			&e.UpdatedAt,
+		)
+		if err != nil {
+			return events, err
+		}
+		events = append(events, e)
+	}
+
+	if err := rows.Err(); err != nil {
+		return events, err
+	}
+
+	return events, nil
+}
+
+// InsertAuditEntry records a change in the audit log
+func (m *postgresDBRepo) InsertAuditEntry(e models.AuditEntry) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = insertAuditEntry(ctx, tx, e)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// insertAuditEntry records a change in the audit log, as part of the change made in tx
+func insertAuditEntry(ctx context.Context, tx *sql.Tx, e models.AuditEntry) error {
+	stmt := `insert into audit_log (actor_id, actor, action, entity, entity_id, before, after, ip, created_at, updated_at)
+	         values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`
+
+	_, err := tx.ExecContext(ctx, stmt,
+		nullInt(e.ActorID),
+		e.Actor,
+		e.Action,
+		e.Entity,
+		e.EntityID,
+		e.Before,
+		e.After,
+		e.IP,
+		time.Now(),
+		time.Now(),
+	)
+
+	return err
+}
+
+// AuditEntries returns the audit log entries selected by f, newest first. At most 200 are returned unless f
+// sets a limit
+func (m *postgresDBRepo) AuditEntries(f models.AuditFilter) ([]models.AuditEntry, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	var entries []models.AuditEntry
+
+	limit := f.Limit
+	if limit == 0 {
+		limit = 200
+	}
+
+	query := `
+		select id, coalesce(actor_id, 0), actor, action, entity, entity_id, before, after, ip, created_at, updated_at
+		from audit_log
+		where (entity = $1 or $1 = '')
+		  and (entity_id = $2 or $2 = 0)
+		  and (actor_id = $3 or $3 = 0)
+		  and (action = $4 or $4 = '')
+		order by created_at desc, id desc
+		limit $5
+	`
+
+	rows, err := m.DB.QueryContext(ctx, query, f.Entity, f.EntityID, f.ActorID, f.Action, limit)
+	if err != nil {
+		return entries, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var e models.AuditEntry
+		err := rows.Scan(
+			&e.ID,
+			&e.ActorID,
+			&e.Actor,
+			&e.Action,
+			&e.Entity,
+			&e.EntityID,
+			&e.Before,
+			&e.After,
+			&e.IP,
+			&e.CreateAt,
+			&e.UpdatedAt,
+		)
+		if err != nil {
+			return entries, err
+		}
+		entries = append(entries, e)
+	}
+
+	if err := rows.Err(); err != nil {
+		return entries, err
+	}
+
+	return entries, nil
+}
+
+// AllRooms returns a slice of all rooms, with their photos
+func (m *postgresDBRepo) AllRooms() ([]models.Room, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	return m.queryRooms(ctx, `select `+roomColumns+` from rooms r order by r.room_name`)
+}
+
+// InsertRoom inserts a room into the database. ErrDuplicateSlug is returned if its slug is taken
+func (m *postgresDBRepo) InsertRoom(room models.Room) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	var newID int
+
+	stmt := `insert into rooms (room_name, slug, description, capacity, max_adults, max_children, amenities,
+	           base_price, weekend_uplift, created_at, updated_at)
+	         values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $10) returning id`
+
+	err := m.DB.QueryRowContext(ctx, stmt,
+		room.RoomName,
+		room.Slug,
+		room.Description,
+		room.Capacity,
+		room.MaxAdults,
+		room.MaxChildren,
+		strings.Join(room.Amenities, "\n"),
+		room.BasePrice,
+		room.WeekendUplift,
+		time.Now(),
+	).Scan(&newID)
+
+	if isUniqueViolation(err) {
+		return 0, repository.ErrDuplicateSlug
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+// UpdateRoom updates the details of a room. ErrDuplicateSlug is returned if its slug is taken
+func (m *postgresDBRepo) UpdateRoom(room models.Room) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	stmt := `update rooms set room_name = $1, slug = $2, description = $3, capacity = $4, max_adults = $5,
+	         max_children = $6, amenities = $7, base_price = $8, weekend_uplift = $9, updated_at = $10
+	         where id = $11`
+
+	_, err := m.DB.ExecContext(ctx, stmt,
+		room.RoomName,
+		room.Slug,
+		room.Description,
+		room.Capacity,
+		room.MaxAdults,
+		room.MaxChildren,
+		strings.Join(room.Amenities, "\n"),
+		room.BasePrice,
+		room.WeekendUplift,
+		time.Now(),
+		room.ID,
+	)
+
+	if isUniqueViolation(err) {
+		return repository.ErrDuplicateSlug
+	}
+
+	return err
+}
+
+// DeleteRoom deletes a room with its photos, blocks and external calendars. ErrRoomInUse is returned if the
+// room has reservations
+func (m *postgresDBRepo) DeleteRoom(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// lock the room so that it can't be booked while it is deleted
+	_, err = tx.ExecContext(ctx, `select id from rooms where id = $1 for update`, id)
+	if err != nil {
+		return err
+	}
+
+	var reservations int
+	err = tx.QueryRowContext(ctx, `select count(id) from reservations where room_id = $1`, id).Scan(&reservations)
+	if err != nil {
+		return err
+	}
+
+	if reservations > 0 {
+		return repository.ErrRoomInUse
+	}
+
+	_, err = tx.ExecContext(ctx, `delete from rooms where id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// InsertRoomPhoto adds a photo after the other photos of its room
+func (m *postgresDBRepo) InsertRoomPhoto(p models.RoomPhoto) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	var newID int
+
+	stmt := `insert into room_photos (room_id, filename, caption, sort_order, created_at, updated_at)
+	         values ($1, $2, $3,
+	           (select coalesce(max(sort_order), 0) + 1 from room_photos where room_id = $1),
+	           $4, $4)
+	         returning id`
+
+	err := m.DB.QueryRowContext(ctx, stmt,
+		p.RoomID,
+		p.Filename,
+		p.Caption,
+		time.Now(),
+	).Scan(&newID)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+// DeleteRoomPhoto deletes a photo of a room and returns it, so that its file can be removed
+func (m *postgresDBRepo) DeleteRoomPhoto(roomID, photoID int) (models.RoomPhoto, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	var p models.RoomPhoto
+
+	query := `delete from room_photos where id = $1 and room_id = $2
+	          returning id, room_id, filename, caption, sort_order, created_at, updated_at`
+
+	err := m.DB.QueryRowContext(ctx, query, photoID, roomID).Scan(
+		&p.ID,
+		&p.RoomID,
+		&p.Filename,
+		&p.Caption,
+		&p.SortOrder,
+		&p.CreateAt,
+		&p.UpdatedAt,
+	)
+
+	return p, err
+}
+
+// SetRoomPhotoOrder orders the photos of a room as in photoIDs. Photos of other rooms are ignored
+func (m *postgresDBRepo) SetRoomPhotoOrder(roomID int, photoIDs []int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for i, id := range photoIDs {
+		_, err = tx.ExecContext(ctx, `update room_photos set sort_order = $1, updated_at = $2
+		                              where id = $3 and room_id = $4`, i+1, time.Now(), id, roomID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// querySeasonalRates runs a query for seasonal rate columns, followed by the room id and name
+func (m *postgresDBRepo) querySeasonalRates(ctx context.Context, query string, args ...interface{}) ([]models.SeasonalRate, error) {
+	var seasons []models.SeasonalRate
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return seasons, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var s models.SeasonalRate
+		err := rows.Scan(
+			&s.ID,
+			&s.RoomID,
+			&s.Name,
+			&s.StartDate,
+			&s.EndDate,
+			&s.Price,
+			&s.CreateAt,
+			&s.UpdatedAt,
+			&s.Room.ID,
+			&s.Room.RoomName,
+		)
+		if err != nil {
+			return seasons, err
+		}
+
+		seasons = append(seasons, s)
+	}
+
+	if err := rows.Err(); err != nil {
+		return seasons, err
+	}
+
+	return seasons, nil
+}
+
+// AllSeasonalRates returns a slice of all seasonal rates, ordered by room and start date
+func (m *postgresDBRepo) AllSeasonalRates() ([]models.SeasonalRate, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	query := `select s.id, s.room_id, s.name, s.start_date, s.end_date, s.price, s.created_at, s.updated_at,
+	          r.id, r.room_name
+	          from seasonal_rates s
+	          left join rooms r on r.id = s.room_id
+	          order by r.room_name, s.start_date`
+
+	return m.querySeasonalRates(ctx, query)
+}
+
+// SeasonalRatesForRoom returns the seasonal rates of a room that apply to some night of a stay from start to end
+func (m *postgresDBRepo) SeasonalRatesForRoom(roomID int, start, end time.Time) ([]models.SeasonalRate, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	query := `select s.id, s.room_id, s.name, s.start_date, s.end_date, s.price, s.created_at, s.updated_at,
+	          r.id, r.room_name
+	          from seasonal_rates s
+	          left join rooms r on r.id = s.room_id
+	          where s.room_id = $1 and s.start_date < $3 and s.end_date >= $2
+	          order by s.start_date`
+
+	return m.querySeasonalRates(ctx, query, roomID, start, end)
+}
+
+// InsertSeasonalRate inserts a seasonal rate into the database
+func (m *postgresDBRepo) InsertSeasonalRate(s models.SeasonalRate) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	var newID int
+
+	stmt := `insert into seasonal_rates (room_id, name, start_date, end_date, price, created_at, updated_at)
+	         values ($1, $2, $3, $4, $5, $6, $6) returning id`
+
+	err := m.DB.QueryRowContext(ctx, stmt,
+		s.RoomID,
+		s.Name,
+		s.StartDate,
+		s.EndDate,
+		s.Price,
+		time.Now(),
+	).Scan(&newID)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+// DeleteSeasonalRate deletes a seasonal rate
+func (m *postgresDBRepo) DeleteSeasonalRate(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `delete from seasonal_rates where id = $1`, id)
+	return err
+}
+
+// AllStayDiscounts returns a slice of all length of stay discounts, ordered by the nights they start at
+func (m *postgresDBRepo) AllStayDiscounts() ([]models.StayDiscount, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	var discounts []models.StayDiscount
+
+	query := `select id, min_nights, percent, created_at, updated_at
+	          from stay_discounts
+	          order by min_nights, percent`
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return discounts, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var d models.StayDiscount
+		err := rows.Scan(&d.ID, &d.MinNights, &d.Percent, &d.CreateAt, &d.UpdatedAt)
+		if err != nil {
+			return discounts, err
+		}
+
+		discounts = append(discounts, d)
+	}
+
+	if err := rows.Err(); err != nil {
+		return discounts, err
+	}
+
+	return discounts, nil
+}
+
+// InsertStayDiscount inserts a length of stay discount into the database
+func (m *postgresDBRepo) InsertStayDiscount(d models.StayDiscount) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	var newID int
+
+	stmt := `insert into stay_discounts (min_nights, percent, created_at, updated_at)
+	         values ($1, $2, $3, $3) returning id`
+
+	err := m.DB.QueryRowContext(ctx, stmt, d.MinNights, d.Percent, time.Now()).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+// DeleteStayDiscount deletes a length of stay discount
+func (m *postgresDBRepo) DeleteStayDiscount(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `delete from stay_discounts where id = $1`, id)
+	return err
+}
+
+// AllFees returns a slice of all taxes and fees, in the order they were added
+func (m *postgresDBRepo) AllFees() ([]models.Fee, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	var fees []models.Fee
+
+	rows, err := m.DB.QueryContext(ctx, `select id, name, kind, amount, created_at, updated_at from fees order by id`)
+	if err != nil {
+		return fees, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var f models.Fee
+		err := rows.Scan(&f.ID, &f.Name, &f.Kind, &f.Amount, &f.CreateAt, &f.UpdatedAt)
+		if err != nil {
+			return fees, err
+		}
+
+		fees = append(fees, f)
+	}
+
+	if err := rows.Err(); err != nil {
+		return fees, err
+	}
+
+	return fees, nil
+}
+
+// InsertFee inserts a tax or fee into the database
+func (m *postgresDBRepo) InsertFee(f models.Fee) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	var newID int
+
+	stmt := `insert into fees (name, kind, amount, created_at, updated_at)
+	         values ($1, $2, $3, $4, $4) returning id`
+
+	err := m.DB.QueryRowContext(ctx, stmt, f.Name, f.Kind, f.Amount, time.Now()).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+// DeleteFee deletes a tax or fee
+func (m *postgresDBRepo) DeleteFee(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `delete from fees where id = $1`, id)
+	return err
+}
+
+// weekdayMask returns the bit mask of days that the closed to arrival and departure columns hold
+func weekdayMask(days []time.Weekday) int {
+	mask := 0
+	for _, d := range days {
+		mask |= 1 << uint(d)
+	}
+
+	return mask
+}
+
+// weekdays returns the days in a bit mask made by weekdayMask, from Sunday
+func weekdays(mask int) []time.Weekday {
+	var days []time.Weekday
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		if mask&(1<<uint(d)) != 0 {
+			days = append(days, d)
+		}
+	}
+
+	return days
+}
+
+func (m *postgresDBRepo) queryStayRules(ctx context.Context, query string, args ...interface{}) ([]models.StayRule, error) {
+	var rules []models.StayRule
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return rules, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var s models.StayRule
+		var closedToArrival, closedToDeparture int
+		err := rows.Scan(
+			&s.ID,
+			&s.RoomID,
+			&s.StartDate,
+			&s.EndDate,
+			&s.MinNights,
+			&s.MaxNights,
+			&closedToArrival,
+			&closedToDeparture,
+			&s.MinAdvanceDays,
+			&s.MaxAdvanceDays,
+			&s.CreateAt,
+			&s.UpdatedAt,
+			&s.Room.ID,
+			&s.Room.RoomName,
+		)
+		if err != nil {
+			return rules, err
+		}
+
+		s.ClosedToArrival = weekdays(closedToArrival)
+		s.ClosedToDeparture = weekdays(closedToDeparture)
+		rules = append(rules, s)
+	}
+
+	if err := rows.Err(); err != nil {
+		return rules, err
+	}
+
+	return rules, nil
+}
+
+// AllStayRules returns a slice of all stay rules, ordered by room and start date
+func (m *postgresDBRepo) AllStayRules() ([]models.StayRule, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	query := `select s.id, s.room_id, s.start_date, s.end_date, s.min_nights, s.max_nights, s.closed_to_arrival,
+	          s.closed_to_departure, s.min_advance_days, s.max_advance_days, s.created_at, s.updated_at,
+	          r.id, r.room_name
+	          from stay_rules s
+	          left join rooms r on r.id = s.room_id
+	          order by r.room_name, s.start_date`
+
+	return m.queryStayRules(ctx, query)
+}
+
+// StayRulesForRoom returns the stay rules of a room whose date range includes the arrival or departure date of
+// a stay from start to end, or any date between
+func (m *postgresDBRepo) StayRulesForRoom(roomID int, start, end time.Time) ([]models.StayRule, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	query := `select s.id, s.room_id, s.start_date, s.end_date, s.min_nights, s.max_nights, s.closed_to_arrival,
+	          s.closed_to_departure, s.min_advance_days, s.max_advance_days, s.created_at, s.updated_at,
+	          r.id, r.room_name
+	          from stay_rules s
+	          left join rooms r on r.id = s.room_id
+	          where s.room_id = $1 and s.start_date <= $3 and s.end_date >= $2
+	          order by s.start_date`
+
+	return m.queryStayRules(ctx, query, roomID, start, end)
+}
+
+// InsertStayRule inserts a stay rule into the database
+func (m *postgresDBRepo) InsertStayRule(rule models.StayRule) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	var newID int
+
+	stmt := `insert into stay_rules (room_id, start_date, end_date, min_nights, max_nights, closed_to_arrival,
+	         closed_to_departure, min_advance_days, max_advance_days, created_at, updated_at)
+	         values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $10) returning id`
+
+	err := m.DB.QueryRowContext(ctx, stmt,
+		rule.RoomID,
+		rule.StartDate,
+		rule.EndDate,
+		rule.MinNights,
+		rule.MaxNights,
+		weekdayMask(rule.ClosedToArrival),
+		weekdayMask(rule.ClosedToDeparture),
+		rule.MinAdvanceDays,
+		rule.MaxAdvanceDays,
+		time.Now(),
+	).Scan(&newID)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+// DeleteStayRule deletes a stay rule
+func (m *postgresDBRepo) DeleteStayRule(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `delete from stay_rules where id = $1`, id)
+	return err
+}
+
+func (m *postgresDBRepo) queryCancellationPolicies(ctx context.Context, query string, args ...interface{}) ([]models.CancellationPolicy, error) {
+	var policies []models.CancellationPolicy
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return policies, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var p models.CancellationPolicy
+		var seasonStart, seasonEnd sql.NullTime
+		err := rows.Scan(
+			&p.ID,
+			&p.RoomID,
+			&p.SeasonalRateID,
+			&p.FreeDays,
+			&p.PenaltyPercent,
+			&p.NonRefundableDays,
+			&p.CreateAt,
+			&p.UpdatedAt,
+			&p.Room.ID,
+			&p.Room.RoomName,
+			&p.SeasonalRate.Name,
+			&seasonStart,
+			&seasonEnd,
+		)
+		if err != nil {
+			return policies, err
+		}
+
+		p.SeasonalRate.ID = p.SeasonalRateID
+		p.SeasonalRate.StartDate = seasonStart.Time
+		p.SeasonalRate.EndDate = seasonEnd.Time
+		policies = append(policies, p)
+	}
+
+	if err := rows.Err(); err != nil {
+		return policies, err
+	}
+
+	return policies, nil
+}
+
+// AllCancellationPolicies returns a slice of all cancellation policies, ordered by room, with the room's
+// standard policy before those of its seasons
+func (m *postgresDBRepo) AllCancellationPolicies() ([]models.CancellationPolicy, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	query := `select p.id, p.room_id, coalesce(p.seasonal_rate_id, 0), p.free_days, p.penalty_percent,
+	          p.non_refundable_days, p.created_at, p.updated_at, r.id, r.room_name, coalesce(s.name, ''),
+	          s.start_date, s.end_date
+	          from cancellation_policies p
+	          left join rooms r on r.id = p.room_id
+	          left join seasonal_rates s on s.id = p.seasonal_rate_id
+	          order by r.room_name, p.seasonal_rate_id is not null, s.start_date`
+
+	return m.queryCancellationPolicies(ctx, query)
+}
+
+// CancellationPolicyForStay returns the cancellation policy of a stay in a room that arrives on arrival, which
+// is the policy of the season it arrives in, or else the room's standard policy. sql.ErrNoRows is returned if
+// neither exists
+func (m *postgresDBRepo) CancellationPolicyForStay(roomID int, arrival time.Time) (models.CancellationPolicy, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	query := `select p.id, p.room_id, coalesce(p.seasonal_rate_id, 0), p.free_days, p.penalty_percent,
+	          p.non_refundable_days, p.created_at, p.updated_at, r.id, r.room_name, coalesce(s.name, ''),
+	          s.start_date, s.end_date
+	          from cancellation_policies p
+	          left join rooms r on r.id = p.room_id
+	          left join seasonal_rates s on s.id = p.seasonal_rate_id
+	          where p.room_id = $1
+	            and (p.seasonal_rate_id is null or $2 between s.start_date and s.end_date)
+	          order by p.seasonal_rate_id is null, s.start_date desc
+	          limit 1`
+
+	policies, err := m.queryCancellationPolicies(ctx, query, roomID, arrival)
+	if err != nil {
+		return models.CancellationPolicy{}, err
+	}
+
+	if len(policies) == 0 {
+		return models.CancellationPolicy{}, sql.ErrNoRows
+	}
+
+	return policies[0], nil
+}
+
+// SaveCancellationPolicy inserts a cancellation policy into the database, replacing the policy the room
+// already had for the same season, or its standard policy
+func (m *postgresDBRepo) SaveCancellationPolicy(p models.CancellationPolicy) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `delete from cancellation_policies
+	                              where room_id = $1 and seasonal_rate_id is not distinct from $2`,
+		p.RoomID, nullInt(p.SeasonalRateID))
+	if err != nil {
+		return 0, err
+	}
+
+	var newID int
+
+	stmt := `insert into cancellation_policies (room_id, seasonal_rate_id, free_days, penalty_percent,
+	         non_refundable_days, created_at, updated_at)
+	         values ($1, $2, $3, $4, $5, $6, $6) returning id`
+
+	err = tx.QueryRowContext(ctx, stmt,
+		p.RoomID,
+		nullInt(p.SeasonalRateID),
+		p.FreeDays,
+		p.PenaltyPercent,
+		p.NonRefundableDays,
+		time.Now(),
+	).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+// DeleteCancellationPolicy deletes a cancellation policy
+func (m *postgresDBRepo) DeleteCancellationPolicy(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `delete from cancellation_policies where id = $1`, id)
+	return err
+}
+
+// GetRestrictionsForRoomByDate returns the restrictions for a room that overlap a date range. Holds aren't
+// returned, as they only keep a room for the few minutes a guest takes to book it
+func (m *postgresDBRepo) GetRestrictionsForRoomByDate(roomID int, start, end time.Time) ([]models.RoomRestriction, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	var restrictions []models.RoomRestriction
+
+	query := `
+	select rr.id, coalesce(rr.reservation_id, 0), rr.restriction_id, rr.room_id,
+	rr.start_date, rr.end_date,
+	coalesce(r.first_name, ''), coalesce(r.last_name, ''),
+	rs.id, rs.restriction_name
+	from room_restrictions rr
+	left join reservations r on
+		r.id = rr.reservation_id
+	left join restrictions rs on
+		rs.id = rr.restriction_id
+	where rr.room_id = $1
+	  and $2 < rr.end_date and $3 > rr.start_date
+	  and rr.restriction_id <> $4
+	order by rr.start_date`
+
+	rows, err := m.DB.QueryContext(ctx, query, roomID, start, end, models.RestrictionHold)
+	if err != nil {
+		return restrictions, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var rr models.RoomRestriction
+		err := rows.Scan(
+			&rr.ID,
+			&rr.ReservationID,
+			&rr.RestrictionID,
+			&rr.RoomID,
+			&rr.StartDate,
+			&rr.EndDate,
+			&rr.Reservation.FirstName,
+			&rr.Reservation.LastName,
+			&rr.Restriction.ID,
+			&rr.Restriction.RestrictionName,
+		)
+
+		if err != nil {
+			return restrictions, err
+		}
+
+		rr.Reservation.ID = rr.ReservationID
+		restrictions = append(restrictions, rr)
+	}
+
+	if err := rows.Err(); err != nil {
+		return restrictions, err
+	}
+
+	return restrictions, nil
+}
+
+// InsertBlockForRoom inserts an owner block for a single night starting on startDate, returning its id
+func (m *postgresDBRepo) InsertBlockForRoom(roomID int, startDate time.Time) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	stmt := `insert into room_restrictions (start_date, end_date, room_id, restriction_id,
+		       created_at, updated_at)
+	         values ($1, $2, $3, $4, $5, $6) returning id`
+
+	var newID int
+	err := m.DB.QueryRowContext(ctx, stmt,
+		startDate,
+		startDate.AddDate(0, 0, 1),
+		roomID,
+		models.RestrictionOwnerBlock,
+		time.Now(),
+		time.Now(),
+	).Scan(&newID)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+// DeleteBlockByID deletes an owner block by id
+func (m *postgresDBRepo) DeleteBlockByID(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	query := `delete from room_restrictions where id = $1 and restriction_id = $2`
+
+	_, err := m.DB.ExecContext(ctx, query, id, models.RestrictionOwnerBlock)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// SplitBlock replaces the owner block id with owner blocks for the date ranges of blocks, which are in its room,
+// so that the nights left out are no longer blocked. The ids of the new blocks are returned in the order of
+// blocks. sql.ErrNoRows is returned if there is no such owner block
+func (m *postgresDBRepo) SplitBlock(id int, blocks []models.RoomRestriction) ([]int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var roomID int
+	err = tx.QueryRowContext(ctx, `delete from room_restrictions where id = $1 and restriction_id = $2
+	                               returning room_id`, id, models.RestrictionOwnerBlock).Scan(&roomID)
+	if err != nil {
+		return nil, err
+	}
+
+	stmt := `insert into room_restrictions (start_date, end_date, room_id, restriction_id,
+		       created_at, updated_at)
+	         values ($1, $2, $3, $4, $5, $5) returning id`
+
+	var ids []int
+	for _, b := range blocks {
+		var newID int
+		err = tx.QueryRowContext(ctx, stmt, b.StartDate, b.EndDate, roomID, models.RestrictionOwnerBlock,
+			time.Now()).Scan(&newID)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, newID)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return ids, nil
+}
+
+// GetRoomByCalendarToken returns the room whose calendar feed is published under token
+func (m *postgresDBRepo) GetRoomByCalendarToken(token string) (models.Room, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	var room models.Room
+
+	query := `select id, room_name, calendar_token, created_at, updated_at from rooms where calendar_token = $1`
+
+	err := m.DB.QueryRowContext(ctx, query, token).Scan(
+		&room.ID,
+		&room.RoomName,
+		&room.CalendarToken,
+		&room.CreateAt,
+		&room.UpdatedAt,
+	)
+
+	if err != nil {
+		return room, err
+	}
+
+	return room, nil
+}
+
+// SetRoomCalendarToken sets the token the calendar feed of a room is published under, replacing any previous one
+func (m *postgresDBRepo) SetRoomCalendarToken(roomID int, token string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	query := `update rooms set calendar_token = $1, updated_at = $2 where id = $3`
+
+	_, err := m.DB.ExecContext(ctx, query, token, time.Now(), roomID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// roomCalendarColumns are the columns scanned by scanRoomCalendar
+const roomCalendarColumns = `c.id, c.room_id, c.name, c.url, c.last_synced_at, c.last_error, c.event_count,
+	c.conflicts, c.created_at, c.updated_at, r.id, r.room_name`
+
+// scanner is implemented by *sql.Row and *sql.Rows
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanRoomCalendar(row scanner) (models.RoomCalendar, error) {
+	var c models.RoomCalendar
+	var lastSynced sql.NullTime
+
+	err := row.Scan(
+		&c.ID,
+		&c.RoomID,
+		&c.Name,
+		&c.URL,
+		&lastSynced,
+		&c.LastError,
+		&c.EventCount,
+		&c.Conflicts,
+		&c.CreateAt,
+		&c.UpdatedAt,
+		&c.Room.ID,
+		&c.Room.RoomName,
+	)
+
+	c.LastSyncedAt = lastSynced.Time
+	return c, err
+}
+
+// AllRoomCalendars returns a slice of all external calendars, ordered by room
+func (m *postgresDBRepo) AllRoomCalendars() ([]models.RoomCalendar, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	var calendars []models.RoomCalendar
+
+	query := `select ` + roomCalendarColumns + `
+	          from room_calendars c
+	          left join rooms r on r.id = c.room_id
+	          order by r.room_name, c.name`
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return calendars, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		c, err := scanRoomCalendar(rows)
+		if err != nil {
+			return calendars, err
+		}
+
+		calendars = append(calendars, c)
+	}
+
+	if err := rows.Err(); err != nil {
+		return calendars, err
+	}
+
+	return calendars, nil
+}
+
+// GetRoomCalendarByID returns an external calendar by id
+func (m *postgresDBRepo) GetRoomCalendarByID(id int) (models.RoomCalendar, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	query := `select ` + roomCalendarColumns + `
+	          from room_calendars c
+	          left join rooms r on r.id = c.room_id
+	          where c.id = $1`
+
+	return scanRoomCalendar(m.DB.QueryRowContext(ctx, query, id))
+}
+
+// InsertRoomCalendar inserts an external calendar into the database
+func (m *postgresDBRepo) InsertRoomCalendar(c models.RoomCalendar) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	var newID int
+
+	stmt := `insert into room_calendars (room_id, name, url, created_at, updated_at)
+	         values ($1, $2, $3, $4, $5) returning id`
+
+	err := m.DB.QueryRowContext(ctx, stmt,
+		c.RoomID,
+		c.Name,
+		c.URL,
+		time.Now(),
+		time.Now(),
+	).Scan(&newID)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+// DeleteRoomCalendar deletes an external calendar and the external bookings imported from it
+func (m *postgresDBRepo) DeleteRoomCalendar(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `delete from room_calendars where id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// ReplaceExternalBookings replaces the external bookings imported from a calendar with bookings. It returns
+// how many of the new bookings overlap a reservation of the room, which means the room was double booked
+func (m *postgresDBRepo) ReplaceExternalBookings(ctx context.Context, calendarID int, bookings []models.RoomRestriction) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*10)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var roomID int
+	err = tx.QueryRowContext(ctx, `select room_id from room_calendars where id = $1 for update`, calendarID).Scan(&roomID)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.ExecContext(ctx, `delete from room_restrictions where room_calendar_id = $1`, calendarID)
+	if err != nil {
+		return 0, err
+	}
+
+	stmt := `insert into room_restrictions (start_date, end_date, room_id, restriction_id, room_calendar_id,
+	           external_uid, created_at, updated_at)
+	         values ($1, $2, $3, $4, $5, $6, $7, $7)`
+
+	for _, b := range bookings {
+		_, err = tx.ExecContext(ctx, stmt,
+			b.StartDate,
+			b.EndDate,
+			roomID,
+			models.RestrictionExternalBooking,
+			calendarID,
+			b.ExternalUID,
+			time.Now(),
+		)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	query := `
+	select count(distinct e.id)
+	from room_restrictions e
+	join room_restrictions r on
+		r.room_id = e.room_id and r.restriction_id = $2
+		and r.start_date < e.end_date and e.start_date < r.end_date
+	where e.room_calendar_id = $1`
+
+	var conflicts int
+	err = tx.QueryRowContext(ctx, query, calendarID, models.RestrictionReservation).Scan(&conflicts)
+	if err != nil {
+		return 0, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	return conflicts, nil
+}
+
+// UpdateRoomCalendarSync records the outcome of the last sync of an external calendar
+func (m *postgresDBRepo) UpdateRoomCalendarSync(c models.RoomCalendar) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	query := `update room_calendars set last_synced_at = $1, last_error = $2, event_count = $3, conflicts = $4,
+	          updated_at = $5
+	          where id = $6`
+
+	_, err := m.DB.ExecContext(ctx, query,
+		c.LastSyncedAt,
+		c.LastError,
+		c.EventCount,
+		c.Conflicts,
+		time.Now(),
+		c.ID,
+	)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// AllAPIKeys returns a slice of all API keys
+func (m *postgresDBRepo) AllAPIKeys() ([]models.APIKey, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	var keys []models.APIKey
+
+	query := `select id, name, key_hash, last_used_at, revoked_at, created_at, updated_at
+	          from api_keys order by created_at desc`
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return keys, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var k models.APIKey
+		var lastUsed, revoked sql.NullTime
+		err := rows.Scan(
+			&k.ID,
+			&k.Name,
+			&k.KeyHash,
+			&lastUsed,
+			&revoked,
+			&k.CreateAt,
+			&k.UpdatedAt,
+		)
+
+		if err != nil {
+			return keys, err
+		}
+
+		k.LastUsedAt = lastUsed.Time
+		k.RevokedAt = revoked.Time
+		keys = append(keys, k)
+	}
+
+	if err := rows.Err(); err != nil {
+		return keys, err
+	}
+
+	return keys, nil
+}
+
+// InsertAPIKey inserts an API key into the database
+func (m *postgresDBRepo) InsertAPIKey(k models.APIKey) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	var newID int
+
+	stmt := `insert into api_keys (name, key_hash, created_at, updated_at)
+	         values ($1, $2, $3, $4) returning id`
+
+	err := m.DB.QueryRowContext(ctx, stmt,
+		k.Name,
+		k.KeyHash,
+		time.Now(),
+		time.Now(),
+	).Scan(&newID)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+// RevokeAPIKey revokes an API key by id
+func (m *postgresDBRepo) RevokeAPIKey(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	query := `update api_keys set revoked_at = $1, updated_at = $1 where id = $2 and revoked_at is null`
+
+	_, err := m.DB.ExecContext(ctx, query, time.Now(), id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// GetAPIKeyByHash returns an active API key by the hash of the key and records that it was used
+func (m *postgresDBRepo) GetAPIKeyByHash(hash string) (models.APIKey, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	var k models.APIKey
+
+	query := `update api_keys set last_used_at = $1
+	          where key_hash = $2 and revoked_at is null
+	          returning id, name, key_hash, last_used_at, created_at, updated_at`
+
+	err := m.DB.QueryRowContext(ctx, query, time.Now(), hash).Scan(
+		&k.ID,
+		&k.Name,
+		&k.KeyHash,
+		&k.LastUsedAt,
+		&k.CreateAt,
+		&k.UpdatedAt,
+	)
+
+	if err != nil {
+		return k, err
+	}
+
+	return k, nil
+}
+
+// GetTOTPSecret returns the TOTP secret of a user with two-factor authentication enabled
+func (m *postgresDBRepo) GetTOTPSecret(userID int) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	var secret string
+	query := `select totp_secret from users where id = $1 and totp_enabled_at is not null`
+
+	err := m.DB.QueryRowContext(ctx, query, userID).Scan(&secret)
+	if err != nil {
+		return "", err
+	}
+
+	return secret, nil
+}
+
+// EnableTOTP turns on two-factor authentication for a user and replaces their recovery codes
+func (m *postgresDBRepo) EnableTOTP(userID int, secret string, recoveryCodeHashes []string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt := `update users set totp_secret = $1, totp_enabled_at = $2, totp_last_step = 0, updated_at = $2
+	         where id = $3`
+
+	_, err = tx.ExecContext(ctx, stmt, secret, time.Now(), userID)
+	if err != nil {
+		return err
+	}
+
+	err = replaceRecoveryCodes(ctx, tx, userID, recoveryCodeHashes)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// DisableTOTP turns off two-factor authentication for a user and removes their recovery codes
+func (m *postgresDBRepo) DisableTOTP(userID int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt := `update users set totp_secret = null, totp_enabled_at = null, totp_last_step = 0, updated_at = $1
+	         where id = $2`
+
+	_, err = tx.ExecContext(ctx, stmt, time.Now(), userID)
+	if err != nil {
+		return err
+	}
+
+	err = replaceRecoveryCodes(ctx, tx, userID, nil)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// UseTOTPStep records that a user logged in with the code for a time step. It returns false if a code for
+// that or a later step was already used, so that a code can't be replayed
+func (m *postgresDBRepo) UseTOTPStep(userID int, step int64) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	stmt := `update users set totp_last_step = $1 where id = $2 and totp_last_step < $1`
+
+	result, err := m.DB.ExecContext(ctx, stmt, step, userID)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return n == 1, nil
+}
+
+// ReplaceRecoveryCodes replaces all of a user's recovery codes
+func (m *postgresDBRepo) ReplaceRecoveryCodes(userID int, codeHashes []string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = replaceRecoveryCodes(ctx, tx, userID, codeHashes)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func replaceRecoveryCodes(ctx context.Context, tx *sql.Tx, userID int, codeHashes []string) error {
+	_, err := tx.ExecContext(ctx, `delete from recovery_codes where user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+
+	stmt := `insert into recovery_codes (user_id, code_hash, created_at, updated_at) values ($1, $2, $3, $4)`
+	for _, hash := range codeHashes {
+		_, err = tx.ExecContext(ctx, stmt, userID, hash, time.Now(), time.Now())
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// UseRecoveryCode marks a user's unused recovery code as used. It returns false if there is no such code
+func (m *postgresDBRepo) UseRecoveryCode(userID int, codeHash string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	stmt := `update recovery_codes set used_at = $1, updated_at = $1
+	         where user_id = $2 and code_hash = $3 and used_at is null`
+
+	result, err := m.DB.ExecContext(ctx, stmt, time.Now(), userID, codeHash)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return n == 1, nil
+}
+
+// CountRecoveryCodes returns the number of unused recovery codes a user has
+func (m *postgresDBRepo) CountRecoveryCodes(userID int) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	var count int
+	query := `select count(id) from recovery_codes where user_id = $1 and used_at is null`
+
+	err := m.DB.QueryRowContext(ctx, query, userID).Scan(&count)
+	if err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
+// GetSetting returns the value of a setting, or an empty string if it isn't set
+func (m *postgresDBRepo) GetSetting(name string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	var value string
+	err := m.DB.QueryRowContext(ctx, `select value from settings where name = $1`, name).Scan(&value)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+
+	return value, nil
+}
+
+// SetSetting sets the value of a setting
+func (m *postgresDBRepo) SetSetting(name, value string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	stmt := `insert into settings (name, value, created_at, updated_at) values ($1, $2, $3, $3)
+	         on conflict (name) do update set value = excluded.value, updated_at = excluded.updated_at`
+
+	_, err := m.DB.ExecContext(ctx, stmt, name, value, time.Now())
+	return err
+}
+
+// InsertWaitlistEntry adds a guest to the waitlist
+func (m *postgresDBRepo) InsertWaitlistEntry(e models.WaitlistEntry) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	var newID int
+	stmt := `insert into waitlist_entries (first_name, email, start_date, end_date, adults, children, created_at, updated_at)
+	         values ($1, $2, $3, $4, $5, $6, $7, $8) returning id`
+
+	err := m.DB.QueryRowContext(ctx, stmt,
+		e.FirstName,
+		e.Email,
+		e.StartDate,
+		e.EndDate,
+		e.Adults,
+		e.Children,
+		time.Now(),
+		time.Now(),
+	).Scan(&newID)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, nil
 }
 
-// SearchAvailabilityForAllRooms returns a slice of available rooms if any for a given date range
-func (m *postgresDBRepo) SearchAvailabilityForAllRooms(start, end time.Time) ([]models.Room, error) {
+// WaitlistEntriesToOffer returns the entries that haven't been offered a room, for stays arriving from the given
+// date, oldest first
+func (m *postgresDBRepo) WaitlistEntriesToOffer(from time.Time) ([]models.WaitlistEntry, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
 	defer cancel()
 
-	query := `
-	select r.id, r.room_name
-	from rooms r
-	where not r.id in (select room_id
-										 from room_restrictions rr
-										 where $1 < end_date and $2 > start_date)`
+	var entries []models.WaitlistEntry
 
-	var rooms []models.Room
+	query := `select id, first_name, email, start_date, end_date, adults, children, created_at, updated_at
+	          from waitlist_entries
+	          where offered_at is null and start_date >= $1
+	          order by created_at, id`
 
-	rows, err := m.DB.QueryContext(ctx, query, start, end)
+	rows, err := m.DB.QueryContext(ctx, query, from)
 	if err != nil {
-		return rooms, err
+		return entries, err
 	}
+	defer rows.Close()
 
 	for rows.Next() {
-		var room models.Room
+		var e models.WaitlistEntry
 		err := rows.Scan(
-			&room.ID,
-			&room.RoomName,
+			&e.ID,
+			&e.FirstName,
+			&e.Email,
+			&e.StartDate,
+			&e.EndDate,
+			&e.Adults,
+			&e.Children,
+			&e.CreateAt,
+			&e.UpdatedAt,
 		)
-
 		if err != nil {
-			return rooms, err
+			return entries, err
 		}
-
-		rooms = append(rooms, room)
+		entries = append(entries, e)
 	}
 
 	if err = rows.Err(); err != nil {
-		return rooms, nil
+		return entries, err
 	}
 
-	return rooms, nil
+	return entries, nil
 }
 
-// GetRoomByID gets a room by id
-func (m *postgresDBRepo) GetRoomByID(id int) (models.Room, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+// OfferWaitlistEntry records that the guest of an entry was offered a room, held by e.HoldID until
+// e.OfferExpiresAt, with a booking link whose token hashes to tokenHash. sql.ErrNoRows is returned if the entry
+// was already offered a room
+func (m *postgresDBRepo) OfferWaitlistEntry(ctx context.Context, e models.WaitlistEntry, tokenHash string) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*3)
 	defer cancel()
 
-	var room models.Room
-
-	query := `select id, room_name, created_at, updated_at from rooms where id = $1`
-
-	err := m.DB.QueryRowContext(ctx, query, id).Scan(
-		&room.ID,
-		&room.RoomName,
-		&room.CreateAt,
-		&room.UpdatedAt,
+	stmt := `update waitlist_entries
+	         set room_id = $1, hold_id = $2, offer_token_hash = $3, offered_at = $4, offer_expires_at = $5, updated_at = $4
+	         where id = $6 and offered_at is null`
+
+	result, err := m.DB.ExecContext(ctx, stmt,
+		e.RoomID,
+		nullInt(e.HoldID),
+		tokenHash,
+		e.OfferedAt,
+		e.OfferExpiresAt,
+		e.ID,
 	)
+	if err != nil {
+		return err
+	}
 
+	n, err := result.RowsAffected()
 	if err != nil {
-		return room, err
+		return err
 	}
 
-	return room, nil
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
 }
 
-// GetUserByID returns a user by id
-func (m *postgresDBRepo) GetUserByID(id int) (models.User, error) {
+// GetWaitlistEntryByToken returns the entry whose offer has a booking link with a token that hashes to
+// tokenHash. sql.ErrNoRows is returned if there is no such offer or it has expired
+func (m *postgresDBRepo) GetWaitlistEntryByToken(tokenHash string) (models.WaitlistEntry, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
 	defer cancel()
 
-	query := `select id, first_name, last_name, email, password, access_level, created_at, updated_at
-	          from users where id = $1`
-
-	row := m.DB.QueryRowContext(ctx, query, id)
-
-	var u models.User
-	err := row.Scan(
-		&u.ID,
-		&u.FirstName,
-		&u.LastName,
-		&u.Email,
-		&u.Password,
-		&u.AccessLevel,
-		&u.CreateAt,
-		&u.UpdatedAt,
+	var e models.WaitlistEntry
+	var holdID sql.NullInt64
+
+	query := `select w.id, w.first_name, w.email, w.start_date, w.end_date, w.adults, w.children, w.room_id,
+	          w.hold_id, w.offered_at, w.offer_expires_at, w.created_at, w.updated_at, r.room_name
+	          from waitlist_entries w
+	          left join rooms r on (r.id = w.room_id)
+	          where w.offer_token_hash = $1 and w.room_id is not null and w.offer_expires_at > $2`
+
+	err := m.DB.QueryRowContext(ctx, query, tokenHash, time.Now()).Scan(
+		&e.ID,
+		&e.FirstName,
+		&e.Email,
+		&e.StartDate,
+		&e.EndDate,
+		&e.Adults,
+		&e.Children,
+		&e.RoomID,
+		&holdID,
+		&e.OfferedAt,
+		&e.OfferExpiresAt,
+		&e.CreateAt,
+		&e.UpdatedAt,
+		&e.Room.RoomName,
 	)
-
 	if err != nil {
-		return u, err
+		return e, err
 	}
 
-	return u, nil
+	e.HoldID = int(holdID.Int64)
+	e.Room.ID = e.RoomID
+
+	return e, nil
 }
 
-func (m *postgresDBRepo) UpdateUser(u models.User) error {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+// ReserveForPayment inserts a reservation that is awaiting payment, with the payment that was started for it.
+// Like BookRoom, the room is locked, the hold with holdID is replaced and ErrRoomUnavailable is returned if the
+// room is taken on the dates of res. The room is held for the reservation until holdUntil
+func (m *postgresDBRepo) ReserveForPayment(ctx context.Context, res models.Reservation, holdID int, holdUntil time.Time, p models.Payment) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*3)
 	defer cancel()
 
-	query := `update users set first_name = $1, last_name = $2, email = $3, access_level = $4, updated_at = $5
-	          where id = $6`
-
-	_, err := m.DB.ExecContext(ctx, query,
-		u.FirstName,
-		u.LastName,
-		u.Email,
-		u.AccessLevel,
-		time.Now(),
-		u.ID,
-	)
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
 
+	var roomID int
+	err = tx.QueryRowContext(ctx, `select id from rooms where id = $1 for update`, res.RoomID).Scan(&roomID)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
-	return nil
-}
+	err = replaceHold(ctx, tx, res, holdID)
+	if err != nil {
+		return 0, err
+	}
 
-// Authenticate authenticates a user
-func (m *postgresDBRepo) Authenticate(email, testPassword string) (int, string, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
-	defer cancel()
+	res.AwaitingPayment = true
+	newID, err := insertReservation(ctx, tx, res)
+	if err != nil {
+		return 0, err
+	}
 
-	var id int
-	var hashedPassword string
+	err = insertRestriction(ctx, tx, models.RoomRestriction{
+		StartDate:     res.StartDate,
+		EndDate:       res.EndDate,
+		RoomID:        res.RoomID,
+		ReservationID: newID,
+		RestrictionID: models.RestrictionHold,
+		ExpiresAt:     holdUntil,
+	})
+	if err != nil {
+		return 0, err
+	}
 
-	row := m.DB.QueryRowContext(ctx, `select id, password from users where email = $1`, email)
-	err := row.Scan(&id, &hashedPassword)
+	p.ReservationID = newID
+	err = insertPayment(ctx, tx, p)
 	if err != nil {
-		return 0, "", err
+		return 0, err
 	}
 
-	err = bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(testPassword))
-	if err == bcrypt.ErrMismatchedHashAndPassword {
-		return 0, "", errors.New("incorrect password")
-	} else if err != nil {
-		return 0, "", err
+	if err = tx.Commit(); err != nil {
+		return 0, err
 	}
 
-	return id, hashedPassword, nil
+	return newID, nil
 }
 
-// AllReservations returns a slice of all reservations
-func (m *postgresDBRepo) AllReservations() ([]models.Reservation, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+// ConfirmPayment records that a pending payment was authorized, and confirms its reservation by turning the
+// reservation's hold into a reservation of the room. The updated payment is returned. ErrPaymentProcessed is
+// returned if the payment isn't pending, ErrReservationClosed if its reservation was cancelled or deleted while
+// the guest paid, and ErrRoomUnavailable if the hold expired and the room was taken
+func (m *postgresDBRepo) ConfirmPayment(ctx context.Context, provider, ref string) (models.Payment, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*3)
 	defer cancel()
 
-	var reservations []models.Reservation
-
-	query := `
-		select r.id, r.first_name, r.last_name, r.email, r.phone, r.start_date,
-		r.end_date, r.room_id, r.created_at, r.updated_at, r.processed,
-		rm.id, rm.room_name
-		from reservations r
-		left join rooms rm on
-		  rm.id = r.room_id
-		order by r.start_date asc
-	`
-
-	rows, err := m.DB.QueryContext(ctx, query)
+	tx, err := m.DB.BeginTx(ctx, nil)
 	if err != nil {
-		return reservations, err
+		return models.Payment{}, err
 	}
-	defer rows.Close()
+	defer tx.Rollback()
 
-	for rows.Next() {
-		var r models.Reservation
-		err := rows.Scan(
-			&r.ID,
-			&r.FirstName,
-			&r.LastName,
-			&r.Email,
-			&r.Phone,
-			&r.StartDate,
-			&r.EndDate,
-			&r.RoomID,
-			&r.CreateAt,
-			&r.UpdatedAt,
-			&r.Processed,
-			&r.Room.ID,
-			&r.Room.RoomName,
-		)
+	query := `select id, reservation_id, provider, provider_ref, kind, status, amount, currency, created_at, updated_at
+	          from payments where provider = $1 and provider_ref = $2 and kind = $3
+	          for update`
 
-		if err != nil {
-			return reservations, err
-		}
+	p, err := scanPayment(tx.QueryRowContext(ctx, query, provider, ref, models.PaymentKindPayment))
+	if err != nil {
+		return p, err
+	}
 
-		reservations = append(reservations, r)
+	if p.Status != models.PaymentPending {
+		return p, repository.ErrPaymentProcessed
 	}
 
-	if err := rows.Err(); err != nil {
-		return reservations, err
+	var res models.Reservation
+	var awaitingPayment bool
+	err = tx.QueryRowContext(ctx, `select id, room_id, start_date, end_date, status, awaiting_payment
+	                               from reservations where id = $1 and deleted_at is null for update`,
+		p.ReservationID).Scan(&res.ID, &res.RoomID, &res.StartDate, &res.EndDate, &res.Status, &awaitingPayment)
+	if errors.Is(err, sql.ErrNoRows) {
+		return p, repository.ErrReservationClosed
+	}
+	if err != nil {
+		return p, err
 	}
 
-	return reservations, nil
-}
+	if !awaitingPayment || !lifecycle.Open(res.Status) {
+		return p, repository.ErrReservationClosed
+	}
 
-// AllNewReservations returns a slice of all new reservations
-func (m *postgresDBRepo) AllNewReservations() ([]models.Reservation, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
-	defer cancel()
+	var roomID int
+	err = tx.QueryRowContext(ctx, `select id from rooms where id = $1 for update`, res.RoomID).Scan(&roomID)
+	if err != nil {
+		return p, err
+	}
 
-	var reservations []models.Reservation
+	_, err = tx.ExecContext(ctx, `delete from room_restrictions where reservation_id = $1 and restriction_id = $2`,
+		res.ID, models.RestrictionHold)
+	if err != nil {
+		return p, err
+	}
 
-	query := `
-		select r.id, r.first_name, r.last_name, r.email, r.phone, r.start_date,
-		r.end_date, r.room_id, r.created_at, r.updated_at,
-		rm.id, rm.room_name
-		from reservations r
-		left join rooms rm on
-		  rm.id = r.room_id
-		where processed = 0
-		order by r.start_date asc
-	`
+	err = replaceHold(ctx, tx, res, 0)
+	if err != nil {
+		return p, err
+	}
 
-	rows, err := m.DB.QueryContext(ctx, query)
+	err = insertRestriction(ctx, tx, models.RoomRestriction{
+		StartDate:     res.StartDate,
+		EndDate:       res.EndDate,
+		RoomID:        res.RoomID,
+		ReservationID: res.ID,
+		RestrictionID: models.RestrictionReservation,
+	})
 	if err != nil {
-		return reservations, err
+		if isExclusionViolation(err) {
+			return p, repository.ErrRoomUnavailable
+		}
+		return p, err
 	}
-	defer rows.Close()
 
-	for rows.Next() {
-		var r models.Reservation
-		err := rows.Scan(
-			&r.ID,
-			&r.FirstName,
-			&r.LastName,
-			&r.Email,
-			&r.Phone,
-			&r.StartDate,
-			&r.EndDate,
-			&r.RoomID,
-			&r.CreateAt,
-			&r.UpdatedAt,
-			&r.Room.ID,
-			&r.Room.RoomName,
-		)
+	_, err = tx.ExecContext(ctx, `update reservations set awaiting_payment = false, status = $1, updated_at = $2
+	                              where id = $3`, models.ReservationConfirmed, time.Now(), res.ID)
+	if err != nil {
+		return p, err
+	}
 
+	if res.Status != models.ReservationConfirmed {
+		err = insertReservationEvent(ctx, tx, res.ID, res.Status, models.ReservationConfirmed)
 		if err != nil {
-			return reservations, err
+			return p, err
 		}
+	}
 
-		reservations = append(reservations, r)
+	p.Status = models.PaymentAuthorized
+	p.UpdatedAt = time.Now()
+	_, err = tx.ExecContext(ctx, `update payments set status = $1, updated_at = $2 where id = $3`,
+		p.Status, p.UpdatedAt, p.ID)
+	if err != nil {
+		return p, err
 	}
 
-	if err := rows.Err(); err != nil {
-		return reservations, err
+	if err = tx.Commit(); err != nil {
+		if isExclusionViolation(err) {
+			return p, repository.ErrRoomUnavailable
+		}
+		return p, err
 	}
 
-	return reservations, nil
+	return p, nil
 }
 
-func (m *postgresDBRepo) GetReservationByID(id int) (models.Reservation, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
-	defer cancel()
-
-	var r models.Reservation
-
-	query := `
-	select r.id, r.first_name, r.last_name, r.email, r.phone, r.start_date,
-	r.end_date, r.room_id, r.created_at, r.updated_at, r.processed,
-	rm.id, rm.room_name
-	from reservations r
-	left join rooms rm on
-		rm.id = r.room_id
-	where r.id = $1`
-
-	row := m.DB.QueryRowContext(ctx, query, id)
+// scanPayment scans a row of payment columns
+func scanPayment(row scanner) (models.Payment, error) {
+	var p models.Payment
 	err := row.Scan(
-		&r.ID,
-		&r.FirstName,
-		&r.LastName,
-		&r.Email,
-		&r.Phone,
-		&r.StartDate,
-		&r.EndDate,
-		&r.RoomID,
-		&r.CreateAt,
-		&r.UpdatedAt,
-		&r.Processed,
-		&r.Room.ID,
-		&r.Room.RoomName,
+		&p.ID,
+		&p.ReservationID,
+		&p.Provider,
+		&p.ProviderRef,
+		&p.Kind,
+		&p.Status,
+		&p.Amount,
+		&p.Currency,
+		&p.CreateAt,
+		&p.UpdatedAt,
 	)
 
-	if err != nil {
-		return r, err
-	}
+	return p, err
+}
 
-	return r, nil
+// insertPayment inserts a payment in tx
+func insertPayment(ctx context.Context, tx *sql.Tx, p models.Payment) error {
+	stmt := `insert into payments (reservation_id, provider, provider_ref, kind, status, amount, currency,
+		       created_at, updated_at)
+	         values ($1, $2, $3, $4, $5, $6, $7, $8, $8)`
+
+	_, err := tx.ExecContext(ctx, stmt,
+		p.ReservationID,
+		p.Provider,
+		p.ProviderRef,
+		p.Kind,
+		p.Status,
+		p.Amount,
+		p.Currency,
+		time.Now(),
+	)
+
+	return err
 }
 
-// UpdateReservation updates a reservation in the database
-func (m *postgresDBRepo) UpdateReservation(r models.Reservation) error {
+// InsertPayment records a payment or refund
+func (m *postgresDBRepo) InsertPayment(p models.Payment) (int, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
 	defer cancel()
 
-	query := `update reservations set first_name = $1, last_name = $2, email = $3, phone = $4, updated_at = $5
-	          where id = $6`
+	var newID int
+	stmt := `insert into payments (reservation_id, provider, provider_ref, kind, status, amount, currency,
+		       created_at, updated_at)
+	         values ($1, $2, $3, $4, $5, $6, $7, $8, $8) returning id`
 
-	_, err := m.DB.ExecContext(ctx, query,
-		r.FirstName,
-		r.LastName,
-		r.Email,
-		r.Phone,
+	err := m.DB.QueryRowContext(ctx, stmt,
+		p.ReservationID,
+		p.Provider,
+		p.ProviderRef,
+		p.Kind,
+		p.Status,
+		p.Amount,
+		p.Currency,
 		time.Now(),
-		r.ID,
-	)
+	).Scan(&newID)
 
 	if err != nil {
-		return err
+		return 0, err
 	}
 
-	return nil
+	return newID, nil
 }
 
-func (m *postgresDBRepo) DeleteReservation(id int) error {
+// UpdatePaymentStatus sets the status of the payment or refund the provider knows by ref, returning it as it was
+// before. sql.ErrNoRows is returned if there is no such payment
+func (m *postgresDBRepo) UpdatePaymentStatus(provider, ref, status string) (models.Payment, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
 	defer cancel()
 
-	query := `
-	delete from reservations where id = $1
-	`
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return models.Payment{}, err
+	}
+	defer tx.Rollback()
 
-	_, err := m.DB.ExecContext(ctx, query, id)
+	query := `select id, reservation_id, provider, provider_ref, kind, status, amount, currency, created_at, updated_at
+	          from payments where provider = $1 and provider_ref = $2
+	          for update`
+
+	p, err := scanPayment(tx.QueryRowContext(ctx, query, provider, ref))
 	if err != nil {
-		return err
+		return p, err
 	}
 
-	return nil
+	_, err = tx.ExecContext(ctx, `update payments set status = $1, updated_at = $2 where id = $3`,
+		status, time.Now(), p.ID)
+	if err != nil {
+		return p, err
+	}
+
+	return p, tx.Commit()
 }
 
-// UpdateProcessedForReservation updates processed for a reservation by id
-func (m *postgresDBRepo) UpdateProcessedForReservation(id, processed int) error {
+// PaymentsForReservation returns the payments and refunds of a reservation, oldest first
+func (m *postgresDBRepo) PaymentsForReservation(reservationID int) ([]models.Payment, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
 	defer cancel()
 
-	query := `
-	 update reservations set processed = $1 where id = $2
-	`
+	var payments []models.Payment
 
-	_, err := m.DB.ExecContext(ctx, query, processed, id)
+	query := `select id, reservation_id, provider, provider_ref, kind, status, amount, currency, created_at, updated_at
+	          from payments where reservation_id = $1
+	          order by created_at, id`
+
+	rows, err := m.DB.QueryContext(ctx, query, reservationID)
 	if err != nil {
-		return err
+		return payments, err
 	}
+	defer rows.Close()
 
-	return nil
+	for rows.Next() {
+		p, err := scanPayment(rows)
+		if err != nil {
+			return payments, err
+		}
+		payments = append(payments, p)
+	}
+
+	if err = rows.Err(); err != nil {
+		return payments, err
+	}
+
+	return payments, nil
 }
@@ -1,14 +1,45 @@
 package dbrepo
 
 import (
+	"context"
+	"database/sql"
 	"errors"
 	"time"
 
+	"github.com/dhanekom/bookings/internal/helpers"
+	"github.com/dhanekom/bookings/internal/lifecycle"
 	"github.com/dhanekom/bookings/internal/models"
+	"github.com/dhanekom/bookings/internal/repository"
+	"github.com/dhanekom/bookings/internal/totp"
 )
 
-func (m *testDBRepo) AllUsers() bool {
-	return true
+func (m *testDBRepo) AllUsers() ([]models.User, error) {
+	users := []models.User{
+		{ID: 1, FirstName: "Admin", LastName: "User", Email: "admin@admin.com", AccessLevel: models.AccessLevelAdmin},
+		{ID: 2, FirstName: "Staff", LastName: "User", Email: "staff@here.com", AccessLevel: models.AccessLevelStaff},
+	}
+
+	return users, nil
+}
+
+// InsertUser fails with ErrDuplicateEmail for the email address "exists@here.com"
+func (m *testDBRepo) InsertUser(u models.User, password string) (int, error) {
+	if u.Email == "exists@here.com" {
+		return 0, repository.ErrDuplicateEmail
+	}
+	return 3, nil
+}
+
+func (m *testDBRepo) UpdateUserPassword(id int, password string) error {
+	return nil
+}
+
+// SetUserActive treats user 1 as the only admin
+func (m *testDBRepo) SetUserActive(id int, active bool) error {
+	if id == 1 && !active {
+		return repository.ErrLastAdmin
+	}
+	return nil
 }
 
 // InsertReservation inserts a reservation into the database
@@ -28,6 +59,74 @@ func (m *testDBRepo) InsertRoomRestriction(r models.RoomRestriction) error {
 	return nil
 }
 
+// BookRoom inserts a reservation and its room restriction
+func (m *testDBRepo) BookRoom(ctx context.Context, res models.Reservation, holdID int) (int, error) {
+	switch res.RoomID {
+	case 0, 1000:
+		return 0, errors.New("some error")
+	case 1001:
+		return 0, repository.ErrRoomUnavailable
+	default:
+		return 1, nil
+	}
+}
+
+// BookRooms inserts a booking and its reservations. Nothing is booked if any of the rooms fails to book
+func (m *testDBRepo) BookRooms(ctx context.Context, b models.Booking, holdIDs []int) (int, []int, error) {
+	var ids []int
+	for i, res := range b.Reservations {
+		if _, err := m.BookRoom(ctx, res, 0); err != nil {
+			return 0, nil, err
+		}
+		ids = append(ids, i+1)
+	}
+
+	return 1, ids, nil
+}
+
+// GetBookingByID returns a booking with its reservations. Booking 1 holds reservations 3 and 4
+func (m *testDBRepo) GetBookingByID(id int) (models.Booking, error) {
+	if id != 1 {
+		return models.Booking{}, sql.ErrNoRows
+	}
+
+	b := models.Booking{ID: 1, FirstName: "John", LastName: "Smith", Email: "john@smith.com"}
+	for _, resID := range []int{3, 4} {
+		r, _ := m.GetReservationByID(resID)
+		b.Reservations = append(b.Reservations, r)
+		b.TotalPrice += r.Quote.Total
+	}
+
+	return b, nil
+}
+
+// PlaceHold inserts a hold on a room. Room 1001 is taken
+func (m *testDBRepo) PlaceHold(ctx context.Context, hold models.RoomRestriction) (int, error) {
+	switch hold.RoomID {
+	case 0:
+		return 0, errors.New("some error")
+	case 1001:
+		return 0, repository.ErrRoomUnavailable
+	default:
+		return hold.RoomID + 100, nil
+	}
+}
+
+// ReleaseHold deletes a hold
+func (m *testDBRepo) ReleaseHold(id int) error {
+	return nil
+}
+
+// DeleteExpiredHolds deletes the holds that expired before now
+func (m *testDBRepo) DeleteExpiredHolds(ctx context.Context, now time.Time) (int, error) {
+	return 0, nil
+}
+
+// CancelUnpaidReservations cancels the reservations whose payment window closed before now
+func (m *testDBRepo) CancelUnpaidReservations(ctx context.Context, now time.Time) (int, error) {
+	return 0, nil
+}
+
 // SearchAvailabilityByDatesByRoomID returns true if availability exists for roomID else returns false
 func (m *testDBRepo) SearchAvailabilityByDatesByRoomID(start, end time.Time, roomID int) (bool, error) {
 	switch roomID {
@@ -40,61 +139,862 @@ func (m *testDBRepo) SearchAvailabilityByDatesByRoomID(start, end time.Time, roo
 	}
 }
 
-// SearchAvailabilityForAllRooms returns a slice of available rooms if any for a given date range
-func (m *testDBRepo) SearchAvailabilityForAllRooms(start, end time.Time) ([]models.Room, error) {
+// SearchAvailabilityForAllRooms returns the rooms that sleep the party for stays in 2050, and no rooms otherwise
+func (m *testDBRepo) SearchAvailabilityForAllRooms(start, end time.Time, adults, children int) ([]models.Room, error) {
 	var rooms []models.Room
+	if start.Year() != 2050 {
+		return rooms, nil
+	}
+
+	all, _ := m.AllRooms()
+	for _, rm := range all {
+		if rm.MaxAdults >= adults && rm.MaxChildren >= children && rm.Capacity >= adults+children {
+			rooms = append(rooms, rm)
+		}
+	}
+
 	return rooms, nil
 }
 
-// GetRoomByID gets a room by id
+// GetRoomByID gets a room by id. Ids other than those of the test rooms get a room that sleeps two adults, so
+// that booking them can fail later
 func (m *testDBRepo) GetRoomByID(id int) (models.Room, error) {
-	var room models.Room
+	room := models.Room{ID: id, Capacity: 2, MaxAdults: 2}
 	if id == 0 {
-		return room, errors.New("room does not exist")
+		return models.Room{}, errors.New("room does not exist")
+	}
+
+	rooms, _ := m.AllRooms()
+	for _, rm := range rooms {
+		if rm.ID == id {
+			return rm, nil
+		}
 	}
+
 	return room, nil
 }
 
+// GetRoomBySlug gets a room by its slug
+func (m *testDBRepo) GetRoomBySlug(slug string) (models.Room, error) {
+	rooms, _ := m.AllRooms()
+	for _, rm := range rooms {
+		if rm.Slug == slug {
+			return rm, nil
+		}
+	}
+
+	return models.Room{}, sql.ErrNoRows
+}
+
+// InsertRoom fails with ErrDuplicateSlug for the slugs of the test rooms
+func (m *testDBRepo) InsertRoom(room models.Room) (int, error) {
+	if _, err := m.GetRoomBySlug(room.Slug); err == nil {
+		return 0, repository.ErrDuplicateSlug
+	}
+	return 3, nil
+}
+
+// UpdateRoom fails with ErrDuplicateSlug if the slug belongs to another test room
+func (m *testDBRepo) UpdateRoom(room models.Room) error {
+	if other, err := m.GetRoomBySlug(room.Slug); err == nil && other.ID != room.ID {
+		return repository.ErrDuplicateSlug
+	}
+	return nil
+}
+
+// DeleteRoom treats room 1 as having reservations
+func (m *testDBRepo) DeleteRoom(id int) error {
+	if id == 1 {
+		return repository.ErrRoomInUse
+	}
+	return nil
+}
+
+func (m *testDBRepo) InsertRoomPhoto(p models.RoomPhoto) (int, error) {
+	return 2, nil
+}
+
+// DeleteRoomPhoto only knows photo 1 of room 1
+func (m *testDBRepo) DeleteRoomPhoto(roomID, photoID int) (models.RoomPhoto, error) {
+	if roomID != 1 || photoID != 1 {
+		return models.RoomPhoto{}, sql.ErrNoRows
+	}
+
+	room, _ := m.GetRoomByID(1)
+	return room.Photos[0], nil
+}
+
+func (m *testDBRepo) SetRoomPhotoOrder(roomID int, photoIDs []int) error {
+	return nil
+}
+
+// AllSeasonalRates returns a summer season for room 1 in January 2050
+func (m *testDBRepo) AllSeasonalRates() ([]models.SeasonalRate, error) {
+	return []models.SeasonalRate{
+		{
+			ID:        1,
+			RoomID:    1,
+			Name:      "Summer",
+			StartDate: time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC),
+			EndDate:   time.Date(2050, 1, 31, 0, 0, 0, 0, time.UTC),
+			Price:     15000,
+			Room:      models.Room{ID: 1, RoomName: "General's Quarters"},
+		},
+	}, nil
+}
+
+func (m *testDBRepo) SeasonalRatesForRoom(roomID int, start, end time.Time) ([]models.SeasonalRate, error) {
+	var seasons []models.SeasonalRate
+
+	all, _ := m.AllSeasonalRates()
+	for _, s := range all {
+		if s.RoomID == roomID && s.StartDate.Before(end) && !s.EndDate.Before(start) {
+			seasons = append(seasons, s)
+		}
+	}
+
+	return seasons, nil
+}
+
+func (m *testDBRepo) InsertSeasonalRate(s models.SeasonalRate) (int, error) {
+	return 2, nil
+}
+
+func (m *testDBRepo) DeleteSeasonalRate(id int) error {
+	return nil
+}
+
+// AllStayDiscounts returns 10% off stays of a week or more
+func (m *testDBRepo) AllStayDiscounts() ([]models.StayDiscount, error) {
+	return []models.StayDiscount{
+		{ID: 1, MinNights: 7, Percent: 1000},
+	}, nil
+}
+
+func (m *testDBRepo) InsertStayDiscount(d models.StayDiscount) (int, error) {
+	return 2, nil
+}
+
+func (m *testDBRepo) DeleteStayDiscount(id int) error {
+	return nil
+}
+
+// AllFees returns 15% VAT and a cleaning fee
+func (m *testDBRepo) AllFees() ([]models.Fee, error) {
+	return []models.Fee{
+		{ID: 1, Name: "VAT", Kind: models.FeePercent, Amount: 1500},
+		{ID: 2, Name: "Cleaning", Kind: models.FeePerStay, Amount: 2500},
+	}, nil
+}
+
+func (m *testDBRepo) InsertFee(f models.Fee) (int, error) {
+	return 3, nil
+}
+
+func (m *testDBRepo) DeleteFee(id int) error {
+	return nil
+}
+
+// AllStayRules returns rules for February 2050: a maximum stay of two weeks in room 1, and a minimum stay
+// of two nights and no arrivals on Sundays in room 2
+func (m *testDBRepo) AllStayRules() ([]models.StayRule, error) {
+	return []models.StayRule{
+		{
+			ID:        2,
+			RoomID:    1,
+			StartDate: time.Date(2050, 2, 1, 0, 0, 0, 0, time.UTC),
+			EndDate:   time.Date(2050, 2, 28, 0, 0, 0, 0, time.UTC),
+			MaxNights: 14,
+			Room:      models.Room{ID: 1, RoomName: "General's Quarters"},
+		},
+		{
+			ID:              1,
+			RoomID:          2,
+			StartDate:       time.Date(2050, 2, 1, 0, 0, 0, 0, time.UTC),
+			EndDate:         time.Date(2050, 2, 28, 0, 0, 0, 0, time.UTC),
+			MinNights:       2,
+			ClosedToArrival: []time.Weekday{time.Sunday},
+			Room:            models.Room{ID: 2, RoomName: "Major's Suite"},
+		},
+	}, nil
+}
+
+func (m *testDBRepo) StayRulesForRoom(roomID int, start, end time.Time) ([]models.StayRule, error) {
+	var rules []models.StayRule
+
+	all, _ := m.AllStayRules()
+	for _, s := range all {
+		if s.RoomID == roomID && !s.StartDate.After(end) && !s.EndDate.Before(start) {
+			rules = append(rules, s)
+		}
+	}
+
+	return rules, nil
+}
+
+func (m *testDBRepo) InsertStayRule(rule models.StayRule) (int, error) {
+	return 2, nil
+}
+
+func (m *testDBRepo) DeleteStayRule(id int) error {
+	return nil
+}
+
+// AllCancellationPolicies returns a standard policy for room 1, and a stricter one for its summer season
+func (m *testDBRepo) AllCancellationPolicies() ([]models.CancellationPolicy, error) {
+	seasons, _ := m.AllSeasonalRates()
+
+	return []models.CancellationPolicy{
+		{
+			ID:                1,
+			RoomID:            1,
+			FreeDays:          14,
+			PenaltyPercent:    50,
+			NonRefundableDays: 3,
+			Room:              models.Room{ID: 1, RoomName: "General's Quarters"},
+		},
+		{
+			ID:                2,
+			RoomID:            1,
+			SeasonalRateID:    1,
+			FreeDays:          30,
+			PenaltyPercent:    100,
+			NonRefundableDays: 7,
+			Room:              models.Room{ID: 1, RoomName: "General's Quarters"},
+			SeasonalRate:      seasons[0],
+		},
+	}, nil
+}
+
+func (m *testDBRepo) CancellationPolicyForStay(roomID int, arrival time.Time) (models.CancellationPolicy, error) {
+	all, _ := m.AllCancellationPolicies()
+
+	var policy models.CancellationPolicy
+	found := false
+	for _, p := range all {
+		if p.RoomID != roomID {
+			continue
+		}
+
+		if p.SeasonalRateID == 0 && !found {
+			policy, found = p, true
+		}
+
+		if p.SeasonalRateID != 0 && !arrival.Before(p.SeasonalRate.StartDate) && !arrival.After(p.SeasonalRate.EndDate) {
+			return p, nil
+		}
+	}
+
+	if !found {
+		return policy, sql.ErrNoRows
+	}
+
+	return policy, nil
+}
+
+func (m *testDBRepo) SaveCancellationPolicy(p models.CancellationPolicy) (int, error) {
+	return 3, nil
+}
+
+func (m *testDBRepo) DeleteCancellationPolicy(id int) error {
+	return nil
+}
+
 func (m *testDBRepo) GetUserByID(id int) (models.User, error) {
 	var u models.User
+	if id == 1000 {
+		return u, sql.ErrNoRows
+	}
 
+	// user 3 has two-factor authentication enabled
+	if id == 3 {
+		u.TOTPEnabledAt = time.Now()
+	}
+
+	u.ID = id
+	u.FirstName = "Admin"
+	u.LastName = "User"
+	u.Email = "admin@admin.com"
+	u.AccessLevel = models.AccessLevelAdmin
 	return u, nil
 }
 
+// UpdateUser treats user 1 as the only admin
 func (m *testDBRepo) UpdateUser(u models.User) error {
+	if u.ID == 1 && u.AccessLevel < models.AccessLevelAdmin {
+		return repository.ErrLastAdmin
+	}
+	if u.Email == "exists@here.com" {
+		return repository.ErrDuplicateEmail
+	}
 	return nil
 }
 
+// Authenticate fails for the password "wrong"
 func (m *testDBRepo) Authenticate(email, testPassword string) (int, string, error) {
+	if testPassword == "wrong" {
+		return 0, "", errors.New("incorrect password")
+	}
+	if email == "totp@here.com" {
+		return 3, "", nil
+	}
+
 	return 1, "", nil
 }
 
-func (m *testDBRepo) AllReservations() ([]models.Reservation, error) {
-	var reservations []models.Reservation
+// AllReservations returns reservations 1, 2 and 5, in status if it isn't empty
+func (m *testDBRepo) AllReservations(status string) ([]models.Reservation, error) {
+	return m.reservationsInStatus(status, 1, 2, 5)
+}
 
-	return reservations, nil
+// AllNewReservations returns reservations 1 and 5, in status if it isn't empty
+func (m *testDBRepo) AllNewReservations(status string) ([]models.Reservation, error) {
+	return m.reservationsInStatus(status, 1, 5)
 }
 
-func (m *testDBRepo) AllNewReservations() ([]models.Reservation, error) {
+// reservationsInStatus returns the reservations with ids, in status if it isn't empty
+func (m *testDBRepo) reservationsInStatus(status string, ids ...int) ([]models.Reservation, error) {
 	var reservations []models.Reservation
+	for _, id := range ids {
+		r, _ := m.GetReservationByID(id)
+		if status == "" || r.Status == status {
+			reservations = append(reservations, r)
+		}
+	}
 
 	return reservations, nil
 }
 
 func (m *testDBRepo) GetReservationByID(id int) (models.Reservation, error) {
 	var r models.Reservation
+	if id == 1000 {
+		return r, sql.ErrNoRows
+	}
+
+	r.ID = id
+	r.FirstName = "John"
+	r.LastName = "Smith"
+	r.Email = "john@smith.com"
+	r.RoomID = 1
+	r.Adults = 2
+	r.ConfirmationCode = TestConfirmationCode
+	r.StartDate = time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC)
+	r.EndDate = time.Date(2050, 1, 3, 0, 0, 0, 0, time.UTC)
+	r.Status = models.ReservationConfirmed
+
+	// reservation 2 was cancelled, and the guest of reservation 5 has checked in
+	switch id {
+	case 2:
+		r.CancelledAt = time.Now()
+		r.Status = models.ReservationCancelled
+	case 5:
+		r.Status = models.ReservationCheckedIn
+	}
+
+	// reservations 3 and 4 were booked together
+	if id == 3 || id == 4 {
+		r.BookingID = 1
+	}
+	if id == 4 {
+		r.RoomID = 2
+		r.Room.RoomName = "Major's Suite"
+	}
 
 	return r, nil
 }
 
+// TestConfirmationCode is the confirmation code of reservation 1
+const TestConfirmationCode = "ABCDEFGHJK"
+
+// GetReservationByCode finds reservation 1 by TestConfirmationCode and john@smith.com
+func (m *testDBRepo) GetReservationByCode(code, email string) (models.Reservation, error) {
+	if code != TestConfirmationCode || email != "john@smith.com" {
+		return models.Reservation{}, sql.ErrNoRows
+	}
+
+	return m.GetReservationByID(1)
+}
+
+// ChangeReservationDates treats every date in 2051 as unavailable
+func (m *testDBRepo) ChangeReservationDates(ctx context.Context, id int, start, end time.Time, quote models.Quote) error {
+	if start.Year() == 2051 {
+		return repository.ErrRoomUnavailable
+	}
+
+	return nil
+}
+
+// CancelReservation returns ErrInvalidTransition for reservations that can't be cancelled any more
+func (m *testDBRepo) CancelReservation(id int, reason string, fee int) error {
+	r, err := m.GetReservationByID(id)
+	if err != nil {
+		return err
+	}
+
+	if !lifecycle.Can(r.Status, models.ReservationCancelled) {
+		return repository.ErrInvalidTransition
+	}
+
+	return nil
+}
+
 func (m *testDBRepo) UpdateReservation(r models.Reservation) error {
 	return nil
 }
 
+// DeleteReservation fails for reservation 1000, which doesn't exist
 func (m *testDBRepo) DeleteReservation(id int) error {
+	if id == 1000 {
+		return sql.ErrNoRows
+	}
 	return nil
 }
 
-func (m *testDBRepo) UpdateProcessedForReservation(id, processed int) error {
+// DeletedReservations returns reservations 6 and 7, which are in the trash
+func (m *testDBRepo) DeletedReservations() ([]models.Reservation, error) {
+	var reservations []models.Reservation
+	for _, id := range []int{6, 7} {
+		r, _ := m.GetReservationByID(id)
+		r.DeletedAt = time.Date(2049, 12, 20, 9, 0, 0, 0, time.UTC)
+		reservations = append(reservations, r)
+	}
+
+	return reservations, nil
+}
+
+// RestoreReservation restores reservation 6. The room of reservation 7 has been booked since it was deleted,
+// and other reservations aren't in the trash
+func (m *testDBRepo) RestoreReservation(id int) error {
+	switch id {
+	case 6:
+		return nil
+	case 7:
+		return repository.ErrRoomUnavailable
+	}
+	return sql.ErrNoRows
+}
+
+func (m *testDBRepo) PurgeDeletedReservations(ctx context.Context, before time.Time) (int, error) {
+	return 0, nil
+}
+
+// TransitionReservation validates the change of state of the reservation
+func (m *testDBRepo) TransitionReservation(id int, status string) error {
+	r, err := m.GetReservationByID(id)
+	if err != nil {
+		return err
+	}
+
+	if status == models.ReservationCancelled || !lifecycle.Can(r.Status, status) {
+		return repository.ErrInvalidTransition
+	}
+
+	return nil
+}
+
+// ReservationEvents returns the booking and confirmation of a reservation
+func (m *testDBRepo) ReservationEvents(reservationID int) ([]models.ReservationEvent, error) {
+	created := time.Date(2049, 12, 1, 9, 0, 0, 0, time.UTC)
+
+	events := []models.ReservationEvent{
+		{ID: 1, ReservationID: reservationID, ToStatus: models.ReservationPending, CreateAt: created},
+		{ID: 2, ReservationID: reservationID, FromStatus: models.ReservationPending, ToStatus: models.ReservationConfirmed, CreateAt: created.Add(time.Hour)},
+	}
+
+	return events, nil
+}
+
+// AuditLog holds the entries recorded in the test repo, oldest first
+var AuditLog []models.AuditEntry
+
+// InsertAuditEntry records entries in AuditLog
+func (m *testDBRepo) InsertAuditEntry(e models.AuditEntry) error {
+	AuditLog = append(AuditLog, e)
+	return nil
+}
+
+// AuditEntries returns the entries in AuditLog selected by f, newest first
+func (m *testDBRepo) AuditEntries(f models.AuditFilter) ([]models.AuditEntry, error) {
+	var entries []models.AuditEntry
+	for i := len(AuditLog) - 1; i >= 0; i-- {
+		e := AuditLog[i]
+		if (f.Entity == "" || e.Entity == f.Entity) && (f.EntityID == 0 || e.EntityID == f.EntityID) &&
+			(f.ActorID == 0 || e.ActorID == f.ActorID) && (f.Action == "" || e.Action == f.Action) {
+			entries = append(entries, e)
+		}
+	}
+
+	return entries, nil
+}
+
+func (m *testDBRepo) AllRooms() ([]models.Room, error) {
+	rooms := []models.Room{
+		{
+			ID:          1,
+			RoomName:    "General's Quarters",
+			Slug:        "generals-quarters",
+			Description: "A quiet room with a view of the sea",
+			Capacity:    2,
+			MaxAdults:   2,
+			Amenities:   []string{"Sea view", "Queen bed"},
+			BasePrice:   12000,
+			Photos: []models.RoomPhoto{
+				{ID: 1, RoomID: 1, Filename: "rooms/generals-quarters.png", Caption: "The bedroom", SortOrder: 1},
+				{ID: 2, RoomID: 1, Filename: "rooms/generals-quarters-bathroom.png", Caption: "The bathroom", SortOrder: 2},
+			},
+		},
+		{
+			ID:            2,
+			RoomName:      "Major's Suite",
+			Slug:          "majors-suite",
+			Description:   "A suite for the whole family",
+			Capacity:      4,
+			MaxAdults:     2,
+			MaxChildren:   3,
+			Amenities:     []string{"Sea view", "King bed", "Sleeper couch"},
+			BasePrice:     18000,
+			WeekendUplift: 2000,
+		},
+	}
+
+	return rooms, nil
+}
+
+func (m *testDBRepo) GetRestrictionsForRoomByDate(roomID int, start, end time.Time) ([]models.RoomRestriction, error) {
+	var restrictions []models.RoomRestriction
+
+	if roomID == 1 {
+		restrictions = append(restrictions,
+			models.RoomRestriction{
+				ID:            1,
+				StartDate:     start,
+				EndDate:       start.AddDate(0, 0, 2),
+				RoomID:        roomID,
+				ReservationID: 1,
+				RestrictionID: models.RestrictionReservation,
+			},
+			models.RoomRestriction{
+				ID:            2,
+				StartDate:     start.AddDate(0, 0, 3),
+				EndDate:       start.AddDate(0, 0, 4),
+				RoomID:        roomID,
+				RestrictionID: models.RestrictionOwnerBlock,
+			},
+			models.RoomRestriction{
+				ID:            3,
+				StartDate:     start.AddDate(0, 0, 5),
+				EndDate:       start.AddDate(0, 0, 7),
+				RoomID:        roomID,
+				RestrictionID: models.RestrictionExternalBooking,
+				ExternalUID:   "booking-1@channel.example.com",
+			},
+		)
+	}
+
+	// in February, room 1 is also blocked from the 10th to the 13th
+	if roomID == 1 && start.Month() == time.February {
+		restrictions = append(restrictions, models.RoomRestriction{
+			ID:            4,
+			StartDate:     start.AddDate(0, 0, 9),
+			EndDate:       start.AddDate(0, 0, 12),
+			RoomID:        roomID,
+			RestrictionID: models.RestrictionOwnerBlock,
+		})
+	}
+
+	return restrictions, nil
+}
+
+func (m *testDBRepo) InsertBlockForRoom(roomID int, startDate time.Time) (int, error) {
+	return 1, nil
+}
+
+func (m *testDBRepo) DeleteBlockByID(id int) error {
 	return nil
 }
+
+// SplitBlock numbers the blocks that replace a block from 10
+func (m *testDBRepo) SplitBlock(id int, blocks []models.RoomRestriction) ([]int, error) {
+	var ids []int
+	for i := range blocks {
+		ids = append(ids, 10+i)
+	}
+
+	return ids, nil
+}
+
+// GetRoomByCalendarToken only knows the token "test-calendar-token" of room 1
+func (m *testDBRepo) GetRoomByCalendarToken(token string) (models.Room, error) {
+	var room models.Room
+	if token != "test-calendar-token" {
+		return room, sql.ErrNoRows
+	}
+
+	room.ID = 1
+	room.RoomName = "General's Quarters"
+	room.CalendarToken = token
+	return room, nil
+}
+
+func (m *testDBRepo) SetRoomCalendarToken(roomID int, token string) error {
+	return nil
+}
+
+func (m *testDBRepo) AllRoomCalendars() ([]models.RoomCalendar, error) {
+	calendars := []models.RoomCalendar{
+		{ID: 1, RoomID: 1, Name: "Channel", URL: "https://channel.example.com/room-1.ics", Room: models.Room{ID: 1, RoomName: "General's Quarters"}},
+	}
+
+	return calendars, nil
+}
+
+// GetRoomCalendarByID only knows calendar 1
+func (m *testDBRepo) GetRoomCalendarByID(id int) (models.RoomCalendar, error) {
+	var c models.RoomCalendar
+	if id != 1 {
+		return c, sql.ErrNoRows
+	}
+
+	calendars, _ := m.AllRoomCalendars()
+	return calendars[0], nil
+}
+
+func (m *testDBRepo) InsertRoomCalendar(c models.RoomCalendar) (int, error) {
+	return 2, nil
+}
+
+func (m *testDBRepo) DeleteRoomCalendar(id int) error {
+	return nil
+}
+
+func (m *testDBRepo) ReplaceExternalBookings(ctx context.Context, calendarID int, bookings []models.RoomRestriction) (int, error) {
+	return 0, nil
+}
+
+func (m *testDBRepo) UpdateRoomCalendarSync(c models.RoomCalendar) error {
+	return nil
+}
+
+// AllAPIKeys returns the key named test that GetAPIKeyByHash accepts
+func (m *testDBRepo) AllAPIKeys() ([]models.APIKey, error) {
+	var keys []models.APIKey
+
+	return append(keys, models.APIKey{ID: 1, Name: "test", KeyHash: helpers.HashToken("test-api-key")}), nil
+}
+
+func (m *testDBRepo) InsertAPIKey(k models.APIKey) (int, error) {
+	return 1, nil
+}
+
+func (m *testDBRepo) RevokeAPIKey(id int) error {
+	return nil
+}
+
+// GetAPIKeyByHash accepts only the hash of the key "test-api-key"
+func (m *testDBRepo) GetAPIKeyByHash(hash string) (models.APIKey, error) {
+	var k models.APIKey
+	if hash != helpers.HashToken("test-api-key") {
+		return k, sql.ErrNoRows
+	}
+
+	k.ID = 1
+	k.Name = "test"
+	k.KeyHash = hash
+	return k, nil
+}
+
+// GetUserByEmail only knows the user admin@admin.com
+func (m *testDBRepo) GetUserByEmail(email string) (models.User, error) {
+	var u models.User
+	if email != "admin@admin.com" {
+		return u, sql.ErrNoRows
+	}
+
+	return m.GetUserByID(1)
+}
+
+func (m *testDBRepo) InsertPasswordReset(userID int, tokenHash string, expires time.Time) error {
+	return nil
+}
+
+// UsePasswordReset resets the password of user 1, unless the new password is "deactivated1", which is set by a
+// user that was deactivated
+func (m *testDBRepo) UsePasswordReset(tokenHash, password string) (int, error) {
+	if password == "deactivated1" {
+		return 0, repository.ErrUserDeactivated
+	}
+
+	return 1, nil
+}
+
+// TestTOTPSecret is the TOTP secret of every test user
+const TestTOTPSecret = "JBSWY3DPEHPK3PXP"
+
+// TestRecoveryCode is the only recovery code the test repo accepts
+const TestRecoveryCode = "abcde-12345"
+
+func (m *testDBRepo) GetTOTPSecret(userID int) (string, error) {
+	return TestTOTPSecret, nil
+}
+
+func (m *testDBRepo) EnableTOTP(userID int, secret string, recoveryCodeHashes []string) error {
+	return nil
+}
+
+func (m *testDBRepo) DisableTOTP(userID int) error {
+	return nil
+}
+
+func (m *testDBRepo) UseTOTPStep(userID int, step int64) (bool, error) {
+	return true, nil
+}
+
+func (m *testDBRepo) ReplaceRecoveryCodes(userID int, codeHashes []string) error {
+	return nil
+}
+
+func (m *testDBRepo) UseRecoveryCode(userID int, codeHash string) (bool, error) {
+	return codeHash == helpers.HashToken(totp.NormalizeRecoveryCode(TestRecoveryCode)), nil
+}
+
+func (m *testDBRepo) CountRecoveryCodes(userID int) (int, error) {
+	return 10, nil
+}
+
+func (m *testDBRepo) GetSetting(name string) (string, error) {
+	return "", nil
+}
+
+func (m *testDBRepo) SetSetting(name, value string) error {
+	return nil
+}
+
+// InsertWaitlistEntry adds a guest to the waitlist. Guests with the email address error@here.com can't be added
+func (m *testDBRepo) InsertWaitlistEntry(e models.WaitlistEntry) (int, error) {
+	if e.Email == "error@here.com" {
+		return 0, errors.New("some error")
+	}
+	return 1, nil
+}
+
+// WaitlistEntriesToOffer returns one guest waiting for two nights in January 2050
+func (m *testDBRepo) WaitlistEntriesToOffer(from time.Time) ([]models.WaitlistEntry, error) {
+	return []models.WaitlistEntry{testWaitlistEntry()}, nil
+}
+
+func (m *testDBRepo) OfferWaitlistEntry(ctx context.Context, e models.WaitlistEntry, tokenHash string) error {
+	return nil
+}
+
+// GetWaitlistEntryByToken returns the waiting guest, offered the General's Quarters, for every token
+func (m *testDBRepo) GetWaitlistEntryByToken(tokenHash string) (models.WaitlistEntry, error) {
+	e := testWaitlistEntry()
+	e.RoomID = 1
+	e.Room = models.Room{ID: 1, RoomName: "General's Quarters"}
+	e.HoldID = 101
+	e.OfferedAt = time.Now()
+	e.OfferExpiresAt = time.Now().Add(24 * time.Hour)
+	return e, nil
+}
+
+func testWaitlistEntry() models.WaitlistEntry {
+	return models.WaitlistEntry{
+		ID:        1,
+		FirstName: "John",
+		Email:     "john@smith.com",
+		StartDate: time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC),
+		EndDate:   time.Date(2050, 1, 3, 0, 0, 0, 0, time.UTC),
+		Adults:    2,
+	}
+}
+
+// ReserveForPayment inserts reservation 1 awaiting payment. Room 1001 is taken
+func (m *testDBRepo) ReserveForPayment(ctx context.Context, res models.Reservation, holdID int, holdUntil time.Time, p models.Payment) (int, error) {
+	if res.RoomID == 1001 {
+		return 0, repository.ErrRoomUnavailable
+	}
+	return 1, nil
+}
+
+// ConfirmPayment confirms reservation 1 with a payment of 100 cents. The payment with ref "processed" was
+// already confirmed, the reservation of the payment with ref "closed" was cancelled, the room of the payment
+// with ref "taken" was taken and there is no payment with ref "unknown"
+func (m *testDBRepo) ConfirmPayment(ctx context.Context, provider, ref string) (models.Payment, error) {
+	p := models.Payment{
+		ID:            1,
+		ReservationID: 1,
+		Provider:      provider,
+		ProviderRef:   ref,
+		Kind:          models.PaymentKindPayment,
+		Status:        models.PaymentAuthorized,
+		Amount:        100,
+		Currency:      "USD",
+	}
+
+	switch ref {
+	case "processed":
+		return p, repository.ErrPaymentProcessed
+	case "closed":
+		p.Status = models.PaymentPending
+		return p, repository.ErrReservationClosed
+	case "taken":
+		p.Status = models.PaymentPending
+		return p, repository.ErrRoomUnavailable
+	case "unknown":
+		return models.Payment{}, sql.ErrNoRows
+	}
+
+	return p, nil
+}
+
+func (m *testDBRepo) InsertPayment(p models.Payment) (int, error) {
+	return 2, nil
+}
+
+// UpdatePaymentStatus updates every payment except the one with ref "unknown". Payments were authorized before
+func (m *testDBRepo) UpdatePaymentStatus(provider, ref, status string) (models.Payment, error) {
+	if ref == "unknown" {
+		return models.Payment{}, sql.ErrNoRows
+	}
+
+	p := models.Payment{
+		ID:            1,
+		ReservationID: 1,
+		Provider:      provider,
+		ProviderRef:   ref,
+		Kind:          models.PaymentKindPayment,
+		Status:        models.PaymentAuthorized,
+		Amount:        100,
+		Currency:      "USD",
+	}
+	return p, nil
+}
+
+// PaymentsForReservation returns a captured payment of $300 and an authorized payment of $60 for reservation 1,
+// and no payments otherwise
+func (m *testDBRepo) PaymentsForReservation(reservationID int) ([]models.Payment, error) {
+	var payments []models.Payment
+	if reservationID != 1 {
+		return payments, nil
+	}
+
+	return append(payments, models.Payment{
+		ID:            1,
+		ReservationID: 1,
+		Provider:      "fake",
+		ProviderRef:   "fake_1",
+		Kind:          models.PaymentKindPayment,
+		Status:        models.PaymentCaptured,
+		Amount:        30000,
+		Currency:      "USD",
+	}, models.Payment{
+		ID:            2,
+		ReservationID: 1,
+		Provider:      "fake",
+		ProviderRef:   "fake_2",
+		Kind:          models.PaymentKindPayment,
+		Status:        models.PaymentAuthorized,
+		Amount:        6000,
+		Currency:      "USD",
+	}), nil
+}
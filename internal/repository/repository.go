@@ -1,26 +1,138 @@
 package repository
 
 import (
+	"context"
+	"errors"
 	"time"
 
 	"github.com/dhanekom/bookings/internal/models"
 )
 
+// ErrRoomUnavailable is returned when a room is already restricted for the requested dates
+var ErrRoomUnavailable = errors.New("room is not available for the requested dates")
+
+// ErrLastAdmin is returned when a change would leave no active admin users
+var ErrLastAdmin = errors.New("there must be at least one active admin user")
+
+// ErrUserDeactivated is returned when the password of a deactivated user is reset
+var ErrUserDeactivated = errors.New("user is deactivated")
+
+// ErrDuplicateEmail is returned when a user's email address is already in use
+var ErrDuplicateEmail = errors.New("email address is already in use")
+
+// ErrDuplicateSlug is returned when a room's slug is already in use
+var ErrDuplicateSlug = errors.New("slug is already in use")
+
+// ErrRoomInUse is returned when a room with reservations is deleted
+var ErrRoomInUse = errors.New("room has reservations")
+
+// ErrPaymentProcessed is returned when a payment that was already authorized or failed is confirmed again
+var ErrPaymentProcessed = errors.New("payment has already been processed")
+
+// ErrReservationClosed is returned when the payment of a reservation that was cancelled, deleted or already
+// confirmed is confirmed
+var ErrReservationClosed = errors.New("reservation is no longer awaiting payment")
+
+// ErrInvalidTransition is returned when a reservation can't change from its state to the one requested
+var ErrInvalidTransition = errors.New("reservation can't change to the requested state")
+
 type DatabaseRepo interface {
-	AllUsers() bool
+	AllUsers() ([]models.User, error)
+	InsertUser(u models.User, password string) (int, error)
+	UpdateUserPassword(id int, password string) error
+	SetUserActive(id int, active bool) error
+	GetUserByEmail(email string) (models.User, error)
+	InsertPasswordReset(userID int, tokenHash string, expires time.Time) error
+	UsePasswordReset(tokenHash, password string) (int, error)
+	GetTOTPSecret(userID int) (string, error)
+	EnableTOTP(userID int, secret string, recoveryCodeHashes []string) error
+	DisableTOTP(userID int) error
+	UseTOTPStep(userID int, step int64) (bool, error)
+	ReplaceRecoveryCodes(userID int, codeHashes []string) error
+	UseRecoveryCode(userID int, codeHash string) (bool, error)
+	CountRecoveryCodes(userID int) (int, error)
+	GetSetting(name string) (string, error)
+	SetSetting(name, value string) error
 
 	InsertReservation(res models.Reservation) (int, error)
 	InsertRoomRestriction(r models.RoomRestriction) error
+	BookRoom(ctx context.Context, res models.Reservation, holdID int) (int, error)
+	BookRooms(ctx context.Context, b models.Booking, holdIDs []int) (int, []int, error)
+	GetBookingByID(id int) (models.Booking, error)
+	PlaceHold(ctx context.Context, hold models.RoomRestriction) (int, error)
+	ReleaseHold(id int) error
+	DeleteExpiredHolds(ctx context.Context, now time.Time) (int, error)
+	CancelUnpaidReservations(ctx context.Context, now time.Time) (int, error)
 	SearchAvailabilityByDatesByRoomID(start, end time.Time, roomID int) (bool, error)
-	SearchAvailabilityForAllRooms(start, end time.Time) ([]models.Room, error)
+	SearchAvailabilityForAllRooms(start, end time.Time, adults, children int) ([]models.Room, error)
 	GetRoomByID(id int) (models.Room, error)
+	GetRoomBySlug(slug string) (models.Room, error)
+	InsertRoom(room models.Room) (int, error)
+	UpdateRoom(room models.Room) error
+	DeleteRoom(id int) error
+	InsertRoomPhoto(p models.RoomPhoto) (int, error)
+	DeleteRoomPhoto(roomID, photoID int) (models.RoomPhoto, error)
+	SetRoomPhotoOrder(roomID int, photoIDs []int) error
+	AllSeasonalRates() ([]models.SeasonalRate, error)
+	SeasonalRatesForRoom(roomID int, start, end time.Time) ([]models.SeasonalRate, error)
+	InsertSeasonalRate(s models.SeasonalRate) (int, error)
+	DeleteSeasonalRate(id int) error
+	AllStayDiscounts() ([]models.StayDiscount, error)
+	InsertStayDiscount(d models.StayDiscount) (int, error)
+	DeleteStayDiscount(id int) error
+	AllFees() ([]models.Fee, error)
+	InsertFee(f models.Fee) (int, error)
+	DeleteFee(id int) error
+	AllStayRules() ([]models.StayRule, error)
+	StayRulesForRoom(roomID int, start, end time.Time) ([]models.StayRule, error)
+	InsertStayRule(rule models.StayRule) (int, error)
+	DeleteStayRule(id int) error
+	AllCancellationPolicies() ([]models.CancellationPolicy, error)
+	CancellationPolicyForStay(roomID int, arrival time.Time) (models.CancellationPolicy, error)
+	SaveCancellationPolicy(p models.CancellationPolicy) (int, error)
+	DeleteCancellationPolicy(id int) error
 	GetUserByID(id int) (models.User, error)
 	UpdateUser(u models.User) error
 	Authenticate(email, testPassword string) (int, string, error)
-	AllReservations() ([]models.Reservation, error)
-	AllNewReservations() ([]models.Reservation, error)
+	AllReservations(status string) ([]models.Reservation, error)
+	AllNewReservations(status string) ([]models.Reservation, error)
 	GetReservationByID(id int) (models.Reservation, error)
+	GetReservationByCode(code, email string) (models.Reservation, error)
+	ChangeReservationDates(ctx context.Context, id int, start, end time.Time, quote models.Quote) error
+	CancelReservation(id int, reason string, fee int) error
 	UpdateReservation(r models.Reservation) error
 	DeleteReservation(id int) error
-	UpdateProcessedForReservation(id, processed int) error
+	DeletedReservations() ([]models.Reservation, error)
+	RestoreReservation(id int) error
+	PurgeDeletedReservations(ctx context.Context, before time.Time) (int, error)
+	TransitionReservation(id int, status string) error
+	ReservationEvents(reservationID int) ([]models.ReservationEvent, error)
+	InsertAuditEntry(e models.AuditEntry) error
+	AuditEntries(f models.AuditFilter) ([]models.AuditEntry, error)
+	AllRooms() ([]models.Room, error)
+	GetRestrictionsForRoomByDate(roomID int, start, end time.Time) ([]models.RoomRestriction, error)
+	InsertBlockForRoom(roomID int, startDate time.Time) (int, error)
+	DeleteBlockByID(id int) error
+	SplitBlock(id int, blocks []models.RoomRestriction) ([]int, error)
+	GetRoomByCalendarToken(token string) (models.Room, error)
+	SetRoomCalendarToken(roomID int, token string) error
+	AllRoomCalendars() ([]models.RoomCalendar, error)
+	GetRoomCalendarByID(id int) (models.RoomCalendar, error)
+	InsertRoomCalendar(c models.RoomCalendar) (int, error)
+	DeleteRoomCalendar(id int) error
+	ReplaceExternalBookings(ctx context.Context, calendarID int, bookings []models.RoomRestriction) (int, error)
+	UpdateRoomCalendarSync(c models.RoomCalendar) error
+	AllAPIKeys() ([]models.APIKey, error)
+	InsertAPIKey(k models.APIKey) (int, error)
+	RevokeAPIKey(id int) error
+	GetAPIKeyByHash(hash string) (models.APIKey, error)
+	InsertWaitlistEntry(e models.WaitlistEntry) (int, error)
+	WaitlistEntriesToOffer(from time.Time) ([]models.WaitlistEntry, error)
+	OfferWaitlistEntry(ctx context.Context, e models.WaitlistEntry, tokenHash string) error
+	GetWaitlistEntryByToken(tokenHash string) (models.WaitlistEntry, error)
+	ReserveForPayment(ctx context.Context, res models.Reservation, holdID int, holdUntil time.Time, p models.Payment) (int, error)
+	ConfirmPayment(ctx context.Context, provider, ref string) (models.Payment, error)
+	InsertPayment(p models.Payment) (int, error)
+	UpdatePaymentStatus(provider, ref, status string) (models.Payment, error)
+	PaymentsForReservation(reservationID int) ([]models.Payment, error)
 }
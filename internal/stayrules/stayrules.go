@@ -0,0 +1,149 @@
+// Package stayrules checks stays against the minimum and maximum length of stay, closed to arrival and
+// departure days, booking lead time and horizon, and occupancy limits of a room
+package stayrules
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/dhanekom/bookings/internal/models"
+)
+
+// Fields of a stay that a violation can be about
+const (
+	Arrival   = "arrival"
+	Departure = "departure"
+	Adults    = "adults"
+	Children  = "children"
+)
+
+// Violation is a rule that a stay breaks, with a message for the guest
+type Violation struct {
+	Field   string
+	Message string
+}
+
+// Check returns the violations of a stay from start to end that is booked on today. The stay must end after it
+// starts and can't start before today, whatever the rules. Rules apply to stays that arrive in their date
+// range, except for closed to departure days, which apply to stays that depart in it
+func Check(rules []models.StayRule, start, end, today time.Time) []Violation {
+	start, end, today = dateOf(start), dateOf(end), dateOf(today)
+
+	if start.Before(today) {
+		return []Violation{{Arrival, "The arrival date can't be in the past"}}
+	}
+
+	if !end.After(start) {
+		return []Violation{{Departure, "The departure date must be after the arrival date"}}
+	}
+
+	var violations []Violation
+	add := func(field, format string, args ...interface{}) {
+		v := Violation{field, fmt.Sprintf(format, args...)}
+		for _, existing := range violations {
+			if existing == v {
+				return
+			}
+		}
+		violations = append(violations, v)
+	}
+
+	nights := days(start, end)
+	lead := days(today, start)
+
+	for _, r := range rules {
+		if covers(r, start) {
+			if r.MinNights > 0 && nights < r.MinNights {
+				add(Departure, "Stays arriving on %s must be at least %s", start.Format("2006-01-02"), plural(r.MinNights, "night"))
+			}
+
+			if r.MaxNights > 0 && nights > r.MaxNights {
+				add(Departure, "Stays arriving on %s can be at most %s", start.Format("2006-01-02"), plural(r.MaxNights, "night"))
+			}
+
+			if has(r.ClosedToArrival, start.Weekday()) {
+				add(Arrival, "Arrivals aren't possible on %ss", start.Weekday())
+			}
+
+			if r.MinAdvanceDays > 0 && lead < r.MinAdvanceDays {
+				add(Arrival, "Stays arriving on %s must be booked at least %s in advance", start.Format("2006-01-02"), plural(r.MinAdvanceDays, "day"))
+			}
+
+			if r.MaxAdvanceDays > 0 && lead > r.MaxAdvanceDays {
+				add(Arrival, "Stays can't be booked more than %s in advance", plural(r.MaxAdvanceDays, "day"))
+			}
+		}
+
+		if covers(r, end) && has(r.ClosedToDeparture, end.Weekday()) {
+			add(Departure, "Departures aren't possible on %ss", end.Weekday())
+		}
+	}
+
+	return violations
+}
+
+// CheckOccupancy returns the violations of the occupancy limits of room by a party of adults and children.
+// Every party needs an adult
+func CheckOccupancy(room models.Room, adults, children int) []Violation {
+	var violations []Violation
+
+	switch {
+	case adults < 1:
+		violations = append(violations, Violation{Adults, "At least one adult must stay"})
+	case adults > room.MaxAdults:
+		violations = append(violations, Violation{Adults, fmt.Sprintf("This room sleeps at most %s", plural(room.MaxAdults, "adult"))})
+	}
+
+	switch {
+	case children < 0:
+		violations = append(violations, Violation{Children, "The number of children can't be negative"})
+	case children > 0 && room.MaxChildren == 0:
+		violations = append(violations, Violation{Children, "This room doesn't sleep children"})
+	case children > room.MaxChildren:
+		limit := fmt.Sprintf("%d children", room.MaxChildren)
+		if room.MaxChildren == 1 {
+			limit = "1 child"
+		}
+		violations = append(violations, Violation{Children, "This room sleeps at most " + limit})
+	}
+
+	if len(violations) == 0 && adults+children > room.Capacity {
+		violations = append(violations, Violation{Children, fmt.Sprintf("This room sleeps at most %s", plural(room.Capacity, "guest"))})
+	}
+
+	return violations
+}
+
+// covers reports whether d is in the date range of r
+func covers(r models.StayRule, d time.Time) bool {
+	return !d.Before(dateOf(r.StartDate)) && !d.After(dateOf(r.EndDate))
+}
+
+// has reports whether days includes d
+func has(days []time.Weekday, d time.Weekday) bool {
+	for _, day := range days {
+		if day == d {
+			return true
+		}
+	}
+	return false
+}
+
+// days returns the number of days from one date to another
+func days(from, to time.Time) int {
+	return int(to.Sub(from) / (24 * time.Hour))
+}
+
+// plural returns n followed by unit, with an s if n isn't 1
+func plural(n int, unit string) string {
+	if n == 1 {
+		return fmt.Sprintf("1 %s", unit)
+	}
+	return fmt.Sprintf("%d %ss", n, unit)
+}
+
+// dateOf returns the date of t at midnight UTC, which is how dates are parsed and stored
+func dateOf(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
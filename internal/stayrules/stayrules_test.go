@@ -0,0 +1,96 @@
+package stayrules
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/dhanekom/bookings/internal/models"
+)
+
+func date(day int) time.Time {
+	return time.Date(2050, 1, day, 0, 0, 0, 0, time.UTC)
+}
+
+// 2050-01-01 is a Saturday
+var testRules = []models.StayRule{
+	{
+		StartDate:         date(1),
+		EndDate:           date(31),
+		MinNights:         2,
+		MaxNights:         7,
+		ClosedToArrival:   []time.Weekday{time.Sunday},
+		ClosedToDeparture: []time.Weekday{time.Saturday},
+		MinAdvanceDays:    3,
+		MaxAdvanceDays:    60,
+	},
+	{
+		StartDate: date(20),
+		EndDate:   date(25),
+		MinNights: 4,
+	},
+}
+
+func TestCheck(t *testing.T) {
+	today := date(1)
+
+	var tests = []struct {
+		name     string
+		start    time.Time
+		end      time.Time
+		today    time.Time
+		expected []Violation
+	}{
+		{"allowed", date(4), date(6), today, nil},
+		{"in the past", date(4), date(6), date(5), []Violation{{Arrival, "The arrival date can't be in the past"}}},
+		{"no nights", date(4), date(4), today, []Violation{{Departure, "The departure date must be after the arrival date"}}},
+		{"too short", date(4), date(5), today, []Violation{{Departure, "Stays arriving on 2050-01-04 must be at least 2 nights"}}},
+		{"too long", date(4), date(12), today, []Violation{{Departure, "Stays arriving on 2050-01-04 can be at most 7 nights"}}},
+		{"closed to arrival", date(9), date(11), today, []Violation{{Arrival, "Arrivals aren't possible on Sundays"}}},
+		{"closed to departure", date(5), date(8), today, []Violation{{Departure, "Departures aren't possible on Saturdays"}}},
+		{"departure after the rule", date(31), date(36), today, nil},
+		{"too soon", date(3), date(5), today, []Violation{{Arrival, "Stays arriving on 2050-01-03 must be booked at least 3 days in advance"}}},
+		{"too far ahead", date(4), date(6), date(4).AddDate(0, 0, -61), []Violation{{Arrival, "Stays can't be booked more than 60 days in advance"}}},
+		{"overlapping rules", date(21), date(23), today, []Violation{{Departure, "Stays arriving on 2050-01-21 must be at least 4 nights"}}},
+		{"times are ignored", date(4).Add(14 * time.Hour), date(6).Add(10 * time.Hour), today.Add(23 * time.Hour), nil},
+	}
+
+	for _, e := range tests {
+		got := Check(testRules, e.start, e.end, e.today)
+		if !reflect.DeepEqual(got, e.expected) {
+			t.Errorf("%s: expected %v, got %v", e.name, e.expected, got)
+		}
+	}
+}
+
+func TestCheckNoRules(t *testing.T) {
+	if v := Check(nil, date(1), date(91), date(1)); v != nil {
+		t.Errorf("expected no violations without rules, got %v", v)
+	}
+}
+
+func TestCheckOccupancy(t *testing.T) {
+	room := models.Room{Capacity: 4, MaxAdults: 2, MaxChildren: 3}
+
+	var tests = []struct {
+		name     string
+		room     models.Room
+		adults   int
+		children int
+		expected []Violation
+	}{
+		{"fits", room, 2, 2, nil},
+		{"no adults", room, 0, 2, []Violation{{Adults, "At least one adult must stay"}}},
+		{"too many adults", room, 3, 0, []Violation{{Adults, "This room sleeps at most 2 adults"}}},
+		{"too many children", room, 1, 4, []Violation{{Children, "This room sleeps at most 3 children"}}},
+		{"no children allowed", models.Room{Capacity: 2, MaxAdults: 2}, 1, 1, []Violation{{Children, "This room doesn't sleep children"}}},
+		{"too many guests", room, 2, 3, []Violation{{Children, "This room sleeps at most 4 guests"}}},
+	}
+
+	for _, e := range tests {
+		got := CheckOccupancy(e.room, e.adults, e.children)
+		if !reflect.DeepEqual(got, e.expected) {
+			t.Errorf("%s: expected %v, got %v", e.name, e.expected, got)
+		}
+	}
+}
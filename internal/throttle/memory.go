@@ -0,0 +1,112 @@
+package throttle
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// pruneEvery is the number of failures recorded between removing expired attempts from a memory store
+const pruneEvery = 1000
+
+type memoryStore struct {
+	mu       sync.Mutex
+	attempts map[string]Attempts
+	windows  map[string]time.Duration
+	fails    int
+}
+
+// NewMemoryStore returns a store that keeps attempts in memory. Attempts are lost on restart and aren't
+// shared between instances of the application
+func NewMemoryStore() Store {
+	return &memoryStore{
+		attempts: make(map[string]Attempts),
+		windows:  make(map[string]time.Duration),
+	}
+}
+
+func (m *memoryStore) Get(key string) (Attempts, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	a, ok := m.attempts[key]
+	if !ok {
+		a.Key = key
+	}
+
+	return a, nil
+}
+
+func (m *memoryStore) Attempt(key string, now time.Time, policy Policy) (Attempts, time.Duration, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	a, ok := m.attempts[key]
+	if !ok {
+		a.Key = key
+	}
+
+	a, wait, err := policy.reserve(a, now)
+	if err != nil {
+		return a, wait, err
+	}
+
+	m.fails++
+	if m.fails%pruneEvery == 0 {
+		m.prune(now)
+	}
+
+	m.attempts[key] = a
+	m.windows[key] = policy.Window
+
+	return a, 0, nil
+}
+
+func (m *memoryStore) Refund(key string, policy Policy) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if a, ok := m.attempts[key]; ok {
+		m.attempts[key] = policy.refund(a)
+	}
+
+	return nil
+}
+
+// prune removes attempts that are no longer locked and have fallen out of their window
+func (m *memoryStore) prune(now time.Time) {
+	for key, a := range m.attempts {
+		if !a.LockedUntil.After(now) && now.Sub(a.LastFailureAt) > m.windows[key] {
+			delete(m.attempts, key)
+			delete(m.windows, key)
+		}
+	}
+}
+
+func (m *memoryStore) Reset(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.attempts, key)
+	delete(m.windows, key)
+
+	return nil
+}
+
+func (m *memoryStore) Locked(now time.Time) ([]Attempts, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var locked []Attempts
+	for _, a := range m.attempts {
+		if a.LockedUntil.After(now) {
+			locked = append(locked, a)
+		}
+	}
+
+	sort.Slice(locked, func(i, j int) bool {
+		return locked[i].LockedUntil.After(locked[j].LockedUntil)
+	})
+
+	return locked, nil
+}
@@ -0,0 +1,168 @@
+package throttle
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+type postgresStore struct {
+	DB *sql.DB
+}
+
+// NewPostgresStore returns a store that keeps attempts in the login_attempts table
+func NewPostgresStore(db *sql.DB) Store {
+	return &postgresStore{DB: db}
+}
+
+func (m *postgresStore) Get(key string) (Attempts, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	query := `select throttle_key, failures, last_failure_at, previous_failure_at, locked_until
+	          from login_attempts where throttle_key = $1`
+
+	a, err := scanAttempts(m.DB.QueryRowContext(ctx, query, key))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Attempts{Key: key}, nil
+	}
+
+	return a, err
+}
+
+func (m *postgresStore) Attempt(key string, now time.Time, policy Policy) (Attempts, time.Duration, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return Attempts{}, 0, err
+	}
+	defer tx.Rollback()
+
+	// make sure there is a row to lock, so that concurrent attempts for the same key are checked and counted
+	// one at a time
+	_, err = tx.ExecContext(ctx, `insert into login_attempts (throttle_key, failures, created_at, updated_at)
+	                              values ($1, 0, $2, $2) on conflict (throttle_key) do nothing`, key, time.Now())
+	if err != nil {
+		return Attempts{}, 0, err
+	}
+
+	query := `select throttle_key, failures, last_failure_at, previous_failure_at, locked_until
+	          from login_attempts where throttle_key = $1 for update`
+
+	a, err := scanAttempts(tx.QueryRowContext(ctx, query, key))
+	if err != nil {
+		return a, 0, err
+	}
+
+	a, wait, err := policy.reserve(a, now)
+	if err != nil {
+		return a, wait, err
+	}
+
+	err = updateAttempts(ctx, tx, a)
+	if err != nil {
+		return a, 0, err
+	}
+
+	return a, 0, tx.Commit()
+}
+
+func (m *postgresStore) Refund(key string, policy Policy) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `select throttle_key, failures, last_failure_at, previous_failure_at, locked_until
+	          from login_attempts where throttle_key = $1 for update`
+
+	a, err := scanAttempts(tx.QueryRowContext(ctx, query, key))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	err = updateAttempts(ctx, tx, policy.refund(a))
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// updateAttempts saves a in tx
+func updateAttempts(ctx context.Context, tx *sql.Tx, a Attempts) error {
+	stmt := `update login_attempts set failures = $1, last_failure_at = $2, previous_failure_at = $3,
+	           locked_until = $4, updated_at = $5
+	         where throttle_key = $6`
+
+	_, err := tx.ExecContext(ctx, stmt, a.Failures, nullTime(a.LastFailureAt), nullTime(a.PreviousFailureAt),
+		nullTime(a.LockedUntil), time.Now(), a.Key)
+	return err
+}
+
+func (m *postgresStore) Reset(key string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `delete from login_attempts where throttle_key = $1`, key)
+	return err
+}
+
+func (m *postgresStore) Locked(now time.Time) ([]Attempts, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	query := `select throttle_key, failures, last_failure_at, previous_failure_at, locked_until
+	          from login_attempts where locked_until > $1 order by locked_until desc`
+
+	rows, err := m.DB.QueryContext(ctx, query, now)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var locked []Attempts
+	for rows.Next() {
+		a, err := scanAttempts(rows)
+		if err != nil {
+			return locked, err
+		}
+		locked = append(locked, a)
+	}
+
+	return locked, rows.Err()
+}
+
+// scanner is implemented by *sql.Row and *sql.Rows
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanAttempts(row scanner) (Attempts, error) {
+	var a Attempts
+	var lastFailureAt, previousFailureAt, lockedUntil sql.NullTime
+
+	err := row.Scan(&a.Key, &a.Failures, &lastFailureAt, &previousFailureAt, &lockedUntil)
+	if err != nil {
+		return a, err
+	}
+
+	a.LastFailureAt = lastFailureAt.Time
+	a.PreviousFailureAt = previousFailureAt.Time
+	a.LockedUntil = lockedUntil.Time
+
+	return a, nil
+}
+
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t, Valid: !t.IsZero()}
+}
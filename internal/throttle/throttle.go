@@ -0,0 +1,276 @@
+// Package throttle slows down and locks out repeated failed logins
+package throttle
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/dhanekom/bookings/internal/background"
+)
+
+// ErrThrottled is returned when an attempt is made before the backoff delay has passed
+var ErrThrottled = errors.New("too many failed attempts, try again later")
+
+// ErrLocked is returned when an account is locked because of too many failed attempts
+var ErrLocked = errors.New("account locked because of too many failed attempts")
+
+// Attempts holds the failed attempts for a key. PreviousFailureAt is the failure before the last one, which
+// becomes the last one again if the last attempt is refunded
+type Attempts struct {
+	Key               string
+	Failures          int
+	LastFailureAt     time.Time
+	PreviousFailureAt time.Time
+	LockedUntil       time.Time
+}
+
+// Store holds failed attempts. Implementations must be safe for concurrent use
+type Store interface {
+	// Get returns the attempts for key, which are empty if there are none
+	Get(key string) (Attempts, error)
+	// Attempt records an attempt for key at now as a failure according to policy and returns the updated
+	// attempts. If policy doesn't allow an attempt at now, nothing is recorded and ErrLocked or ErrThrottled is
+	// returned along with how long to wait. Checking and recording must be atomic, so that concurrent attempts
+	// for the same key are let through one at a time
+	Attempt(key string, now time.Time, policy Policy) (Attempts, time.Duration, error)
+	// Refund takes back the last attempt recorded for key according to policy
+	Refund(key string, policy Policy) error
+	// Reset forgets all attempts for key
+	Reset(key string) error
+	// Locked returns the attempts that are locked at now
+	Locked(now time.Time) ([]Attempts, error)
+}
+
+// Policy describes how failed attempts for a key are throttled
+type Policy struct {
+	// FreeAttempts is the number of failures allowed before a backoff delay is applied
+	FreeAttempts int
+	// BaseDelay is the delay after the first failure past FreeAttempts, doubling with each further failure
+	BaseDelay time.Duration
+	// MaxDelay caps the backoff delay
+	MaxDelay time.Duration
+	// LockAfter is the number of failures after which the key is locked. 0 never locks
+	LockAfter int
+	// LockFor is how long a key stays locked
+	LockFor time.Duration
+	// Window is how long failures are remembered after the last one
+	Window time.Duration
+}
+
+// Delay returns the backoff delay after the given number of failures
+func (p Policy) Delay(failures int) time.Duration {
+	n := failures - p.FreeAttempts
+	if n <= 0 {
+		return 0
+	}
+
+	delay := p.BaseDelay
+	for i := 1; i < n && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+
+	if delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+
+	return delay
+}
+
+// reserve returns a with a failure at now added if an attempt is allowed at now. Otherwise it returns a
+// unchanged, how long to wait and ErrLocked or ErrThrottled
+func (p Policy) reserve(a Attempts, now time.Time) (Attempts, time.Duration, error) {
+	if a.LockedUntil.After(now) {
+		return a, a.LockedUntil.Sub(now), ErrLocked
+	}
+
+	if wait := p.wait(a, now); wait > 0 {
+		return a, wait, ErrThrottled
+	}
+
+	return p.apply(a, now), 0, nil
+}
+
+// wait returns how long to wait at now before the next attempt for a is allowed
+func (p Policy) wait(a Attempts, now time.Time) time.Duration {
+	if a.LastFailureAt.IsZero() || now.Sub(a.LastFailureAt) > p.Window {
+		return 0
+	}
+
+	next := a.LastFailureAt.Add(p.Delay(a.Failures))
+	if next.After(now) {
+		return next.Sub(now)
+	}
+
+	return 0
+}
+
+// apply returns a with a failure at now added
+func (p Policy) apply(a Attempts, now time.Time) Attempts {
+	if a.LastFailureAt.IsZero() || now.Sub(a.LastFailureAt) > p.Window {
+		a.Failures = 0
+	}
+
+	a.Failures++
+	a.PreviousFailureAt = a.LastFailureAt
+	a.LastFailureAt = now
+
+	if p.LockAfter > 0 && a.Failures >= p.LockAfter {
+		a.LockedUntil = now.Add(p.LockFor)
+	}
+
+	return a
+}
+
+// refund returns a with its last failure taken back, along with the delay and lock it caused
+func (p Policy) refund(a Attempts) Attempts {
+	if a.Failures > 0 {
+		a.Failures--
+	}
+
+	// the backoff runs from the failure before, so that a refunded attempt doesn't delay the next one
+	a.LastFailureAt = a.PreviousFailureAt
+	a.PreviousFailureAt = time.Time{}
+
+	if p.LockAfter == 0 || a.Failures < p.LockAfter {
+		a.LockedUntil = time.Time{}
+	}
+
+	return a
+}
+
+// DefaultAccountPolicy throttles attempts against a single account and locks it after 10 failures
+var DefaultAccountPolicy = Policy{
+	FreeAttempts: 3,
+	BaseDelay:    time.Second,
+	MaxDelay:     time.Minute,
+	LockAfter:    10,
+	LockFor:      time.Hour,
+	Window:       time.Hour,
+}
+
+// DefaultIPPolicy throttles attempts from a single client IP without ever locking it
+var DefaultIPPolicy = Policy{
+	FreeAttempts: 10,
+	BaseDelay:    time.Second,
+	MaxDelay:     5 * time.Minute,
+	Window:       time.Hour,
+}
+
+// Throttler tracks failed logins by account and by client IP
+type Throttler struct {
+	Store         Store
+	Clock         background.Clock
+	AccountPolicy Policy
+	IPPolicy      Policy
+}
+
+// New returns a throttler with the default policies. A nil clock uses the system time
+func New(store Store, clock background.Clock) *Throttler {
+	if clock == nil {
+		clock = background.SystemClock{}
+	}
+
+	return &Throttler{
+		Store:         store,
+		Clock:         clock,
+		AccountPolicy: DefaultAccountPolicy,
+		IPPolicy:      DefaultIPPolicy,
+	}
+}
+
+const (
+	accountPrefix = "account:"
+	ipPrefix      = "ip:"
+)
+
+func accountKey(email string) string {
+	return accountPrefix + strings.ToLower(strings.TrimSpace(email))
+}
+
+func ipKey(ip string) string {
+	return ipPrefix + ip
+}
+
+// Attempt records an attempt to log in to email from ip, which counts as a failure until Success or Cancel is
+// called. Counting attempts up front means that concurrent attempts can't all get past the backoff before any
+// of them has failed. If the attempt isn't allowed now, nothing is recorded and ErrLocked or ErrThrottled is
+// returned along with how long to wait
+func (t *Throttler) Attempt(email, ip string) (time.Duration, error) {
+	now := t.Clock.Now()
+
+	_, wait, err := t.Store.Attempt(accountKey(email), now, t.AccountPolicy)
+	if err != nil {
+		return wait, err
+	}
+
+	_, wait, err = t.Store.Attempt(ipKey(ip), now, t.IPPolicy)
+	if err != nil {
+		// the attempt isn't made, so it doesn't count against the account either
+		if refundErr := t.Store.Refund(accountKey(email), t.AccountPolicy); refundErr != nil {
+			return 0, refundErr
+		}
+		return wait, err
+	}
+
+	return 0, nil
+}
+
+// Success forgets the failed logins for email and takes back the attempt from ip. Earlier failures from the
+// client IP are kept, so that logging in to one account doesn't allow guessing the passwords of others
+func (t *Throttler) Success(email, ip string) error {
+	err := t.Store.Reset(accountKey(email))
+	if err != nil {
+		return err
+	}
+
+	return t.Store.Refund(ipKey(ip), t.IPPolicy)
+}
+
+// Cancel takes back an attempt to log in to email from ip that neither failed nor succeeded, such as a right
+// password that still needs a second factor
+func (t *Throttler) Cancel(email, ip string) error {
+	err := t.Store.Refund(accountKey(email), t.AccountPolicy)
+	if err != nil {
+		return err
+	}
+
+	return t.Store.Refund(ipKey(ip), t.IPPolicy)
+}
+
+// LockedAccount is an account that is locked because of failed logins
+type LockedAccount struct {
+	Email         string
+	Failures      int
+	LastFailureAt time.Time
+	LockedUntil   time.Time
+}
+
+// LockedAccounts returns the accounts that are currently locked
+func (t *Throttler) LockedAccounts() ([]LockedAccount, error) {
+	locked, err := t.Store.Locked(t.Clock.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	var accounts []LockedAccount
+	for _, a := range locked {
+		if !strings.HasPrefix(a.Key, accountPrefix) {
+			continue
+		}
+
+		accounts = append(accounts, LockedAccount{
+			Email:         strings.TrimPrefix(a.Key, accountPrefix),
+			Failures:      a.Failures,
+			LastFailureAt: a.LastFailureAt,
+			LockedUntil:   a.LockedUntil,
+		})
+	}
+
+	return accounts, nil
+}
+
+// Unlock unlocks an account and forgets its failed logins
+func (t *Throttler) Unlock(email string) error {
+	return t.Store.Reset(accountKey(email))
+}
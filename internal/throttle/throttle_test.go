@@ -0,0 +1,323 @@
+package throttle
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+func newTestThrottler() (*Throttler, *fakeClock) {
+	clock := &fakeClock{now: time.Date(2050, 1, 1, 12, 0, 0, 0, time.UTC)}
+	t := New(NewMemoryStore(), clock)
+	t.AccountPolicy = Policy{
+		FreeAttempts: 2,
+		BaseDelay:    time.Second,
+		MaxDelay:     8 * time.Second,
+		LockAfter:    6,
+		LockFor:      time.Hour,
+		Window:       time.Hour,
+	}
+	t.IPPolicy = Policy{
+		FreeAttempts: 4,
+		BaseDelay:    time.Second,
+		MaxDelay:     time.Minute,
+		Window:       time.Hour,
+	}
+
+	return t, clock
+}
+
+func TestPolicy_Delay(t *testing.T) {
+	p := Policy{FreeAttempts: 2, BaseDelay: time.Second, MaxDelay: 5 * time.Second}
+
+	var tests = []struct {
+		failures int
+		expected time.Duration
+	}{
+		{0, 0},
+		{2, 0},
+		{3, time.Second},
+		{4, 2 * time.Second},
+		{5, 4 * time.Second},
+		{6, 5 * time.Second},
+		{1000, 5 * time.Second},
+	}
+
+	for _, e := range tests {
+		if d := p.Delay(e.failures); d != e.expected {
+			t.Errorf("%d failures: expected delay %s, got %s", e.failures, e.expected, d)
+		}
+	}
+}
+
+// fail waits out any backoff and then makes an attempt to log in to email from ip that fails
+func fail(t *testing.T, th *Throttler, clock *fakeClock, email, ip string) {
+	for {
+		wait, err := th.Attempt(email, ip)
+		if errors.Is(err, ErrThrottled) {
+			clock.Advance(wait)
+			continue
+		}
+
+		if err != nil {
+			t.Fatalf("unexpected error for %s from %s: %v", email, ip, err)
+		}
+		return
+	}
+}
+
+func TestThrottler_Backoff(t *testing.T) {
+	th, clock := newTestThrottler()
+
+	// attempts count as failures until they succeed, so the free attempts are used up after the third
+	for i := 0; i < 3; i++ {
+		if _, err := th.Attempt("john@here.com", "10.0.0.1"); err != nil {
+			t.Fatalf("attempt %d: expected no error, got %v", i+1, err)
+		}
+	}
+
+	wait, err := th.Attempt("john@here.com", "10.0.0.1")
+	if !errors.Is(err, ErrThrottled) {
+		t.Fatalf("expected ErrThrottled, got %v", err)
+	}
+	if wait != time.Second {
+		t.Errorf("expected to wait 1s, got %s", wait)
+	}
+
+	// the email address is case insensitive
+	if _, err := th.Attempt("JOHN@here.com", "10.0.0.2"); !errors.Is(err, ErrThrottled) {
+		t.Errorf("expected ErrThrottled for a differently cased email, got %v", err)
+	}
+
+	clock.Advance(time.Second)
+	if _, err := th.Attempt("john@here.com", "10.0.0.1"); err != nil {
+		t.Errorf("expected no error after waiting, got %v", err)
+	}
+
+	if wait, _ := th.Attempt("john@here.com", "10.0.0.1"); wait != 2*time.Second {
+		t.Errorf("expected the delay to double to 2s, got %s", wait)
+	}
+
+	// throttled attempts aren't counted
+	a, _ := th.Store.Get(accountKey("john@here.com"))
+	if a.Failures != 4 {
+		t.Errorf("expected 4 failures, got %d", a.Failures)
+	}
+
+	// other accounts are not affected by the account's failures
+	if _, err := th.Attempt("jane@here.com", "10.0.0.2"); err != nil {
+		t.Errorf("expected no error for another account, got %v", err)
+	}
+}
+
+func TestThrottler_Window(t *testing.T) {
+	th, clock := newTestThrottler()
+
+	for i := 0; i < 3; i++ {
+		fail(t, th, clock, "john@here.com", "10.0.0.1")
+	}
+
+	clock.Advance(time.Hour + time.Second)
+	if _, err := th.Attempt("john@here.com", "10.0.0.1"); err != nil {
+		t.Errorf("expected failures to be forgotten after the window, got %v", err)
+	}
+
+	a, _ := th.Store.Get(accountKey("john@here.com"))
+	if a.Failures != 1 {
+		t.Errorf("expected the failure count to restart at 1, got %d", a.Failures)
+	}
+}
+
+func TestThrottler_Lockout(t *testing.T) {
+	th, clock := newTestThrottler()
+
+	for i := 0; i < 6; i++ {
+		fail(t, th, clock, "john@here.com", "10.0.0.1")
+	}
+
+	wait, err := th.Attempt("john@here.com", "10.0.0.2")
+	if !errors.Is(err, ErrLocked) {
+		t.Fatalf("expected ErrLocked, got %v", err)
+	}
+	if wait != time.Hour {
+		t.Errorf("expected to wait 1h, got %s", wait)
+	}
+
+	accounts, err := th.LockedAccounts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(accounts) != 1 || accounts[0].Email != "john@here.com" {
+		t.Fatalf("expected john@here.com to be the only locked account, got %v", accounts)
+	}
+
+	// a successful password check doesn't get a chance while the account is locked, but an admin can unlock it
+	err = th.Unlock("john@here.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := th.Attempt("john@here.com", "10.0.0.2"); err != nil {
+		t.Errorf("expected no error after unlocking, got %v", err)
+	}
+
+	for i := 0; i < 6; i++ {
+		fail(t, th, clock, "jane@here.com", "10.0.0.3")
+	}
+
+	clock.Advance(time.Hour)
+	if accounts, _ := th.LockedAccounts(); len(accounts) != 0 {
+		t.Errorf("expected no locked accounts, got %v", accounts)
+	}
+
+	if _, err := th.Attempt("jane@here.com", "10.0.0.4"); errors.Is(err, ErrLocked) {
+		t.Errorf("expected the lock to expire")
+	}
+}
+
+func TestThrottler_IP(t *testing.T) {
+	th, clock := newTestThrottler()
+
+	// spread the failures over many accounts so that only the IP is throttled
+	emails := []string{"a@here.com", "b@here.com", "c@here.com", "d@here.com", "e@here.com"}
+	for _, email := range emails {
+		fail(t, th, clock, email, "10.0.0.1")
+	}
+
+	if _, err := th.Attempt("f@here.com", "10.0.0.1"); !errors.Is(err, ErrThrottled) {
+		t.Errorf("expected ErrThrottled for the IP, got %v", err)
+	}
+
+	// an attempt refused because of the IP doesn't count against the account
+	if a, _ := th.Store.Get(accountKey("f@here.com")); a.Failures != 0 {
+		t.Errorf("expected no failures for the account, got %d", a.Failures)
+	}
+
+	if _, err := th.Attempt("f@here.com", "10.0.0.2"); err != nil {
+		t.Errorf("expected no error from another IP, got %v", err)
+	}
+
+	if accounts, _ := th.LockedAccounts(); len(accounts) != 0 {
+		t.Errorf("expected IPs never to be listed as locked accounts, got %v", accounts)
+	}
+}
+
+func TestThrottler_Success(t *testing.T) {
+	th, clock := newTestThrottler()
+
+	for i := 0; i < 5; i++ {
+		fail(t, th, clock, "john@here.com", "10.0.0.1")
+	}
+
+	clock.Advance(time.Minute)
+	if _, err := th.Attempt("john@here.com", "10.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+
+	err := th.Success("john@here.com", "10.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a, _ := th.Store.Get(accountKey("john@here.com"))
+	if a.Failures != 0 {
+		t.Errorf("expected account failures to be reset, got %d", a.Failures)
+	}
+
+	a, _ = th.Store.Get(ipKey("10.0.0.1"))
+	if a.Failures != 5 {
+		t.Errorf("expected earlier IP failures to be kept and the successful attempt not to count, got %d", a.Failures)
+	}
+}
+
+func TestThrottler_Cancel(t *testing.T) {
+	th, _ := newTestThrottler()
+
+	for i := 0; i < 3; i++ {
+		if _, err := th.Attempt("john@here.com", "10.0.0.1"); err != nil {
+			t.Fatal(err)
+		}
+
+		err := th.Cancel("john@here.com", "10.0.0.1")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, key := range []string{accountKey("john@here.com"), ipKey("10.0.0.1")} {
+		if a, _ := th.Store.Get(key); a.Failures != 0 {
+			t.Errorf("%s: expected cancelled attempts not to count, got %d failures", key, a.Failures)
+		}
+	}
+}
+
+func TestThrottler_CancelThenSecondFactor(t *testing.T) {
+	th, clock := newTestThrottler()
+
+	// fail past the free attempts, waiting out each delay
+	for i := 0; i < 3; i++ {
+		if _, err := th.Attempt("john@here.com", "10.0.0.1"); err != nil {
+			t.Fatal(err)
+		}
+		clock.Advance(10 * time.Second)
+	}
+
+	// the right password still needs a second factor, which is checked straight away
+	if _, err := th.Attempt("john@here.com", "10.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+
+	err := th.Cancel("john@here.com", "10.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if wait, err := th.Attempt("john@here.com", "10.0.0.1"); err != nil {
+		t.Errorf("expected the second factor not to be throttled by the cancelled attempt, got %v waiting %s", err, wait)
+	}
+
+	a, _ := th.Store.Get(accountKey("john@here.com"))
+	if a.Failures != 4 {
+		t.Errorf("expected the earlier failures and the second factor to count, got %d", a.Failures)
+	}
+}
+
+func TestThrottler_Concurrent(t *testing.T) {
+	th, _ := newTestThrottler()
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	allowed := 0
+
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			if _, err := th.Attempt("john@here.com", "10.0.0.1"); err == nil {
+				mu.Lock()
+				allowed++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	// only the free attempts and the one that starts the backoff get through, however many are made at once
+	if allowed != th.AccountPolicy.FreeAttempts+1 {
+		t.Errorf("expected %d concurrent attempts to be allowed, got %d", th.AccountPolicy.FreeAttempts+1, allowed)
+	}
+}
@@ -0,0 +1,128 @@
+package totp
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA1 key from the RFC 6238 test vectors
+var rfcSecret = base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+
+func TestCode(t *testing.T) {
+	// the RFC 6238 appendix B values, truncated to 6 digits
+	var tests = []struct {
+		unix     int64
+		expected string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+
+	for _, e := range tests {
+		code, err := Code(rfcSecret, Step(time.Unix(e.unix, 0)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if code != e.expected {
+			t.Errorf("time %d: expected %s, got %s", e.unix, e.expected, code)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+
+	var tests = []struct {
+		name     string
+		code     string
+		at       time.Time
+		expected bool
+	}{
+		{"current period", "005924", now, true},
+		{"with spaces", "005 924", now, true},
+		{"previous period", "005924", now.Add(Period * time.Second), true},
+		{"next period", "005924", now.Add(-Period * time.Second), true},
+		{"too old", "005924", now.Add(2 * Period * time.Second), false},
+		{"wrong code", "123456", now, false},
+		{"too short", "05924", now, false},
+	}
+
+	for _, e := range tests {
+		step, ok := Validate(rfcSecret, e.code, e.at)
+		if ok != e.expected {
+			t.Errorf("%s: expected %v, got %v", e.name, e.expected, ok)
+		}
+
+		if ok && step != Step(now) {
+			t.Errorf("%s: expected step %d, got %d", e.name, Step(now), step)
+		}
+	}
+}
+
+func TestGenerateSecret(t *testing.T) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Code(secret, 1); err != nil {
+		t.Errorf("generated secret %s can't be used - %v", secret, err)
+	}
+
+	other, _ := GenerateSecret()
+	if secret == other {
+		t.Error("expected secrets to be random")
+	}
+}
+
+func TestURI(t *testing.T) {
+	uri := URI("Bookings", "admin@here.com", "JBSWY3DPEHPK3PXP")
+
+	if !strings.HasPrefix(uri, "otpauth://totp/Bookings:admin@here.com?") {
+		t.Errorf("unexpected URI prefix in %s", uri)
+	}
+
+	if !strings.Contains(uri, "secret=JBSWY3DPEHPK3PXP") || !strings.Contains(uri, "issuer=Bookings") {
+		t.Errorf("expected secret and issuer in %s", uri)
+	}
+}
+
+func TestQRCode(t *testing.T) {
+	qr, err := QRCode(URI("Bookings", "admin@here.com", "JBSWY3DPEHPK3PXP"), 200)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(qr, "data:image/png;base64,") {
+		t.Errorf("expected a PNG data URI, got %.40s", qr)
+	}
+}
+
+func TestNewRecoveryCodes(t *testing.T) {
+	codes, err := NewRecoveryCodes(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	seen := make(map[string]bool)
+	for _, code := range codes {
+		if len(code) != 11 || code[5] != '-' {
+			t.Errorf("unexpected recovery code format %s", code)
+		}
+		if seen[code] {
+			t.Errorf("duplicate recovery code %s", code)
+		}
+		seen[code] = true
+	}
+
+	if NormalizeRecoveryCode(" ABCDE-12345 ") != "abcde12345" {
+		t.Errorf("expected recovery codes to be normalized, got %s", NormalizeRecoveryCode(" ABCDE-12345 "))
+	}
+}
@@ -0,0 +1,68 @@
+// Package trash purges deleted reservations once they have been in the trash for longer than the retention
+// period, until when they can be restored
+package trash
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/dhanekom/bookings/internal/background"
+)
+
+// Store holds deleted reservations
+type Store interface {
+	// PurgeDeletedReservations erases the guests' details from the reservations deleted before before, so they
+	// can no longer be restored, and returns how many were purged
+	PurgeDeletedReservations(ctx context.Context, before time.Time) (int, error)
+}
+
+// Purger purges deleted reservations
+type Purger struct {
+	Store Store
+	Clock background.Clock
+	// Retention is how long deleted reservations are kept in the trash
+	Retention time.Duration
+	// Interval is the time between purges
+	Interval time.Duration
+	// ErrorLog logs purges that failed. Nothing is logged if it is nil
+	ErrorLog *log.Logger
+}
+
+// New returns a purger with default settings, which keeps deleted reservations for 30 days. A nil clock uses
+// the system time
+func New(store Store, clock background.Clock) *Purger {
+	if clock == nil {
+		clock = background.SystemClock{}
+	}
+
+	return &Purger{
+		Store:     store,
+		Clock:     clock,
+		Retention: 30 * 24 * time.Hour,
+		Interval:  time.Hour,
+	}
+}
+
+// Purge purges the reservations that have been in the trash for longer than Retention and returns how many were
+// purged
+func (p *Purger) Purge(ctx context.Context) (int, error) {
+	return p.Store.PurgeDeletedReservations(ctx, p.Clock.Now().Add(-p.Retention))
+}
+
+// Start purges straight away and then every Interval until ctx is done. The returned wait group is done once
+// purging has stopped
+func (p *Purger) Start(ctx context.Context) *sync.WaitGroup {
+	var wg sync.WaitGroup
+	background.Job{
+		Run: func(ctx context.Context) error {
+			_, err := p.Purge(ctx)
+			return err
+		},
+		Interval: p.Interval,
+		ErrorLog: p.ErrorLog,
+	}.Start(ctx, &wg)
+
+	return &wg
+}
@@ -0,0 +1,114 @@
+package trash
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+var testNow = time.Date(2050, 1, 31, 12, 0, 0, 0, time.UTC)
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (c fakeClock) Now() time.Time {
+	return c.now
+}
+
+// fakeStore holds the deletion times of reservations in memory
+type fakeStore struct {
+	mu      sync.Mutex
+	deleted []time.Time
+	purges  int
+	fail    error
+}
+
+func (s *fakeStore) PurgeDeletedReservations(ctx context.Context, before time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.purges++
+	if s.fail != nil {
+		return 0, s.fail
+	}
+
+	var kept []time.Time
+	for _, deletedAt := range s.deleted {
+		if deletedAt.After(before) {
+			kept = append(kept, deletedAt)
+		}
+	}
+
+	purged := len(s.deleted) - len(kept)
+	s.deleted = kept
+	return purged, nil
+}
+
+func (s *fakeStore) count() (deleted, purges int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.deleted), s.purges
+}
+
+func TestPurge(t *testing.T) {
+	store := &fakeStore{deleted: []time.Time{
+		testNow.AddDate(0, 0, -31),
+		testNow.AddDate(0, 0, -30),
+		testNow.AddDate(0, 0, -29),
+		testNow,
+	}}
+	p := New(store, fakeClock{testNow})
+
+	purged, err := p.Purge(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if purged != 2 {
+		t.Errorf("expected the reservations deleted 30 or more days ago to be purged, got %d", purged)
+	}
+
+	if n, _ := store.count(); n != 2 {
+		t.Errorf("expected 2 reservations to be kept, got %d", n)
+	}
+
+	p.Retention = 0
+	if purged, _ := p.Purge(context.Background()); purged != 2 {
+		t.Errorf("expected a retention of 0 to purge everything, got %d", purged)
+	}
+
+	store.fail = errors.New("some error")
+	if _, err := p.Purge(context.Background()); err == nil {
+		t.Error("expected the store error to be returned")
+	}
+}
+
+func TestStartPurgesUntilDone(t *testing.T) {
+	store := &fakeStore{deleted: []time.Time{testNow.AddDate(0, -2, 0)}}
+	p := New(store, fakeClock{testNow})
+	p.Interval = 10 * time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	wg := p.Start(ctx)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for _, purges := store.count(); purges < 3 && time.Now().Before(deadline); _, purges = store.count() {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	cancel()
+	wg.Wait()
+
+	deleted, purges := store.count()
+	if purges < 3 {
+		t.Errorf("expected repeated purges, got %d", purges)
+	}
+
+	if deleted != 0 {
+		t.Errorf("expected the old reservation to be purged, got %d", deleted)
+	}
+}
@@ -0,0 +1,123 @@
+// Package waitlist offers rooms that become available to the guests waiting for them, in the order they joined
+// the waitlist
+package waitlist
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/dhanekom/bookings/internal/background"
+	"github.com/dhanekom/bookings/internal/models"
+)
+
+// Store holds the waitlist and the rooms that can be offered
+type Store interface {
+	// WaitlistEntriesToOffer returns the entries that haven't been offered a room, for stays arriving from
+	// the given date, oldest first
+	WaitlistEntriesToOffer(from time.Time) ([]models.WaitlistEntry, error)
+	// SearchAvailabilityForAllRooms returns the rooms that are available for a stay and sleep its party
+	SearchAvailabilityForAllRooms(start, end time.Time, adults, children int) ([]models.Room, error)
+}
+
+// OfferFunc offers room to the guest of an entry and holds it for them, so that it isn't offered again
+type OfferFunc func(ctx context.Context, e models.WaitlistEntry, room models.Room) error
+
+// Matcher offers available rooms to waiting guests. It matches whenever it is triggered, such as when a
+// reservation is cancelled, and every Interval, so that rooms whose offers expired go to the next guest
+type Matcher struct {
+	Store Store
+	Offer OfferFunc
+	Clock background.Clock
+	// Interval is the time between matches that weren't triggered
+	Interval time.Duration
+	// OfferDuration is how long a guest has to book the room they were offered
+	OfferDuration time.Duration
+	// ErrorLog logs matches and offers that failed. Nothing is logged if it is nil
+	ErrorLog *log.Logger
+
+	trigger chan struct{}
+}
+
+// New returns a matcher with default settings. A nil clock uses the system time
+func New(store Store, offer OfferFunc, clock background.Clock) *Matcher {
+	if clock == nil {
+		clock = background.SystemClock{}
+	}
+
+	return &Matcher{
+		Store:         store,
+		Offer:         offer,
+		Clock:         clock,
+		Interval:      15 * time.Minute,
+		OfferDuration: 24 * time.Hour,
+		trigger:       make(chan struct{}, 1),
+	}
+}
+
+// Trigger asks the matcher to match as soon as it can. It doesn't wait for the match, and triggers that
+// arrive while a match is pending are merged into it
+func (m *Matcher) Trigger() {
+	select {
+	case m.trigger <- struct{}{}:
+	default:
+	}
+}
+
+// Match offers a room to every waiting guest that a room is available for, oldest entry first, and returns how
+// many offers were made. A room that is offered is held, so it isn't offered to a later guest as well. An
+// offer that fails doesn't stop later guests from being offered rooms
+func (m *Matcher) Match(ctx context.Context) (int, error) {
+	now := m.Clock.Now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+
+	entries, err := m.Store.WaitlistEntriesToOffer(today)
+	if err != nil {
+		return 0, err
+	}
+
+	offers := 0
+	for _, e := range entries {
+		if ctx.Err() != nil {
+			return offers, ctx.Err()
+		}
+
+		rooms, err := m.Store.SearchAvailabilityForAllRooms(e.StartDate, e.EndDate, e.Adults, e.Children)
+		if err != nil {
+			return offers, err
+		}
+
+		if len(rooms) == 0 {
+			continue
+		}
+
+		err = m.Offer(ctx, e, rooms[0])
+		if err != nil {
+			if m.ErrorLog != nil {
+				m.ErrorLog.Printf("waitlist entry %d couldn't be offered room %d: %s", e.ID, rooms[0].ID, err)
+			}
+			continue
+		}
+		offers++
+	}
+
+	return offers, nil
+}
+
+// Start matches straight away, and then whenever it is triggered or Interval has passed, until ctx is done. The
+// returned wait group is done once matching has stopped
+func (m *Matcher) Start(ctx context.Context) *sync.WaitGroup {
+	var wg sync.WaitGroup
+	background.Job{
+		Run: func(ctx context.Context) error {
+			_, err := m.Match(ctx)
+			return err
+		},
+		Interval: m.Interval,
+		Trigger:  m.trigger,
+		ErrorLog: m.ErrorLog,
+	}.Start(ctx, &wg)
+
+	return &wg
+}
@@ -0,0 +1,186 @@
+package waitlist
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dhanekom/bookings/internal/models"
+)
+
+var testNow = time.Date(2050, 1, 1, 12, 0, 0, 0, time.UTC)
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (c fakeClock) Now() time.Time {
+	return c.now
+}
+
+// fakeStore keeps the waitlist and the free rooms in memory. A room that is offered is no longer free
+type fakeStore struct {
+	mu      sync.Mutex
+	entries []models.WaitlistEntry
+	free    map[int]models.Room
+	offered map[int]int
+	matches int
+	fail    error
+}
+
+func (s *fakeStore) WaitlistEntriesToOffer(from time.Time) ([]models.WaitlistEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.matches++
+	if s.fail != nil {
+		return nil, s.fail
+	}
+
+	var entries []models.WaitlistEntry
+	for _, e := range s.entries {
+		if _, ok := s.offered[e.ID]; !ok && !e.StartDate.Before(from) {
+			entries = append(entries, e)
+		}
+	}
+
+	return entries, nil
+}
+
+func (s *fakeStore) SearchAvailabilityForAllRooms(start, end time.Time, adults, children int) ([]models.Room, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var rooms []models.Room
+	for _, room := range s.free {
+		if room.Capacity >= adults+children {
+			rooms = append(rooms, room)
+		}
+	}
+	sort.Slice(rooms, func(i, j int) bool { return rooms[i].ID < rooms[j].ID })
+
+	return rooms, nil
+}
+
+func (s *fakeStore) offer(ctx context.Context, e models.WaitlistEntry, room models.Room) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if e.Email == "fail@here.com" {
+		return errors.New("some error")
+	}
+
+	delete(s.free, room.ID)
+	s.offered[e.ID] = room.ID
+	return nil
+}
+
+func (s *fakeStore) count() (offered, matches int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.offered), s.matches
+}
+
+func newFakeStore(entries []models.WaitlistEntry, rooms ...models.Room) *fakeStore {
+	s := &fakeStore{entries: entries, free: make(map[int]models.Room), offered: make(map[int]int)}
+	for _, room := range rooms {
+		s.free[room.ID] = room
+	}
+	return s
+}
+
+func entry(id int, email string, arrival time.Time, adults int) models.WaitlistEntry {
+	return models.WaitlistEntry{
+		ID:        id,
+		Email:     email,
+		StartDate: arrival,
+		EndDate:   arrival.AddDate(0, 0, 2),
+		Adults:    adults,
+	}
+}
+
+func TestMatch(t *testing.T) {
+	tomorrow := testNow.AddDate(0, 0, 1)
+
+	var tests = []struct {
+		name     string
+		entries  []models.WaitlistEntry
+		rooms    []models.Room
+		expected map[int]int
+	}{
+		{"oldest entry first", []models.WaitlistEntry{entry(1, "a@here.com", tomorrow, 2), entry(2, "b@here.com", tomorrow, 2)},
+			[]models.Room{{ID: 1, Capacity: 2}}, map[int]int{1: 1}},
+		{"every entry with a room", []models.WaitlistEntry{entry(1, "a@here.com", tomorrow, 2), entry(2, "b@here.com", tomorrow, 2)},
+			[]models.Room{{ID: 1, Capacity: 2}, {ID: 2, Capacity: 2}}, map[int]int{1: 1, 2: 2}},
+		{"party too large skipped", []models.WaitlistEntry{entry(1, "a@here.com", tomorrow, 4), entry(2, "b@here.com", tomorrow, 2)},
+			[]models.Room{{ID: 1, Capacity: 2}}, map[int]int{2: 1}},
+		{"failed offer skipped", []models.WaitlistEntry{entry(1, "fail@here.com", tomorrow, 2), entry(2, "b@here.com", tomorrow, 2)},
+			[]models.Room{{ID: 1, Capacity: 2}}, map[int]int{2: 1}},
+		{"past stays skipped", []models.WaitlistEntry{entry(1, "a@here.com", testNow.AddDate(0, 0, -1), 2)},
+			[]models.Room{{ID: 1, Capacity: 2}}, map[int]int{}},
+		{"no rooms", []models.WaitlistEntry{entry(1, "a@here.com", tomorrow, 2)}, nil, map[int]int{}},
+	}
+
+	for _, e := range tests {
+		store := newFakeStore(e.entries, e.rooms...)
+		m := New(store, store.offer, fakeClock{testNow})
+
+		offers, err := m.Match(context.Background())
+		if err != nil {
+			t.Fatalf("%s: %s", e.name, err)
+		}
+
+		if offers != len(e.expected) {
+			t.Errorf("%s: expected %d offers, got %d", e.name, len(e.expected), offers)
+		}
+
+		for id, roomID := range e.expected {
+			if store.offered[id] != roomID {
+				t.Errorf("%s: expected entry %d to be offered room %d, got %d", e.name, id, roomID, store.offered[id])
+			}
+		}
+	}
+
+	store := newFakeStore(nil)
+	store.fail = errors.New("some error")
+	if _, err := New(store, store.offer, fakeClock{testNow}).Match(context.Background()); err == nil {
+		t.Error("expected the store error to be returned")
+	}
+}
+
+func TestStartMatchesWhenTriggered(t *testing.T) {
+	store := newFakeStore([]models.WaitlistEntry{entry(1, "a@here.com", testNow.AddDate(0, 0, 1), 2)})
+	m := New(store, store.offer, fakeClock{testNow})
+	m.Interval = time.Hour
+
+	ctx, cancel := context.WithCancel(context.Background())
+	wg := m.Start(ctx)
+
+	waitFor := func(done func() bool) {
+		deadline := time.Now().Add(5 * time.Second)
+		for !done() && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	// a room is freed after the first match, and only offered once the matcher is triggered
+	waitFor(func() bool { _, matches := store.count(); return matches >= 1 })
+	store.mu.Lock()
+	store.free[1] = models.Room{ID: 1, Capacity: 2}
+	store.mu.Unlock()
+
+	m.Trigger()
+	m.Trigger()
+	waitFor(func() bool { offered, _ := store.count(); return offered == 1 })
+
+	cancel()
+	wg.Wait()
+
+	if offered, _ := store.count(); offered != 1 {
+		t.Errorf("expected the freed room to be offered when triggered, got %d offers", offered)
+	}
+}
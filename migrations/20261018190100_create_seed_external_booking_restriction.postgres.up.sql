@@ -0,0 +1,3 @@
+INSERT INTO public.restrictions (id,restriction_name,created_at,updated_at) VALUES
+	 (3,'External Booking','2026-10-18 00:00:00.000','2026-10-18 00:00:00.000');
+SELECT setval(pg_get_serial_sequence('restrictions', 'id'), (SELECT max(id) FROM restrictions));
@@ -0,0 +1,2 @@
+DELETE FROM public.room_photos WHERE filename IN ('rooms/generals-quarters.png', 'rooms/majors-suite.png');
+ALTER TABLE public.rooms ALTER COLUMN slug DROP NOT NULL;
@@ -0,0 +1,15 @@
+UPDATE public.rooms SET slug = 'generals-quarters', capacity = 2, base_price = 12000,
+	description = 'Your home away from home, set on the majestic waters of the Atlantic Ocean, this will be a vacation to remember.',
+	amenities = E'Sea view\nQueen bed\nEn-suite bathroom\nFree Wi-Fi'
+	WHERE room_name = 'General''s Quarters';
+UPDATE public.rooms SET slug = 'majors-suite', capacity = 4, base_price = 18000,
+	description = 'Your home away from home, set on the majestic waters of the Atlantic Ocean, this will be a vacation to remember.',
+	amenities = E'Sea view\nKing bed\nSleeper couch\nEn-suite bathroom\nFree Wi-Fi'
+	WHERE room_name = 'Major''s Suite';
+UPDATE public.rooms SET slug = 'room-' || id WHERE slug IS NULL;
+ALTER TABLE public.rooms ALTER COLUMN slug SET NOT NULL;
+
+INSERT INTO public.room_photos (room_id,filename,caption,sort_order,created_at,updated_at)
+	SELECT id, 'rooms/generals-quarters.png', 'General''s Quarters', 1, now(), now() FROM public.rooms WHERE slug = 'generals-quarters';
+INSERT INTO public.room_photos (room_id,filename,caption,sort_order,created_at,updated_at)
+	SELECT id, 'rooms/majors-suite.png', 'Major''s Suite', 1, now(), now() FROM public.rooms WHERE slug = 'majors-suite';
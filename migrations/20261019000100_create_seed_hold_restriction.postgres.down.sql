@@ -0,0 +1,2 @@
+DELETE FROM room_restrictions WHERE restriction_id = 4;
+DELETE FROM restrictions WHERE id = 4;
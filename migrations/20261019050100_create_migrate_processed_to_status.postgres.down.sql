@@ -0,0 +1,3 @@
+ALTER TABLE reservations ADD COLUMN processed integer DEFAULT 0 NOT NULL;
+UPDATE reservations SET processed = 1 WHERE status <> 'pending';
+DELETE FROM reservation_events;
@@ -0,0 +1,10 @@
+UPDATE reservations SET status = CASE
+	WHEN cancelled_at IS NOT NULL THEN 'cancelled'
+	WHEN processed = 1 THEN 'confirmed'
+	ELSE 'pending'
+END;
+INSERT INTO reservation_events (reservation_id,from_status,to_status,created_at,updated_at)
+	SELECT id,'','pending',created_at,created_at FROM reservations;
+INSERT INTO reservation_events (reservation_id,from_status,to_status,created_at,updated_at)
+	SELECT id,'pending',status,updated_at,updated_at FROM reservations WHERE status <> 'pending';
+ALTER TABLE reservations DROP COLUMN processed;
@@ -115,6 +115,14 @@ function addCheckAvailability(roomID, CSRFToken){
               <input disabled required class="form-control" type="text" name="end" id="end" placeholder="Departure date" autocomplete="off">
             </div>
           </div>
+          <div class="row mb-2">
+            <div class="col">
+              <input required class="form-control" type="number" min="1" name="adults" value="2" placeholder="Adults">
+            </div>
+            <div class="col">
+              <input class="form-control" type="number" min="0" name="children" value="0" placeholder="Children">
+            </div>
+          </div>
         </form>
       `;
     attention.custom({
@@ -152,16 +160,45 @@ function addCheckAvailability(roomID, CSRFToken){
                 icon: 'success',
                 showConfirmButton: false,
                 msg: '<p>Room is available!</p>'
-                    +'<p><a href="/book-room?id='+data.room_id+'&s='+data.start_date+'&e='+data.end_date+'"'
+                    +'<p>' + data.total + ' for ' + data.nights + (data.nights === 1 ? ' night' : ' nights') + '</p>'
+                    +'<p><a href="/book-room?id='+data.room_id+'&s='+data.start_date+'&e='+data.end_date
+                    +'&a='+data.adults+'&c='+(data.children || 0)+'"'
                     +' class="btn btn-primary">Book now!</a></p>'
               })
             } else {
               attention.error({
-                msg: "Room is not available for the selected dates"
+                msg: data.message || "Room is not available for the selected dates"
               })
             }
           })
       }
     })
   });    
-}
\ No newline at end of file
+}
+
+// addHoldCountdown counts down the time left on the hold shown in the element with elementID, if there is one
+function addHoldCountdown(elementID) {
+  const elem = document.getElementById(elementID);
+  if (elem === null) {
+    return;
+  }
+
+  const expires = new Date(elem.dataset.expires);
+
+  let update = function () {
+    const left = Math.floor((expires - new Date()) / 1000);
+    if (left <= 0) {
+      elem.classList.replace("alert-info", "alert-warning");
+      elem.textContent = "Your hold on this room has expired. You can still book it if it hasn't been taken.";
+      clearInterval(timer);
+      return;
+    }
+
+    const minutes = Math.floor(left / 60);
+    const seconds = String(left % 60).padStart(2, "0");
+    elem.textContent = "We're holding this room for you for another " + minutes + ":" + seconds + ".";
+  }
+
+  const timer = setInterval(update, 1000);
+  update();
+}